@@ -1,19 +1,26 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/mhpenta/starterA/internal/app"
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/auth/localauth"
 	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database"
+	"github.com/mhpenta/starterA/internal/database/repo"
 	httphandlers "github.com/mhpenta/starterA/internal/handlers/http"
 	"github.com/mhpenta/starterA/internal/routes"
 	"github.com/mhpenta/starterA/internal/service"
@@ -27,8 +34,11 @@ import (
 
 // Options defines command-line options for the application
 type Options struct {
-	ConfigPath string `short:"c" long:"config" description:"Path to configuration file" default:"config.toml"`
-	Verbose    bool   `short:"v" long:"verbose" description:"Show verbose debug information"`
+	ConfigPath  string `short:"c" long:"config" description:"Path to configuration file" default:"config.toml"`
+	Verbose     bool   `short:"v" long:"verbose" description:"Show verbose debug information"`
+	RollBack    int    `long:"rollback" description:"Roll back the given number of schema migrations and exit"`
+	GrantAdmin  string `long:"grant-admin" description:"Grant the admin role to the user with the given email and exit"`
+	SetPassword string `long:"set-password" description:"Set the password of the user with the given email, read from standard input, and exit"`
 }
 
 func main() {
@@ -54,6 +64,30 @@ func main() {
 		syscall.SIGTERM)
 	defer cancel()
 
+	if opts.RollBack > 0 {
+		if err := rollback(ctx, logger, cfg, opts.RollBack); err != nil {
+			logger.Error("Error rolling back migrations", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.GrantAdmin != "" {
+		if err := grantAdmin(ctx, logger, cfg, opts.GrantAdmin); err != nil {
+			logger.Error("Error granting admin role", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.SetPassword != "" {
+		if err := setPassword(ctx, logger, cfg, opts.SetPassword, os.Stdin); err != nil {
+			logger.Error("Error setting password", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := run(ctx, logger, cfg); err != nil {
 		logger.Error("Error running application", "error", err)
 	}
@@ -72,11 +106,134 @@ func run(ctx context.Context, logger *slog.Logger, cfg *config.Config) error {
 		}
 	}(a)
 
-	svc := service.New(ctx, a, a.Logger)
+	svc := service.New(ctx, a.DB, a.Tx, a.Logger)
+	if a.Auth != nil {
+		svc.Policies = service.DefaultPolicies()
+		svc.Provisioning = service.Provisioning{
+			Provider:    cfg.Auth.Provider,
+			UserInfo:    a.Auth,
+			UIDIsUserID: cfg.Auth.Provider == config.AuthProviderLocal,
+		}
+		svc.MFA = service.MFAConfig{Issuer: cfg.Auth.MFAIssuer}
+	}
 
 	httpHandlers := httphandlers.New(svc, a.Logger)
 
-	return runServer(ctx, cfg.Server, a, httpHandlers)
+	var authHandlers *httphandlers.AuthHandlers
+	if a.Auth != nil {
+		cookieCfg, err := httphandlers.SessionCookieConfigFromAuth(cfg.Auth)
+		if err != nil {
+			return fmt.Errorf("invalid auth cookie config: %w", err)
+		}
+		authHandlers = httphandlers.NewAuthHandlers(a.Auth, cookieCfg, a.Logger)
+		authHandlers.CSRF = httphandlers.CSRFConfigFromServer(cfg.Server)
+		authHandlers.Limiter = a.Limiter
+		authHandlers.MFA = httphandlers.MFAConfig{
+			Verifier: svc,
+			MaxAge:   time.Duration(cfg.Auth.MFAMaxAgeSeconds) * time.Second,
+			Required: cfg.Auth.RequireMFA,
+		}
+		authHandlers.MagicLink = httphandlers.MagicLinkConfig{
+			Manager:     a.MagicLinks,
+			RedirectURL: cfg.Auth.MagicLinkRedirectURL,
+		}
+		authHandlers.OAuth = httphandlers.OAuthConfig{
+			Manager:     a.OAuth,
+			Provisioner: svc,
+			RedirectURL: cfg.Auth.OAuthRedirectURL,
+		}
+	}
+
+	return runServer(ctx, cfg.Server, a, httpHandlers, authHandlers)
+}
+
+// rollback reverts the most recent schema migrations without starting the application
+func rollback(ctx context.Context, logger *slog.Logger, cfg *config.Config, steps int) error {
+	dbConn, err := database.GetConnection(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("error getting db connection: %w", err)
+	}
+	defer func() {
+		if err := dbConn.Close(); err != nil {
+			logger.Error("Error closing database", "error", err)
+		}
+	}()
+
+	migrations, err := database.Migrations()
+	if err != nil {
+		return err
+	}
+
+	return database.NewMigrator(dbConn, migrations, logger).Down(ctx, steps)
+}
+
+// grantAdmin grants the admin role to an existing user without starting the
+// server, so the first admin can be created
+func grantAdmin(ctx context.Context, logger *slog.Logger, cfg *config.Config, email string) error {
+	a, err := app.New(ctx, logger, cfg)
+	if err != nil {
+		return fmt.Errorf("app initialization error: %w", err)
+	}
+	defer func() {
+		if err := a.Close(); err != nil {
+			logger.Error("Error closing application", "error", err)
+		}
+	}()
+
+	user, err := service.New(ctx, a.DB, a.Tx, a.Logger).GrantRole(ctx, email, auth.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	logger.Info("Granted admin role", "user_id", user.ID, "email", user.Email)
+	return nil
+}
+
+// setPassword sets the password of an existing user of the local auth
+// provider without starting the server, reading it from the first line of in
+func setPassword(ctx context.Context, logger *slog.Logger, cfg *config.Config, email string, in io.Reader) error {
+	a, err := app.New(ctx, logger, cfg)
+	if err != nil {
+		return fmt.Errorf("app initialization error: %w", err)
+	}
+	defer func() {
+		if err := a.Close(); err != nil {
+			logger.Error("Error closing application", "error", err)
+		}
+	}()
+
+	provider, ok := auth.Lookup[*localauth.Provider](a.Auth)
+	if !ok {
+		return errors.New("passwords can only be set with the local auth provider")
+	}
+	user, err := setUserPassword(ctx, service.New(ctx, a.DB, a.Tx, a.Logger), provider, email, in)
+	if err != nil {
+		return err
+	}
+	logger.Info("Set password", "user_id", user.ID, "email", user.Email)
+	return nil
+}
+
+// setUserPassword reads a password from the first line of in and sets it
+// for the user with the given email
+func setUserPassword(ctx context.Context, svc *service.Service, provider *localauth.Provider, email string, in io.Reader) (*repo.User, error) {
+	user, err := svc.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error reading password: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return nil, errors.New("no password given on standard input")
+	}
+
+	if err := provider.SetPassword(ctx, user.ID, password); err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
 // runServer starts the server using the given configuration and initializes routes
@@ -84,11 +241,17 @@ func runServer(
 	ctx context.Context,
 	serverCfg config.Server,
 	a *app.Application,
-	httpHandlers *httphandlers.HTTPHandlers) error {
+	httpHandlers *httphandlers.HTTPHandlers,
+	authHandlers *httphandlers.AuthHandlers) error {
+
+	trustedProxies, err := auth.ParseTrustedProxies(serverCfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(auth.TrustedRealIP(trustedProxies))
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(time.Duration(serverCfg.TaskTimeOutInSeconds) * time.Second)) // Use the longest timeout for all routes by default
@@ -97,10 +260,11 @@ func runServer(
 		AllowedOrigins: serverCfg.AllowedCorsURLs,
 		AllowedMethods: []string{"GET", "POST", "DELETE", "PUT", "OPTIONS"},
 		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{auth.CSRFHeader},
 	})
 	wrappedHandler := corsHandler.Handler(r)
 
-	routes.RegisterRoutes(r, httpHandlers)
+	routes.RegisterRoutes(r, httpHandlers, authHandlers)
 
 	server := &http.Server{
 		Addr:              ":" + fmt.Sprint(serverCfg.Port),
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log/slog"
 	"net"
@@ -11,8 +12,11 @@ import (
 	"time"
 
 	"github.com/mhpenta/starterA/internal/app"
+	"github.com/mhpenta/starterA/internal/auth/localauth"
 	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database/memdb"
 	httphandlers "github.com/mhpenta/starterA/internal/handlers/http"
+	"github.com/mhpenta/starterA/internal/service"
 )
 
 func TestRunServerReturnsListenError(t *testing.T) {
@@ -35,6 +39,7 @@ func TestRunServerReturnsListenError(t *testing.T) {
 		},
 		&app.Application{Logger: logger},
 		httphandlers.New(nil, logger),
+		nil,
 	)
 	if err == nil {
 		t.Fatal("expected listen error, got nil")
@@ -43,3 +48,34 @@ func TestRunServerReturnsListenError(t *testing.T) {
 		t.Fatalf("expected HTTP startup error, got %v", err)
 	}
 }
+
+func TestSetUserPasswordEnablesLogin(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := memdb.New()
+	svc := service.New(ctx, store, store, logger)
+	provider, err := localauth.New(store, localauth.Config{
+		Secret: []byte(strings.Repeat("s", 32)),
+		Argon2: localauth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		Logger: logger,
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	if _, err := svc.CreateUser(ctx, &service.CreateUserInput{Username: "marc", Email: "marc@example.com"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	if _, err := setUserPassword(ctx, svc, provider, "marc@example.com", strings.NewReader("short\n")); !errors.Is(err, localauth.ErrWeakPassword) {
+		t.Fatalf("weak password err = %v, want %v", err, localauth.ErrWeakPassword)
+	}
+	if _, err := setUserPassword(ctx, svc, provider, "nobody@example.com", strings.NewReader("correct horse battery\n")); !errors.Is(err, service.ErrUserNotFound) {
+		t.Fatalf("unknown user err = %v, want %v", err, service.ErrUserNotFound)
+	}
+	if _, err := setUserPassword(ctx, svc, provider, "marc@example.com", strings.NewReader("correct horse battery\r\n")); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	if _, err := provider.Login(ctx, "marc", "correct horse battery"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+}
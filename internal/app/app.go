@@ -5,10 +5,17 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/auth/dbsession"
+	"github.com/mhpenta/starterA/internal/auth/dbthrottle"
+	"github.com/mhpenta/starterA/internal/auth/magiclink"
+	"github.com/mhpenta/starterA/internal/auth/oauth"
 	"github.com/mhpenta/starterA/internal/config"
 	"github.com/mhpenta/starterA/internal/database"
 	"github.com/mhpenta/starterA/internal/database/repo"
+	"github.com/mhpenta/starterA/internal/mail"
 	"log/slog"
+	"time"
 )
 
 // Application is a dependency container for the application.
@@ -20,6 +27,18 @@ type Application struct {
 	Config *config.Config
 	DB     *repo.Queries
 	DBConn *sql.DB
+	Tx     database.TxStarter
+	// Auth verifies tokens and session cookies; nil when auth is disabled
+	Auth auth.Servicer
+	// Limiter throttles failed logins; nil when auth or throttling is disabled
+	Limiter *auth.Limiter
+	// Mailer sends email as configured by the [Mail] section
+	Mailer mail.Mailer
+	// MagicLinks sends passwordless login links; nil when disabled
+	MagicLinks *magiclink.Manager
+	// OAuth runs logins with external OAuth providers; nil when none are
+	// configured
+	OAuth *oauth.Manager
 }
 
 // New creates a new Application instance with the provided dependencies
@@ -30,14 +49,91 @@ func New(appCtx context.Context, logger *slog.Logger, cfg *config.Config) (*Appl
 		return nil, fmt.Errorf("error getting db connection: %w", err)
 	}
 
+	// Refuse to start against a schema that has drifted from the embedded
+	// migrations, applying any pending ones first when enabled
+	if cfg.Database.AutoMigrate {
+		err = database.Migrate(appCtx, dbConn, logger)
+	} else {
+		err = database.VerifyMigrations(appCtx, dbConn, logger)
+	}
+	if err != nil {
+		if closeErr := dbConn.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("closing database: %w", closeErr))
+		}
+		return nil, fmt.Errorf("error migrating database: %w", err)
+	}
+
 	db := repo.New(dbConn)
 
+	authProvider, err := newAuthProvider(cfg, db, logger)
+	if err != nil {
+		if closeErr := dbConn.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("closing database: %w", closeErr))
+		}
+		return nil, fmt.Errorf("error configuring auth: %w", err)
+	}
+	if authProvider == nil {
+		logger.Warn("Authentication is disabled; every route is public")
+	}
+	if usesSessionTable(authProvider) && cfg.Auth.SessionPurgeIntervalSeconds > 0 {
+		interval := time.Duration(cfg.Auth.SessionPurgeIntervalSeconds) * time.Second
+		go dbsession.New(db).RunPurger(appCtx, interval, logger)
+	}
+
+	// Throttle counters, magic links and OAuth states share one interval
+	purgeInterval := time.Duration(cfg.Auth.PurgeIntervalSeconds) * time.Second
+
+	var limiter *auth.Limiter
+	if authProvider != nil && cfg.Auth.Throttle {
+		limiter = auth.NewLimiter(dbthrottle.New(db), auth.LimiterConfig{
+			FreeAttempts:     cfg.Auth.ThrottleFreeAttempts,
+			LockoutThreshold: cfg.Auth.ThrottleLockoutThreshold,
+			LockoutDuration:  time.Duration(cfg.Auth.ThrottleLockoutSeconds) * time.Second,
+			Logger:           logger,
+		})
+		if cfg.Auth.PurgeIntervalSeconds > 0 {
+			go limiter.RunPurger(appCtx, purgeInterval)
+		}
+	}
+
+	var magicLinks *magiclink.Manager
+	mailer, err := newMailer(cfg.Mail, logger)
+	if err == nil {
+		magicLinks, err = newMagicLinks(cfg, authProvider, db, mailer, logger)
+	}
+	if err != nil {
+		if closeErr := dbConn.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("closing database: %w", closeErr))
+		}
+		return nil, fmt.Errorf("error configuring mail: %w", err)
+	}
+	if magicLinks != nil && cfg.Auth.PurgeIntervalSeconds > 0 {
+		go magicLinks.RunPurger(appCtx, purgeInterval)
+	}
+
+	oauthManager, err := newOAuth(cfg, authProvider, db, logger)
+	if err != nil {
+		if closeErr := dbConn.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("closing database: %w", closeErr))
+		}
+		return nil, fmt.Errorf("error configuring OAuth: %w", err)
+	}
+	if oauthManager != nil && cfg.Auth.PurgeIntervalSeconds > 0 {
+		go oauthManager.RunPurger(appCtx, purgeInterval)
+	}
+
 	return &Application{
-		AppCtx: appCtx,
-		Logger: logger,
-		Config: cfg,
-		DB:     db,
-		DBConn: dbConn,
+		AppCtx:     appCtx,
+		Logger:     logger,
+		Config:     cfg,
+		DB:         db,
+		DBConn:     dbConn,
+		Tx:         database.NewTxStarter(dbConn),
+		Auth:       authProvider,
+		Limiter:    limiter,
+		Mailer:     mailer,
+		MagicLinks: magicLinks,
+		OAuth:      oauthManager,
 	}, nil
 }
 
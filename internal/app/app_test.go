@@ -0,0 +1,231 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database/repo"
+	"github.com/mhpenta/starterA/internal/mail"
+)
+
+func TestNewWithInMemoryDatabaseRunsOffline(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{
+		Database: config.Database{
+			Mode:        config.DatabaseModeMemory,
+			AutoMigrate: true,
+		},
+	}
+
+	a, err := New(ctx, logger, cfg)
+	if err != nil {
+		t.Fatalf("new application: %v", err)
+	}
+	t.Cleanup(func() { _ = a.Close() })
+
+	user, err := a.DB.CreateUser(ctx, repo.CreateUserParams{
+		Username: "marc",
+		Email:    "marc@example.com",
+	})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	got, err := a.DB.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if got.Username != "marc" {
+		t.Fatalf("Username = %q, want marc", got.Username)
+	}
+}
+
+func TestNewConfiguresAuthProvider(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := map[string]struct {
+		provider    string
+		environment string
+		wantAuth    bool
+		wantErr     bool
+		wantErrIs   error
+	}{
+		"disabled":             {provider: config.AuthProviderNone, environment: config.DevelopmentEnvironment},
+		"mock in dev":          {provider: config.AuthProviderMock, environment: config.DevelopmentEnvironment, wantAuth: true},
+		"mock in prod":         {provider: config.AuthProviderMock, environment: config.ProductionEnvironment, wantErr: true, wantErrIs: ErrMockAuthInProduction},
+		"jwt without issuer":   {provider: config.AuthProviderJWT, environment: config.ProductionEnvironment, wantErr: true},
+		"local without secret": {provider: config.AuthProviderLocal, environment: config.DevelopmentEnvironment, wantErr: true},
+		"unknown provider":     {provider: "kerberos", environment: config.DevelopmentEnvironment, wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a, err := New(context.Background(), logger, &config.Config{
+				App:      config.App{Environment: tt.environment},
+				Auth:     config.Auth{Provider: tt.provider},
+				Database: config.Database{Mode: config.DatabaseModeMemory, AutoMigrate: true},
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErrIs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("new application: %v", err)
+			}
+			t.Cleanup(func() { _ = a.Close() })
+
+			if (a.Auth != nil) != tt.wantAuth {
+				t.Fatalf("Auth = %v, want configured = %v", a.Auth, tt.wantAuth)
+			}
+		})
+	}
+}
+
+func TestNewWrapsProviderWithServerSessions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	a, err := New(context.Background(), logger, &config.Config{
+		App:      config.App{Environment: config.DevelopmentEnvironment},
+		Auth:     config.Auth{Provider: config.AuthProviderMock, ServerSessions: true},
+		Database: config.Database{Mode: config.DatabaseModeMemory, AutoMigrate: true},
+	})
+	if err != nil {
+		t.Fatalf("new application: %v", err)
+	}
+	t.Cleanup(func() { _ = a.Close() })
+
+	manager, ok := a.Auth.(*auth.SessionManager)
+	if !ok {
+		t.Fatalf("Auth = %T, want *auth.SessionManager", a.Auth)
+	}
+	if _, ok := manager.Unwrap().(*auth.MockProvider); !ok {
+		t.Fatalf("Unwrap = %T, want *auth.MockProvider", manager.Unwrap())
+	}
+}
+
+func TestNewWrapsSessionsWithTokenCache(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	a, err := New(context.Background(), logger, &config.Config{
+		App:      config.App{Environment: config.DevelopmentEnvironment},
+		Auth:     config.Auth{Provider: config.AuthProviderMock, ServerSessions: true, TokenCache: true, APIKeys: true},
+		Database: config.Database{Mode: config.DatabaseModeMemory, AutoMigrate: true},
+	})
+	if err != nil {
+		t.Fatalf("new application: %v", err)
+	}
+	t.Cleanup(func() { _ = a.Close() })
+
+	cache, ok := auth.Lookup[*auth.TokenCache](a.Auth)
+	if !ok {
+		t.Fatalf("Auth = %T, want an *auth.TokenCache in the chain", a.Auth)
+	}
+	if _, ok := cache.Unwrap().(*auth.SessionManager); !ok {
+		t.Fatalf("Unwrap = %T, want *auth.SessionManager", cache.Unwrap())
+	}
+	if _, ok := auth.Lookup[auth.SessionRevoker](a.Auth); !ok {
+		t.Fatal("Lookup found no SessionRevoker through the cache")
+	}
+}
+
+func TestNewConfiguresMagicLinks(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	secret := strings.Repeat("s", 32)
+
+	a, err := New(context.Background(), logger, &config.Config{
+		Auth:     config.Auth{Provider: config.AuthProviderLocal, SessionSecret: secret, MagicLink: true},
+		Mail:     config.Mail{Driver: config.MailDriverFile, Dir: t.TempDir()},
+		Database: config.Database{Mode: config.DatabaseModeMemory, AutoMigrate: true},
+	})
+	if err != nil {
+		t.Fatalf("new application: %v", err)
+	}
+	t.Cleanup(func() { _ = a.Close() })
+	if a.MagicLinks == nil {
+		t.Fatal("MagicLinks = nil, want a manager")
+	}
+	if _, ok := a.Mailer.(*mail.FileMailer); !ok {
+		t.Fatalf("Mailer = %T, want *mail.FileMailer", a.Mailer)
+	}
+
+	// The mock provider cannot issue ID tokens for arbitrary users
+	_, err = New(context.Background(), logger, &config.Config{
+		Auth:     config.Auth{Provider: config.AuthProviderMock, MagicLink: true},
+		Database: config.Database{Mode: config.DatabaseModeMemory, AutoMigrate: true},
+	})
+	if err == nil {
+		t.Fatal("magic links with the mock provider succeeded, want error")
+	}
+}
+
+func TestMagicLinkURLDefaultsToServerDomain(t *testing.T) {
+	for want, cfg := range map[string]*config.Config{
+		"https://example.com/auth/magic-link/verify":   {Server: config.Server{ServerDomain: "example.com", Port: "8080"}},
+		"http://localhost:8080/auth/magic-link/verify": {Server: config.Server{Port: "8080"}},
+		"https://app.example.com/login/verify": {
+			Server: config.Server{ServerDomain: "example.com"},
+			Auth:   config.Auth{MagicLinkURL: "https://app.example.com/login/verify"},
+		},
+	} {
+		if got := magicLinkURL(cfg); got != want {
+			t.Fatalf("magicLinkURL = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestOAuthProviderConfigFillsSecretAndCallback(t *testing.T) {
+	t.Setenv("AUTH_OAUTH_MY_IDP_CLIENT_SECRET", "from-env")
+	cfg := &config.Config{Server: config.Server{ServerDomain: "example.com"}}
+
+	p := oauthProviderConfig(cfg, "my-idp", config.OAuthProvider{Issuer: "https://idp.example.com", ClientID: "starter-app"})
+	if p.ClientSecret != "from-env" {
+		t.Fatalf("ClientSecret = %q, want from-env", p.ClientSecret)
+	}
+	if p.RedirectURL != "https://example.com/auth/my-idp/callback" {
+		t.Fatalf("RedirectURL = %q", p.RedirectURL)
+	}
+
+	p = oauthProviderConfig(cfg, "my-idp", config.OAuthProvider{ClientSecret: "from-file", RedirectURL: "https://app.example.com/cb"})
+	if p.ClientSecret != "from-file" || p.RedirectURL != "https://app.example.com/cb" {
+		t.Fatalf("explicit settings were replaced: %+v", p)
+	}
+}
+
+func TestNewRequiresIDTokenIssuerForOAuth(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	providers := map[string]config.OAuthProvider{
+		"google": {Issuer: "https://accounts.google.com", ClientID: "starter-app"},
+	}
+
+	a, err := New(context.Background(), logger, &config.Config{
+		Auth:     config.Auth{Provider: config.AuthProviderLocal, SessionSecret: strings.Repeat("s", 32), OAuthProviders: providers},
+		Database: config.Database{Mode: config.DatabaseModeMemory, AutoMigrate: true},
+	})
+	if err != nil {
+		t.Fatalf("new application: %v", err)
+	}
+	t.Cleanup(func() { _ = a.Close() })
+	if a.OAuth == nil || len(a.OAuth.Providers()) != 1 {
+		t.Fatalf("OAuth = %v, want a manager for google", a.OAuth)
+	}
+
+	_, err = New(context.Background(), logger, &config.Config{
+		Auth:     config.Auth{Provider: config.AuthProviderMock, OAuthProviders: providers},
+		Database: config.Database{Mode: config.DatabaseModeMemory, AutoMigrate: true},
+	})
+	if err == nil {
+		t.Fatal("OAuth with the mock provider succeeded, want error")
+	}
+}
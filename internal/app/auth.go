@@ -0,0 +1,103 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/auth/apikey"
+	"github.com/mhpenta/starterA/internal/auth/dbsession"
+	"github.com/mhpenta/starterA/internal/auth/localauth"
+	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+var ErrMockAuthInProduction = errors.New("the mock auth provider cannot be used in production")
+
+// newAuthProvider builds the auth.Servicer selected by the [Auth] config
+// section, wrapped in an auth.SessionManager when ServerSessions is on, an
+// auth.TokenCache when TokenCache is on and accepting API keys when APIKeys
+// is on. It returns nil when authentication is disabled.
+func newAuthProvider(cfg *config.Config, queries repo.Store, logger *slog.Logger) (auth.Servicer, error) {
+	provider, err := newBaseAuthProvider(cfg, queries, logger)
+	if err != nil || provider == nil {
+		return provider, err
+	}
+
+	// The local provider already keeps its sessions in the database
+	if cfg.Auth.ServerSessions && cfg.Auth.Provider != config.AuthProviderLocal {
+		provider = auth.NewSessionManager(provider, dbsession.New(queries), auth.SessionManagerConfig{
+			IdleTimeout: time.Duration(cfg.Auth.SessionLifetimeSeconds) * time.Second,
+			MaxLifetime: time.Duration(cfg.Auth.SessionMaxLifetimeSeconds) * time.Second,
+			Logger:      logger,
+		})
+	}
+
+	// Below API keys, which have their own lookups, so only tokens and
+	// cookies are cached
+	if cfg.Auth.TokenCache {
+		provider = auth.NewTokenCache(provider, auth.TokenCacheConfig{
+			TTL:         time.Duration(cfg.Auth.TokenCacheTTLSeconds) * time.Second,
+			NegativeTTL: time.Duration(cfg.Auth.TokenCacheNegativeTTLSeconds) * time.Second,
+			MaxEntries:  cfg.Auth.TokenCacheSize,
+		})
+	}
+
+	if cfg.Auth.APIKeys {
+		provider = auth.WithAPIKeys(provider, apikey.NewVerifier(queries, apikey.Config{Logger: logger}))
+	}
+
+	return provider, nil
+}
+
+// usesSessionTable reports whether provider keeps sessions in the sessions
+// table, which then needs purging
+func usesSessionTable(provider auth.Servicer) bool {
+	if _, ok := auth.Lookup[*auth.SessionManager](provider); ok {
+		return true
+	}
+	_, ok := auth.Lookup[*localauth.Provider](provider)
+	return ok
+}
+
+// newBaseAuthProvider builds the provider named by cfg.Auth.Provider
+func newBaseAuthProvider(cfg *config.Config, queries repo.Store, logger *slog.Logger) (auth.Servicer, error) {
+	switch cfg.Auth.Provider {
+	case config.AuthProviderNone, "":
+		return nil, nil
+	case config.AuthProviderMock:
+		if cfg.App.Environment == config.ProductionEnvironment {
+			return nil, ErrMockAuthInProduction
+		}
+		return auth.NewMockProvider(), nil
+	case config.AuthProviderJWT:
+		return auth.NewJWTProvider(auth.JWTConfig{
+			Issuer:    cfg.Auth.Issuer,
+			Audience:  cfg.Auth.Audience,
+			JWKSURL:   cfg.Auth.JWKSURL,
+			ClockSkew: time.Duration(cfg.Auth.ClockSkewSeconds) * time.Second,
+			CacheTTL:  time.Duration(cfg.Auth.JWKSCacheTTLSeconds) * time.Second,
+		})
+	case config.AuthProviderLocal:
+		params := localauth.DefaultArgon2Params
+		if cfg.Auth.Argon2MemoryKiB > 0 {
+			params.Memory = cfg.Auth.Argon2MemoryKiB
+		}
+		if cfg.Auth.Argon2Iterations > 0 {
+			params.Iterations = cfg.Auth.Argon2Iterations
+		}
+		if cfg.Auth.Argon2Parallelism > 0 {
+			params.Parallelism = cfg.Auth.Argon2Parallelism
+		}
+		return localauth.New(queries, localauth.Config{
+			Secret: []byte(cfg.Auth.SessionSecret),
+			Argon2: params,
+			Policy: localauth.PasswordPolicy{MinLength: cfg.Auth.PasswordMinLength},
+			Logger: logger,
+		})
+	default:
+		return nil, fmt.Errorf("unknown auth provider %q", cfg.Auth.Provider)
+	}
+}
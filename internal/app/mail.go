@@ -0,0 +1,72 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/auth/magiclink"
+	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database/repo"
+	"github.com/mhpenta/starterA/internal/mail"
+)
+
+// newMailer builds the mail.Mailer selected by the [Mail] config section
+func newMailer(cfg config.Mail, logger *slog.Logger) (mail.Mailer, error) {
+	switch cfg.Driver {
+	case config.MailDriverLog, "":
+		return mail.NewLogMailer(logger), nil
+	case config.MailDriverFile:
+		return mail.NewFileMailer(cfg.Dir, cfg.From)
+	case config.MailDriverSMTP:
+		return mail.NewSMTPMailer(mail.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		})
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// newMagicLinks builds the magic link manager when MagicLink is on. It
+// needs a provider that can turn a verified user into a session.
+func newMagicLinks(cfg *config.Config, provider auth.Servicer, queries repo.Store, mailer mail.Mailer, logger *slog.Logger) (*magiclink.Manager, error) {
+	if !cfg.Auth.MagicLink || provider == nil {
+		return nil, nil
+	}
+	if _, ok := auth.Lookup[auth.IDTokenIssuer](provider); !ok {
+		return nil, fmt.Errorf("magic links need a provider that issues ID tokens, such as %q", config.AuthProviderLocal)
+	}
+
+	return magiclink.New(queries, magiclink.Config{
+		URL:    magicLinkURL(cfg),
+		Mailer: mailer,
+		TTL:    time.Duration(cfg.Auth.MagicLinkTTLSeconds) * time.Second,
+		Logger: logger,
+	})
+}
+
+// magicLinkURL is Auth.MagicLinkURL, or the verify endpoint's publicURL
+func magicLinkURL(cfg *config.Config) string {
+	if cfg.Auth.MagicLinkURL != "" {
+		return cfg.Auth.MagicLinkURL
+	}
+	return publicURL(cfg, "/auth/magic-link/verify")
+}
+
+// publicURL is the absolute address of path on Server.ServerDomain, or on
+// localhost when no domain is set
+func publicURL(cfg *config.Config, path string) string {
+	u := url.URL{Scheme: "https", Host: cfg.Server.ServerDomain, Path: path}
+	if cfg.Server.ServerDomain == "" {
+		u.Scheme = "http"
+		u.Host = net.JoinHostPort("localhost", cfg.Server.Port)
+	}
+	return u.String()
+}
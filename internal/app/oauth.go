@@ -0,0 +1,58 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/auth/oauth"
+	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+// newOAuth builds the OAuth manager when providers are configured. Like
+// magic links, it needs a provider that can turn a local user into a
+// session.
+func newOAuth(cfg *config.Config, provider auth.Servicer, queries repo.Store, logger *slog.Logger) (*oauth.Manager, error) {
+	if len(cfg.Auth.OAuthProviders) == 0 || provider == nil {
+		return nil, nil
+	}
+	if _, ok := auth.Lookup[auth.IDTokenIssuer](provider); !ok {
+		return nil, fmt.Errorf("OAuth login needs a provider that issues ID tokens, such as %q", config.AuthProviderLocal)
+	}
+
+	providers := make([]oauth.ProviderConfig, 0, len(cfg.Auth.OAuthProviders))
+	for name, p := range cfg.Auth.OAuthProviders {
+		providers = append(providers, oauthProviderConfig(cfg, name, p))
+	}
+
+	return oauth.New(queries, oauth.Config{Providers: providers, Logger: logger})
+}
+
+// oauthProviderConfig fills in the client secret from the environment and
+// the callback address from publicURL when the config leaves them empty
+func oauthProviderConfig(cfg *config.Config, name string, p config.OAuthProvider) oauth.ProviderConfig {
+	secret := p.ClientSecret
+	if secret == "" {
+		env := "AUTH_OAUTH_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_CLIENT_SECRET"
+		secret = os.Getenv(env)
+	}
+	redirectURL := p.RedirectURL
+	if redirectURL == "" {
+		redirectURL = publicURL(cfg, "/auth/"+name+"/callback")
+	}
+
+	return oauth.ProviderConfig{
+		Name:         name,
+		Issuer:       p.Issuer,
+		ClientID:     p.ClientID,
+		ClientSecret: secret,
+		AuthURL:      p.AuthURL,
+		TokenURL:     p.TokenURL,
+		JWKSURL:      p.JWKSURL,
+		Scopes:       p.Scopes,
+		RedirectURL:  redirectURL,
+	}
+}
@@ -0,0 +1,181 @@
+// Package apikey generates and verifies API keys for machine clients.
+//
+// A key looks like sk_<prefix>_<secret>. The 12 hex character prefix is
+// stored in the clear so keys can be looked up and recognised in listings;
+// only the SHA-256 of the random secret is stored. Secrets carry 256 bits of
+// entropy, so a fast hash is enough.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+const (
+	keyPrefix    = "sk_"
+	prefixLength = 12
+
+	// DefaultTouchInterval limits how often last_used_at is written for a
+	// busy key.
+	DefaultTouchInterval = time.Minute
+)
+
+// ErrMalformedKey is returned by Parse for strings that are not API keys
+var ErrMalformedKey = errors.New("apikey: malformed key")
+
+// Key is a newly generated API key
+type Key struct {
+	// Prefix identifies the key and is safe to display
+	Prefix string
+	// SecretHash is what gets stored
+	SecretHash string
+	// Plaintext is the full key, shown to the caller once
+	Plaintext string
+}
+
+// Generate creates a random API key
+func Generate() (Key, error) {
+	prefix := make([]byte, prefixLength/2)
+	if _, err := rand.Read(prefix); err != nil {
+		return Key{}, err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, err
+	}
+
+	encodedPrefix := hex.EncodeToString(prefix)
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+	return Key{
+		Prefix:     encodedPrefix,
+		SecretHash: HashSecret(encodedSecret),
+		Plaintext:  keyPrefix + encodedPrefix + "_" + encodedSecret,
+	}, nil
+}
+
+// Parse splits an API key into its prefix and secret. The secret may itself
+// contain underscores, so the prefix is read by position.
+func Parse(key string) (prefix, secret string, err error) {
+	rest, ok := strings.CutPrefix(key, keyPrefix)
+	if !ok || len(rest) < prefixLength+2 || rest[prefixLength] != '_' {
+		return "", "", ErrMalformedKey
+	}
+	prefix, secret = rest[:prefixLength], rest[prefixLength+1:]
+	if _, err := hex.DecodeString(prefix); err != nil {
+		return "", "", ErrMalformedKey
+	}
+	return prefix, secret, nil
+}
+
+// HashSecret returns the stored form of a key secret
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Store is the subset of repo.Store used by Verifier
+type Store interface {
+	GetApiKeyByPrefix(ctx context.Context, prefix string) (repo.ApiKey, error)
+	TouchApiKey(ctx context.Context, arg repo.TouchApiKeyParams) (int64, error)
+}
+
+// Config configures a Verifier
+type Config struct {
+	// TouchInterval is zero for DefaultTouchInterval
+	TouchInterval time.Duration
+	// Now returns the current time; nil means time.Now
+	Now    func() time.Time
+	Logger *slog.Logger
+}
+
+// Verifier checks API keys against the api_keys table. It implements
+// auth.APIKeyVerifier; combine it with a provider using auth.WithAPIKeys.
+type Verifier struct {
+	store         Store
+	touchInterval time.Duration
+	now           func() time.Time
+	logger        *slog.Logger
+}
+
+// NewVerifier creates a Verifier
+func NewVerifier(store Store, cfg Config) *Verifier {
+	if cfg.TouchInterval <= 0 {
+		cfg.TouchInterval = DefaultTouchInterval
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	return &Verifier{
+		store:         store,
+		touchInterval: cfg.TouchInterval,
+		now:           cfg.Now,
+		logger:        cfg.Logger,
+	}
+}
+
+// VerifyAPIKey returns a token for a valid, unexpired key. The token's UID
+// is "apikey:<id>" and its claims carry the key's scopes and prefix.
+func (v *Verifier) VerifyAPIKey(ctx context.Context, key string) (*auth.Token, error) {
+	prefix, secret, err := Parse(key)
+	if err != nil {
+		return nil, auth.ErrMalformedToken
+	}
+
+	stored, err := v.store.GetApiKeyByPrefix(ctx, prefix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(HashSecret(secret)), []byte(stored.SecretHash)) != 1 {
+		return nil, auth.ErrInvalidToken
+	}
+
+	now := v.now()
+	if stored.ExpiresAt.Valid && !now.Before(stored.ExpiresAt.Time) {
+		return nil, auth.ErrExpiredToken
+	}
+
+	if !stored.LastUsedAt.Valid || now.Sub(stored.LastUsedAt.Time) >= v.touchInterval {
+		if _, err := v.store.TouchApiKey(ctx, repo.TouchApiKeyParams{
+			LastUsedAt: sql.NullTime{Time: now.UTC(), Valid: true},
+			ID:         stored.ID,
+		}); err != nil {
+			v.logger.Warn("Failed to record API key use", "prefix", stored.Prefix, "error", err)
+		}
+	}
+
+	token := &auth.Token{
+		UID: "apikey:" + strconv.FormatInt(stored.ID, 10),
+		Claims: map[string]interface{}{
+			auth.ClaimAPIKey: stored.Prefix,
+			"scope":          stored.Scopes,
+			"name":           stored.Name,
+		},
+		IssuedAt: stored.CreatedAt,
+	}
+	if stored.ExpiresAt.Valid {
+		token.Expiry = stored.ExpiresAt.Time
+	}
+	return token, nil
+}
+
+var _ auth.APIKeyVerifier = (*Verifier)(nil)
@@ -0,0 +1,112 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/database/memdb"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func TestGenerateAndParse(t *testing.T) {
+	key, err := Generate()
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if !strings.HasPrefix(key.Plaintext, "sk_"+key.Prefix+"_") {
+		t.Fatalf("Plaintext = %q, want sk_%s_ prefix", key.Plaintext, key.Prefix)
+	}
+
+	prefix, secret, err := Parse(key.Plaintext)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if prefix != key.Prefix || HashSecret(secret) != key.SecretHash {
+		t.Fatalf("Parse = %q, %q; want prefix %q and matching secret", prefix, secret, key.Prefix)
+	}
+
+	// Secrets are base64url and may contain underscores
+	if _, secret, err := Parse("sk_0123456789ab_a_b_c"); err != nil || secret != "a_b_c" {
+		t.Fatalf("Parse = %q, %v; want a_b_c", secret, err)
+	}
+
+	for _, bad := range []string{"", "sk_", "pk_0123456789ab_secret", "sk_0123456789ab", "sk_0123456789abXsecret", "sk_zzzzzzzzzzzz_secret"} {
+		if _, _, err := Parse(bad); !errors.Is(err, ErrMalformedKey) {
+			t.Errorf("Parse(%q) err = %v, want %v", bad, err, ErrMalformedKey)
+		}
+	}
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.New()
+	now := time.Now()
+	verifier := NewVerifier(store, Config{
+		Now:    func() time.Time { return now },
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	key := createKey(t, store, "users:read", sql.NullTime{Time: now.Add(time.Hour), Valid: true})
+
+	token, err := verifier.VerifyAPIKey(ctx, key.Plaintext)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if token.Claims[auth.ClaimAPIKey] != key.Prefix || token.Claims["scope"] != "users:read" {
+		t.Fatalf("claims = %v, want key prefix and scope", token.Claims)
+	}
+	if err := auth.HasAnyScope("users:read").Check(token); err != nil {
+		t.Fatalf("scope check: %v", err)
+	}
+
+	stored, err := store.GetApiKeyByPrefix(ctx, key.Prefix)
+	if err != nil {
+		t.Fatalf("get key: %v", err)
+	}
+	if !stored.LastUsedAt.Valid {
+		t.Fatal("LastUsedAt not recorded")
+	}
+
+	wrongSecret := key.Plaintext[:len(key.Plaintext)-1] + "x"
+	if strings.HasSuffix(key.Plaintext, "x") {
+		wrongSecret = key.Plaintext[:len(key.Plaintext)-1] + "y"
+	}
+	if _, err := verifier.VerifyAPIKey(ctx, wrongSecret); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Fatalf("wrong secret err = %v, want %v", err, auth.ErrInvalidToken)
+	}
+	if _, err := verifier.VerifyAPIKey(ctx, "not-a-key"); !auth.IsInvalidError(err) {
+		t.Fatalf("malformed err = %v, want invalid", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := verifier.VerifyAPIKey(ctx, key.Plaintext); !errors.Is(err, auth.ErrExpiredToken) {
+		t.Fatalf("expired err = %v, want %v", err, auth.ErrExpiredToken)
+	}
+}
+
+func createKey(t *testing.T, store *memdb.Store, scopes string, expiresAt sql.NullTime) Key {
+	t.Helper()
+
+	key, err := Generate()
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if _, err := store.CreateApiKey(context.Background(), repo.CreateApiKeyParams{
+		Prefix:     key.Prefix,
+		SecretHash: key.SecretHash,
+		Name:       "batch",
+		Scopes:     scopes,
+		CreatedBy:  "user-admin",
+		ExpiresAt:  expiresAt,
+	}); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	return key
+}
@@ -0,0 +1,38 @@
+package auth
+
+import "context"
+
+// apiKeyProvider adds API key verification to a provider that lacks it
+type apiKeyProvider struct {
+	Servicer
+	keys APIKeyVerifier
+}
+
+// WithAPIKeys returns provider extended to accept API keys checked by keys.
+// Other capabilities of provider stay reachable through Lookup.
+func WithAPIKeys(provider Servicer, keys APIKeyVerifier) Servicer {
+	return &apiKeyProvider{Servicer: provider, keys: keys}
+}
+
+// VerifyAPIKey delegates to the API key verifier.
+func (p *apiKeyProvider) VerifyAPIKey(ctx context.Context, key string) (*Token, error) {
+	return p.keys.VerifyAPIKey(ctx, key)
+}
+
+// Unwrap returns the decorated provider.
+func (p *apiKeyProvider) Unwrap() Servicer {
+	return p.Servicer
+}
+
+// APIKeyScopes allows tokens from API keys only if they hold one of the
+// given scopes. Other tokens are not limited by it, so it can guard routes
+// that users reach interactively and batch jobs reach with keys.
+func APIKeyScopes(scopes ...string) Policy {
+	scoped := HasAnyScope(scopes...)
+	return func(token *Token) error {
+		if _, ok := token.Claims[ClaimAPIKey]; !ok {
+			return nil
+		}
+		return scoped(token)
+	}
+}
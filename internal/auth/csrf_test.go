@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	const cookie = "session-value"
+	token := CSRFTokenForSession(cookie)
+	handler := CSRFProtect(CSRFConfig{TrustedOrigins: []string{"https://App.example.com/", "*"}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		cookie  bool
+		bearer  bool
+		origin  string
+		referer string
+		header  string
+		form    string
+		want    int
+	}{
+		{name: "safe method", method: http.MethodGet, cookie: true, want: http.StatusNoContent},
+		{name: "bearer token", method: http.MethodPost, bearer: true, cookie: true, origin: "https://evil.example", want: http.StatusNoContent},
+		{name: "unauthenticated", method: http.MethodPost, want: http.StatusNoContent},
+		{name: "cookie without token", method: http.MethodPost, cookie: true, want: http.StatusForbidden},
+		{name: "cookie with wrong token", method: http.MethodPost, cookie: true, header: "nope", want: http.StatusForbidden},
+		{name: "cookie with header token", method: http.MethodPut, cookie: true, header: token, want: http.StatusNoContent},
+		{name: "cookie with form token", method: http.MethodPost, cookie: true, form: token, want: http.StatusNoContent},
+		{name: "same origin", method: http.MethodDelete, cookie: true, origin: "http://example.com", header: token, want: http.StatusNoContent},
+		{name: "trusted origin", method: http.MethodPost, cookie: true, origin: "https://app.example.com", header: token, want: http.StatusNoContent},
+		{name: "foreign origin", method: http.MethodPost, cookie: true, origin: "https://evil.example", header: token, want: http.StatusForbidden},
+		{name: "null origin", method: http.MethodPost, cookie: true, origin: "null", header: token, want: http.StatusForbidden},
+		{name: "trusted referer", method: http.MethodPost, cookie: true, referer: "https://app.example.com/users?page=2", header: token, want: http.StatusNoContent},
+		{name: "foreign referer", method: http.MethodPost, cookie: true, referer: "https://evil.example/form", header: token, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body string
+			if tt.form != "" {
+				body = url.Values{CSRFFormField: {tt.form}}.Encode()
+			}
+			req := httptest.NewRequest(tt.method, "http://example.com/api/users", strings.NewReader(body))
+			if tt.form != "" {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: cookie})
+			}
+			if tt.bearer {
+				req.Header.Set(AuthHeader, BearerPrefix+"test-token-1")
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				req.Header.Set("Referer", tt.referer)
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if got := CSRFToken(req); got != "" {
+		t.Fatalf("CSRFToken without cookie = %q, want empty", got)
+	}
+
+	req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: "one"})
+	if got := CSRFToken(req); got != CSRFTokenForSession("one") || got == CSRFTokenForSession("two") {
+		t.Fatalf("CSRFToken = %q, want the token for the session", got)
+	}
+}
@@ -0,0 +1,168 @@
+// Package dbsession stores auth.SessionManager sessions in the sessions
+// table, which it shares with the local provider. Both key sessions by the
+// SHA-256 of the cookie secret and by subject UID, so revoking a user's
+// sessions works the same whichever issued them.
+package dbsession
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+// Queries is the subset of repo.Store used by Store
+type Queries interface {
+	CreateSession(ctx context.Context, arg repo.CreateSessionParams) (repo.Session, error)
+	GetSession(ctx context.Context, id string) (repo.Session, error)
+	ListSubjectSessions(ctx context.Context, subject string) ([]repo.Session, error)
+	TouchSession(ctx context.Context, arg repo.TouchSessionParams) (int64, error)
+	RevokeSession(ctx context.Context, id string) (int64, error)
+	RevokeSubjectSessions(ctx context.Context, subject string) (int64, error)
+	MarkSessionMFA(ctx context.Context, arg repo.MarkSessionMFAParams) (int64, error)
+	DeleteExpiredSessions(ctx context.Context, expiresAt time.Time) (int64, error)
+}
+
+// Store implements auth.SessionStore on top of the generated queries
+type Store struct {
+	queries Queries
+}
+
+// New creates a Store
+func New(queries Queries) *Store {
+	return &Store{queries: queries}
+}
+
+// CreateSession inserts a session. CreatedAt is set by the database.
+func (s *Store) CreateSession(ctx context.Context, session auth.SessionRecord) error {
+	_, err := s.queries.CreateSession(ctx, repo.CreateSessionParams{
+		ID:                 session.ID,
+		Subject:            session.UID,
+		ExpiresAt:          session.ExpiresAt.UTC(),
+		IdleTimeoutSeconds: int64(session.IdleTimeout / time.Second),
+		Ip:                 session.Metadata.IP,
+		UserAgent:          session.Metadata.UserAgent,
+		Device:             session.Metadata.Device,
+	})
+	return err
+}
+
+// GetSession returns auth.ErrSessionNotFound for an unknown ID
+func (s *Store) GetSession(ctx context.Context, id string) (*auth.SessionRecord, error) {
+	session, err := s.queries.GetSession(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := toRecord(session)
+	return &record, nil
+}
+
+// ListUserSessions returns the sessions of a subject, newest first
+func (s *Store) ListUserSessions(ctx context.Context, uid string) ([]auth.SessionRecord, error) {
+	sessions, err := s.queries.ListSubjectSessions(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]auth.SessionRecord, 0, len(sessions))
+	for _, session := range sessions {
+		records = append(records, toRecord(session))
+	}
+	return records, nil
+}
+
+// TouchSession records a use of a live session and its new expiry.
+// Revoked and deleted sessions are left alone.
+func (s *Store) TouchSession(ctx context.Context, id string, lastSeenAt, expiresAt time.Time) error {
+	_, err := s.queries.TouchSession(ctx, repo.TouchSessionParams{
+		LastSeenAt: sql.NullTime{Time: lastSeenAt.UTC(), Valid: true},
+		ExpiresAt:  expiresAt.UTC(),
+		ID:         id,
+	})
+	return err
+}
+
+// RevokeSession revokes one session; revoking an unknown or already
+// revoked session is not an error
+func (s *Store) RevokeSession(ctx context.Context, id string) error {
+	_, err := s.queries.RevokeSession(ctx, id)
+	return err
+}
+
+// RevokeUserSessions revokes every live session of a subject
+func (s *Store) RevokeUserSessions(ctx context.Context, uid string) (int64, error) {
+	return s.queries.RevokeSubjectSessions(ctx, uid)
+}
+
+// MarkSessionMFA records a second factor on a live session; revoked and
+// deleted sessions are left alone
+func (s *Store) MarkSessionMFA(ctx context.Context, id string, at time.Time) error {
+	_, err := s.queries.MarkSessionMFA(ctx, repo.MarkSessionMFAParams{
+		MfaVerifiedAt: sql.NullTime{Time: at.UTC(), Valid: true},
+		ID:            id,
+	})
+	return err
+}
+
+// PurgeExpiredSessions deletes sessions that expired before the given time,
+// revoked or not
+func (s *Store) PurgeExpiredSessions(ctx context.Context, before time.Time) (int64, error) {
+	return s.queries.DeleteExpiredSessions(ctx, before.UTC())
+}
+
+// RunPurger calls PurgeExpiredSessions every interval until ctx is done
+func (s *Store) RunPurger(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.PurgeExpiredSessions(ctx, time.Now())
+			if err != nil {
+				logger.Error("Failed to purge expired sessions", "error", err)
+				continue
+			}
+			if purged > 0 {
+				logger.Info("Purged expired sessions", "count", purged)
+			}
+		}
+	}
+}
+
+func toRecord(session repo.Session) auth.SessionRecord {
+	record := auth.SessionRecord{
+		ID:  session.ID,
+		UID: session.Subject,
+		Metadata: auth.SessionMetadata{
+			IP:        session.Ip,
+			UserAgent: session.UserAgent,
+			Device:    session.Device,
+		},
+		CreatedAt:   session.CreatedAt,
+		ExpiresAt:   session.ExpiresAt,
+		IdleTimeout: time.Duration(session.IdleTimeoutSeconds) * time.Second,
+	}
+	if session.LastSeenAt.Valid {
+		record.LastSeenAt = session.LastSeenAt.Time
+	}
+	if session.RevokedAt.Valid {
+		record.RevokedAt = session.RevokedAt.Time
+	}
+	if session.MfaVerifiedAt.Valid {
+		record.MFAAt = session.MfaVerifiedAt.Time
+	}
+	return record
+}
+
+var _ auth.SessionStore = (*Store)(nil)
@@ -0,0 +1,284 @@
+package dbsession
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database"
+	"github.com/mhpenta/starterA/internal/database/memdb"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func TestSessionManagerRevokesSessions(t *testing.T) {
+	for name, queries := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			manager, _ := newTestManager(queries)
+
+			req := httptest.NewRequest("POST", "/auth/session", nil)
+			req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0) Mobile/15E148")
+			phone, err := manager.CreateSessionCookie(auth.ContextWithSessionMetadata(ctx, auth.SessionMetadataFromRequest(req)), "test-token-1", time.Hour)
+			if err != nil {
+				t.Fatalf("create session: %v", err)
+			}
+			laptop, err := manager.CreateSessionCookie(ctx, "test-token-1", time.Hour)
+			if err != nil {
+				t.Fatalf("create session: %v", err)
+			}
+
+			token, err := manager.VerifySessionCookieAndCheckRevoked(ctx, phone)
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if token.UID != "user-1" {
+				t.Fatalf("UID = %q, want user-1", token.UID)
+			}
+
+			sessions, err := manager.ListSessions(ctx, "user-1")
+			if err != nil {
+				t.Fatalf("list sessions: %v", err)
+			}
+			if len(sessions) != 2 {
+				t.Fatalf("len(sessions) = %d, want 2", len(sessions))
+			}
+			var devices []string
+			for _, s := range sessions {
+				devices = append(devices, s.Metadata.Device)
+				if s.IdleTimeout != time.Hour {
+					t.Fatalf("IdleTimeout = %v, want 1h", s.IdleTimeout)
+				}
+			}
+			if !strings.Contains(strings.Join(devices, ","), "mobile") {
+				t.Fatalf("devices = %v, want a mobile session", devices)
+			}
+
+			if err := manager.RevokeSessionCookie(ctx, phone); err != nil {
+				t.Fatalf("revoke: %v", err)
+			}
+			if _, err := manager.VerifySessionCookie(ctx, phone); !errors.Is(err, auth.ErrRevokedSessionCookie) {
+				t.Fatalf("err = %v, want %v", err, auth.ErrRevokedSessionCookie)
+			}
+			if _, err := manager.VerifySessionCookie(ctx, laptop); err != nil {
+				t.Fatalf("other session: %v", err)
+			}
+
+			if err := manager.RevokeUserSessions(ctx, "user-1"); err != nil {
+				t.Fatalf("revoke user sessions: %v", err)
+			}
+			if _, err := manager.VerifySessionCookieAndCheckRevoked(ctx, laptop); !auth.IsRevokedError(err) {
+				t.Fatalf("err = %v, want revoked", err)
+			}
+		})
+	}
+}
+
+func TestSessionManagerSlidesExpiration(t *testing.T) {
+	for name, queries := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			manager, clock := newTestManager(queries)
+
+			cookie, err := manager.CreateSessionCookie(ctx, "test-token-1", time.Hour)
+			if err != nil {
+				t.Fatalf("create session: %v", err)
+			}
+
+			// Regular use keeps the session alive past its first expiry...
+			for i := 0; i < 3; i++ {
+				clock.Advance(50 * time.Minute)
+				if _, err := manager.VerifySessionCookie(ctx, cookie); err != nil {
+					t.Fatalf("use %d: %v", i, err)
+				}
+			}
+
+			// ...up to MaxLifetime after creation
+			clock.Advance(50 * time.Minute)
+			if _, err := manager.VerifySessionCookie(ctx, cookie); !errors.Is(err, auth.ErrExpiredSessionCookie) {
+				t.Fatalf("err = %v, want %v", err, auth.ErrExpiredSessionCookie)
+			}
+
+			idle, err := manager.CreateSessionCookie(ctx, "test-token-1", time.Hour)
+			if err != nil {
+				t.Fatalf("create session: %v", err)
+			}
+			clock.Advance(61 * time.Minute)
+			if _, err := manager.VerifySessionCookie(ctx, idle); !auth.IsExpiredError(err) {
+				t.Fatalf("idle session err = %v, want expired", err)
+			}
+		})
+	}
+}
+
+func TestTokenCacheKeepsSessionExpirySliding(t *testing.T) {
+	for name, queries := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			manager, clock := newTestManager(queries)
+			cache := auth.NewTokenCache(manager, auth.TokenCacheConfig{TTL: 2 * time.Hour, Now: clock.Now})
+
+			cookie, err := manager.CreateSessionCookie(ctx, "test-token-1", 30*time.Minute)
+			if err != nil {
+				t.Fatalf("create session: %v", err)
+			}
+
+			// Uses answered from the cache still slide the idle timeout
+			for i := 0; i < 6; i++ {
+				clock.Advance(20 * time.Minute)
+				if _, err := cache.VerifySessionCookie(ctx, cookie); err != nil {
+					t.Fatalf("use %d: %v", i, err)
+				}
+			}
+			if _, err := manager.VerifySessionCookie(ctx, cookie); err != nil {
+				t.Fatalf("session after cached uses: %v", err)
+			}
+		})
+	}
+}
+
+func TestSessionManagerPurgesExpiredSessions(t *testing.T) {
+	for name, queries := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			manager, clock := newTestManager(queries)
+
+			old, err := manager.CreateSessionCookie(ctx, "test-token-1", time.Hour)
+			if err != nil {
+				t.Fatalf("create session: %v", err)
+			}
+			clock.Advance(2 * time.Hour)
+			if _, err := manager.CreateSessionCookie(ctx, "test-token-1", time.Hour); err != nil {
+				t.Fatalf("create session: %v", err)
+			}
+
+			purged, err := manager.PurgeExpired(ctx)
+			if err != nil {
+				t.Fatalf("purge: %v", err)
+			}
+			if purged != 1 {
+				t.Fatalf("purged = %d, want 1", purged)
+			}
+			if _, err := manager.VerifySessionCookie(ctx, old); !errors.Is(err, auth.ErrInvalidSessionCookie) {
+				t.Fatalf("err = %v, want %v", err, auth.ErrInvalidSessionCookie)
+			}
+			if sessions, _ := manager.ListSessions(ctx, "user-1"); len(sessions) != 1 {
+				t.Fatalf("len(sessions) = %d, want 1", len(sessions))
+			}
+		})
+	}
+}
+
+func TestSessionManagerRejectsSwappedInnerCookie(t *testing.T) {
+	ctx := context.Background()
+	manager, _ := newTestManager(memdb.New())
+
+	cookie, err := manager.CreateSessionCookie(ctx, "test-token-1", time.Hour)
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	// A session secret only vouches for the user it was issued to
+	secret, _, _ := strings.Cut(cookie, ".")
+	for _, forged := range []string{secret + ".test-token-admin", secret, "." + "test-token-1"} {
+		if _, err := manager.VerifySessionCookie(ctx, forged); !auth.IsInvalidError(err) {
+			t.Fatalf("%q: err = %v, want invalid", forged, err)
+		}
+	}
+}
+
+func TestSessionManagerRecordsStepUp(t *testing.T) {
+	for name, queries := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			manager, clock := newTestManager(queries)
+
+			cookie, err := manager.CreateSessionCookie(ctx, "test-token-admin", time.Hour)
+			if err != nil {
+				t.Fatalf("create session: %v", err)
+			}
+			token, err := manager.VerifySessionCookie(ctx, cookie)
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if _, ok := auth.MFAVerifiedAt(token); ok {
+				t.Fatal("new session reports MFA")
+			}
+
+			steppedAt := clock.Now().Truncate(time.Second)
+			recorder, ok := auth.Lookup[auth.StepUpRecorder](manager)
+			if !ok {
+				t.Fatal("SessionManager is not a StepUpRecorder")
+			}
+			if err := recorder.RecordStepUp(ctx, cookie, steppedAt); err != nil {
+				t.Fatalf("record step-up: %v", err)
+			}
+
+			token, err = manager.VerifySessionCookie(ctx, cookie)
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if at, ok := auth.MFAVerifiedAt(token); !ok || !at.Equal(steppedAt) {
+				t.Fatalf("MFAVerifiedAt = %v, %v, want %v", at, ok, steppedAt)
+			}
+			if token.Claims["role"] != "admin" {
+				t.Fatalf("claims = %v, want role kept", token.Claims)
+			}
+
+			// Other sessions of the same user are not stepped up
+			other, err := manager.CreateSessionCookie(ctx, "test-token-admin", time.Hour)
+			if err != nil {
+				t.Fatalf("create session: %v", err)
+			}
+			if token, err := manager.VerifySessionCookie(ctx, other); err != nil || token.Claims[auth.ClaimMFA] != nil {
+				t.Fatalf("other session = %v, %v, want no MFA", token, err)
+			}
+		})
+	}
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestManager(queries Queries) (*auth.SessionManager, *fakeClock) {
+	clock := &fakeClock{now: time.Now()}
+	manager := auth.NewSessionManager(auth.NewMockProvider(), New(queries), auth.SessionManagerConfig{
+		MaxLifetime: 3 * time.Hour,
+		Now:         clock.Now,
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	return manager, clock
+}
+
+func testStores(t *testing.T) map[string]Queries {
+	t.Helper()
+
+	db, err := database.GetConnection(config.Database{Mode: config.DatabaseModeMemory})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := database.Migrate(context.Background(), db, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return map[string]Queries{
+		"memdb":  memdb.New(),
+		"sqlite": repo.New(db),
+	}
+}
@@ -0,0 +1,128 @@
+// Package dbthrottle stores auth.Limiter counters in the auth_throttles
+// table, so failed attempts count against a key on every instance and
+// survive restarts.
+package dbthrottle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+// Queries is the subset of repo.Store used by Store
+type Queries interface {
+	GetAuthThrottle(ctx context.Context, key string) (repo.AuthThrottle, error)
+	ReserveAuthAttempt(ctx context.Context, arg repo.ReserveAuthAttemptParams) (repo.AuthThrottle, error)
+	ReleaseAuthAttempt(ctx context.Context, key string) (int64, error)
+	LockAuthThrottle(ctx context.Context, arg repo.LockAuthThrottleParams) (int64, error)
+	DeleteAuthThrottle(ctx context.Context, key string) (int64, error)
+	ListLockedAuthThrottles(ctx context.Context, lockedUntil sql.NullTime) ([]repo.AuthThrottle, error)
+	DeleteStaleAuthThrottles(ctx context.Context, arg repo.DeleteStaleAuthThrottlesParams) (int64, error)
+}
+
+// Store implements auth.ThrottleStore on top of the generated queries
+type Store struct {
+	queries Queries
+}
+
+// New creates a Store
+func New(queries Queries) *Store {
+	return &Store{queries: queries}
+}
+
+// GetThrottle returns nil for a key without failures
+func (s *Store) GetThrottle(ctx context.Context, key string) (*auth.ThrottleRecord, error) {
+	throttle, err := s.queries.GetAuthThrottle(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := toRecord(throttle)
+	return &record, nil
+}
+
+// ReserveAttempt counts an attempt and holds the key in a single upsert, so
+// concurrent attempts on several instances are all counted and cannot slip
+// past a lock. The upsert returns no row for a locked key.
+func (s *Store) ReserveAttempt(ctx context.Context, key string, at, windowStart time.Time, freeAttempts int64, holdUntil time.Time) (int64, bool, error) {
+	throttle, err := s.queries.ReserveAuthAttempt(ctx, repo.ReserveAuthAttemptParams{
+		Key:           key,
+		LastFailureAt: at.UTC(),
+		WindowStart:   windowStart.UTC(),
+		FreeAttempts:  freeAttempts,
+		HoldUntil:     sql.NullTime{Time: holdUntil.UTC(), Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return throttle.Failures, true, nil
+}
+
+// ReleaseAttempt uncounts an attempt and unlocks the key. A key that passed
+// ReserveAttempt was not locked, so any lock is the one the attempt set.
+func (s *Store) ReleaseAttempt(ctx context.Context, key string) error {
+	_, err := s.queries.ReleaseAuthAttempt(ctx, key)
+	return err
+}
+
+// LockThrottle locks a key that has failures recorded until the given time
+func (s *Store) LockThrottle(ctx context.Context, key string, until time.Time) error {
+	_, err := s.queries.LockAuthThrottle(ctx, repo.LockAuthThrottleParams{
+		LockedUntil: sql.NullTime{Time: until.UTC(), Valid: true},
+		Key:         key,
+	})
+	return err
+}
+
+// ResetThrottle deletes a key, reporting whether it existed
+func (s *Store) ResetThrottle(ctx context.Context, key string) (bool, error) {
+	deleted, err := s.queries.DeleteAuthThrottle(ctx, key)
+	return deleted > 0, err
+}
+
+// ListLockedThrottles returns keys locked beyond now, longest lock first
+func (s *Store) ListLockedThrottles(ctx context.Context, now time.Time) ([]auth.ThrottleRecord, error) {
+	throttles, err := s.queries.ListLockedAuthThrottles(ctx, sql.NullTime{Time: now.UTC(), Valid: true})
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]auth.ThrottleRecord, 0, len(throttles))
+	for _, throttle := range throttles {
+		records = append(records, toRecord(throttle))
+	}
+	return records, nil
+}
+
+// PurgeThrottles deletes keys whose last failure and lock both ended before
+// the given time
+func (s *Store) PurgeThrottles(ctx context.Context, before time.Time) (int64, error) {
+	return s.queries.DeleteStaleAuthThrottles(ctx, repo.DeleteStaleAuthThrottlesParams{
+		LastFailureAt: before.UTC(),
+		LockedUntil:   sql.NullTime{Time: before.UTC(), Valid: true},
+	})
+}
+
+func toRecord(throttle repo.AuthThrottle) auth.ThrottleRecord {
+	record := auth.ThrottleRecord{
+		Key:           throttle.Key,
+		Failures:      throttle.Failures,
+		LastFailureAt: throttle.LastFailureAt,
+	}
+	if throttle.LockedUntil.Valid {
+		record.LockedUntil = throttle.LockedUntil.Time
+	}
+	return record
+}
+
+var _ auth.ThrottleStore = (*Store)(nil)
@@ -0,0 +1,189 @@
+package dbthrottle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database"
+	"github.com/mhpenta/starterA/internal/database/memdb"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func TestLimiterLocksAfterRepeatedFailures(t *testing.T) {
+	for name, queries := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			limiter, clock := newTestLimiter(queries)
+			key := auth.ThrottleKeyUsername("Alice")
+
+			// Each checked attempt counts as a failure unless handed back
+			for range 4 {
+				if err := limiter.Check(ctx, key); err != nil {
+					t.Fatalf("Check within free attempts = %v, want nil", err)
+				}
+			}
+
+			ipKey := auth.ThrottleKeyIP("192.0.2.1")
+			var throttled *auth.ThrottledError
+			if err := limiter.Check(ctx, key, ipKey); !errors.As(err, &throttled) {
+				t.Fatalf("Check = %v, want ThrottledError", err)
+			}
+			if throttled.RetryAfter != time.Second {
+				t.Fatalf("RetryAfter = %v, want 1s", throttled.RetryAfter)
+			}
+			// A refused attempt counts against none of its keys
+			if record, err := New(queries).GetThrottle(ctx, ipKey); err != nil || record == nil || record.Failures != 0 {
+				t.Fatalf("GetThrottle(ip) = %+v, %v, want no failures", record, err)
+			}
+
+			// The fifth failure doubles the delay, the sixth reaches the lockout
+			*clock = clock.Add(2 * time.Second)
+			if err := limiter.Check(ctx, key); err != nil {
+				t.Fatalf("Check after delay = %v, want nil", err)
+			}
+			if err := limiter.Check(ctx, key); !errors.As(err, &throttled) || throttled.RetryAfter != 2*time.Second {
+				t.Fatalf("Check = %v, want 2s ThrottledError", err)
+			}
+			*clock = clock.Add(2 * time.Second)
+			if err := limiter.Check(ctx, key); err != nil {
+				t.Fatalf("Check after delay = %v, want nil", err)
+			}
+			if err := limiter.Check(ctx, key); !errors.As(err, &throttled) || throttled.RetryAfter != time.Hour {
+				t.Fatalf("Check = %v, want 1h ThrottledError", err)
+			}
+
+			locked, err := limiter.Locked(ctx)
+			if err != nil {
+				t.Fatalf("Locked: %v", err)
+			}
+			if len(locked) != 1 || locked[0].Key != "user:alice" || locked[0].Failures != 6 {
+				t.Fatalf("Locked = %+v, want user:alice with 6 failures", locked)
+			}
+
+			found, err := limiter.Unlock(ctx, key)
+			if err != nil || !found {
+				t.Fatalf("Unlock = %v, %v, want true", found, err)
+			}
+			if err := limiter.Check(ctx, key); err != nil {
+				t.Fatalf("Check after unlock = %v, want nil", err)
+			}
+			limiter.Succeed(ctx, key)
+			if found, _ := limiter.Unlock(ctx, key); found {
+				t.Fatal("second Unlock found the key")
+			}
+		})
+	}
+}
+
+func TestLimiterForgetsOldFailures(t *testing.T) {
+	for name, queries := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			limiter, clock := newTestLimiter(queries)
+			userKey, ipKey := auth.ThrottleKeyUsername("bob"), auth.ThrottleKeyIP("192.0.2.1")
+
+			for range 3 {
+				if err := limiter.Check(ctx, userKey, ipKey); err != nil {
+					t.Fatalf("Check = %v, want nil", err)
+				}
+			}
+
+			// Failures older than the window no longer count
+			*clock = clock.Add(2 * time.Hour)
+			for range 2 {
+				if err := limiter.Check(ctx, userKey, ipKey); err != nil {
+					t.Fatalf("Check after window = %v, want nil", err)
+				}
+			}
+
+			// Success clears the account key and hands back the IP's attempt,
+			// keeping its earlier failure
+			limiter.Succeed(ctx, userKey)
+			limiter.Release(ctx, ipKey)
+			record, err := New(queries).GetThrottle(ctx, userKey)
+			if err != nil || record != nil {
+				t.Fatalf("GetThrottle(user) = %+v, %v, want nil", record, err)
+			}
+			record, err = New(queries).GetThrottle(ctx, ipKey)
+			if err != nil || record == nil || record.Failures != 1 {
+				t.Fatalf("GetThrottle(ip) = %+v, %v, want 1 failure", record, err)
+			}
+
+			*clock = clock.Add(2 * time.Hour)
+			purged, err := limiter.Purge(ctx)
+			if err != nil || purged != 1 {
+				t.Fatalf("Purge = %d, %v, want 1", purged, err)
+			}
+		})
+	}
+}
+
+func TestLimiterCountsConcurrentAttempts(t *testing.T) {
+	for name, queries := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			limiter, _ := newTestLimiter(queries)
+			key := auth.ThrottleKeyUsername("carol")
+
+			// Every attempt is counted before it is verified, so a burst
+			// gets the free attempts and the one that starts the backoff
+			var allowed atomic.Int64
+			var wg sync.WaitGroup
+			for range 20 {
+				wg.Go(func() {
+					if limiter.Check(ctx, key) == nil {
+						allowed.Add(1)
+					}
+				})
+			}
+			wg.Wait()
+
+			if got := allowed.Load(); got != 4 {
+				t.Fatalf("allowed = %d, want 4", got)
+			}
+			record, err := New(queries).GetThrottle(ctx, key)
+			if err != nil || record == nil || record.Failures != 4 {
+				t.Fatalf("GetThrottle = %+v, %v, want 4 failures", record, err)
+			}
+		})
+	}
+}
+
+func newTestLimiter(queries Queries) (*auth.Limiter, *time.Time) {
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := auth.NewLimiter(New(queries), auth.LimiterConfig{
+		FreeAttempts:     3,
+		LockoutThreshold: 6,
+		LockoutDuration:  time.Hour,
+		Window:           time.Hour,
+		Now:              func() time.Time { return clock },
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	return limiter, &clock
+}
+
+func testStores(t *testing.T) map[string]Queries {
+	t.Helper()
+
+	db, err := database.GetConnection(config.Database{Mode: config.DatabaseModeMemory})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := database.Migrate(context.Background(), db, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return map[string]Queries{
+		"memdb":  memdb.New(),
+		"sqlite": repo.New(db),
+	}
+}
@@ -18,6 +18,9 @@ var (
 	ErrExpiredSessionCookie = errors.New("auth: session cookie expired")
 	ErrRevokedSessionCookie = errors.New("auth: session cookie revoked")
 
+	// Credential errors
+	ErrInvalidCredentials = errors.New("auth: invalid credentials")
+
 	// User errors
 	ErrUserNotFound = errors.New("auth: user not found")
 	ErrUserDisabled = errors.New("auth: user disabled")
@@ -42,3 +45,61 @@ func IsInvalidError(err error) bool {
 		errors.Is(err, ErrMalformedToken) ||
 		errors.Is(err, ErrInvalidSessionCookie)
 }
+
+// Stable codes for authentication failures, sent to clients in the code
+// member of the problem details written by DefaultErrorHandler.
+const (
+	CodeMissingToken   = "missing_token"
+	CodeInvalidToken   = "invalid_token"
+	CodeExpiredToken   = "expired_token"
+	CodeRevokedToken   = "revoked_token"
+	CodeMalformedToken = "malformed_token"
+	CodeUserDisabled   = "user_disabled"
+)
+
+// AuthError describes why the auth middleware rejected a request. Code and
+// Description are safe to show to clients; Err is the provider's error and
+// may carry details that are not.
+type AuthError struct {
+	Code        string
+	Description string
+	// Source is where the rejected credentials were found; TokenSourceNone
+	// when there were none
+	Source TokenSource
+	// RequestID is the chi request ID, if the request has one
+	RequestID string
+	Err       error
+}
+
+// NewAuthError classifies err, returned while verifying credentials from
+// source, into an AuthError. A nil err means no credentials were sent.
+func NewAuthError(err error, source TokenSource) *AuthError {
+	e := &AuthError{Source: source, Err: err}
+	switch {
+	case err == nil || source == TokenSourceNone ||
+		errors.Is(err, ErrMissingToken) || errors.Is(err, ErrMissingCookie):
+		e.Code, e.Description = CodeMissingToken, "Authentication required"
+	case IsRevokedError(err):
+		e.Code, e.Description = CodeRevokedToken, "The token has been revoked"
+	case IsExpiredError(err):
+		e.Code, e.Description = CodeExpiredToken, "The token has expired"
+	case errors.Is(err, ErrMalformedToken):
+		e.Code, e.Description = CodeMalformedToken, "The token is malformed"
+	case errors.Is(err, ErrUserDisabled):
+		e.Code, e.Description = CodeUserDisabled, "The user is disabled"
+	default:
+		e.Code, e.Description = CodeInvalidToken, "The token is invalid"
+	}
+	return e
+}
+
+func (e *AuthError) Error() string {
+	if e.Err == nil {
+		return "auth: " + e.Code
+	}
+	return "auth: " + e.Code + ": " + e.Err.Error()
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
@@ -14,3 +14,87 @@ type Servicer interface {
 	CreateSessionCookie(ctx context.Context, idToken string, expiresIn time.Duration) (string, error)
 	GetUserInfo(ctx context.Context, uid string) (*UserInfo, error)
 }
+
+// PasswordAuthenticator is implemented by providers that check passwords
+// themselves. Login returns an ID token for CreateSessionCookie, or
+// ErrInvalidCredentials.
+type PasswordAuthenticator interface {
+	Login(ctx context.Context, username, password string) (string, error)
+}
+
+// PasswordChanger is implemented by providers that store passwords
+// themselves. ChangePassword replaces the password of the user with the
+// given UID, returning ErrInvalidCredentials when currentPassword does not
+// match.
+type PasswordChanger interface {
+	ChangePassword(ctx context.Context, uid, currentPassword, newPassword string) error
+}
+
+// IDTokenIssuer is implemented by providers that can mint an ID token for
+// one of their own users. Flows that establish identity some other way, such
+// as magic links, exchange it for a session with CreateSessionCookie.
+// Unknown UIDs get ErrUserNotFound.
+type IDTokenIssuer interface {
+	IssueIDToken(ctx context.Context, uid string) (string, error)
+}
+
+// SessionRevoker is implemented by providers that track sessions server-side
+// and can revoke them before they expire.
+type SessionRevoker interface {
+	RevokeSessionCookie(ctx context.Context, sessionCookie string) error
+	RevokeUserSessions(ctx context.Context, uid string) error
+}
+
+// APIKeyVerifier is implemented by providers that accept API keys from the
+// X-API-Key header or the ApiKey authorization scheme.
+type APIKeyVerifier interface {
+	VerifyAPIKey(ctx context.Context, key string) (*Token, error)
+}
+
+// StepUpRecorder is implemented by providers with server-side sessions that
+// can remember a second factor. After RecordStepUp, tokens verified from the
+// session cookie carry ClaimMFA.
+type StepUpRecorder interface {
+	RecordStepUp(ctx context.Context, sessionCookie string, at time.Time) error
+}
+
+// Unwrapper is implemented by providers that decorate another provider.
+type Unwrapper interface {
+	Unwrap() Servicer
+}
+
+// forwarder is implemented by decorators, such as TokenCache, that
+// implement optional interfaces only to act on calls on their way to the
+// provider they wrap. forwards reports whether the interface behind
+// capability, a nil pointer to it, is one of those.
+type forwarder interface {
+	Unwrapper
+	forwards(capability any) bool
+}
+
+// Lookup returns the first provider in the decorator chain starting at
+// provider that implements T, following Unwrap. Use it instead of a type
+// assertion to find optional capabilities such as SessionRevoker. A
+// decorator that only forwards T counts only if a provider below it
+// implements T.
+func Lookup[T any](provider Servicer) (T, bool) {
+	for provider != nil {
+		if found, ok := provider.(T); ok {
+			f, ok := provider.(forwarder)
+			if !ok || !f.forwards((*T)(nil)) {
+				return found, true
+			}
+			if _, ok := Lookup[T](f.Unwrap()); ok {
+				return found, true
+			}
+		}
+		wrapper, ok := provider.(Unwrapper)
+		if !ok {
+			break
+		}
+		provider = wrapper.Unwrap()
+	}
+
+	var zero T
+	return zero, false
+}
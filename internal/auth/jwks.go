@@ -0,0 +1,289 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported JWS signing algorithms.
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// minRSAKeyBits is the smallest RSA modulus accepted from a JWKS.
+const minRSAKeyBits = 2048
+
+// maxJWKSBytes bounds the size of a JWKS or discovery document.
+const maxJWKSBytes = 1 << 20
+
+// errUnknownKey is returned when no key in the JWKS matches a token header.
+var errUnknownKey = errors.New("auth: no matching signing key")
+
+// jsonWebKey is a public key from a JWKS with the fields needed to verify.
+type jsonWebKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// matches reports whether the key can verify a token with the given header.
+func (k jsonWebKey) matches(kid, alg string) bool {
+	if kid != "" && k.kid != kid {
+		return false
+	}
+	if k.alg != "" && k.alg != alg {
+		return false
+	}
+	switch k.key.(type) {
+	case *rsa.PublicKey:
+		return alg == AlgRS256
+	case *ecdsa.PublicKey:
+		return alg == AlgES256
+	case ed25519.PublicKey:
+		return alg == AlgEdDSA
+	}
+	return false
+}
+
+// jwksCache fetches a JWKS and caches its keys. Keys are refetched when the
+// cache expires, or early when a token names a kid the cache has not seen,
+// which is how key rotation shows up. Early refetches are rate limited so
+// tokens with made-up kids cannot hammer the identity provider.
+type jwksCache struct {
+	client             *http.Client
+	jwksURL            string
+	issuer             string
+	ttl                time.Duration
+	minRefreshInterval time.Duration
+	now                func() time.Time
+
+	// refreshMu serialises fetches so concurrent misses share one request
+	refreshMu sync.Mutex
+
+	mu        sync.RWMutex
+	keys      []jsonWebKey
+	fetchedAt time.Time
+}
+
+// key returns the key that verifies a token with the given kid and alg.
+func (c *jwksCache) key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	keys, fetchedAt := c.snapshot()
+	age := c.now().Sub(fetchedAt)
+	if !fetchedAt.IsZero() && age < c.ttl {
+		if k, err := selectKey(keys, kid, alg); err == nil || age < c.minRefreshInterval {
+			return k, err
+		}
+	}
+
+	keys, err := c.refresh(ctx, fetchedAt)
+	if err != nil {
+		// Keep verifying with the last known keys while the JWKS endpoint
+		// is unavailable
+		if stale, _ := c.snapshot(); len(stale) > 0 {
+			if k, keyErr := selectKey(stale, kid, alg); keyErr == nil {
+				return k, nil
+			}
+		}
+		return nil, err
+	}
+
+	return selectKey(keys, kid, alg)
+}
+
+func (c *jwksCache) snapshot() ([]jsonWebKey, time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.keys, c.fetchedAt
+}
+
+// refresh fetches the JWKS unless another caller already did so after seen.
+func (c *jwksCache) refresh(ctx context.Context, seen time.Time) ([]jsonWebKey, error) {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	if keys, fetchedAt := c.snapshot(); fetchedAt.After(seen) {
+		return keys, nil
+	}
+
+	if c.jwksURL == "" {
+		jwksURL, err := c.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.jwksURL = jwksURL
+	}
+
+	var doc struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := c.getJSON(ctx, c.jwksURL, &doc); err != nil {
+		return nil, fmt.Errorf("auth: fetching JWKS: %w", err)
+	}
+
+	keys := make([]jsonWebKey, 0, len(doc.Keys))
+	for _, raw := range doc.Keys {
+		// Keys that are not for signing or use unsupported types are
+		// skipped rather than failing the whole set
+		if k, err := parseJWK(raw); err == nil {
+			keys = append(keys, k)
+		}
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.fetchedAt = c.now()
+	c.mu.Unlock()
+
+	return keys, nil
+}
+
+// discover resolves the JWKS URL from the issuer's OpenID configuration.
+func (c *jwksCache) discover(ctx context.Context) (string, error) {
+	var doc struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	url := strings.TrimSuffix(c.issuer, "/") + "/.well-known/openid-configuration"
+	if err := c.getJSON(ctx, url, &doc); err != nil {
+		return "", fmt.Errorf("auth: fetching OpenID configuration: %w", err)
+	}
+	if doc.Issuer != c.issuer {
+		return "", fmt.Errorf("auth: OpenID configuration is for issuer %q, want %q", doc.Issuer, c.issuer)
+	}
+	if doc.JWKSURI == "" {
+		return "", errors.New("auth: OpenID configuration has no jwks_uri")
+	}
+	return doc.JWKSURI, nil
+}
+
+func (c *jwksCache) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxJWKSBytes)).Decode(v)
+}
+
+// selectKey finds the key for a token header. A token without a kid is only
+// accepted when exactly one key could verify it.
+func selectKey(keys []jsonWebKey, kid, alg string) (crypto.PublicKey, error) {
+	var found []jsonWebKey
+	for _, k := range keys {
+		if k.matches(kid, alg) {
+			found = append(found, k)
+		}
+	}
+	if len(found) != 1 {
+		return nil, errUnknownKey
+	}
+	return found[0].key, nil
+}
+
+// parseJWK decodes a single RSA, P-256 or Ed25519 signing key.
+func parseJWK(raw json.RawMessage) (jsonWebKey, error) {
+	var k struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return jsonWebKey{}, err
+	}
+	if k.Use != "" && k.Use != "sig" {
+		return jsonWebKey{}, fmt.Errorf("key %q is not a signing key", k.Kid)
+	}
+
+	key := jsonWebKey{kid: k.Kid, alg: k.Alg}
+	switch {
+	case k.Kty == "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return key, err
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil {
+			return key, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return key, fmt.Errorf("key %q has an invalid RSA exponent", k.Kid)
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+		if pub.N.BitLen() < minRSAKeyBits {
+			return key, fmt.Errorf("key %q is shorter than %d bits", k.Kid, minRSAKeyBits)
+		}
+		key.key = pub
+
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return key, err
+		}
+		y, err := decodeSegment(k.Y)
+		if err != nil {
+			return key, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return key, fmt.Errorf("key %q has invalid P-256 coordinates", k.Kid)
+		}
+		point := append(append([]byte{4}, x...), y...)
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return key, err
+		}
+		key.key = pub
+
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return key, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return key, fmt.Errorf("key %q has an invalid Ed25519 public key", k.Kid)
+		}
+		key.key = ed25519.PublicKey(x)
+
+	default:
+		return key, fmt.Errorf("key %q has unsupported type %s %s", k.Kid, k.Kty, k.Crv)
+	}
+
+	return key, nil
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,378 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultClockSkew is the leeway applied to exp, nbf and iat.
+	DefaultClockSkew = time.Minute
+
+	// DefaultJWKSCacheTTL is how long fetched keys are trusted before the
+	// JWKS is fetched again.
+	DefaultJWKSCacheTTL = time.Hour
+
+	// DefaultJWKSMinRefreshInterval is the shortest time between fetches
+	// triggered by tokens signed with an unknown key.
+	DefaultJWKSMinRefreshInterval = time.Minute
+
+	// maxTokenBytes bounds the size of a token accepted for verification.
+	maxTokenBytes = 16 << 10
+
+	// maxNumericDate is 9999-12-31T23:59:59Z, the latest accepted time claim.
+	maxNumericDate = 253402300799
+)
+
+// JWTConfig configures a JWTProvider.
+type JWTConfig struct {
+	// Issuer is the required iss claim. When JWKSURL is empty, the JWKS
+	// location is discovered from Issuer's OpenID configuration.
+	Issuer string
+	// Audience lists accepted aud values; a token must carry at least one.
+	Audience []string
+	// JWKSURL is where the signing keys are published.
+	JWKSURL string
+	// Algorithms restricts the accepted signing algorithms.
+	// Empty means RS256, ES256 and EdDSA.
+	Algorithms []string
+	// ClockSkew is the leeway for time-based claims; zero means DefaultClockSkew.
+	ClockSkew time.Duration
+	// CacheTTL is how long fetched keys are used; zero means DefaultJWKSCacheTTL.
+	CacheTTL time.Duration
+	// MinRefreshInterval limits refetches for unknown key ids;
+	// zero means DefaultJWKSMinRefreshInterval.
+	MinRefreshInterval time.Duration
+	// HTTPClient fetches the JWKS; nil means a client with a 10 second timeout.
+	HTTPClient *http.Client
+	// Now returns the current time; nil means time.Now.
+	Now func() time.Time
+}
+
+// JWTProvider verifies JWTs issued by an OpenID Connect or other JWT issuer
+// against the issuer's published JWKS. Session cookies are expected to carry
+// the same JWTs, so revocation cannot be checked here, and a session lasts
+// until the token's own expiry whatever lifetime is asked for. The issuer's
+// user directory is not reachable with a JWKS alone, so GetUserInfo only
+// knows the identity of the verified token in the request context.
+type JWTProvider struct {
+	issuer     string
+	audience   []string
+	algorithms []string
+	clockSkew  time.Duration
+	now        func() time.Time
+	keys       *jwksCache
+}
+
+// NewJWTProvider creates a JWTProvider. Keys are fetched lazily on first use.
+func NewJWTProvider(cfg JWTConfig) (*JWTProvider, error) {
+	if cfg.Issuer == "" {
+		return nil, errors.New("auth: JWT issuer is required")
+	}
+	if len(cfg.Audience) == 0 {
+		return nil, errors.New("auth: JWT audience is required")
+	}
+
+	algorithms := cfg.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{AlgRS256, AlgES256, AlgEdDSA}
+	}
+	for _, alg := range algorithms {
+		if alg != AlgRS256 && alg != AlgES256 && alg != AlgEdDSA {
+			return nil, fmt.Errorf("auth: unsupported JWT algorithm %q", alg)
+		}
+	}
+
+	if cfg.ClockSkew == 0 {
+		cfg.ClockSkew = DefaultClockSkew
+	}
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = DefaultJWKSCacheTTL
+	}
+	if cfg.MinRefreshInterval == 0 {
+		cfg.MinRefreshInterval = DefaultJWKSMinRefreshInterval
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	return &JWTProvider{
+		issuer:     cfg.Issuer,
+		audience:   cfg.Audience,
+		algorithms: algorithms,
+		clockSkew:  cfg.ClockSkew,
+		now:        cfg.Now,
+		keys: &jwksCache{
+			client:             cfg.HTTPClient,
+			jwksURL:            cfg.JWKSURL,
+			issuer:             cfg.Issuer,
+			ttl:                cfg.CacheTTL,
+			minRefreshInterval: cfg.MinRefreshInterval,
+			now:                cfg.Now,
+		},
+	}, nil
+}
+
+// VerifyIDToken verifies a JWT's signature and claims.
+func (p *JWTProvider) VerifyIDToken(ctx context.Context, idToken string) (*Token, error) {
+	return p.verify(ctx, idToken)
+}
+
+// VerifySessionCookie verifies a session cookie holding a JWT.
+func (p *JWTProvider) VerifySessionCookie(ctx context.Context, sessionCookie string) (*Token, error) {
+	return p.verify(ctx, sessionCookie)
+}
+
+// VerifySessionCookieRevoked verifies a session cookie. JWTs cannot be
+// revoked by this provider; wrap it with a session store for that.
+func (p *JWTProvider) VerifySessionCookieRevoked(ctx context.Context, sessionCookie string) (*Token, error) {
+	return p.verify(ctx, sessionCookie)
+}
+
+// VerifySessionCookieAndCheckRevoked verifies a session cookie.
+// See VerifySessionCookieRevoked.
+func (p *JWTProvider) VerifySessionCookieAndCheckRevoked(ctx context.Context, sessionCookie string) (*Token, error) {
+	return p.verify(ctx, sessionCookie)
+}
+
+// CreateSessionCookie verifies the ID token and returns it for use as the
+// session cookie value. The token is signed by the issuer and cannot be
+// reissued, so expiresIn is not applied to it: the value stays valid until
+// the token's exp claim, and only the cookie's Max-Age, which the caller sets,
+// can end the session sooner in the browser.
+func (p *JWTProvider) CreateSessionCookie(ctx context.Context, idToken string, expiresIn time.Duration) (string, error) {
+	if _, err := p.verify(ctx, idToken); err != nil {
+		return "", err
+	}
+	return idToken, nil
+}
+
+// GetUserInfo describes uid by the claims of the verified token in ctx, as
+// stored by the auth middleware. Other users cannot be looked up, so for
+// them it returns ErrUserNotFound.
+func (p *JWTProvider) GetUserInfo(ctx context.Context, uid string) (*UserInfo, error) {
+	token, ok := TokenFromContext(ctx)
+	if !ok || token == nil || token.UID != uid {
+		return nil, ErrUserNotFound
+	}
+	return UserInfoFromToken(token), nil
+}
+
+// jwtHeader is the JOSE header of a signed JWT.
+type jwtHeader struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid"`
+	Crit []string `json:"crit"`
+}
+
+func (p *JWTProvider) verify(ctx context.Context, raw string) (*Token, error) {
+	if raw == "" {
+		return nil, ErrMissingToken
+	}
+	if len(raw) > maxTokenBytes {
+		return nil, fmt.Errorf("%w: token is too large", ErrMalformedToken)
+	}
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: want 3 segments, got %d", ErrMalformedToken, len(parts))
+	}
+
+	headerJSON, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformedToken, err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformedToken, err)
+	}
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", ErrMalformedToken, err)
+	}
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrMalformedToken, err)
+	}
+
+	if !slices.Contains(p.algorithms, header.Alg) {
+		return nil, fmt.Errorf("%w: algorithm %q is not accepted", ErrInvalidToken, header.Alg)
+	}
+	if len(header.Crit) > 0 {
+		return nil, fmt.Errorf("%w: unsupported critical header %v", ErrInvalidToken, header.Crit)
+	}
+
+	key, err := p.keys.key(ctx, header.Kid, header.Alg)
+	if errors.Is(err, errUnknownKey) {
+		return nil, fmt.Errorf("%w: no key for kid %q", ErrInvalidToken, header.Kid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signingInput := []byte(parts[0] + "." + parts[1])
+	if !verifySignature(header.Alg, key, signingInput, signature) {
+		return nil, fmt.Errorf("%w: signature verification failed", ErrInvalidToken)
+	}
+
+	var claims map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrMalformedToken, err)
+	}
+
+	return p.validateClaims(claims)
+}
+
+// validateClaims checks the registered claims and maps them into a Token.
+func (p *JWTProvider) validateClaims(claims map[string]interface{}) (*Token, error) {
+	now := p.now()
+
+	if iss, _ := claims["iss"].(string); iss != p.issuer {
+		return nil, fmt.Errorf("%w: issuer %q is not trusted", ErrInvalidToken, iss)
+	}
+	if !p.audienceMatches(claims["aud"]) {
+		return nil, fmt.Errorf("%w: audience is not accepted", ErrInvalidToken)
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, fmt.Errorf("%w: missing sub claim", ErrInvalidToken)
+	}
+
+	exp, ok, err := numericDate(claims, "exp")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: missing exp claim", ErrInvalidToken)
+	}
+	if !now.Before(exp.Add(p.clockSkew)) {
+		return nil, ErrExpiredToken
+	}
+
+	nbf, ok, err := numericDate(claims, "nbf")
+	if err != nil {
+		return nil, err
+	}
+	if ok && now.Add(p.clockSkew).Before(nbf) {
+		return nil, fmt.Errorf("%w: token is not valid yet", ErrInvalidToken)
+	}
+
+	iat, ok, err := numericDate(claims, "iat")
+	if err != nil {
+		return nil, err
+	}
+	if ok && now.Add(p.clockSkew).Before(iat) {
+		return nil, fmt.Errorf("%w: token was issued in the future", ErrInvalidToken)
+	}
+
+	email, _ := claims["email"].(string)
+
+	return &Token{
+		UID:           sub,
+		Email:         email,
+		EmailVerified: claimBool(claims["email_verified"]),
+		Claims:        claims,
+		Expiry:        exp,
+		IssuedAt:      iat,
+	}, nil
+}
+
+// audienceMatches reports whether aud, a string or array of strings,
+// contains an accepted audience.
+func (p *JWTProvider) audienceMatches(aud interface{}) bool {
+	switch v := aud.(type) {
+	case string:
+		return slices.Contains(p.audience, v)
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && slices.Contains(p.audience, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// numericDate reads a JWT NumericDate claim, reporting whether it was present.
+func numericDate(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, isNumber := v.(json.Number)
+	if !isNumber {
+		return time.Time{}, false, fmt.Errorf("%w: %s must be a number", ErrMalformedToken, name)
+	}
+	f, err := n.Float64()
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f < 0 || f > maxNumericDate {
+		return time.Time{}, false, fmt.Errorf("%w: %s is out of range", ErrMalformedToken, name)
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC(), true, nil
+}
+
+// claimBool reads a boolean claim, accepting the "true" strings some
+// issuers send for email_verified.
+func claimBool(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	}
+	return false
+}
+
+// verifySignature checks a JWS signature over signingInput.
+func verifySignature(alg string, key crypto.PublicKey, signingInput, signature []byte) bool {
+	switch alg {
+	case AlgRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		digest := sha256.Sum256(signingInput)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+
+	case AlgES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		digest := sha256.Sum256(signingInput)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+
+	case AlgEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return false
+		}
+		return ed25519.Verify(pub, signingInput, signature)
+	}
+	return false
+}
+
+// Ensure JWTProvider implements Servicer
+var _ Servicer = (*JWTProvider)(nil)
@@ -0,0 +1,426 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "starter-app"
+)
+
+func TestJWTProviderVerifiesSupportedAlgorithms(t *testing.T) {
+	issuer := newTestJWKSServer(t)
+	provider := newTestJWTProvider(t, issuer, func(cfg *JWTConfig) {
+		cfg.MinRefreshInterval = time.Nanosecond
+	})
+
+	for _, key := range []testSigningKey{
+		newRSAKey(t, "rsa-1"),
+		newECKey(t, "ec-1"),
+		newEdKey(t, "ed-1"),
+	} {
+		t.Run(key.alg, func(t *testing.T) {
+			issuer.setKeys(key)
+			raw := key.sign(t, validClaims())
+
+			token, err := provider.VerifyIDToken(context.Background(), raw)
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if token.UID != "user-42" || token.Email != "marc@example.com" || !token.EmailVerified {
+				t.Fatalf("token = %+v", token)
+			}
+			if token.Claims["role"] != "admin" {
+				t.Fatalf("role claim = %v, want admin", token.Claims["role"])
+			}
+			if token.Expiry.IsZero() || token.IssuedAt.IsZero() {
+				t.Fatalf("token times = %v / %v, want both set", token.Expiry, token.IssuedAt)
+			}
+		})
+	}
+}
+
+func TestJWTProviderUserInfoComesFromTokenInContext(t *testing.T) {
+	issuer := newTestJWKSServer(t)
+	key := newRSAKey(t, "rsa-1")
+	issuer.setKeys(key)
+	provider := newTestJWTProvider(t, issuer, nil)
+
+	claims := validClaims()
+	claims["name"] = "Marc"
+	token, err := provider.VerifyIDToken(context.Background(), key.sign(t, claims))
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	ctx := ContextWithToken(context.Background(), token)
+
+	info, err := provider.GetUserInfo(ctx, "user-42")
+	if err != nil {
+		t.Fatalf("user info: %v", err)
+	}
+	if info.Email != "marc@example.com" || !info.EmailVerified || info.DisplayName != "Marc" {
+		t.Fatalf("user info = %+v", info)
+	}
+
+	for _, ctx := range []context.Context{context.Background(), ctx} {
+		if _, err := provider.GetUserInfo(ctx, "someone-else"); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("err = %v, want %v", err, ErrUserNotFound)
+		}
+	}
+}
+
+func TestJWTProviderRejectsInvalidClaims(t *testing.T) {
+	issuer := newTestJWKSServer(t)
+	key := newRSAKey(t, "rsa-1")
+	issuer.setKeys(key)
+	provider := newTestJWTProvider(t, issuer, nil)
+	now := time.Now()
+
+	tests := map[string]struct {
+		edit func(map[string]interface{})
+		want error
+	}{
+		"expired":             {func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() }, ErrExpiredToken},
+		"not yet valid":       {func(c map[string]interface{}) { c["nbf"] = now.Add(5 * time.Minute).Unix() }, ErrInvalidToken},
+		"issued in future":    {func(c map[string]interface{}) { c["iat"] = now.Add(5 * time.Minute).Unix() }, ErrInvalidToken},
+		"wrong issuer":        {func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, ErrInvalidToken},
+		"wrong audience":      {func(c map[string]interface{}) { c["aud"] = "other-app" }, ErrInvalidToken},
+		"missing exp":         {func(c map[string]interface{}) { delete(c, "exp") }, ErrInvalidToken},
+		"missing sub":         {func(c map[string]interface{}) { delete(c, "sub") }, ErrInvalidToken},
+		"exp not a number":    {func(c map[string]interface{}) { c["exp"] = "tomorrow" }, ErrMalformedToken},
+		"audience in array":   {func(c map[string]interface{}) { c["aud"] = []string{"other-app", testAudience} }, nil},
+		"expired within skew": {func(c map[string]interface{}) { c["exp"] = now.Add(-30 * time.Second).Unix() }, nil},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			claims := validClaims()
+			tt.edit(claims)
+
+			_, err := provider.VerifyIDToken(context.Background(), key.sign(t, claims))
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("verify: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTProviderRejectsBadSignaturesAndHeaders(t *testing.T) {
+	issuer := newTestJWKSServer(t)
+	key := newRSAKey(t, "rsa-1")
+	issuer.setKeys(key)
+	provider := newTestJWTProvider(t, issuer, nil)
+
+	valid := key.sign(t, validClaims())
+	parts := strings.Split(valid, ".")
+	forged := newRSAKey(t, "rsa-1").sign(t, validClaims())
+	unsigned := encodeJSON(t, map[string]string{"alg": "none", "kid": "rsa-1"}) + "." + parts[1] + "."
+
+	tests := map[string]struct {
+		token string
+		want  error
+	}{
+		"garbage":          {"not-a-jwt", ErrMalformedToken},
+		"bad base64":       {"!!!." + parts[1] + "." + parts[2], ErrMalformedToken},
+		"tampered payload": {parts[0] + "." + encodeJSON(t, map[string]interface{}{"sub": "admin"}) + "." + parts[2], ErrInvalidToken},
+		"wrong key":        {forged, ErrInvalidToken},
+		"alg none":         {unsigned, ErrInvalidToken},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := provider.VerifyIDToken(context.Background(), tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTProviderRefetchesKeysOnRotation(t *testing.T) {
+	issuer := newTestJWKSServer(t)
+	oldKey, newKey := newRSAKey(t, "old"), newECKey(t, "new")
+	issuer.setKeys(oldKey)
+	provider := newTestJWTProvider(t, issuer, func(cfg *JWTConfig) {
+		cfg.MinRefreshInterval = time.Nanosecond
+	})
+
+	if _, err := provider.VerifyIDToken(context.Background(), oldKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("verify with old key: %v", err)
+	}
+	if _, err := provider.VerifyIDToken(context.Background(), oldKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("verify with cached old key: %v", err)
+	}
+	if got := issuer.fetches.Load(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", got)
+	}
+
+	issuer.setKeys(oldKey, newKey)
+	if _, err := provider.VerifyIDToken(context.Background(), newKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("verify with rotated key: %v", err)
+	}
+	if got := issuer.fetches.Load(); got != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", got)
+	}
+}
+
+func TestJWTProviderRateLimitsUnknownKeyRefetches(t *testing.T) {
+	issuer := newTestJWKSServer(t)
+	key := newRSAKey(t, "rsa-1")
+	issuer.setKeys(key)
+	provider := newTestJWTProvider(t, issuer, nil)
+
+	if _, err := provider.VerifyIDToken(context.Background(), key.sign(t, validClaims())); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	unknown := newRSAKey(t, "made-up")
+	for i := 0; i < 5; i++ {
+		if _, err := provider.VerifyIDToken(context.Background(), unknown.sign(t, validClaims())); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("err = %v, want %v", err, ErrInvalidToken)
+		}
+	}
+	if got := issuer.fetches.Load(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", got)
+	}
+}
+
+func TestJWTProviderDiscoversJWKSFromIssuer(t *testing.T) {
+	issuer := newTestJWKSServer(t)
+	key := newEdKey(t, "ed-1")
+	issuer.setKeys(key)
+
+	provider, err := NewJWTProvider(JWTConfig{
+		Issuer:     issuer.URL,
+		Audience:   []string{testAudience},
+		HTTPClient: issuer.Client(),
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+
+	claims := validClaims()
+	claims["iss"] = issuer.URL
+	if _, err := provider.VerifyIDToken(context.Background(), key.sign(t, claims)); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+}
+
+func TestNewJWTProviderRequiresIssuerAndAudience(t *testing.T) {
+	if _, err := NewJWTProvider(JWTConfig{Audience: []string{testAudience}}); err == nil {
+		t.Fatal("expected error without issuer, got nil")
+	}
+	if _, err := NewJWTProvider(JWTConfig{Issuer: testIssuer}); err == nil {
+		t.Fatal("expected error without audience, got nil")
+	}
+	if _, err := NewJWTProvider(JWTConfig{Issuer: testIssuer, Audience: []string{testAudience}, Algorithms: []string{"HS256"}}); err == nil {
+		t.Fatal("expected error for HS256, got nil")
+	}
+}
+
+// testJWKSServer publishes a JWKS and an OpenID configuration pointing at it.
+type testJWKSServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []map[string]string
+	fetches atomic.Int64
+}
+
+func newTestJWKSServer(t *testing.T) *testJWKSServer {
+	t.Helper()
+
+	s := &testJWKSServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	})
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   s.URL,
+			"jwks_uri": s.URL + "/jwks.json",
+		})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *testJWKSServer) setKeys(keys ...testSigningKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = nil
+	for _, k := range keys {
+		s.keys = append(s.keys, k.jwk)
+	}
+}
+
+func newTestJWTProvider(t *testing.T, issuer *testJWKSServer, configure func(*JWTConfig)) *JWTProvider {
+	t.Helper()
+
+	cfg := JWTConfig{
+		Issuer:     testIssuer,
+		Audience:   []string{testAudience},
+		JWKSURL:    issuer.URL + "/jwks.json",
+		HTTPClient: issuer.Client(),
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	provider, err := NewJWTProvider(cfg)
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	return provider
+}
+
+func validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            testIssuer,
+		"aud":            testAudience,
+		"sub":            "user-42",
+		"email":          "marc@example.com",
+		"email_verified": true,
+		"role":           "admin",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+}
+
+// testSigningKey is a private key and the JWK publishing its public half.
+type testSigningKey struct {
+	kid  string
+	alg  string
+	jwk  map[string]string
+	sign func(t *testing.T, claims map[string]interface{}) string
+}
+
+func newRSAKey(t *testing.T, kid string) testSigningKey {
+	t.Helper()
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	return testSigningKey{
+		kid: kid,
+		alg: AlgRS256,
+		jwk: map[string]string{
+			"kty": "RSA", "kid": kid, "use": "sig", "alg": AlgRS256,
+			"n": b64(priv.N.Bytes()),
+			"e": b64(big.NewInt(int64(priv.E)).Bytes()),
+		},
+		sign: signer(AlgRS256, kid, func(input []byte) ([]byte, error) {
+			digest := sha256.Sum256(input)
+			return rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, digest[:])
+		}),
+	}
+}
+
+func newECKey(t *testing.T, kid string) testSigningKey {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate EC key: %v", err)
+	}
+	point, err := priv.PublicKey.Bytes()
+	if err != nil {
+		t.Fatalf("encode EC key: %v", err)
+	}
+	return testSigningKey{
+		kid: kid,
+		alg: AlgES256,
+		jwk: map[string]string{
+			"kty": "EC", "kid": kid, "crv": "P-256",
+			"x": b64(point[1:33]),
+			"y": b64(point[33:]),
+		},
+		sign: signer(AlgES256, kid, func(input []byte) ([]byte, error) {
+			digest := sha256.Sum256(input)
+			r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+			if err != nil {
+				return nil, err
+			}
+			sig := make([]byte, 64)
+			r.FillBytes(sig[:32])
+			s.FillBytes(sig[32:])
+			return sig, nil
+		}),
+	}
+}
+
+func newEdKey(t *testing.T, kid string) testSigningKey {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate Ed25519 key: %v", err)
+	}
+	return testSigningKey{
+		kid: kid,
+		alg: AlgEdDSA,
+		jwk: map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(pub)},
+		sign: signer(AlgEdDSA, kid, func(input []byte) ([]byte, error) {
+			return ed25519.Sign(priv, input), nil
+		}),
+	}
+}
+
+func signer(alg, kid string, sign func([]byte) ([]byte, error)) func(*testing.T, map[string]interface{}) string {
+	return func(t *testing.T, claims map[string]interface{}) string {
+		t.Helper()
+
+		input := encodeJSON(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encodeJSON(t, claims)
+		sig, err := sign([]byte(input))
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return input + "." + b64(sig)
+	}
+}
+
+func encodeJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return b64(data)
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,176 @@
+package localauth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrWeakPassword      = errors.New("localauth: password does not meet the policy")
+	ErrUnknownHashFormat = errors.New("localauth: unknown password hash format")
+)
+
+// Argon2Params are the argon2id cost parameters used for new hashes.
+// Stored hashes with different parameters are rehashed on the next login.
+type Argon2Params struct {
+	// Memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// HashPassword encodes password as a PHC-format argon2id string:
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>
+func HashPassword(password string, params Argon2Params) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyPassword reports whether password matches the encoded hash, and
+// whether the hash should be replaced because it uses bcrypt or parameters
+// other than params. bcrypt hashes are accepted so users imported from
+// other systems can log in and be migrated to argon2id.
+func VerifyPassword(password, encoded string, params Argon2Params) (match, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return verifyArgon2id(password, encoded, params)
+
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		return true, true, nil
+	}
+
+	return false, false, ErrUnknownHashFormat
+}
+
+func verifyArgon2id(password, encoded string, params Argon2Params) (bool, bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrUnknownHashFormat
+	}
+
+	var stored Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &stored.Memory, &stored.Iterations, &stored.Parallelism); err != nil {
+		return false, false, ErrUnknownHashFormat
+	}
+	if stored.Memory == 0 || stored.Iterations == 0 || stored.Parallelism == 0 {
+		return false, false, ErrUnknownHashFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrUnknownHashFormat
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false, false, ErrUnknownHashFormat
+	}
+	stored.SaltLength = uint32(len(salt))
+	stored.KeyLength = uint32(len(want))
+
+	got := argon2.IDKey([]byte(password), salt, stored.Iterations, stored.Memory, stored.Parallelism, stored.KeyLength)
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return false, false, nil
+	}
+
+	return true, stored != params, nil
+}
+
+// PasswordPolicy is the set of rules a new password must satisfy
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters; zero means 12
+	MinLength int
+	// MaxLength is the maximum number of characters; zero means 256
+	MaxLength int
+}
+
+// PasswordPolicyError lists every rule a password broke.
+// It matches ErrWeakPassword with errors.Is.
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return ErrWeakPassword.Error() + ": " + strings.Join(e.Violations, "; ")
+}
+
+func (e *PasswordPolicyError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+// Check returns a *PasswordPolicyError if password is unacceptable for the
+// user with the given username and email
+func (p PasswordPolicy) Check(password, username, email string) error {
+	minLength, maxLength := p.MinLength, p.MaxLength
+	if minLength == 0 {
+		minLength = 12
+	}
+	if maxLength == 0 {
+		maxLength = 256
+	}
+
+	var violations []string
+	length := utf8.RuneCountInString(password)
+	if !utf8.ValidString(password) {
+		violations = append(violations, "must be valid UTF-8")
+	}
+	if length < minLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters", minLength))
+	}
+	if length > maxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d characters", maxLength))
+	}
+	if strings.TrimSpace(password) == "" {
+		violations = append(violations, "must not be blank")
+	}
+	lower := strings.ToLower(password)
+	if (username != "" && strings.Contains(lower, strings.ToLower(username))) ||
+		(email != "" && strings.Contains(lower, strings.ToLower(email))) {
+		violations = append(violations, "must not contain the username or email")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
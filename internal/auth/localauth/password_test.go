@@ -0,0 +1,86 @@
+package localauth
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2Params keep hashing fast in tests
+var testArgon2Params = Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHashPasswordRoundTrip(t *testing.T) {
+	hash, err := HashPassword("correct horse battery", testArgon2Params)
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+
+	match, needsRehash, err := VerifyPassword("correct horse battery", hash, testArgon2Params)
+	if err != nil || !match || needsRehash {
+		t.Fatalf("verify = %v, %v, %v; want match without rehash", match, needsRehash, err)
+	}
+
+	match, _, err = VerifyPassword("wrong horse battery", hash, testArgon2Params)
+	if err != nil || match {
+		t.Fatalf("verify wrong password = %v, %v; want no match", match, err)
+	}
+}
+
+func TestVerifyPasswordFlagsOutdatedHashes(t *testing.T) {
+	hash, err := HashPassword("correct horse battery", testArgon2Params)
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+	match, needsRehash, err := VerifyPassword("correct horse battery", hash, stronger)
+	if err != nil || !match || !needsRehash {
+		t.Fatalf("verify = %v, %v, %v; want match needing rehash", match, needsRehash, err)
+	}
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse battery"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	match, needsRehash, err = VerifyPassword("correct horse battery", string(legacy), testArgon2Params)
+	if err != nil || !match || !needsRehash {
+		t.Fatalf("verify bcrypt = %v, %v, %v; want match needing rehash", match, needsRehash, err)
+	}
+}
+
+func TestVerifyPasswordRejectsUnknownFormat(t *testing.T) {
+	for _, hash := range []string{"", "plaintext", "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5"} {
+		if _, _, err := VerifyPassword("password", hash, testArgon2Params); !errors.Is(err, ErrUnknownHashFormat) {
+			t.Errorf("VerifyPassword(%q) err = %v, want %v", hash, err, ErrUnknownHashFormat)
+		}
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{}
+
+	tests := map[string]struct {
+		password string
+		wantErr  bool
+	}{
+		"long enough":       {"correct horse battery", false},
+		"too short":         {"short", true},
+		"blank":             {"              ", true},
+		"contains username": {"marc-is-my-password", true},
+		"contains email":    {"xx marc@example.com xx", true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := policy.Check(tt.password, "marc", "marc@example.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error = %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrWeakPassword) {
+				t.Fatalf("err = %v, want %v", err, ErrWeakPassword)
+			}
+		})
+	}
+}
@@ -0,0 +1,493 @@
+// Package localauth is an auth.Servicer that authenticates users against
+// password hashes stored next to the users table, for deployments without
+// an external identity provider.
+//
+// Login exchanges a username and password for a short-lived signed ID token,
+// which CreateSessionCookie exchanges for a signed session cookie backed by a
+// row in the sessions table. Every session cookie verification consults that
+// table, so revocation always takes effect immediately.
+package localauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+const (
+	// DefaultIDTokenTTL is how long an ID token from Login can be exchanged
+	// for a session or used as a bearer token
+	DefaultIDTokenTTL = 15 * time.Minute
+
+	// DefaultSessionTTL is the session lifetime when CreateSessionCookie is
+	// not given one
+	DefaultSessionTTL = 7 * 24 * time.Hour
+
+	// MaxSessionTTL caps the lifetime a caller may request
+	MaxSessionTTL = 30 * 24 * time.Hour
+
+	// minSecretLength is the shortest accepted signing secret, in bytes
+	minSecretLength = 32
+)
+
+// Store is the subset of repo.Store the provider needs
+type Store interface {
+	GetUser(ctx context.Context, id int64) (repo.User, error)
+	GetUserCredentials(ctx context.Context, username string) (repo.GetUserCredentialsRow, error)
+	SetUserPassword(ctx context.Context, arg repo.SetUserPasswordParams) (int64, error)
+	CreateSession(ctx context.Context, arg repo.CreateSessionParams) (repo.Session, error)
+	GetSession(ctx context.Context, id string) (repo.Session, error)
+	RevokeSession(ctx context.Context, id string) (int64, error)
+	RevokeSubjectSessions(ctx context.Context, subject string) (int64, error)
+	MarkSessionMFA(ctx context.Context, arg repo.MarkSessionMFAParams) (int64, error)
+}
+
+// Config configures a Provider
+type Config struct {
+	// Secret signs ID tokens and session cookies; at least 32 bytes
+	Secret []byte
+	// Argon2 are the cost parameters for new hashes; zero means DefaultArgon2Params
+	Argon2 Argon2Params
+	Policy PasswordPolicy
+	// IDTokenTTL is zero for DefaultIDTokenTTL
+	IDTokenTTL time.Duration
+	// SessionTTL is zero for DefaultSessionTTL
+	SessionTTL time.Duration
+	// Now returns the current time; nil means time.Now
+	Now    func() time.Time
+	Logger *slog.Logger
+}
+
+// Provider authenticates users with locally stored passwords.
+// UIDs are the decimal users.id.
+type Provider struct {
+	store      Store
+	idKey      []byte
+	sessionKey []byte
+	argon2     Argon2Params
+	policy     PasswordPolicy
+	idTokenTTL time.Duration
+	sessionTTL time.Duration
+	now        func() time.Time
+	logger     *slog.Logger
+
+	// dummyHash is compared against when a username does not exist, so
+	// unknown and known usernames take the same time to reject
+	dummyHash string
+}
+
+// New creates a Provider backed by store
+func New(store Store, cfg Config) (*Provider, error) {
+	if len(cfg.Secret) < minSecretLength {
+		return nil, fmt.Errorf("localauth: secret must be at least %d bytes", minSecretLength)
+	}
+	if cfg.Argon2 == (Argon2Params{}) {
+		cfg.Argon2 = DefaultArgon2Params
+	}
+	if cfg.IDTokenTTL == 0 {
+		cfg.IDTokenTTL = DefaultIDTokenTTL
+	}
+	if cfg.SessionTTL == 0 {
+		cfg.SessionTTL = DefaultSessionTTL
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	dummy := make([]byte, 32)
+	if _, err := rand.Read(dummy); err != nil {
+		return nil, err
+	}
+	dummyHash, err := HashPassword(hex.EncodeToString(dummy), cfg.Argon2)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		store:      store,
+		idKey:      deriveKey(cfg.Secret, "localauth id token"),
+		sessionKey: deriveKey(cfg.Secret, "localauth session cookie"),
+		argon2:     cfg.Argon2,
+		policy:     cfg.Policy,
+		idTokenTTL: cfg.IDTokenTTL,
+		sessionTTL: cfg.SessionTTL,
+		now:        cfg.Now,
+		logger:     cfg.Logger,
+		dummyHash:  dummyHash,
+	}, nil
+}
+
+// SetPassword checks password against the policy and stores its hash for
+// the user. It returns a *PasswordPolicyError for a weak password and
+// auth.ErrUserNotFound for an unknown user.
+func (p *Provider) SetPassword(ctx context.Context, userID int64, password string) error {
+	user, err := p.store.GetUser(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := p.policy.Check(password, user.Username, user.Email); err != nil {
+		return err
+	}
+
+	hash, err := HashPassword(password, p.argon2)
+	if err != nil {
+		return err
+	}
+	_, err = p.store.SetUserPassword(ctx, repo.SetUserPasswordParams{UserID: userID, PasswordHash: hash})
+	return err
+}
+
+// ChangePassword replaces the password of the user with the given UID after
+// checking their current one. It returns auth.ErrInvalidCredentials when the
+// current password is wrong or none is set, and a *PasswordPolicyError for a
+// weak new password.
+func (p *Provider) ChangePassword(ctx context.Context, uid, currentPassword, newPassword string) error {
+	userID, err := strconv.ParseInt(uid, 10, 64)
+	if err != nil {
+		return auth.ErrUserNotFound
+	}
+	user, err := p.store.GetUser(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	creds, err := p.store.GetUserCredentials(ctx, user.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, _, _ = VerifyPassword(currentPassword, p.dummyHash, p.argon2)
+		return auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+	match, _, err := VerifyPassword(currentPassword, creds.PasswordHash, p.argon2)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return auth.ErrInvalidCredentials
+	}
+
+	return p.SetPassword(ctx, userID, newPassword)
+}
+
+// Login checks a username and password and returns an ID token for
+// CreateSessionCookie, or auth.ErrInvalidCredentials. Hashes made with
+// outdated parameters are replaced.
+func (p *Provider) Login(ctx context.Context, username, password string) (string, error) {
+	creds, err := p.store.GetUserCredentials(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, _, _ = VerifyPassword(password, p.dummyHash, p.argon2)
+		return "", auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", err
+	}
+
+	match, needsRehash, err := VerifyPassword(password, creds.PasswordHash, p.argon2)
+	if err != nil {
+		return "", err
+	}
+	if !match {
+		return "", auth.ErrInvalidCredentials
+	}
+
+	if needsRehash {
+		p.rehash(ctx, creds.ID, password)
+	}
+
+	return p.signIDToken(creds.ID, creds.Username, creds.Email)
+}
+
+// IssueIDToken returns an ID token for the user with the given UID without
+// checking a password, for callers that verified the user another way
+func (p *Provider) IssueIDToken(ctx context.Context, uid string) (string, error) {
+	userID, err := strconv.ParseInt(uid, 10, 64)
+	if err != nil {
+		return "", auth.ErrUserNotFound
+	}
+	user, err := p.store.GetUser(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", auth.ErrUserNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return p.signIDToken(user.ID, user.Username, user.Email)
+}
+
+// rehash replaces a stored hash with one using the current parameters.
+// Failure is logged rather than failing a login that already succeeded.
+func (p *Provider) rehash(ctx context.Context, userID int64, password string) {
+	hash, err := HashPassword(password, p.argon2)
+	if err == nil {
+		_, err = p.store.SetUserPassword(ctx, repo.SetUserPasswordParams{UserID: userID, PasswordHash: hash})
+	}
+	if err != nil {
+		p.logger.Warn("Failed to rehash password", "user_id", userID, "error", err)
+		return
+	}
+	p.logger.Info("Rehashed password with current parameters", "user_id", userID)
+}
+
+// idTokenClaims is the signed payload of an ID token
+type idTokenClaims struct {
+	Subject  string `json:"sub"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	IssuedAt int64  `json:"iat"`
+	Expiry   int64  `json:"exp"`
+}
+
+func (p *Provider) signIDToken(userID int64, username, email string) (string, error) {
+	now := p.now()
+	payload, err := json.Marshal(idTokenClaims{
+		Subject:  strconv.FormatInt(userID, 10),
+		Username: username,
+		Email:    email,
+		IssuedAt: now.Unix(),
+		Expiry:   now.Add(p.idTokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + sign(p.idKey, encoded), nil
+}
+
+// VerifyIDToken verifies an ID token returned by Login
+func (p *Provider) VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error) {
+	encoded, ok := verifySigned(p.idKey, idToken)
+	if !ok {
+		return nil, auth.ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, auth.ErrMalformedToken
+	}
+	var claims idTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, auth.ErrMalformedToken
+	}
+
+	expiry := time.Unix(claims.Expiry, 0)
+	if !p.now().Before(expiry) {
+		return nil, auth.ErrExpiredToken
+	}
+
+	return &auth.Token{
+		UID:      claims.Subject,
+		Email:    claims.Email,
+		Claims:   map[string]interface{}{"username": claims.Username},
+		Expiry:   expiry,
+		IssuedAt: time.Unix(claims.IssuedAt, 0),
+	}, nil
+}
+
+// CreateSessionCookie exchanges an ID token for a session cookie lasting
+// expiresIn, or the configured session lifetime when expiresIn is zero
+func (p *Provider) CreateSessionCookie(ctx context.Context, idToken string, expiresIn time.Duration) (string, error) {
+	token, err := p.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return "", err
+	}
+
+	if expiresIn <= 0 {
+		expiresIn = p.sessionTTL
+	}
+	if expiresIn > MaxSessionTTL {
+		expiresIn = MaxSessionTTL
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+
+	md, _ := auth.SessionMetadataFromContext(ctx)
+	if _, err := p.store.CreateSession(ctx, repo.CreateSessionParams{
+		ID:        sessionID(encoded),
+		Subject:   token.UID,
+		ExpiresAt: p.now().Add(expiresIn).UTC(),
+		Ip:        md.IP,
+		UserAgent: md.UserAgent,
+		Device:    md.Device,
+	}); err != nil {
+		return "", fmt.Errorf("localauth: creating session: %w", err)
+	}
+
+	return encoded + "." + sign(p.sessionKey, encoded), nil
+}
+
+// VerifySessionCookie verifies a session cookie against the sessions table,
+// rejecting expired and revoked sessions and deleted users
+func (p *Provider) VerifySessionCookie(ctx context.Context, sessionCookie string) (*auth.Token, error) {
+	encoded, ok := verifySigned(p.sessionKey, sessionCookie)
+	if !ok {
+		return nil, auth.ErrInvalidSessionCookie
+	}
+
+	session, err := p.store.GetSession(ctx, sessionID(encoded))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrInvalidSessionCookie
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.RevokedAt.Valid {
+		return nil, auth.ErrRevokedSessionCookie
+	}
+	if !p.now().Before(session.ExpiresAt) {
+		return nil, auth.ErrExpiredSessionCookie
+	}
+
+	userID, err := strconv.ParseInt(session.Subject, 10, 64)
+	if err != nil {
+		return nil, auth.ErrInvalidSessionCookie
+	}
+	user, err := p.store.GetUser(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %w", auth.ErrInvalidSessionCookie, auth.ErrUserNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token := &auth.Token{
+		UID:      session.Subject,
+		Email:    user.Email,
+		Claims:   map[string]interface{}{"username": user.Username},
+		Expiry:   session.ExpiresAt,
+		IssuedAt: session.CreatedAt,
+	}
+	if session.MfaVerifiedAt.Valid {
+		token.Claims[auth.ClaimMFA] = session.MfaVerifiedAt.Time.Unix()
+	}
+	return token, nil
+}
+
+// VerifySessionCookieRevoked is VerifySessionCookie, which always checks revocation
+func (p *Provider) VerifySessionCookieRevoked(ctx context.Context, sessionCookie string) (*auth.Token, error) {
+	return p.VerifySessionCookie(ctx, sessionCookie)
+}
+
+// VerifySessionCookieAndCheckRevoked is VerifySessionCookie, which always checks revocation
+func (p *Provider) VerifySessionCookieAndCheckRevoked(ctx context.Context, sessionCookie string) (*auth.Token, error) {
+	return p.VerifySessionCookie(ctx, sessionCookie)
+}
+
+// RevokeSessionCookie revokes the session behind a cookie
+func (p *Provider) RevokeSessionCookie(ctx context.Context, sessionCookie string) error {
+	encoded, ok := verifySigned(p.sessionKey, sessionCookie)
+	if !ok {
+		return auth.ErrInvalidSessionCookie
+	}
+	_, err := p.store.RevokeSession(ctx, sessionID(encoded))
+	return err
+}
+
+// RecordStepUp marks the session behind a cookie as having passed a second
+// factor at the given time
+func (p *Provider) RecordStepUp(ctx context.Context, sessionCookie string, at time.Time) error {
+	encoded, ok := verifySigned(p.sessionKey, sessionCookie)
+	if !ok {
+		return auth.ErrInvalidSessionCookie
+	}
+	_, err := p.store.MarkSessionMFA(ctx, repo.MarkSessionMFAParams{
+		MfaVerifiedAt: sql.NullTime{Time: at.UTC(), Valid: true},
+		ID:            sessionID(encoded),
+	})
+	return err
+}
+
+// RevokeUserSessions revokes every session of the user with the given UID
+func (p *Provider) RevokeUserSessions(ctx context.Context, uid string) error {
+	_, err := p.store.RevokeSubjectSessions(ctx, uid)
+	return err
+}
+
+// GetUserInfo returns the local user with the given UID
+func (p *Provider) GetUserInfo(ctx context.Context, uid string) (*auth.UserInfo, error) {
+	userID, err := strconv.ParseInt(uid, 10, 64)
+	if err != nil {
+		return nil, auth.ErrUserNotFound
+	}
+	user, err := p.store.GetUser(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.UserInfo{
+		UID:         uid,
+		Email:       user.Email,
+		DisplayName: user.Username,
+	}, nil
+}
+
+// deriveKey derives a purpose-specific key so ID tokens and session
+// cookies can never be substituted for each other
+func deriveKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func sign(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySigned splits "<value>.<signature>" and returns value if the
+// signature is valid
+func verifySigned(key []byte, signed string) (string, bool) {
+	value, signature, ok := strings.Cut(signed, ".")
+	if !ok || value == "" {
+		return "", false
+	}
+	return value, hmac.Equal([]byte(signature), []byte(sign(key, value)))
+}
+
+// sessionID is the sessions.id stored for a cookie secret
+func sessionID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	_ auth.Servicer              = (*Provider)(nil)
+	_ auth.PasswordAuthenticator = (*Provider)(nil)
+	_ auth.IDTokenIssuer         = (*Provider)(nil)
+	_ auth.SessionRevoker        = (*Provider)(nil)
+	_ auth.StepUpRecorder        = (*Provider)(nil)
+)
@@ -0,0 +1,368 @@
+package localauth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database"
+	"github.com/mhpenta/starterA/internal/database/memdb"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+const testPassword = "correct horse battery"
+
+func TestLoginIssuesSessionCookie(t *testing.T) {
+	for name, store := range map[string]Store{
+		"memdb":  memdb.New(),
+		"sqlite": newSQLiteStore(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			provider, _ := newTestProvider(t, store)
+			user := createUserWithPassword(t, store, provider, "marc")
+
+			idToken, err := provider.Login(ctx, "marc", testPassword)
+			if err != nil {
+				t.Fatalf("login: %v", err)
+			}
+			token, err := provider.VerifyIDToken(ctx, idToken)
+			if err != nil {
+				t.Fatalf("verify ID token: %v", err)
+			}
+			if token.UID != strconv.FormatInt(user.ID, 10) || token.Email != "marc@example.com" {
+				t.Fatalf("ID token = %+v", token)
+			}
+
+			cookie, err := provider.CreateSessionCookie(ctx, idToken, time.Hour)
+			if err != nil {
+				t.Fatalf("create session: %v", err)
+			}
+			session, err := provider.VerifySessionCookieAndCheckRevoked(ctx, cookie)
+			if err != nil {
+				t.Fatalf("verify session: %v", err)
+			}
+			if session.UID != token.UID || session.Claims["username"] != "marc" {
+				t.Fatalf("session token = %+v", session)
+			}
+
+			info, err := provider.GetUserInfo(ctx, token.UID)
+			if err != nil || info.DisplayName != "marc" {
+				t.Fatalf("user info = %+v, %v", info, err)
+			}
+		})
+	}
+}
+
+func TestLoginRejectsBadCredentials(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.New()
+	provider, _ := newTestProvider(t, store)
+	createUserWithPassword(t, store, provider, "marc")
+	if _, err := store.CreateUser(ctx, repo.CreateUserParams{Username: "nopass", Email: "nopass@example.com"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	for _, creds := range [][2]string{{"marc", "wrong password!"}, {"nobody", testPassword}, {"nopass", testPassword}} {
+		if _, err := provider.Login(ctx, creds[0], creds[1]); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Errorf("login %s err = %v, want %v", creds[0], err, auth.ErrInvalidCredentials)
+		}
+	}
+}
+
+func TestLoginRehashesOutdatedPasswords(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.New()
+	provider, _ := newTestProvider(t, store)
+	user := createUserWithPassword(t, store, provider, "marc")
+
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+	upgraded, err := New(store, Config{Secret: testSecret(), Argon2: stronger, Logger: discardLogger()})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	if _, err := upgraded.Login(ctx, "marc", testPassword); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	creds, err := store.GetUserCredentials(ctx, user.Username)
+	if err != nil {
+		t.Fatalf("get credentials: %v", err)
+	}
+	if !strings.Contains(creds.PasswordHash, ",t=2,") {
+		t.Fatalf("hash = %q, want rehashed with t=2", creds.PasswordHash)
+	}
+}
+
+func TestSetPasswordEnforcesPolicy(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.New()
+	provider, _ := newTestProvider(t, store)
+	user, err := store.CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	if err := provider.SetPassword(ctx, user.ID, "short"); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("err = %v, want %v", err, ErrWeakPassword)
+	}
+	if err := provider.SetPassword(ctx, user.ID+1, testPassword); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Fatalf("err = %v, want %v", err, auth.ErrUserNotFound)
+	}
+}
+
+func TestChangePasswordRequiresCurrentPassword(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.New()
+	provider, _ := newTestProvider(t, store)
+	user := createUserWithPassword(t, store, provider, "marc")
+	uid := strconv.FormatInt(user.ID, 10)
+	const newPassword = "battery staple horse"
+
+	if err := provider.ChangePassword(ctx, uid, "wrong password", newPassword); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("wrong current password err = %v, want %v", err, auth.ErrInvalidCredentials)
+	}
+	if err := provider.ChangePassword(ctx, uid, testPassword, "short"); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("weak password err = %v, want %v", err, ErrWeakPassword)
+	}
+	if err := provider.ChangePassword(ctx, uid, testPassword, newPassword); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	if _, err := provider.Login(ctx, "marc", testPassword); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("login with old password err = %v, want %v", err, auth.ErrInvalidCredentials)
+	}
+	if _, err := provider.Login(ctx, "marc", newPassword); err != nil {
+		t.Fatalf("login with new password: %v", err)
+	}
+
+	other, err := store.CreateUser(ctx, repo.CreateUserParams{Username: "anna", Email: "anna@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := provider.ChangePassword(ctx, strconv.FormatInt(other.ID, 10), "", newPassword); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("user without password err = %v, want %v", err, auth.ErrInvalidCredentials)
+	}
+}
+
+func TestSessionCookieRevocationAndExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.New()
+	provider, clock := newTestProvider(t, store)
+	user := createUserWithPassword(t, store, provider, "marc")
+
+	newCookie := func() string {
+		t.Helper()
+		idToken, err := provider.Login(ctx, "marc", testPassword)
+		if err != nil {
+			t.Fatalf("login: %v", err)
+		}
+		cookie, err := provider.CreateSessionCookie(ctx, idToken, time.Hour)
+		if err != nil {
+			t.Fatalf("create session: %v", err)
+		}
+		return cookie
+	}
+
+	revoked := newCookie()
+	if err := provider.RevokeSessionCookie(ctx, revoked); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, err := provider.VerifySessionCookieAndCheckRevoked(ctx, revoked); !errors.Is(err, auth.ErrRevokedSessionCookie) {
+		t.Fatalf("revoked err = %v, want %v", err, auth.ErrRevokedSessionCookie)
+	}
+
+	first, second := newCookie(), newCookie()
+	if err := provider.RevokeUserSessions(ctx, strconv.FormatInt(user.ID, 10)); err != nil {
+		t.Fatalf("revoke all: %v", err)
+	}
+	for _, cookie := range []string{first, second} {
+		if _, err := provider.VerifySessionCookie(ctx, cookie); !errors.Is(err, auth.ErrRevokedSessionCookie) {
+			t.Fatalf("revoked err = %v, want %v", err, auth.ErrRevokedSessionCookie)
+		}
+	}
+
+	expiring := newCookie()
+	clock.Advance(2 * time.Hour)
+	if _, err := provider.VerifySessionCookie(ctx, expiring); !errors.Is(err, auth.ErrExpiredSessionCookie) {
+		t.Fatalf("expired err = %v, want %v", err, auth.ErrExpiredSessionCookie)
+	}
+}
+
+func TestRecordStepUpMarksSession(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.New()
+	provider, clock := newTestProvider(t, store)
+	createUserWithPassword(t, store, provider, "marc")
+
+	idToken, err := provider.Login(ctx, "marc", testPassword)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	cookie, err := provider.CreateSessionCookie(ctx, idToken, time.Hour)
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	steppedAt := clock.Now().Truncate(time.Second)
+	if err := provider.RecordStepUp(ctx, cookie, steppedAt); err != nil {
+		t.Fatalf("record step-up: %v", err)
+	}
+	token, err := provider.VerifySessionCookie(ctx, cookie)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if at, ok := auth.MFAVerifiedAt(token); !ok || !at.Equal(steppedAt) {
+		t.Fatalf("MFAVerifiedAt = %v, %v, want %v", at, ok, steppedAt)
+	}
+
+	if err := provider.RecordStepUp(ctx, cookie+"x", steppedAt); !auth.IsInvalidError(err) {
+		t.Fatalf("forged cookie err = %v, want invalid", err)
+	}
+}
+
+func TestIssueIDTokenSkipsPassword(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.New()
+	provider, _ := newTestProvider(t, store)
+	user := createUserWithPassword(t, store, provider, "marc")
+	uid := strconv.FormatInt(user.ID, 10)
+
+	idToken, err := provider.IssueIDToken(ctx, uid)
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	cookie, err := provider.CreateSessionCookie(ctx, idToken, time.Hour)
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	if token, err := provider.VerifySessionCookie(ctx, cookie); err != nil || token.UID != uid {
+		t.Fatalf("session token = %+v, %v; want UID %s", token, err, uid)
+	}
+
+	for _, unknown := range []string{"999", "marc"} {
+		if _, err := provider.IssueIDToken(ctx, unknown); !errors.Is(err, auth.ErrUserNotFound) {
+			t.Fatalf("IssueIDToken(%q) = %v, want ErrUserNotFound", unknown, err)
+		}
+	}
+}
+
+func TestSessionCookieRejectsForgeries(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.New()
+	provider, _ := newTestProvider(t, store)
+	createUserWithPassword(t, store, provider, "marc")
+
+	idToken, err := provider.Login(ctx, "marc", testPassword)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	cookie, err := provider.CreateSessionCookie(ctx, idToken, 0)
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	value, signature, _ := strings.Cut(cookie, ".")
+
+	for name, forged := range map[string]string{
+		"ID token as cookie": idToken,
+		"unsigned":           value,
+		"bad signature":      value + "." + strings.Repeat("A", len(signature)),
+	} {
+		if _, err := provider.VerifySessionCookie(ctx, forged); !errors.Is(err, auth.ErrInvalidSessionCookie) {
+			t.Errorf("%s: err = %v, want %v", name, err, auth.ErrInvalidSessionCookie)
+		}
+	}
+	if _, err := provider.VerifyIDToken(ctx, cookie); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("cookie as ID token: err = %v, want %v", err, auth.ErrInvalidToken)
+	}
+
+	other, err := New(store, Config{Secret: []byte(strings.Repeat("x", 32)), Argon2: testArgon2Params, Logger: discardLogger()})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	if _, err := other.VerifySessionCookie(ctx, cookie); !errors.Is(err, auth.ErrInvalidSessionCookie) {
+		t.Errorf("other secret: err = %v, want %v", err, auth.ErrInvalidSessionCookie)
+	}
+}
+
+func TestNewRequiresLongSecret(t *testing.T) {
+	if _, err := New(memdb.New(), Config{Secret: []byte("too short")}); err == nil {
+		t.Fatal("expected error for short secret, got nil")
+	}
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestProvider(t *testing.T, store Store) (*Provider, *fakeClock) {
+	t.Helper()
+
+	clock := &fakeClock{now: time.Now()}
+	provider, err := New(store, Config{
+		Secret: testSecret(),
+		Argon2: testArgon2Params,
+		Now:    clock.Now,
+		Logger: discardLogger(),
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	return provider, clock
+}
+
+func createUserWithPassword(t *testing.T, store Store, provider *Provider, username string) repo.User {
+	t.Helper()
+
+	creator, ok := store.(interface {
+		CreateUser(ctx context.Context, arg repo.CreateUserParams) (repo.User, error)
+	})
+	if !ok {
+		t.Fatal("store cannot create users")
+	}
+	user, err := creator.CreateUser(context.Background(), repo.CreateUserParams{Username: username, Email: username + "@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := provider.SetPassword(context.Background(), user.ID, testPassword); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	return user
+}
+
+func newSQLiteStore(t *testing.T) repo.Store {
+	t.Helper()
+
+	db, err := database.GetConnection(config.Database{Mode: config.DatabaseModeMemory})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := database.Migrate(context.Background(), db, discardLogger()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return repo.New(db)
+}
+
+func testSecret() []byte {
+	return []byte("0123456789abcdef0123456789abcdef")
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
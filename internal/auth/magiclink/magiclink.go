@@ -0,0 +1,216 @@
+// Package magiclink implements passwordless login by email.
+//
+// Send mails a link carrying a random single-use token to a registered
+// address; only the SHA-256 of the token is stored. Consume exchanges the
+// token for the user's UID once, before it expires. The caller then turns
+// the UID into a session, for example through auth.IDTokenIssuer and
+// CreateSessionCookie.
+package magiclink
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+	"github.com/mhpenta/starterA/internal/mail"
+)
+
+const (
+	// DefaultTTL is how long a link works when Config.TTL is zero
+	DefaultTTL = 15 * time.Minute
+
+	// DefaultSubject is the email subject when Config.Subject is empty
+	DefaultSubject = "Your sign-in link"
+
+	// TokenParam is the query parameter of Config.URL carrying the token
+	TokenParam = "token"
+)
+
+// ErrInvalidLink is returned by Consume for unknown, used and expired tokens
+var ErrInvalidLink = errors.New("magiclink: invalid or expired link")
+
+// Store is the subset of repo.Store the Manager needs
+type Store interface {
+	GetUserByEmail(ctx context.Context, email string) (repo.User, error)
+	CreateMagicLink(ctx context.Context, arg repo.CreateMagicLinkParams) (int64, error)
+	ConsumeMagicLink(ctx context.Context, arg repo.ConsumeMagicLinkParams) (repo.MagicLink, error)
+	DeleteExpiredMagicLinks(ctx context.Context, expiresAt time.Time) (int64, error)
+}
+
+// Config configures a Manager
+type Config struct {
+	// URL is the absolute address of the verify endpoint, such as
+	// https://example.com/auth/magic-link/verify; the token is added as
+	// its TokenParam query parameter
+	URL    string
+	Mailer mail.Mailer
+	// Subject is empty for DefaultSubject
+	Subject string
+	// TTL is zero for DefaultTTL
+	TTL time.Duration
+	// Now returns the current time; nil means time.Now
+	Now    func() time.Time
+	Logger *slog.Logger
+}
+
+// Manager sends and consumes magic links. UIDs are the decimal users.id.
+type Manager struct {
+	store   Store
+	url     *url.URL
+	mailer  mail.Mailer
+	subject string
+	ttl     time.Duration
+	now     func() time.Time
+	logger  *slog.Logger
+}
+
+// New creates a Manager
+func New(store Store, cfg Config) (*Manager, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return nil, fmt.Errorf("magiclink: URL must be absolute, got %q", cfg.URL)
+	}
+	if cfg.Mailer == nil {
+		return nil, errors.New("magiclink: a mailer is required")
+	}
+	if cfg.Subject == "" {
+		cfg.Subject = DefaultSubject
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	return &Manager{
+		store:   store,
+		url:     u,
+		mailer:  cfg.Mailer,
+		subject: cfg.Subject,
+		ttl:     cfg.TTL,
+		now:     cfg.Now,
+		logger:  cfg.Logger,
+	}, nil
+}
+
+// Send mails a new link to the user registered with email. Unknown
+// addresses are ignored without an error, so callers cannot tell which
+// addresses are registered.
+func (m *Manager) Send(ctx context.Context, email string) error {
+	email = strings.TrimSpace(email)
+	user, err := m.store.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.logger.Info("Magic link requested for unknown email")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("magiclink: looking up user: %w", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	if _, err := m.store.CreateMagicLink(ctx, repo.CreateMagicLinkParams{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: m.now().Add(m.ttl).UTC(),
+	}); err != nil {
+		return fmt.Errorf("magiclink: storing link: %w", err)
+	}
+
+	link := *m.url
+	query := link.Query()
+	query.Set(TokenParam, token)
+	link.RawQuery = query.Encode()
+
+	if err := m.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: m.subject,
+		Body:    body(link.String(), m.ttl),
+	}); err != nil {
+		return fmt.Errorf("magiclink: sending email: %w", err)
+	}
+
+	m.logger.Info("Sent magic link", "user_id", user.ID)
+	return nil
+}
+
+// Consume uses up token and returns the UID of the user it was sent to,
+// or ErrInvalidLink
+func (m *Manager) Consume(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", ErrInvalidLink
+	}
+
+	link, err := m.store.ConsumeMagicLink(ctx, repo.ConsumeMagicLinkParams{
+		UsedAt:    sql.NullTime{Time: m.now().UTC(), Valid: true},
+		TokenHash: hashToken(token),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrInvalidLink
+	}
+	if err != nil {
+		return "", fmt.Errorf("magiclink: consuming link: %w", err)
+	}
+
+	return strconv.FormatInt(link.UserID, 10), nil
+}
+
+// Purge deletes expired links and returns how many were removed
+func (m *Manager) Purge(ctx context.Context) (int64, error) {
+	return m.store.DeleteExpiredMagicLinks(ctx, m.now().UTC())
+}
+
+// RunPurger calls Purge every interval until ctx is done
+func (m *Manager) RunPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := m.Purge(ctx)
+			if err != nil {
+				m.logger.Error("Failed to purge magic links", "error", err)
+				continue
+			}
+			if purged > 0 {
+				m.logger.Info("Purged magic links", "count", purged)
+			}
+		}
+	}
+}
+
+// hashToken returns the stored form of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func body(link string, ttl time.Duration) string {
+	return fmt.Sprintf(`Use the link below to sign in. It works once and expires in %d minutes.
+
+%s
+
+If you did not ask to sign in, you can ignore this email.
+`, int(ttl.Round(time.Minute).Minutes()), link)
+}
@@ -0,0 +1,178 @@
+package magiclink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database"
+	"github.com/mhpenta/starterA/internal/database/memdb"
+	"github.com/mhpenta/starterA/internal/database/repo"
+	"github.com/mhpenta/starterA/internal/mail"
+)
+
+func TestMagicLinkIsSingleUse(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			manager, dir, _ := newTestManager(t, store)
+			user := createUser(t, store, "marc")
+
+			if err := manager.Send(ctx, " marc@example.com "); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			link := lastLink(t, dir)
+			if link.Host != "example.com" || link.Path != "/auth/magic-link/verify" {
+				t.Fatalf("link = %s", link)
+			}
+
+			uid, err := manager.Consume(ctx, link.Query().Get(TokenParam))
+			if err != nil {
+				t.Fatalf("Consume: %v", err)
+			}
+			if uid != strconv.FormatInt(user.ID, 10) {
+				t.Fatalf("uid = %s, want %d", uid, user.ID)
+			}
+			if _, err := manager.Consume(ctx, link.Query().Get(TokenParam)); !errors.Is(err, ErrInvalidLink) {
+				t.Fatalf("second Consume = %v, want ErrInvalidLink", err)
+			}
+			for _, token := range []string{"", "not-a-token"} {
+				if _, err := manager.Consume(ctx, token); !errors.Is(err, ErrInvalidLink) {
+					t.Fatalf("Consume(%q) = %v, want ErrInvalidLink", token, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMagicLinkExpires(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			manager, dir, clock := newTestManager(t, store)
+			createUser(t, store, "marc")
+
+			if err := manager.Send(ctx, "marc@example.com"); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			token := lastLink(t, dir).Query().Get(TokenParam)
+
+			*clock = clock.Add(DefaultTTL + time.Second)
+			if _, err := manager.Consume(ctx, token); !errors.Is(err, ErrInvalidLink) {
+				t.Fatalf("Consume after expiry = %v, want ErrInvalidLink", err)
+			}
+			if purged, err := manager.Purge(ctx); err != nil || purged != 1 {
+				t.Fatalf("Purge = %d, %v; want 1", purged, err)
+			}
+		})
+	}
+}
+
+func TestSendIgnoresUnknownEmail(t *testing.T) {
+	manager, dir, _ := newTestManager(t, memdb.New())
+
+	if err := manager.Send(context.Background(), "nobody@example.com"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.eml")); len(files) != 0 {
+		t.Fatalf("sent %d emails, want none", len(files))
+	}
+}
+
+func TestNewRequiresAbsoluteURLAndMailer(t *testing.T) {
+	mailer := mail.NewLogMailer(slog.New(slog.DiscardHandler))
+	for _, cfg := range []Config{
+		{URL: "/auth/magic-link/verify", Mailer: mailer},
+		{URL: "https://example.com/auth/magic-link/verify"},
+	} {
+		if _, err := New(memdb.New(), cfg); err == nil {
+			t.Fatalf("New(%+v) succeeded, want error", cfg)
+		}
+	}
+}
+
+var linkPattern = regexp.MustCompile(`https://\S+`)
+
+// lastLink returns the link in the newest email written to dir
+func lastLink(t *testing.T, dir string) *url.URL {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no emails in %s: %v", dir, err)
+	}
+	data, err := os.ReadFile(files[len(files)-1])
+	if err != nil {
+		t.Fatalf("read email: %v", err)
+	}
+	match := linkPattern.Find(data)
+	if match == nil {
+		t.Fatalf("email %q has no link", data)
+	}
+	link, err := url.Parse(string(match))
+	if err != nil {
+		t.Fatalf("parse link: %v", err)
+	}
+	return link
+}
+
+func newTestManager(t *testing.T, store Store) (*Manager, string, *time.Time) {
+	t.Helper()
+
+	dir := t.TempDir()
+	mailer, err := mail.NewFileMailer(dir, "noreply@example.com")
+	if err != nil {
+		t.Fatalf("NewFileMailer: %v", err)
+	}
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	manager, err := New(store, Config{
+		URL:    "https://example.com/auth/magic-link/verify",
+		Mailer: mailer,
+		Now:    func() time.Time { return clock },
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return manager, dir, &clock
+}
+
+func createUser(t *testing.T, store Store, username string) repo.User {
+	t.Helper()
+
+	creator := store.(interface {
+		CreateUser(ctx context.Context, arg repo.CreateUserParams) (repo.User, error)
+	})
+	user, err := creator.CreateUser(context.Background(), repo.CreateUserParams{Username: username, Email: username + "@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	db, err := database.GetConnection(config.Database{Mode: config.DatabaseModeMemory})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := database.Migrate(context.Background(), db, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return map[string]Store{
+		"memdb":  memdb.New(),
+		"sqlite": repo.New(db),
+	}
+}
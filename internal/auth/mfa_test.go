@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMFAVerifiedAt(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   bool
+	}{
+		{"none", map[string]interface{}{"role": "admin"}, false},
+		{"int64", map[string]interface{}{ClaimMFA: at.Unix()}, true},
+		{"float64", map[string]interface{}{ClaimMFA: float64(at.Unix())}, true},
+		{"json number", map[string]interface{}{ClaimMFA: json.Number("1700000000")}, true},
+		{"amr mfa", map[string]interface{}{ClaimAMR: []interface{}{"pwd", "mfa"}}, true},
+		{"amr otp", map[string]interface{}{ClaimAMR: []string{"otp"}}, true},
+		{"amr pwd", map[string]interface{}{ClaimAMR: []string{"pwd"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := MFAVerifiedAt(&Token{Claims: tt.claims, IssuedAt: at})
+			if ok != tt.want {
+				t.Fatalf("ok = %v, want %v", ok, tt.want)
+			}
+			if ok && !got.Equal(at) {
+				t.Fatalf("verified at %v, want %v", got, at)
+			}
+		})
+	}
+}
+
+func TestTokenWithMFAKeepsOriginal(t *testing.T) {
+	original := &Token{UID: "u1", Claims: map[string]interface{}{"role": "admin"}}
+	stepped := TokenWithMFA(original, time.Now())
+
+	if _, ok := original.Claims[ClaimMFA]; ok {
+		t.Fatal("TokenWithMFA modified the original claims")
+	}
+	if stepped.UID != "u1" || stepped.Claims["role"] != "admin" {
+		t.Fatalf("stepped = %+v, want original fields kept", stepped)
+	}
+	if err := HasMFA(time.Minute).Check(stepped); err != nil {
+		t.Fatalf("HasMFA(stepped) = %v, want nil", err)
+	}
+}
+
+func TestHasMFARefusesMissingOrStaleStepUp(t *testing.T) {
+	stale := TokenWithMFA(&Token{UID: "u1"}, time.Now().Add(-time.Hour))
+	for _, token := range []*Token{{UID: "u1"}, stale} {
+		if err := HasMFA(15 * time.Minute).Check(token); !errors.Is(err, ErrForbidden) {
+			t.Fatalf("HasMFA = %v, want ErrForbidden", err)
+		}
+	}
+	if err := HasMFA(0).Check(stale); err != nil {
+		t.Fatalf("HasMFA(0) = %v, want nil", err)
+	}
+}
@@ -1,8 +1,15 @@
 package auth
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mhpenta/starterA/internal/problem"
 )
 
 // TokenSource indicates where the token was found.
@@ -12,16 +19,25 @@ const (
 	TokenSourceNone TokenSource = iota
 	TokenSourceHeader
 	TokenSourceCookie
+	TokenSourceAPIKey
 )
 
 // extractToken attempts to get a token from the request.
-// It checks the Authorization header first, then falls back to the session cookie.
+// It checks the Authorization header first (bearer token or API key), then
+// the X-API-Key header, then falls back to the session cookie.
 func extractToken(r *http.Request) (token string, source TokenSource) {
 	// Check Authorization header first
 	authHeader := r.Header.Get(AuthHeader)
 	if strings.HasPrefix(authHeader, BearerPrefix) {
 		return strings.TrimPrefix(authHeader, BearerPrefix), TokenSourceHeader
 	}
+	if strings.HasPrefix(authHeader, APIKeyPrefix) {
+		return strings.TrimPrefix(authHeader, APIKeyPrefix), TokenSourceAPIKey
+	}
+
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key, TokenSourceAPIKey
+	}
 
 	// Fall back to session cookie
 	cookie, err := r.Cookie(SessionCookieName)
@@ -32,108 +48,208 @@ func extractToken(r *http.Request) (token string, source TokenSource) {
 	return "", TokenSourceNone
 }
 
-// RequireAuth returns middleware that requires a valid auth token.
-// Requests without a valid token receive a 401 Unauthorized response.
-func RequireAuth(provider Servicer) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			tokenStr, source := extractToken(r)
-			if tokenStr == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
+// ErrorHandler writes the response for a request the auth middleware
+// rejected.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err *AuthError)
 
-			var token *Token
-			var err error
+// FailureHook observes credentials the auth middleware rejected, for
+// example to log or count them. It runs before the ErrorHandler and must
+// not write to the response.
+type FailureHook func(r *http.Request, err *AuthError)
 
-			// Verify based on source
-			switch source {
-			case TokenSourceHeader:
-				token, err = provider.VerifyIDToken(r.Context(), tokenStr)
-			case TokenSourceCookie:
-				token, err = provider.VerifySessionCookie(r.Context(), tokenStr)
-			}
+// MiddlewareOption configures RequireAuth, RequireAuthWithRevocationCheck
+// and OptionalAuth.
+type MiddlewareOption func(*middlewareOptions)
 
-			if err != nil {
-				// Could log the error here for debugging
-				// But don't leak error details to client
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
+type middlewareOptions struct {
+	errorHandler ErrorHandler
+	hooks        []FailureHook
+}
 
-			// Add token to context and continue
-			ctx := ContextWithToken(r.Context(), token)
-			next.ServeHTTP(w, r.WithContext(ctx))
-		})
+// WithErrorHandler replaces DefaultErrorHandler. Given to OptionalAuth, it
+// also makes invalid credentials fail instead of continuing anonymously.
+func WithErrorHandler(handler ErrorHandler) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.errorHandler = handler
 	}
 }
 
-// OptionalAuth returns middleware that validates a token if present,
-// but allows the request to continue even without authentication.
-// If a token is present and valid, it's added to the context.
-// If no token or invalid token, the request continues without a token in context.
-func OptionalAuth(provider Servicer) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			tokenStr, source := extractToken(r)
-			if tokenStr == "" {
-				// No token, continue without auth
-				next.ServeHTTP(w, r)
-				return
-			}
+// WithFailureHook adds a hook called for every rejected request. Hooks run
+// in the order they were added.
+func WithFailureHook(hook FailureHook) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.hooks = append(o.hooks, hook)
+	}
+}
 
-			var token *Token
-			var err error
+// WithLogger logs rejected requests to logger with their request ID.
+// Requests without credentials are logged at debug level, others at warn.
+func WithLogger(logger *slog.Logger) MiddlewareOption {
+	return WithFailureHook(func(r *http.Request, err *AuthError) {
+		level := slog.LevelWarn
+		if err.Code == CodeMissingToken {
+			level = slog.LevelDebug
+		}
+		logger.Log(r.Context(), level, "Authentication failed",
+			"code", err.Code,
+			"source", err.Source.String(),
+			"error", err.Err,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"request_id", err.RequestID,
+		)
+	})
+}
 
-			switch source {
-			case TokenSourceHeader:
-				token, err = provider.VerifyIDToken(r.Context(), tokenStr)
-			case TokenSourceCookie:
-				token, err = provider.VerifySessionCookie(r.Context(), tokenStr)
-			}
+// DefaultErrorHandler answers 401 with an RFC 6750 WWW-Authenticate
+// challenge and problem details whose code member is err.Code. The
+// challenge uses the ApiKey scheme for rejected API keys and Bearer
+// otherwise; it carries error="invalid_token" unless no credentials were
+// sent.
+func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err *AuthError) {
+	w.Header().Set("WWW-Authenticate", challenge(err))
+
+	p := problem.New(http.StatusUnauthorized, problem.TypeUnauthorized, err.Description)
+	p.Code = err.Code
+	p.Instance = err.RequestID
+	_ = problem.Write(w, r, p)
+}
 
-			if err != nil {
-				// Invalid token, but optional auth - continue without it
-				next.ServeHTTP(w, r)
-				return
-			}
+// Challenge returns the WWW-Authenticate value for a 401 answering r with
+// err, in the form DefaultErrorHandler uses. Token errors describe the
+// credentials r carries; other failures, such as a wrong password or a
+// handler reached without a token, get a bare challenge naming the scheme.
+func Challenge(r *http.Request, err error) string {
+	_, source := extractToken(r)
+	var authErr *AuthError
+	switch {
+	case errors.As(err, &authErr):
+	case IsExpiredError(err), IsRevokedError(err), IsInvalidError(err), errors.Is(err, ErrUserDisabled):
+		authErr = NewAuthError(err, source)
+	default:
+		authErr = NewAuthError(nil, source)
+	}
+	return challenge(authErr)
+}
 
-			// Valid token - add to context
-			ctx := ContextWithToken(r.Context(), token)
-			next.ServeHTTP(w, r.WithContext(ctx))
-		})
+// challenge builds the WWW-Authenticate value for err
+func challenge(err *AuthError) string {
+	scheme := "Bearer"
+	if err.Source == TokenSourceAPIKey {
+		scheme = strings.TrimSpace(APIKeyPrefix)
+	}
+	if err.Code == CodeMissingToken {
+		return scheme
+	}
+	return fmt.Sprintf("%s error=%q, error_description=%q", scheme, "invalid_token", err.Description)
+}
+
+// String names the source for logs.
+func (s TokenSource) String() string {
+	switch s {
+	case TokenSourceHeader:
+		return "header"
+	case TokenSourceCookie:
+		return "cookie"
+	case TokenSourceAPIKey:
+		return "api_key"
+	default:
+		return "none"
 	}
 }
 
+// RequireAuth returns middleware that requires a valid auth token.
+// Requests without a valid token are passed to the ErrorHandler, by default
+// DefaultErrorHandler, after any FailureHooks.
+func RequireAuth(provider Servicer, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	return authMiddleware(provider, false, true, opts)
+}
+
+// OptionalAuth returns middleware that validates a token if present,
+// but allows the request to continue even without authentication.
+// If a token is present and valid, it's added to the context.
+// If no token or invalid token, the request continues without a token in
+// context; FailureHooks still see invalid tokens, and WithErrorHandler
+// makes them fail instead.
+func OptionalAuth(provider Servicer, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	return authMiddleware(provider, false, false, opts)
+}
+
 // RequireAuthWithRevocationCheck is like RequireAuth but also checks if the
 // session has been revoked. Use this for sensitive operations.
-func RequireAuthWithRevocationCheck(provider Servicer) func(http.Handler) http.Handler {
+func RequireAuthWithRevocationCheck(provider Servicer, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	return authMiddleware(provider, true, true, opts)
+}
+
+// authMiddleware verifies the request's credentials, checking cookies for
+// revocation when checkRevoked is set. Without required, requests lacking
+// credentials continue anonymously, and so do invalid ones unless an
+// ErrorHandler was given.
+func authMiddleware(provider Servicer, checkRevoked, required bool, opts []MiddlewareOption) func(http.Handler) http.Handler {
+	var o middlewareOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	errorHandler := o.errorHandler
+	if errorHandler == nil && required {
+		errorHandler = DefaultErrorHandler
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			tokenStr, source := extractToken(r)
-			if tokenStr == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			if tokenStr == "" && !required {
+				// No token, continue without auth
+				next.ServeHTTP(w, r)
 				return
 			}
 
-			var token *Token
-			var err error
-
-			switch source {
-			case TokenSourceHeader:
-				token, err = provider.VerifyIDToken(r.Context(), tokenStr)
-			case TokenSourceCookie:
-				// Use the revocation-checking method for cookies
-				token, err = provider.VerifySessionCookieAndCheckRevoked(r.Context(), tokenStr)
-			}
-
+			token, err := verify(r.Context(), provider, tokenStr, source, checkRevoked)
 			if err != nil {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				authErr := NewAuthError(err, source)
+				authErr.RequestID = middleware.GetReqID(r.Context())
+				for _, hook := range o.hooks {
+					hook(r, authErr)
+				}
+				if errorHandler == nil {
+					// Invalid token, but optional auth - continue without it
+					next.ServeHTTP(w, r)
+					return
+				}
+				errorHandler(w, r, authErr)
 				return
 			}
 
+			// Add token to context and continue
 			ctx := ContextWithToken(r.Context(), token)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// verify checks tokenStr according to where it was found
+func verify(ctx context.Context, provider Servicer, tokenStr string, source TokenSource, checkRevoked bool) (*Token, error) {
+	switch source {
+	case TokenSourceHeader:
+		return provider.VerifyIDToken(ctx, tokenStr)
+	case TokenSourceCookie:
+		if checkRevoked {
+			return provider.VerifySessionCookieAndCheckRevoked(ctx, tokenStr)
+		}
+		return provider.VerifySessionCookie(ctx, tokenStr)
+	case TokenSourceAPIKey:
+		return verifyAPIKey(ctx, provider, tokenStr)
+	default:
+		return nil, ErrMissingToken
+	}
+}
+
+// verifyAPIKey verifies an API key with the provider's APIKeyVerifier, if it
+// has one
+func verifyAPIKey(ctx context.Context, provider Servicer, key string) (*Token, error) {
+	verifier, ok := Lookup[APIKeyVerifier](provider)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return verifier.VerifyAPIKey(ctx, key)
+}
@@ -1,9 +1,20 @@
 package auth
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mhpenta/starterA/internal/problem"
 )
 
 func TestRequireAuthWithBearerTokenAddsTokenToContext(t *testing.T) {
@@ -86,3 +97,216 @@ func TestOptionalAuthAllowsInvalidTokenWithoutContext(t *testing.T) {
 		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
 	}
 }
+
+func TestRequireAuthDescribesFailures(t *testing.T) {
+	provider := NewMockProvider()
+	provider.AddUser("test-token-expired", &Token{UID: "user-old", Expiry: time.Now().Add(-time.Hour)}, nil)
+	handler := RequireAuth(WithAPIKeys(provider, fakeKeyVerifier{}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("next handler should not run")
+	}))
+
+	tests := []struct {
+		name, header, value string
+		code, challenge     string
+	}{
+		{"missing", "", "", CodeMissingToken, "Bearer"},
+		{"expired", AuthHeader, BearerPrefix + "test-token-expired", CodeExpiredToken,
+			`Bearer error="invalid_token", error_description="The token has expired"`},
+		{"unknown", AuthHeader, BearerPrefix + "bad-token", CodeInvalidToken,
+			`Bearer error="invalid_token", error_description="The token is invalid"`},
+		{"api key", APIKeyHeader, "sk_bad", CodeInvalidToken,
+			`ApiKey error="invalid_token", error_description="The token is invalid"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); got != tt.challenge {
+				t.Fatalf("WWW-Authenticate = %q, want %q", got, tt.challenge)
+			}
+			var body problem.Details
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Code != tt.code {
+				t.Fatalf("body = %+v, %v; want code %q", body, err, tt.code)
+			}
+		})
+	}
+}
+
+func TestNewAuthErrorClassifiesProviderErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, CodeMissingToken},
+		{ErrMissingCookie, CodeMissingToken},
+		{fmt.Errorf("session: %w", ErrRevokedSessionCookie), CodeRevokedToken},
+		{ErrExpiredSessionCookie, CodeExpiredToken},
+		{fmt.Errorf("%w: bad header", ErrMalformedToken), CodeMalformedToken},
+		{ErrUserDisabled, CodeUserDisabled},
+		{errors.New("jwks unavailable"), CodeInvalidToken},
+	}
+
+	for _, tt := range tests {
+		if got := NewAuthError(tt.err, TokenSourceCookie); got.Code != tt.want {
+			t.Errorf("NewAuthError(%v).Code = %q, want %q", tt.err, got.Code, tt.want)
+		}
+	}
+}
+
+func TestChallengeMatchesRequestCredentials(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		err    error
+		want   string
+	}{
+		{"no credentials", "", ErrTokenNotInContext, "Bearer"},
+		{"wrong password", BearerPrefix + "tok", ErrInvalidCredentials, "Bearer"},
+		{"expired bearer", BearerPrefix + "tok", ErrExpiredToken, `Bearer error="invalid_token", error_description="The token has expired"`},
+		{"revoked api key", APIKeyPrefix + "key", ErrRevokedToken, `ApiKey error="invalid_token", error_description="The token has been revoked"`},
+		{"auth error", "", NewAuthError(ErrInvalidToken, TokenSourceCookie), `Bearer error="invalid_token", error_description="The token is invalid"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(AuthHeader, tt.header)
+			}
+			if got := Challenge(req, fmt.Errorf("wrapped: %w", tt.err)); got != tt.want {
+				t.Fatalf("Challenge = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareOptions(t *testing.T) {
+	provider := NewMockProvider()
+	var seen []*AuthError
+	hook := WithFailureHook(func(r *http.Request, err *AuthError) { seen = append(seen, err) })
+	teapot := WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err *AuthError) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		want    int
+	}{
+		{"require with handler", RequireAuth(provider, hook, teapot)(next), http.StatusTeapot},
+		{"optional continues", OptionalAuth(provider, hook)(next), http.StatusNoContent},
+		{"optional with handler", OptionalAuth(provider, hook, teapot)(next), http.StatusTeapot},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(AuthHeader, BearerPrefix+"bad-token")
+			rec := httptest.NewRecorder()
+			middleware.RequestID(tt.handler).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if len(seen) != 1 || seen[0].Code != CodeInvalidToken || seen[0].RequestID == "" {
+				t.Fatalf("hook saw %+v, want one invalid_token failure with a request ID", seen)
+			}
+		})
+	}
+
+	// Optional auth does not report requests without credentials
+	seen = nil
+	rec := httptest.NewRecorder()
+	OptionalAuth(provider, hook)(next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNoContent || len(seen) != 0 {
+		t.Fatalf("anonymous status = %d, hook calls = %d; want 204 and none", rec.Code, len(seen))
+	}
+}
+
+func TestWithLoggerRecordsRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	handler := middleware.RequestID(RequireAuth(NewMockProvider(), WithLogger(logger))(http.NotFoundHandler()))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: "bad-token"})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, want := range []string{"level=WARN", "code=invalid_token", "source=cookie", "path=/api/users", "request_id="} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("log %q does not contain %q", buf.String(), want)
+		}
+	}
+}
+
+type fakeKeyVerifier map[string]*Token
+
+func (f fakeKeyVerifier) VerifyAPIKey(ctx context.Context, key string) (*Token, error) {
+	if token, ok := f[key]; ok {
+		return token, nil
+	}
+	return nil, ErrInvalidToken
+}
+
+func TestRequireAuthAcceptsAPIKeys(t *testing.T) {
+	keys := fakeKeyVerifier{"sk_good": {UID: "apikey:1", Claims: map[string]interface{}{ClaimAPIKey: "good"}}}
+	handler := func(provider Servicer) http.Handler {
+		return RequireAuth(provider)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if UIDFromContext(r.Context()) != "apikey:1" {
+				t.Fatalf("UID = %q, want apikey:1", UIDFromContext(r.Context()))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+
+	tests := []struct {
+		name     string
+		provider Servicer
+		header   string
+		value    string
+		want     int
+	}{
+		{"x-api-key header", WithAPIKeys(NewMockProvider(), keys), APIKeyHeader, "sk_good", http.StatusNoContent},
+		{"authorization scheme", WithAPIKeys(NewMockProvider(), keys), AuthHeader, APIKeyPrefix + "sk_good", http.StatusNoContent},
+		{"unknown key", WithAPIKeys(NewMockProvider(), keys), APIKeyHeader, "sk_bad", http.StatusUnauthorized},
+		{"provider without keys", NewMockProvider(), APIKeyHeader, "sk_good", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(tt.header, tt.value)
+			rec := httptest.NewRecorder()
+			handler(tt.provider).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupFollowsDecorators(t *testing.T) {
+	mock := NewMockProvider()
+	provider := WithAPIKeys(mock, fakeKeyVerifier{})
+
+	found, ok := Lookup[*MockProvider](provider)
+	if !ok || found != mock {
+		t.Fatalf("Lookup = %v, %v; want the mock provider", found, ok)
+	}
+	if _, ok := Lookup[SessionRevoker](provider); ok {
+		t.Fatal("Lookup found a SessionRevoker the chain does not have")
+	}
+}
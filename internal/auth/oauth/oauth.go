@@ -0,0 +1,452 @@
+// Package oauth implements login with external OAuth 2.0 / OpenID Connect
+// providers using the authorization code flow with PKCE.
+//
+// Start stores a random state, nonce and PKCE verifier and returns the
+// provider's authorization URL; only the SHA-256 of the state is stored.
+// Finish consumes the state once, exchanges the code at the provider's
+// token endpoint and verifies the returned ID token, including its nonce.
+// The caller then links the identity to a local user and starts a session,
+// for example through service.ProvisionIdentity, auth.IDTokenIssuer and
+// CreateSessionCookie.
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+const (
+	// DefaultStateTTL is how long a login may take when Config.StateTTL is zero
+	DefaultStateTTL = 10 * time.Minute
+
+	// maxResponseBytes bounds the size of discovery and token responses
+	maxResponseBytes = 1 << 20
+)
+
+// DefaultScopes are requested when ProviderConfig.Scopes is empty
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+var (
+	// ErrUnknownProvider is returned for provider names that are not configured
+	ErrUnknownProvider = errors.New("oauth: unknown provider")
+
+	// ErrInvalidState is returned by Finish for unknown, used and expired states
+	ErrInvalidState = errors.New("oauth: invalid or expired state")
+)
+
+// ProviderError is an error reported by the provider, either on the redirect
+// back from the authorization endpoint or by the token endpoint
+type ProviderError struct {
+	Code        string
+	Description string
+}
+
+func (e *ProviderError) Error() string {
+	if e.Description == "" {
+		return "oauth: provider error: " + e.Code
+	}
+	return "oauth: provider error: " + e.Code + ": " + e.Description
+}
+
+// Store is the subset of repo.Store the Manager needs
+type Store interface {
+	CreateOAuthState(ctx context.Context, arg repo.CreateOAuthStateParams) (int64, error)
+	ConsumeOAuthState(ctx context.Context, arg repo.ConsumeOAuthStateParams) (repo.OauthState, error)
+	DeleteExpiredOAuthStates(ctx context.Context, expiresAt time.Time) (int64, error)
+}
+
+// ProviderConfig configures one external provider
+type ProviderConfig struct {
+	// Name identifies the provider in URLs such as /auth/{name}/login; it
+	// is lowercase letters, digits, '-' and '_'
+	Name string
+	// Issuer is the required iss claim of ID tokens. Endpoints left empty
+	// are discovered from Issuer's OpenID configuration.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	JWKSURL      string
+	// Scopes is empty for DefaultScopes
+	Scopes []string
+	// RedirectURL is the absolute address of the callback endpoint
+	// registered with the provider, such as
+	// https://example.com/auth/google/callback
+	RedirectURL string
+}
+
+// Config configures a Manager
+type Config struct {
+	Providers []ProviderConfig
+	// StateTTL is zero for DefaultStateTTL
+	StateTTL time.Duration
+	// HTTPClient talks to the providers; nil means a client with a 10
+	// second timeout
+	HTTPClient *http.Client
+	// Now returns the current time; nil means time.Now
+	Now    func() time.Time
+	Logger *slog.Logger
+}
+
+// Manager runs the authorization code flow against the configured providers
+type Manager struct {
+	store     Store
+	providers map[string]*provider
+	ttl       time.Duration
+	client    *http.Client
+	now       func() time.Time
+	logger    *slog.Logger
+}
+
+// provider is a configured provider with its lazily discovered endpoints
+type provider struct {
+	cfg      ProviderConfig
+	verifier *auth.JWTProvider
+
+	mu       sync.Mutex
+	authURL  string
+	tokenURL string
+}
+
+var providerName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// New creates a Manager. Endpoints and keys are fetched lazily on first use.
+func New(store Store, cfg Config) (*Manager, error) {
+	if cfg.StateTTL <= 0 {
+		cfg.StateTTL = DefaultStateTTL
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	providers := make(map[string]*provider, len(cfg.Providers))
+	for _, pc := range cfg.Providers {
+		if !providerName.MatchString(pc.Name) {
+			return nil, fmt.Errorf("oauth: invalid provider name %q", pc.Name)
+		}
+		if _, ok := providers[pc.Name]; ok {
+			return nil, fmt.Errorf("oauth: provider %q is configured twice", pc.Name)
+		}
+		if pc.ClientID == "" {
+			return nil, fmt.Errorf("oauth: provider %q has no client ID", pc.Name)
+		}
+		if u, err := url.Parse(pc.RedirectURL); err != nil || !u.IsAbs() || u.Host == "" {
+			return nil, fmt.Errorf("oauth: provider %q redirect URL must be absolute, got %q", pc.Name, pc.RedirectURL)
+		}
+		if len(pc.Scopes) == 0 {
+			pc.Scopes = DefaultScopes
+		}
+
+		verifier, err := auth.NewJWTProvider(auth.JWTConfig{
+			Issuer:     pc.Issuer,
+			Audience:   []string{pc.ClientID},
+			JWKSURL:    pc.JWKSURL,
+			HTTPClient: cfg.HTTPClient,
+			Now:        cfg.Now,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("oauth: provider %q: %w", pc.Name, err)
+		}
+		providers[pc.Name] = &provider{cfg: pc, verifier: verifier, authURL: pc.AuthURL, tokenURL: pc.TokenURL}
+	}
+
+	return &Manager{
+		store:     store,
+		providers: providers,
+		ttl:       cfg.StateTTL,
+		client:    cfg.HTTPClient,
+		now:       cfg.Now,
+		logger:    cfg.Logger,
+	}, nil
+}
+
+// Providers returns the names of the configured providers in order
+func (m *Manager) Providers() []string {
+	names := make([]string, 0, len(m.providers))
+	for name := range m.providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Start begins a login with the named provider. It returns the URL to send
+// the browser to and the state, which the caller should bind to the browser,
+// for example in a cookie, and pass to Finish.
+func (m *Manager) Start(ctx context.Context, name string) (authURL, state string, err error) {
+	p, ok := m.providers[name]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+	endpoint, _, err := m.endpoints(ctx, p)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err = randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	codeVerifier, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := m.store.CreateOAuthState(ctx, repo.CreateOAuthStateParams{
+		StateHash:    hashState(state),
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    m.now().Add(m.ttl).UTC(),
+	}); err != nil {
+		return "", "", fmt.Errorf("oauth: storing state: %w", err)
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("oauth: provider %q authorization URL: %w", name, err)
+	}
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	u.RawQuery = query.Encode()
+
+	return u.String(), state, nil
+}
+
+// Finish completes a login with the named provider. It uses up state,
+// exchanges code for tokens and returns the verified ID token. Unknown, used
+// and expired states give ErrInvalidState; errors from the token endpoint
+// are *ProviderError.
+func (m *Manager) Finish(ctx context.Context, name, state, code string) (*auth.Token, error) {
+	p, ok := m.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	if state == "" {
+		return nil, ErrInvalidState
+	}
+
+	stored, err := m.store.ConsumeOAuthState(ctx, repo.ConsumeOAuthStateParams{
+		StateHash: hashState(state),
+		Provider:  name,
+		ExpiresAt: m.now().UTC(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidState
+	}
+	if err != nil {
+		return nil, fmt.Errorf("oauth: consuming state: %w", err)
+	}
+	if code == "" {
+		return nil, &ProviderError{Code: "invalid_request", Description: "no authorization code"}
+	}
+
+	idToken, err := m.exchange(ctx, p, code, stored.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	token, err := p.verifier.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+	if nonce, _ := token.Claims["nonce"].(string); nonce != stored.Nonce {
+		return nil, fmt.Errorf("%w: nonce does not match", auth.ErrInvalidToken)
+	}
+
+	return token, nil
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchange redeems code at the token endpoint and returns the ID token.
+// Confidential clients authenticate with HTTP Basic, public clients send
+// only their client_id.
+func (m *Manager) exchange(ctx context.Context, p *provider, code, codeVerifier string) (string, error) {
+	_, endpoint, err := m.endpoints(ctx, p)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oauth: exchanging code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body tokenResponse
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&body)
+	if body.Error != "" {
+		return "", &ProviderError{Code: body.Error, Description: body.ErrorDescription}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oauth: token endpoint returned %s", resp.Status)
+	}
+	if decodeErr != nil {
+		return "", fmt.Errorf("oauth: decoding token response: %w", decodeErr)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("%w: token response has no id_token", auth.ErrMissingToken)
+	}
+
+	return body.IDToken, nil
+}
+
+// endpoints returns the provider's authorization and token endpoints,
+// discovering them from the issuer the first time they are needed. Failed
+// discoveries are retried on the next call.
+func (m *Manager) endpoints(ctx context.Context, p *provider) (authURL, tokenURL string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.authURL != "" && p.tokenURL != "" {
+		return p.authURL, p.tokenURL, nil
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+	}
+	discovery := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := m.getJSON(ctx, discovery, &doc); err != nil {
+		return "", "", fmt.Errorf("oauth: fetching OpenID configuration of %q: %w", p.cfg.Name, err)
+	}
+	if doc.Issuer != p.cfg.Issuer {
+		return "", "", fmt.Errorf("oauth: OpenID configuration is for issuer %q, want %q", doc.Issuer, p.cfg.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" {
+		return "", "", fmt.Errorf("oauth: OpenID configuration of %q lacks endpoints", p.cfg.Name)
+	}
+
+	if p.authURL == "" {
+		p.authURL = doc.AuthorizationEndpoint
+	}
+	if p.tokenURL == "" {
+		p.tokenURL = doc.TokenEndpoint
+	}
+	return p.authURL, p.tokenURL, nil
+}
+
+func (m *Manager) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(v)
+}
+
+// Purge deletes expired states and returns how many were removed
+func (m *Manager) Purge(ctx context.Context) (int64, error) {
+	return m.store.DeleteExpiredOAuthStates(ctx, m.now().UTC())
+}
+
+// RunPurger calls Purge every interval until ctx is done
+func (m *Manager) RunPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := m.Purge(ctx)
+			if err != nil {
+				m.logger.Error("Failed to purge OAuth states", "error", err)
+				continue
+			}
+			if purged > 0 {
+				m.logger.Info("Purged OAuth states", "count", purged)
+			}
+		}
+	}
+}
+
+// randomString returns 32 random bytes, base64url encoded without padding,
+// which also makes a valid PKCE code verifier
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashState returns the stored form of a state
+func hashState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
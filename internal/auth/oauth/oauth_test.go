@@ -0,0 +1,200 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth/oauth/oauthtest"
+	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database"
+	"github.com/mhpenta/starterA/internal/database/memdb"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+const testRedirectURL = "https://app.example.com/auth/test/callback"
+
+func TestLoginRoundTrip(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			server := newTestServer(t, "")
+			manager, _ := newTestManager(t, store, server, "test")
+
+			callback := authorize(t, manager, server, "test")
+			if callback.Get("code") == "" {
+				t.Fatalf("callback = %v, want a code", callback)
+			}
+
+			token, err := manager.Finish(ctx, "test", callback.Get("state"), callback.Get("code"))
+			if err != nil {
+				t.Fatalf("Finish: %v", err)
+			}
+			if token.UID != "user-1" || token.Email != "marc@example.com" || !token.EmailVerified {
+				t.Fatalf("token = %+v", token)
+			}
+
+			_, err = manager.Finish(ctx, "test", callback.Get("state"), callback.Get("code"))
+			if !errors.Is(err, ErrInvalidState) {
+				t.Fatalf("second Finish = %v, want ErrInvalidState", err)
+			}
+		})
+	}
+}
+
+func TestFinishAuthenticatesConfidentialClient(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "s3cret/+")
+	manager, _ := newTestManager(t, memdb.New(), server, "test")
+
+	callback := authorize(t, manager, server, "test")
+	if _, err := manager.Finish(ctx, "test", callback.Get("state"), callback.Get("code")); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+
+	wrong, err := New(memdb.New(), Config{Providers: []ProviderConfig{{
+		Name:         "test",
+		Issuer:       server.Issuer(),
+		ClientID:     server.ClientID,
+		ClientSecret: "rotated",
+		RedirectURL:  testRedirectURL,
+	}}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	callback = authorize(t, wrong, server, "test")
+	_, err = wrong.Finish(ctx, "test", callback.Get("state"), callback.Get("code"))
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Code != "invalid_client" {
+		t.Fatalf("Finish with a wrong secret = %v, want invalid_client", err)
+	}
+}
+
+func TestFinishRejectsForeignAndExpiredStates(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			server := newTestServer(t, "")
+			manager, clock := newTestManager(t, store, server, "test", "other")
+
+			callback := authorize(t, manager, server, "test")
+			for _, state := range []string{"", "not-a-state"} {
+				if _, err := manager.Finish(ctx, "test", state, callback.Get("code")); !errors.Is(err, ErrInvalidState) {
+					t.Fatalf("Finish(state %q) = %v, want ErrInvalidState", state, err)
+				}
+			}
+			if _, err := manager.Finish(ctx, "other", callback.Get("state"), callback.Get("code")); !errors.Is(err, ErrInvalidState) {
+				t.Fatalf("Finish with another provider = %v, want ErrInvalidState", err)
+			}
+			if _, err := manager.Finish(ctx, "missing", callback.Get("state"), callback.Get("code")); !errors.Is(err, ErrUnknownProvider) {
+				t.Fatalf("Finish with an unknown provider = %v, want ErrUnknownProvider", err)
+			}
+
+			*clock = clock.Add(DefaultStateTTL + time.Second)
+			if _, err := manager.Finish(ctx, "test", callback.Get("state"), callback.Get("code")); !errors.Is(err, ErrInvalidState) {
+				t.Fatalf("Finish after expiry = %v, want ErrInvalidState", err)
+			}
+			if purged, err := manager.Purge(ctx); err != nil || purged != 1 {
+				t.Fatalf("Purge = %d, %v; want 1", purged, err)
+			}
+		})
+	}
+}
+
+func TestNewValidatesProviders(t *testing.T) {
+	valid := ProviderConfig{Name: "test", Issuer: "https://issuer.example.com", ClientID: "app", RedirectURL: testRedirectURL}
+	for name, change := range map[string]func(*ProviderConfig){
+		"name":     func(p *ProviderConfig) { p.Name = "Bad Name" },
+		"client":   func(p *ProviderConfig) { p.ClientID = "" },
+		"issuer":   func(p *ProviderConfig) { p.Issuer = "" },
+		"redirect": func(p *ProviderConfig) { p.RedirectURL = "/auth/test/callback" },
+	} {
+		pc := valid
+		change(&pc)
+		if _, err := New(memdb.New(), Config{Providers: []ProviderConfig{pc}}); err == nil {
+			t.Fatalf("New with an invalid %s succeeded", name)
+		}
+	}
+
+	if _, err := New(memdb.New(), Config{Providers: []ProviderConfig{valid, valid}}); err == nil {
+		t.Fatal("New with a duplicate provider succeeded")
+	}
+}
+
+// authorize starts a login and returns the query of the provider's redirect
+// back to the app
+func authorize(t *testing.T, manager *Manager, server *oauthtest.Server, name string) url.Values {
+	t.Helper()
+
+	authURL, state, err := manager.Start(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	callback, err := server.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if callback.Query().Get("state") != state {
+		t.Fatalf("callback state = %q, want %q", callback.Query().Get("state"), state)
+	}
+	return callback.Query()
+}
+
+func newTestServer(t *testing.T, secret string) *oauthtest.Server {
+	t.Helper()
+
+	server, err := oauthtest.NewServer("starter-app", secret)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestManager configures every name as a provider backed by server
+func newTestManager(t *testing.T, store Store, server *oauthtest.Server, names ...string) (*Manager, *time.Time) {
+	t.Helper()
+
+	var providers []ProviderConfig
+	for _, name := range names {
+		providers = append(providers, ProviderConfig{
+			Name:         name,
+			Issuer:       server.Issuer(),
+			ClientID:     server.ClientID,
+			ClientSecret: server.ClientSecret,
+			RedirectURL:  testRedirectURL,
+		})
+	}
+	clock := time.Now()
+	manager, err := New(store, Config{
+		Providers: providers,
+		Now:       func() time.Time { return clock },
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return manager, &clock
+}
+
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	db, err := database.GetConnection(config.Database{Mode: config.DatabaseModeMemory})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := database.Migrate(context.Background(), db, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return map[string]Store{
+		"memdb":  memdb.New(),
+		"sqlite": repo.New(db),
+	}
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/mhpenta/starterA/internal/problem"
+)
+
+// ErrForbidden is matched by every ForbiddenError.
+var ErrForbidden = errors.New("auth: forbidden")
+
+// ForbiddenError reports which policy an authenticated caller failed. It
+// matches ErrForbidden with errors.Is.
+type ForbiddenError struct {
+	Reason string
+}
+
+func (e *ForbiddenError) Error() string {
+	return ErrForbidden.Error() + ": " + e.Reason
+}
+
+func (e *ForbiddenError) Unwrap() error {
+	return ErrForbidden
+}
+
+// Policy decides whether a verified token may proceed, returning nil to
+// allow it or a *ForbiddenError. Policies compose with AllOf and AnyOf.
+type Policy func(token *Token) error
+
+// Check applies the policy, treating a nil token as unauthenticated.
+func (p Policy) Check(token *Token) error {
+	if token == nil {
+		return ErrTokenNotInContext
+	}
+	return p(token)
+}
+
+// HasRole allows tokens carrying any of the given roles in their "role" or
+// "roles" claim. Roles granted to local users are added to the token by
+// TokenWithRoles.
+func HasRole(roles ...string) Policy {
+	reason := "requires role " + strings.Join(roles, " or ")
+	return func(token *Token) error {
+		held := append(claimStrings(token.Claims["role"]), claimStrings(token.Claims["roles"])...)
+		for _, role := range roles {
+			if slices.Contains(held, role) {
+				return nil
+			}
+		}
+		return &ForbiddenError{Reason: reason}
+	}
+}
+
+// TokenWithRoles returns a copy of token whose "roles" claim also lists the
+// given roles. The original claims map is not modified.
+func TokenWithRoles(token *Token, roles ...string) *Token {
+	granted := *token
+	granted.Claims = maps.Clone(token.Claims)
+	if granted.Claims == nil {
+		granted.Claims = make(map[string]interface{})
+	}
+	held := claimStrings(token.Claims["roles"])
+	for _, role := range roles {
+		if !slices.Contains(held, role) {
+			held = append(held, role)
+		}
+	}
+	merged := make([]interface{}, len(held))
+	for i, role := range held {
+		merged[i] = role
+	}
+	granted.Claims["roles"] = merged
+	return &granted
+}
+
+// HasClaim allows tokens carrying the named claim. With values, the claim
+// (or, for a list claim, one of its elements) must equal one of them.
+func HasClaim(name string, values ...string) Policy {
+	reason := "requires claim " + name
+	if len(values) > 0 {
+		reason += " = " + strings.Join(values, " or ")
+	}
+	return func(token *Token) error {
+		claim, ok := token.Claims[name]
+		if !ok {
+			return &ForbiddenError{Reason: reason}
+		}
+		if len(values) == 0 {
+			return nil
+		}
+		for _, got := range claimStrings(claim) {
+			if slices.Contains(values, got) {
+				return nil
+			}
+		}
+		return &ForbiddenError{Reason: reason}
+	}
+}
+
+// HasAnyScope allows tokens granted any of the given OAuth scopes, read from
+// the space-separated "scope" claim or the "scp" list claim.
+func HasAnyScope(scopes ...string) Policy {
+	reason := "requires scope " + strings.Join(scopes, " or ")
+	return func(token *Token) error {
+		var granted []string
+		for _, name := range []string{"scope", "scp"} {
+			for _, s := range claimStrings(token.Claims[name]) {
+				granted = append(granted, strings.Fields(s)...)
+			}
+		}
+		for _, scope := range scopes {
+			if slices.Contains(granted, scope) {
+				return nil
+			}
+		}
+		return &ForbiddenError{Reason: reason}
+	}
+}
+
+// AllOf allows a token only if every policy does, reporting the first failure.
+func AllOf(policies ...Policy) Policy {
+	return func(token *Token) error {
+		for _, p := range policies {
+			if err := p(token); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// AnyOf allows a token if at least one policy does.
+func AnyOf(policies ...Policy) Policy {
+	return func(token *Token) error {
+		var reasons []string
+		for _, p := range policies {
+			err := p(token)
+			if err == nil {
+				return nil
+			}
+			var forbidden *ForbiddenError
+			if !errors.As(err, &forbidden) {
+				return err
+			}
+			reasons = append(reasons, forbidden.Reason)
+		}
+		return &ForbiddenError{Reason: strings.Join(reasons, ", or ")}
+	}
+}
+
+// Authorize checks policy against the token in ctx. It is the transport
+// independent form of RequirePolicy: it returns ErrTokenNotInContext when the
+// caller is unauthenticated and a *ForbiddenError when the policy refuses.
+func Authorize(ctx context.Context, policy Policy) error {
+	token, _ := TokenFromContext(ctx)
+	return policy.Check(token)
+}
+
+// RequirePolicy returns middleware enforcing policy on the token added by
+// RequireAuth, which must run first. Unauthenticated requests get 401 and
+// refused ones 403, both as problem details.
+func RequirePolicy(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := Authorize(r.Context(), policy)
+			if err == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var forbidden *ForbiddenError
+			if errors.As(err, &forbidden) {
+				_ = problem.Write(w, r, problem.New(http.StatusForbidden, problem.TypeForbidden, "Forbidden: "+forbidden.Reason))
+				return
+			}
+			_ = problem.Write(w, r, problem.New(http.StatusUnauthorized, problem.TypeUnauthorized, "Authentication required"))
+		})
+	}
+}
+
+// RequireRole restricts a route to tokens with any of the given roles.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return RequirePolicy(HasRole(roles...))
+}
+
+// RequireClaim restricts a route to tokens carrying the named claim,
+// optionally with one of the given values.
+func RequireClaim(name string, values ...string) func(http.Handler) http.Handler {
+	return RequirePolicy(HasClaim(name, values...))
+}
+
+// RequireAnyScope restricts a route to tokens granted any of the given scopes.
+func RequireAnyScope(scopes ...string) func(http.Handler) http.Handler {
+	return RequirePolicy(HasAnyScope(scopes...))
+}
+
+// claimStrings flattens a claim value into strings; JSON lists become one
+// string per element and other scalars are formatted
+func claimStrings(claim interface{}) []string {
+	switch v := claim.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, e := range v {
+			out = append(out, claimStrings(e)...)
+		}
+		return out
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
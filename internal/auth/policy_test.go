@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mhpenta/starterA/internal/problem"
+)
+
+func TestPolicies(t *testing.T) {
+	tokens := map[string]*Token{
+		"admin":  {UID: "a", Claims: map[string]interface{}{"role": "admin"}},
+		"roles":  {UID: "b", Claims: map[string]interface{}{"roles": []interface{}{"editor", "auditor"}}},
+		"scoped": {UID: "c", Claims: map[string]interface{}{"scope": "users:read users:write", "tenant": "acme"}},
+		"scp":    {UID: "d", Claims: map[string]interface{}{"scp": []interface{}{"users:read"}, "level": float64(3)}},
+		"bare":   {UID: "e"},
+	}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		allow  []string
+	}{
+		{"role string", HasRole(RoleAdmin), []string{"admin"}},
+		{"roles list", HasRole("auditor", RoleAdmin), []string{"admin", "roles"}},
+		{"claim present", HasClaim("tenant"), []string{"scoped"}},
+		{"claim value", HasClaim("tenant", "other", "acme"), []string{"scoped"}},
+		{"numeric claim", HasClaim("level", "3"), []string{"scp"}},
+		{"scope string", HasAnyScope("users:write"), []string{"scoped"}},
+		{"scp list", HasAnyScope("users:read"), []string{"scoped", "scp"}},
+		{"all of", AllOf(HasAnyScope("users:read"), HasClaim("tenant", "acme")), []string{"scoped"}},
+		{"any of", AnyOf(HasRole(RoleAdmin), HasClaim("level")), []string{"admin", "scp"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, token := range tokens {
+				err := tt.policy.Check(token)
+				want := false
+				for _, a := range tt.allow {
+					want = want || a == name
+				}
+				if want && err != nil {
+					t.Errorf("%s: err = %v, want allowed", name, err)
+				}
+				if !want && !errors.Is(err, ErrForbidden) {
+					t.Errorf("%s: err = %v, want %v", name, err, ErrForbidden)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorizeWithoutTokenIsUnauthenticated(t *testing.T) {
+	err := Authorize(context.Background(), HasRole(RoleAdmin))
+	if !errors.Is(err, ErrTokenNotInContext) {
+		t.Fatalf("err = %v, want %v", err, ErrTokenNotInContext)
+	}
+}
+
+func TestRequireRoleReturnsForbiddenProblem(t *testing.T) {
+	provider := NewMockProvider()
+	handler := RequireAuth(provider)(RequireRole(RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	for token, want := range map[string]int{
+		"test-token-admin": http.StatusNoContent,
+		"test-token-1":     http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodDelete, "/", nil)
+		req.Header.Set(AuthHeader, BearerPrefix+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != want {
+			t.Fatalf("%s: status = %d, want %d", token, rec.Code, want)
+		}
+		if want != http.StatusForbidden {
+			continue
+		}
+		var p problem.Details
+		if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+			t.Fatalf("decode problem: %v", err)
+		}
+		if p.Type != problem.TypeForbidden || p.Status != http.StatusForbidden {
+			t.Fatalf("problem = %#v, want forbidden", p)
+		}
+	}
+
+	// Without RequireAuth in front there is no token, which is a 401
+	rec := httptest.NewRecorder()
+	RequireRole(RoleAdmin)(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
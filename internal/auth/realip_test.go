@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedRealIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+	var got string
+	handler := TrustedRealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = SessionMetadataFromRequest(r).IP
+	}))
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{name: "direct client ignores headers", remote: "203.0.113.7:5000", xff: []string{"198.51.100.1"}, realIP: "198.51.100.2", want: "203.0.113.7"},
+		{name: "trusted proxy", remote: "10.0.0.2:5000", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "spoofed hop left of client", remote: "10.0.0.2:5000", xff: []string{"192.0.2.66, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chained trusted proxies", remote: "[::1]:5000", xff: []string{"198.51.100.1", "10.1.1.1"}, want: "198.51.100.1"},
+		{name: "real ip header", remote: "10.0.0.2:5000", realIP: "198.51.100.3", want: "198.51.100.3"},
+		{name: "garbled hop", remote: "10.0.0.2:5000", xff: []string{"198.51.100.1, nonsense"}, want: "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+			req.RemoteAddr = tt.remote
+			for _, xff := range tt.xff {
+				req.Header.Add("X-Forwarded-For", xff)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Fatalf("IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Fatal("ParseTrustedProxies accepted an invalid prefix")
+	}
+}
@@ -0,0 +1,406 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSessionIdleTimeout applies when CreateSessionCookie is called
+	// without a lifetime.
+	DefaultSessionIdleTimeout = 24 * time.Hour
+
+	// DefaultSessionMaxLifetime caps how far sliding expiration can extend
+	// a session past its creation.
+	DefaultSessionMaxLifetime = 30 * 24 * time.Hour
+
+	// DefaultSessionTouchInterval limits how often a session's expiry is
+	// written back, so busy sessions do not cost a write per request.
+	DefaultSessionTouchInterval = time.Minute
+
+	// maxUserAgentLength bounds the user agent stored with a session
+	maxUserAgentLength = 512
+)
+
+// ErrSessionNotFound is returned by a SessionStore for an unknown session ID.
+var ErrSessionNotFound = errors.New("auth: session not found")
+
+// SessionMetadata describes the client a session was issued to.
+type SessionMetadata struct {
+	IP        string
+	UserAgent string
+	Device    string
+}
+
+// SessionMetadataFromRequest collects session metadata from a request. The
+// IP is taken from RemoteAddr, so put TrustedRealIP in front when the app
+// runs behind a proxy.
+func SessionMetadataFromRequest(r *http.Request) SessionMetadata {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	return SessionMetadata{
+		IP:        ip,
+		UserAgent: userAgent,
+		Device:    deviceFromUserAgent(userAgent),
+	}
+}
+
+// deviceFromUserAgent gives a coarse device class for listing sessions
+func deviceFromUserAgent(userAgent string) string {
+	switch {
+	case userAgent == "":
+		return ""
+	case strings.Contains(userAgent, "iPad") || strings.Contains(userAgent, "Tablet"):
+		return "tablet"
+	case strings.Contains(userAgent, "Mobi") || strings.Contains(userAgent, "Android"):
+		return "mobile"
+	default:
+		return "desktop"
+	}
+}
+
+// ContextWithSessionMetadata returns a new context carrying the metadata that
+// CreateSessionCookie records for a new session.
+func ContextWithSessionMetadata(ctx context.Context, md SessionMetadata) context.Context {
+	return context.WithValue(ctx, sessionMetadataKey, md)
+}
+
+// SessionMetadataFromContext returns the metadata added by ContextWithSessionMetadata.
+func SessionMetadataFromContext(ctx context.Context) (SessionMetadata, bool) {
+	md, ok := ctx.Value(sessionMetadataKey).(SessionMetadata)
+	return md, ok
+}
+
+// SessionRecord is a server-side session. ID is a hash of the secret in the
+// cookie, never the secret itself.
+type SessionRecord struct {
+	ID        string
+	UID       string
+	Metadata  SessionMetadata
+	CreatedAt time.Time
+	// LastSeenAt is zero until the session is first used
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	// IdleTimeout is how far each use pushes ExpiresAt out; zero means
+	// ExpiresAt is fixed
+	IdleTimeout time.Duration
+	// RevokedAt is zero for live sessions
+	RevokedAt time.Time
+	// MFAAt is when the session last passed a second factor, or zero
+	MFAAt time.Time
+}
+
+// Revoked reports whether the session has been revoked.
+func (s *SessionRecord) Revoked() bool {
+	return !s.RevokedAt.IsZero()
+}
+
+// SessionStore persists server-side sessions. See internal/auth/dbsession for
+// the database implementation.
+type SessionStore interface {
+	CreateSession(ctx context.Context, session SessionRecord) error
+	// GetSession returns ErrSessionNotFound for an unknown ID
+	GetSession(ctx context.Context, id string) (*SessionRecord, error)
+	ListUserSessions(ctx context.Context, uid string) ([]SessionRecord, error)
+	TouchSession(ctx context.Context, id string, lastSeenAt, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, id string) error
+	RevokeUserSessions(ctx context.Context, uid string) (int64, error)
+	// MarkSessionMFA records a second factor on a live session
+	MarkSessionMFA(ctx context.Context, id string, at time.Time) error
+	// PurgeExpiredSessions deletes sessions that expired before the given time
+	PurgeExpiredSessions(ctx context.Context, before time.Time) (int64, error)
+}
+
+// SessionManagerConfig configures a SessionManager.
+type SessionManagerConfig struct {
+	// IdleTimeout is used when CreateSessionCookie gets no lifetime; zero
+	// means DefaultSessionIdleTimeout
+	IdleTimeout time.Duration
+	// MaxLifetime is zero for DefaultSessionMaxLifetime
+	MaxLifetime time.Duration
+	// TouchInterval is zero for DefaultSessionTouchInterval
+	TouchInterval time.Duration
+	// Now returns the current time; nil means time.Now
+	Now    func() time.Time
+	Logger *slog.Logger
+}
+
+// SessionManager decorates any Servicer with server-side sessions. Each
+// session cookie it issues pairs a random secret, recorded in the
+// SessionStore, with the inner provider's cookie, which is issued for
+// MaxLifetime. Verifying a cookie checks both, so a session can be revoked
+// one at a time or per user even when the inner provider cannot revoke
+// anything itself.
+//
+// The lifetime passed to CreateSessionCookie is an idle timeout: every use
+// slides the expiry forward, up to MaxLifetime after creation. A session can
+// never outlive the inner cookie, so with a provider whose cookies are its
+// ID tokens (such as JWTProvider) sessions end when the token expires.
+type SessionManager struct {
+	inner         Servicer
+	store         SessionStore
+	idleTimeout   time.Duration
+	maxLifetime   time.Duration
+	touchInterval time.Duration
+	now           func() time.Time
+	logger        *slog.Logger
+}
+
+// NewSessionManager wraps inner with sessions recorded in store.
+func NewSessionManager(inner Servicer, store SessionStore, cfg SessionManagerConfig) *SessionManager {
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = DefaultSessionIdleTimeout
+	}
+	if cfg.MaxLifetime <= 0 {
+		cfg.MaxLifetime = DefaultSessionMaxLifetime
+	}
+	if cfg.TouchInterval <= 0 {
+		cfg.TouchInterval = DefaultSessionTouchInterval
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	return &SessionManager{
+		inner:         inner,
+		store:         store,
+		idleTimeout:   cfg.IdleTimeout,
+		maxLifetime:   cfg.MaxLifetime,
+		touchInterval: cfg.TouchInterval,
+		now:           cfg.Now,
+		logger:        cfg.Logger,
+	}
+}
+
+// Unwrap returns the decorated provider.
+func (m *SessionManager) Unwrap() Servicer {
+	return m.inner
+}
+
+// VerifyIDToken delegates to the inner provider.
+func (m *SessionManager) VerifyIDToken(ctx context.Context, idToken string) (*Token, error) {
+	return m.inner.VerifyIDToken(ctx, idToken)
+}
+
+// GetUserInfo delegates to the inner provider.
+func (m *SessionManager) GetUserInfo(ctx context.Context, uid string) (*UserInfo, error) {
+	return m.inner.GetUserInfo(ctx, uid)
+}
+
+// CreateSessionCookie records a new session for the ID token's subject, with
+// any SessionMetadata found in ctx, and returns its cookie.
+func (m *SessionManager) CreateSessionCookie(ctx context.Context, idToken string, expiresIn time.Duration) (string, error) {
+	token, err := m.inner.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return "", err
+	}
+
+	idle := expiresIn
+	if idle <= 0 {
+		idle = m.idleTimeout
+	}
+	if idle > m.maxLifetime {
+		idle = m.maxLifetime
+	}
+
+	innerCookie, err := m.inner.CreateSessionCookie(ctx, idToken, m.maxLifetime)
+	if err != nil {
+		return "", err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+
+	md, _ := SessionMetadataFromContext(ctx)
+	now := m.now()
+	if err := m.store.CreateSession(ctx, SessionRecord{
+		ID:          hashSessionSecret(encoded),
+		UID:         token.UID,
+		Metadata:    md,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(idle),
+		IdleTimeout: idle,
+	}); err != nil {
+		return "", fmt.Errorf("auth: recording session: %w", err)
+	}
+
+	return encoded + "." + innerCookie, nil
+}
+
+// VerifySessionCookie checks the session record, which always includes
+// revocation, and the inner provider's cookie.
+func (m *SessionManager) VerifySessionCookie(ctx context.Context, sessionCookie string) (*Token, error) {
+	return m.verify(ctx, sessionCookie, m.inner.VerifySessionCookie)
+}
+
+// VerifySessionCookieRevoked is VerifySessionCookieAndCheckRevoked.
+func (m *SessionManager) VerifySessionCookieRevoked(ctx context.Context, sessionCookie string) (*Token, error) {
+	return m.verify(ctx, sessionCookie, m.inner.VerifySessionCookieRevoked)
+}
+
+// VerifySessionCookieAndCheckRevoked checks the session record and asks the
+// inner provider to check its cookie for revocation too.
+func (m *SessionManager) VerifySessionCookieAndCheckRevoked(ctx context.Context, sessionCookie string) (*Token, error) {
+	return m.verify(ctx, sessionCookie, m.inner.VerifySessionCookieAndCheckRevoked)
+}
+
+func (m *SessionManager) verify(ctx context.Context, sessionCookie string, verifyInner func(context.Context, string) (*Token, error)) (*Token, error) {
+	secret, innerCookie, ok := strings.Cut(sessionCookie, ".")
+	if !ok || secret == "" || innerCookie == "" {
+		return nil, ErrInvalidSessionCookie
+	}
+
+	session, err := m.store.GetSession(ctx, hashSessionSecret(secret))
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil, ErrInvalidSessionCookie
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	if session.Revoked() {
+		return nil, ErrRevokedSessionCookie
+	}
+	if !now.Before(session.ExpiresAt) {
+		return nil, ErrExpiredSessionCookie
+	}
+
+	token, err := verifyInner(ctx, innerCookie)
+	if err != nil {
+		return nil, err
+	}
+	if token.UID != session.UID {
+		return nil, ErrInvalidSessionCookie
+	}
+
+	expiresAt := m.touch(ctx, session, now)
+
+	// Report whichever ends first, the session or the inner cookie
+	verified := *token
+	if verified.Expiry.IsZero() || expiresAt.Before(verified.Expiry) {
+		verified.Expiry = expiresAt
+	}
+	if !session.MFAAt.IsZero() {
+		return TokenWithMFA(&verified, session.MFAAt), nil
+	}
+	return &verified, nil
+}
+
+// touch slides the expiry of an idle-timeout session and returns the
+// session's expiry. Failures are logged rather than failing the request.
+func (m *SessionManager) touch(ctx context.Context, session *SessionRecord, now time.Time) time.Time {
+	if session.IdleTimeout <= 0 {
+		return session.ExpiresAt
+	}
+
+	lastSeen := session.LastSeenAt
+	if lastSeen.IsZero() {
+		lastSeen = session.CreatedAt
+	}
+	if now.Sub(lastSeen) < m.touchInterval {
+		return session.ExpiresAt
+	}
+
+	expiresAt := now.Add(session.IdleTimeout)
+	if limit := session.CreatedAt.Add(m.maxLifetime); expiresAt.After(limit) {
+		expiresAt = limit
+	}
+	if !expiresAt.After(session.ExpiresAt) {
+		return session.ExpiresAt
+	}
+
+	if err := m.store.TouchSession(ctx, session.ID, now, expiresAt); err != nil {
+		m.logger.Warn("Failed to extend session", "error", err)
+		return session.ExpiresAt
+	}
+	return expiresAt
+}
+
+// RevokeSessionCookie revokes the session behind a cookie, and the inner
+// cookie when the inner provider supports revocation.
+func (m *SessionManager) RevokeSessionCookie(ctx context.Context, sessionCookie string) error {
+	secret, innerCookie, ok := strings.Cut(sessionCookie, ".")
+	if !ok || secret == "" {
+		return ErrInvalidSessionCookie
+	}
+	if err := m.store.RevokeSession(ctx, hashSessionSecret(secret)); err != nil {
+		return err
+	}
+
+	if revoker, ok := m.inner.(SessionRevoker); ok {
+		if err := revoker.RevokeSessionCookie(ctx, innerCookie); err != nil && !IsInvalidError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordStepUp marks the session behind a cookie as having passed a second
+// factor at the given time.
+func (m *SessionManager) RecordStepUp(ctx context.Context, sessionCookie string, at time.Time) error {
+	secret, _, ok := strings.Cut(sessionCookie, ".")
+	if !ok || secret == "" {
+		return ErrInvalidSessionCookie
+	}
+	return m.store.MarkSessionMFA(ctx, hashSessionSecret(secret), at)
+}
+
+// RevokeUserSessions revokes every session of the user with the given UID.
+func (m *SessionManager) RevokeUserSessions(ctx context.Context, uid string) error {
+	if _, err := m.store.RevokeUserSessions(ctx, uid); err != nil {
+		return err
+	}
+
+	if revoker, ok := m.inner.(SessionRevoker); ok {
+		return revoker.RevokeUserSessions(ctx, uid)
+	}
+	return nil
+}
+
+// ListSessions returns the sessions of the user with the given UID, newest
+// first, including revoked sessions that have not been purged.
+func (m *SessionManager) ListSessions(ctx context.Context, uid string) ([]SessionRecord, error) {
+	return m.store.ListUserSessions(ctx, uid)
+}
+
+// PurgeExpired deletes expired sessions and returns how many were removed.
+func (m *SessionManager) PurgeExpired(ctx context.Context) (int64, error) {
+	return m.store.PurgeExpiredSessions(ctx, m.now())
+}
+
+// hashSessionSecret is the SessionRecord.ID stored for a cookie secret
+func hashSessionSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	_ Servicer       = (*SessionManager)(nil)
+	_ SessionRevoker = (*SessionManager)(nil)
+	_ StepUpRecorder = (*SessionManager)(nil)
+)
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSessionMetadataFromRequest(t *testing.T) {
+	req := httptest.NewRequest("POST", "/auth/session", nil)
+	req.RemoteAddr = "203.0.113.7:52100"
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Linux; Android 14) Mobile Safari/537.36"+strings.Repeat("x", 1000))
+
+	md := SessionMetadataFromRequest(req)
+	if md.IP != "203.0.113.7" {
+		t.Fatalf("IP = %q, want 203.0.113.7", md.IP)
+	}
+	if md.Device != "mobile" {
+		t.Fatalf("Device = %q, want mobile", md.Device)
+	}
+	if len(md.UserAgent) != maxUserAgentLength {
+		t.Fatalf("len(UserAgent) = %d, want %d", len(md.UserAgent), maxUserAgentLength)
+	}
+}
@@ -9,4 +9,33 @@ const (
 
 	// BearerPrefix is the prefix for bearer tokens in the Authorization header.
 	BearerPrefix = "Bearer "
+
+	// APIKeyHeader is the header used for API key authentication.
+	APIKeyHeader = "X-API-Key"
+
+	// APIKeyPrefix is the prefix for API keys in the Authorization header.
+	APIKeyPrefix = "ApiKey "
+
+	// CSRFHeader carries the CSRF token on cookie-authenticated requests.
+	CSRFHeader = "X-CSRF-Token"
+
+	// CSRFFormField carries the CSRF token in HTML form posts.
+	CSRFFormField = "csrf_token"
+
+	// ClaimAPIKey is set on tokens authenticated by an API key, holding the
+	// key's visible prefix.
+	ClaimAPIKey = "api_key"
+
+	// ClaimMFA is set on tokens whose session passed a second factor,
+	// holding the Unix time of the check.
+	ClaimMFA = "mfa_at"
+
+	// ClaimAMR is the standard authentication methods claim of ID tokens.
+	ClaimAMR = "amr"
+
+	// RoleAdmin is the role claim value that grants administrative access.
+	RoleAdmin = "admin"
+
+	// RoleUser is the role claim value of ordinary users.
+	RoleUser = "user"
 )
@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+)
+
+// Defaults for LimiterConfig.
+const (
+	DefaultThrottleFreeAttempts     = 5
+	DefaultThrottleBaseDelay        = time.Second
+	DefaultThrottleMaxDelay         = 15 * time.Minute
+	DefaultThrottleLockoutThreshold = 20
+	DefaultThrottleLockoutDuration  = time.Hour
+	DefaultThrottleWindow           = 24 * time.Hour
+)
+
+// ErrThrottled is matched by every ThrottledError.
+var ErrThrottled = errors.New("auth: too many failed attempts")
+
+// ThrottledError reports that a limiter key is locked and when to retry.
+// It matches ErrThrottled with errors.Is.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%s: retry after %s", ErrThrottled, e.RetryAfter.Round(time.Second))
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrThrottled
+}
+
+// ThrottleKeyUsername is the limiter key for a login name, case-insensitive.
+func ThrottleKeyUsername(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// ThrottleKeyEmail is the limiter key for an email address, case-insensitive.
+func ThrottleKeyEmail(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ThrottleKeyIP is the limiter key for a client IP address.
+func ThrottleKeyIP(ip string) string {
+	return "ip:" + ip
+}
+
+// ThrottleKeyUID is the limiter key for an authenticated user.
+func ThrottleKeyUID(uid string) string {
+	return "uid:" + uid
+}
+
+// ThrottleRecord is the failure count of one limiter key. Attempts still
+// being verified count as failures.
+type ThrottleRecord struct {
+	Key           string    `json:"key"`
+	Failures      int64     `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	// LockedUntil is zero when the key is not locked
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// ThrottleStore persists limiter counters so limits hold across restarts and
+// instances. See internal/auth/dbthrottle for the database implementation.
+type ThrottleStore interface {
+	// GetThrottle returns nil for a key without failures
+	GetThrottle(ctx context.Context, key string) (*ThrottleRecord, error)
+	// ReserveAttempt atomically counts an attempt against a key that is not
+	// locked at the given time, restarting the count when the previous
+	// attempt was before windowStart, and locks the key until holdUntil when
+	// the count goes past freeAttempts. It returns the new count, or false
+	// without counting anything when the key is locked.
+	ReserveAttempt(ctx context.Context, key string, at, windowStart time.Time, freeAttempts int64, holdUntil time.Time) (int64, bool, error)
+	// ReleaseAttempt uncounts an attempt that did not fail and unlocks the key
+	ReleaseAttempt(ctx context.Context, key string) error
+	LockThrottle(ctx context.Context, key string, until time.Time) error
+	// ResetThrottle forgets a key, reporting whether it had failures recorded
+	ResetThrottle(ctx context.Context, key string) (bool, error)
+	ListLockedThrottles(ctx context.Context, now time.Time) ([]ThrottleRecord, error)
+	// PurgeThrottles deletes keys whose last failure and lock ended before
+	// the given time
+	PurgeThrottles(ctx context.Context, before time.Time) (int64, error)
+}
+
+// LimiterConfig configures a Limiter. Zero fields take the defaults above.
+type LimiterConfig struct {
+	// FreeAttempts is how many failures a key may have before each further
+	// failure locks it for an exponentially growing delay
+	FreeAttempts int
+	// BaseDelay is the first delay; it doubles per failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutThreshold is the failure count at which a key is locked out
+	// for LockoutDuration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// Window is how long failures are remembered; it is raised to at least
+	// LockoutDuration
+	Window time.Duration
+	// Now returns the current time; nil means time.Now
+	Now    func() time.Time
+	Logger *slog.Logger
+}
+
+// Limiter slows down brute-force attacks on credentials. Callers Check the
+// keys of a request before verifying credentials, which counts the attempt
+// as a failure up front, so concurrent requests cannot all get past the
+// free attempts. Attempts that turn out not to fail are handed back with
+// Succeed or Release. Keys are usually built with ThrottleKeyUsername,
+// ThrottleKeyIP and ThrottleKeyUID. A nil *Limiter never throttles.
+type Limiter struct {
+	store ThrottleStore
+	cfg   LimiterConfig
+}
+
+// NewLimiter creates a Limiter
+func NewLimiter(store ThrottleStore, cfg LimiterConfig) *Limiter {
+	if cfg.FreeAttempts <= 0 {
+		cfg.FreeAttempts = DefaultThrottleFreeAttempts
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = DefaultThrottleBaseDelay
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = DefaultThrottleMaxDelay
+	}
+	if cfg.LockoutThreshold <= 0 {
+		cfg.LockoutThreshold = DefaultThrottleLockoutThreshold
+	}
+	if cfg.LockoutDuration <= 0 {
+		cfg.LockoutDuration = DefaultThrottleLockoutDuration
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultThrottleWindow
+	}
+	cfg.Window = max(cfg.Window, cfg.LockoutDuration, cfg.MaxDelay)
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	return &Limiter{store: store, cfg: cfg}
+}
+
+// Check reserves an attempt against every key, counting it as a failed one
+// and locking the keys that have run out of free attempts. When any key is
+// already locked it reserves nothing and returns a *ThrottledError giving
+// the longest remaining lock. Store errors are returned as is.
+//
+// A failed attempt needs nothing more. Callers hand back every key of an
+// attempt that did not fail with Succeed or Release.
+func (l *Limiter) Check(ctx context.Context, keys ...string) error {
+	if l == nil {
+		return nil
+	}
+	now := l.cfg.Now()
+	// Until the precise delay below is set, the key is held for the
+	// shortest one so that concurrent attempts see it locked
+	holdUntil := now.Add(l.cfg.BaseDelay)
+
+	var reserved []string
+	var retryAfter time.Duration
+	for _, key := range keys {
+		failures, ok, err := l.store.ReserveAttempt(ctx, key, now, now.Add(-l.cfg.Window), int64(l.cfg.FreeAttempts), holdUntil)
+		if err != nil {
+			l.Release(ctx, reserved...)
+			return err
+		}
+		if !ok {
+			record, err := l.store.GetThrottle(ctx, key)
+			if err != nil {
+				l.Release(ctx, reserved...)
+				return err
+			}
+			if record != nil && record.LockedUntil.After(now) {
+				retryAfter = max(retryAfter, record.LockedUntil.Sub(now))
+			}
+			continue
+		}
+		reserved = append(reserved, key)
+		l.lock(ctx, key, failures, now)
+	}
+
+	if retryAfter > 0 {
+		l.Release(ctx, reserved...)
+		return &ThrottledError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// Succeed forgets the failures of keys after a successful attempt, including
+// the one reserved by Check. Only pass keys that identify the account, not
+// the IP, so one valid login cannot clear an attacker's IP counter; hand
+// those back with Release.
+func (l *Limiter) Succeed(ctx context.Context, keys ...string) {
+	if l == nil {
+		return
+	}
+	for _, key := range keys {
+		if _, err := l.store.ResetThrottle(ctx, key); err != nil {
+			l.cfg.Logger.Error("Failed to reset auth throttle", "key", key, "error", err)
+		}
+	}
+}
+
+// Release hands back the attempt reserved by Check for keys, when it
+// succeeded or failed for a reason other than bad credentials. Store errors
+// are logged, not returned, so they never change a response.
+func (l *Limiter) Release(ctx context.Context, keys ...string) {
+	if l == nil {
+		return
+	}
+	for _, key := range keys {
+		if err := l.store.ReleaseAttempt(ctx, key); err != nil {
+			l.cfg.Logger.Error("Failed to release auth attempt", "key", key, "error", err)
+		}
+	}
+}
+
+// Unlock clears a key, reporting whether it had any failures recorded
+func (l *Limiter) Unlock(ctx context.Context, key string) (bool, error) {
+	return l.store.ResetThrottle(ctx, key)
+}
+
+// Locked lists the keys that are currently locked
+func (l *Limiter) Locked(ctx context.Context) ([]ThrottleRecord, error) {
+	return l.store.ListLockedThrottles(ctx, l.cfg.Now())
+}
+
+// Purge deletes keys that no longer affect any decision
+func (l *Limiter) Purge(ctx context.Context) (int64, error) {
+	return l.store.PurgeThrottles(ctx, l.cfg.Now().Add(-l.cfg.Window))
+}
+
+// RunPurger calls Purge every interval until ctx is done
+func (l *Limiter) RunPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := l.Purge(ctx)
+			if err != nil {
+				l.cfg.Logger.Error("Failed to purge auth throttles", "error", err)
+				continue
+			}
+			if purged > 0 {
+				l.cfg.Logger.Info("Purged auth throttles", "count", purged)
+			}
+		}
+	}
+}
+
+// lock locks key for the delay of its nth failure, replacing the hold set
+// when the attempt was reserved. Store errors are logged; the hold still
+// slows the key down.
+func (l *Limiter) lock(ctx context.Context, key string, failures int64, now time.Time) {
+	delay := l.delay(failures)
+	if delay <= 0 {
+		return
+	}
+	if err := l.store.LockThrottle(ctx, key, now.Add(delay)); err != nil {
+		l.cfg.Logger.Error("Failed to lock auth throttle", "key", key, "error", err)
+		return
+	}
+	if failures == int64(l.cfg.LockoutThreshold) {
+		l.cfg.Logger.Warn("Locked out after repeated auth failures", "key", key, "failures", failures, "until", now.Add(delay))
+	}
+}
+
+// delay is how long a key is locked after its nth failure: nothing for the
+// free attempts, then BaseDelay doubling up to MaxDelay, and LockoutDuration
+// from LockoutThreshold on.
+func (l *Limiter) delay(failures int64) time.Duration {
+	if failures >= int64(l.cfg.LockoutThreshold) {
+		return l.cfg.LockoutDuration
+	}
+	excess := failures - int64(l.cfg.FreeAttempts)
+	if excess <= 0 {
+		return 0
+	}
+
+	delay := float64(l.cfg.BaseDelay) * math.Pow(2, float64(excess-1))
+	if delay >= float64(l.cfg.MaxDelay) {
+		return l.cfg.MaxDelay
+	}
+	return time.Duration(delay)
+}
@@ -0,0 +1,440 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTokenCacheTTL is the longest a verified token is reused.
+	DefaultTokenCacheTTL = time.Minute
+
+	// DefaultTokenCacheNegativeTTL is how long a rejected token stays rejected
+	// without asking the provider again.
+	DefaultTokenCacheNegativeTTL = 10 * time.Second
+
+	// DefaultTokenCacheSize is the default maximum number of cached tokens.
+	DefaultTokenCacheSize = 10000
+)
+
+// TokenCacheConfig configures a TokenCache.
+type TokenCacheConfig struct {
+	// TTL bounds how long a verified token is reused; it is also cut short
+	// by the token's own Expiry. Zero means DefaultTokenCacheTTL.
+	TTL time.Duration
+	// NegativeTTL is how long invalid, expired and revoked tokens are
+	// remembered. Zero means DefaultTokenCacheNegativeTTL; negative turns
+	// negative caching off. Other errors, such as an unreachable JWKS, are
+	// never cached.
+	NegativeTTL time.Duration
+	// MaxEntries bounds the cache; the least recently used token is evicted
+	// first. Zero means DefaultTokenCacheSize.
+	MaxEntries int
+	// Now returns the current time; nil means time.Now.
+	Now func() time.Time
+}
+
+// TokenCache decorates a Servicer with an in-memory cache of
+// VerifyIDToken and VerifySessionCookie results, keyed by a SHA-256 of the
+// token. Concurrent verifications of the same token share one call to the
+// inner provider; if its caller goes away or the call panics, the others
+// verify again instead of sharing that failure. Returned tokens are deep
+// copies, so callers may change them.
+//
+// Session cookies of a SessionManager are reused for at most its
+// TouchInterval, so cache hits never hold back the sliding expiry it
+// records when cookies are used.
+//
+// Revoking through the cache, or recording a step-up, drops the affected
+// entries; Invalidate and InvalidateUser do the same for changes made
+// elsewhere. VerifySessionCookieAndCheckRevoked always asks the inner
+// provider, so routes that need to see revocations by other instances at
+// once should use RequireAuthWithRevocationCheck.
+type TokenCache struct {
+	inner Servicer
+	ttl   time.Duration
+	// cookieTTL is ttl for session cookies, cut to the inner
+	// SessionManager's touch interval
+	cookieTTL   time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	now         func() time.Time
+
+	mu       sync.Mutex
+	entries  map[tokenCacheKey]*list.Element
+	lru      *list.List
+	byUID    map[string]map[tokenCacheKey]struct{}
+	inflight map[tokenCacheKey]*tokenCall
+	// generation changes on every invalidation, so verifications that
+	// started before it do not cache their now stale results
+	generation uint64
+}
+
+// tokenKind separates ID tokens from session cookies, which are verified
+// differently even when the strings are equal
+type tokenKind byte
+
+const (
+	kindIDToken tokenKind = iota
+	kindSessionCookie
+)
+
+type tokenCacheKey [sha256.Size]byte
+
+type tokenCacheEntry struct {
+	key     tokenCacheKey
+	token   *Token
+	err     error
+	expires time.Time
+}
+
+// tokenCall is a verification in flight
+type tokenCall struct {
+	done  chan struct{}
+	token *Token
+	err   error
+	// abandoned is set when the call ended without a verdict on the token
+	// because its caller's context ended or it panicked, so waiters verify
+	// again rather than share the failure
+	abandoned bool
+}
+
+// NewTokenCache wraps inner with a token cache.
+func NewTokenCache(inner Servicer, cfg TokenCacheConfig) *TokenCache {
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTokenCacheTTL
+	}
+	if cfg.NegativeTTL == 0 {
+		cfg.NegativeTTL = DefaultTokenCacheNegativeTTL
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = DefaultTokenCacheSize
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	cookieTTL := cfg.TTL
+	if sessions, ok := Lookup[*SessionManager](inner); ok {
+		cookieTTL = min(cookieTTL, sessions.touchInterval)
+	}
+
+	return &TokenCache{
+		inner:       inner,
+		ttl:         cfg.TTL,
+		cookieTTL:   cookieTTL,
+		negativeTTL: cfg.NegativeTTL,
+		maxEntries:  cfg.MaxEntries,
+		now:         cfg.Now,
+		entries:     make(map[tokenCacheKey]*list.Element),
+		lru:         list.New(),
+		byUID:       make(map[string]map[tokenCacheKey]struct{}),
+		inflight:    make(map[tokenCacheKey]*tokenCall),
+	}
+}
+
+// Unwrap returns the decorated provider.
+func (c *TokenCache) Unwrap() Servicer {
+	return c.inner
+}
+
+// forwards tells Lookup that TokenCache only passes on revocations and
+// step-ups, so it counts as a SessionRevoker or StepUpRecorder only when
+// the inner provider is one.
+func (c *TokenCache) forwards(capability any) bool {
+	switch capability.(type) {
+	case *SessionRevoker, *StepUpRecorder:
+		return true
+	}
+	return false
+}
+
+// VerifyIDToken returns the cached result for idToken or asks the inner
+// provider.
+func (c *TokenCache) VerifyIDToken(ctx context.Context, idToken string) (*Token, error) {
+	return c.verify(ctx, kindIDToken, idToken, c.inner.VerifyIDToken)
+}
+
+// VerifySessionCookie returns the cached result for sessionCookie or asks
+// the inner provider.
+func (c *TokenCache) VerifySessionCookie(ctx context.Context, sessionCookie string) (*Token, error) {
+	return c.verify(ctx, kindSessionCookie, sessionCookie, c.inner.VerifySessionCookie)
+}
+
+// VerifySessionCookieRevoked asks the inner provider, dropping the cached
+// cookie if it turns out to be revoked.
+func (c *TokenCache) VerifySessionCookieRevoked(ctx context.Context, sessionCookie string) (*Token, error) {
+	token, err := c.inner.VerifySessionCookieRevoked(ctx, sessionCookie)
+	if IsRevokedError(err) {
+		c.Invalidate(sessionCookie)
+	}
+	return token, err
+}
+
+// VerifySessionCookieAndCheckRevoked asks the inner provider, dropping the
+// cached cookie if it turns out to be revoked.
+func (c *TokenCache) VerifySessionCookieAndCheckRevoked(ctx context.Context, sessionCookie string) (*Token, error) {
+	token, err := c.inner.VerifySessionCookieAndCheckRevoked(ctx, sessionCookie)
+	if IsRevokedError(err) {
+		c.Invalidate(sessionCookie)
+	}
+	return token, err
+}
+
+// CreateSessionCookie delegates to the inner provider.
+func (c *TokenCache) CreateSessionCookie(ctx context.Context, idToken string, expiresIn time.Duration) (string, error) {
+	return c.inner.CreateSessionCookie(ctx, idToken, expiresIn)
+}
+
+// GetUserInfo delegates to the inner provider.
+func (c *TokenCache) GetUserInfo(ctx context.Context, uid string) (*UserInfo, error) {
+	return c.inner.GetUserInfo(ctx, uid)
+}
+
+// RevokeSessionCookie revokes the cookie with the inner provider and drops
+// it from the cache. Lookup only finds it when the inner provider can
+// revoke sessions.
+func (c *TokenCache) RevokeSessionCookie(ctx context.Context, sessionCookie string) error {
+	defer c.Invalidate(sessionCookie)
+
+	revoker, ok := Lookup[SessionRevoker](c.inner)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return revoker.RevokeSessionCookie(ctx, sessionCookie)
+}
+
+// RevokeUserSessions revokes the user's sessions with the inner provider
+// and drops every cached token of the user. Lookup only finds it when the
+// inner provider can revoke sessions.
+func (c *TokenCache) RevokeUserSessions(ctx context.Context, uid string) error {
+	defer c.InvalidateUser(uid)
+
+	revoker, ok := Lookup[SessionRevoker](c.inner)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return revoker.RevokeUserSessions(ctx, uid)
+}
+
+// RecordStepUp records the step-up with the inner provider and drops the
+// cookie from the cache, so its next verification carries ClaimMFA.
+// Lookup only finds it when the inner provider can record step-ups.
+func (c *TokenCache) RecordStepUp(ctx context.Context, sessionCookie string, at time.Time) error {
+	defer c.Invalidate(sessionCookie)
+
+	recorder, ok := Lookup[StepUpRecorder](c.inner)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return recorder.RecordStepUp(ctx, sessionCookie, at)
+}
+
+// Invalidate drops the cached results for token, whether it was verified as
+// an ID token or a session cookie.
+func (c *TokenCache) Invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, kind := range []tokenKind{kindIDToken, kindSessionCookie} {
+		if elem, ok := c.entries[cacheKeyFor(kind, token)]; ok {
+			c.remove(elem)
+		}
+	}
+}
+
+// InvalidateUser drops every cached token of the user with the given UID,
+// for example after the user is disabled or deleted.
+func (c *TokenCache) InvalidateUser(uid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for key := range c.byUID[uid] {
+		c.remove(c.entries[key])
+	}
+}
+
+// Clear drops every cached result.
+func (c *TokenCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	clear(c.entries)
+	clear(c.byUID)
+	c.lru.Init()
+}
+
+// Len returns the number of cached results, including expired ones not yet
+// evicted.
+func (c *TokenCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *TokenCache) verify(ctx context.Context, kind tokenKind, raw string, verifyInner func(context.Context, string) (*Token, error)) (*Token, error) {
+	if raw == "" {
+		return verifyInner(ctx, raw)
+	}
+	key := cacheKeyFor(kind, raw)
+
+	for {
+		c.mu.Lock()
+		if elem, ok := c.entries[key]; ok {
+			entry := elem.Value.(*tokenCacheEntry)
+			if c.now().Before(entry.expires) {
+				c.lru.MoveToFront(elem)
+				c.mu.Unlock()
+				return cloneToken(entry.token), entry.err
+			}
+			c.remove(elem)
+		}
+		call, ok := c.inflight[key]
+		if !ok {
+			break
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-call.done:
+			if !call.abandoned {
+				return cloneToken(call.token), call.err
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// c.mu is still held from the loop
+	call := &tokenCall{done: make(chan struct{}), abandoned: true}
+	c.inflight[key] = call
+	generation := c.generation
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, key)
+		if !call.abandoned && generation == c.generation {
+			ttl := c.ttl
+			if kind == kindSessionCookie {
+				ttl = c.cookieTTL
+			}
+			c.store(key, ttl, call.token, call.err)
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.token, call.err = verifyInner(ctx, raw)
+	call.abandoned = call.err != nil && ctx.Err() != nil
+
+	return cloneToken(call.token), call.err
+}
+
+// store caches a verification result for up to ttl if it is worth keeping.
+// c.mu must be held.
+func (c *TokenCache) store(key tokenCacheKey, ttl time.Duration, token *Token, err error) {
+	now := c.now()
+	var expires time.Time
+	switch {
+	case err == nil && token != nil:
+		expires = now.Add(ttl)
+		if !token.Expiry.IsZero() && token.Expiry.Before(expires) {
+			expires = token.Expiry
+		}
+	case err != nil && c.negativeTTL > 0 && isDefinitiveError(err):
+		expires = now.Add(c.negativeTTL)
+	default:
+		return
+	}
+	if !now.Before(expires) {
+		return
+	}
+
+	entry := &tokenCacheEntry{key: key, token: cloneToken(token), err: err, expires: expires}
+	c.entries[key] = c.lru.PushFront(entry)
+	if token != nil {
+		if c.byUID[token.UID] == nil {
+			c.byUID[token.UID] = make(map[tokenCacheKey]struct{})
+		}
+		c.byUID[token.UID][key] = struct{}{}
+	}
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops an entry. c.mu must be held.
+func (c *TokenCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*tokenCacheEntry)
+	delete(c.entries, entry.key)
+	if entry.token != nil {
+		keys := c.byUID[entry.token.UID]
+		delete(keys, entry.key)
+		if len(keys) == 0 {
+			delete(c.byUID, entry.token.UID)
+		}
+	}
+}
+
+// isDefinitiveError reports whether err says something about the token
+// itself rather than about the provider being unavailable
+func isDefinitiveError(err error) bool {
+	return IsInvalidError(err) || IsExpiredError(err) || IsRevokedError(err) || errors.Is(err, ErrUserDisabled)
+}
+
+func cacheKeyFor(kind tokenKind, token string) tokenCacheKey {
+	h := sha256.New()
+	h.Write([]byte{byte(kind)})
+	h.Write([]byte(token))
+	var key tokenCacheKey
+	h.Sum(key[:0])
+	return key
+}
+
+// cloneToken deep-copies a token so callers cannot change the cached one
+func cloneToken(t *Token) *Token {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	if t.Claims != nil {
+		c.Claims = cloneClaim(t.Claims).(map[string]interface{})
+	}
+	return &c
+}
+
+// cloneClaim copies the maps and slices of a decoded JSON claim
+func cloneClaim(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			c[k] = cloneClaim(item)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, item := range v {
+			c[i] = cloneClaim(item)
+		}
+		return c
+	case []string:
+		return slices.Clone(v)
+	default:
+		return v
+	}
+}
+
+var (
+	_ Servicer       = (*TokenCache)(nil)
+	_ SessionRevoker = (*TokenCache)(nil)
+	_ StepUpRecorder = (*TokenCache)(nil)
+)
@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenCacheReusesVerifiedTokens(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingProvider{Servicer: NewMockProvider()}
+	cache := NewTokenCache(inner, TokenCacheConfig{})
+
+	for range 3 {
+		token, err := cache.VerifyIDToken(ctx, "test-token-1")
+		if err != nil || token.UID != "user-1" {
+			t.Fatalf("VerifyIDToken = %+v, %v", token, err)
+		}
+		token.Claims["role"] = "admin"
+	}
+	if calls := inner.calls.Load(); calls != 1 {
+		t.Fatalf("inner calls = %d, want 1", calls)
+	}
+
+	token, _ := cache.VerifyIDToken(ctx, "test-token-1")
+	if token.Claims["role"] != "user" {
+		t.Fatalf("role = %v, want the cached token unchanged", token.Claims["role"])
+	}
+
+	// Session cookies are cached apart from ID tokens
+	if _, err := cache.VerifySessionCookie(ctx, "test-token-1"); err != nil {
+		t.Fatalf("VerifySessionCookie: %v", err)
+	}
+	if calls := inner.calls.Load(); calls != 2 {
+		t.Fatalf("inner calls = %d, want 2", calls)
+	}
+}
+
+func TestTokenCacheExpiresEntries(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	mock := NewMockProvider()
+	mock.AddUser("short-token", &Token{UID: "short", Expiry: now.Add(10 * time.Second)}, nil)
+	inner := &countingProvider{Servicer: mock}
+	cache := NewTokenCache(inner, TokenCacheConfig{TTL: time.Minute, Now: func() time.Time { return now }})
+
+	for _, raw := range []string{"test-token-1", "short-token"} {
+		if _, err := cache.VerifyIDToken(ctx, raw); err != nil {
+			t.Fatalf("VerifyIDToken(%q): %v", raw, err)
+		}
+	}
+
+	// The short token's own expiry ends its entry before the TTL
+	now = now.Add(15 * time.Second)
+	_, _ = cache.VerifyIDToken(ctx, "test-token-1")
+	_, _ = cache.VerifyIDToken(ctx, "short-token")
+	if calls := inner.calls.Load(); calls != 3 {
+		t.Fatalf("inner calls = %d, want 3", calls)
+	}
+
+	now = now.Add(time.Minute)
+	_, _ = cache.VerifyIDToken(ctx, "test-token-1")
+	if calls := inner.calls.Load(); calls != 4 {
+		t.Fatalf("inner calls after the TTL = %d, want 4", calls)
+	}
+}
+
+func TestTokenCacheRemembersRejectedTokens(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	inner := &countingProvider{Servicer: NewMockProvider()}
+	cache := NewTokenCache(inner, TokenCacheConfig{NegativeTTL: 5 * time.Second, Now: func() time.Time { return now }})
+
+	for range 2 {
+		if _, err := cache.VerifyIDToken(ctx, "forged"); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("VerifyIDToken = %v, want ErrInvalidToken", err)
+		}
+	}
+	if calls := inner.calls.Load(); calls != 1 {
+		t.Fatalf("inner calls = %d, want 1", calls)
+	}
+
+	now = now.Add(6 * time.Second)
+	_, _ = cache.VerifyIDToken(ctx, "forged")
+	if calls := inner.calls.Load(); calls != 2 {
+		t.Fatalf("inner calls after the negative TTL = %d, want 2", calls)
+	}
+
+	// Provider outages are retried at once
+	inner.err = errors.New("jwks unavailable")
+	_, _ = cache.VerifyIDToken(ctx, "test-token-1")
+	_, _ = cache.VerifyIDToken(ctx, "test-token-1")
+	if calls := inner.calls.Load(); calls != 4 {
+		t.Fatalf("inner calls with an outage = %d, want 4", calls)
+	}
+}
+
+func TestTokenCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingProvider{Servicer: NewMockProvider()}
+	cache := NewTokenCache(inner, TokenCacheConfig{MaxEntries: 2})
+
+	_, _ = cache.VerifyIDToken(ctx, "test-token-1")
+	_, _ = cache.VerifyIDToken(ctx, "test-token-admin")
+	_, _ = cache.VerifyIDToken(ctx, "test-token-1")
+	_, _ = cache.VerifyIDToken(ctx, "test-token-unverified")
+	if cache.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", cache.Len())
+	}
+
+	calls := inner.calls.Load()
+	_, _ = cache.VerifyIDToken(ctx, "test-token-1")
+	if inner.calls.Load() != calls {
+		t.Fatal("recently used token was evicted")
+	}
+	_, _ = cache.VerifyIDToken(ctx, "test-token-admin")
+	if inner.calls.Load() != calls+1 {
+		t.Fatal("least recently used token was not evicted")
+	}
+}
+
+func TestTokenCacheSharesConcurrentVerifications(t *testing.T) {
+	ctx := context.Background()
+	release := make(chan struct{})
+	inner := &countingProvider{Servicer: NewMockProvider(), block: release}
+	cache := NewTokenCache(inner, TokenCacheConfig{})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.VerifyIDToken(ctx, "test-token-1")
+			errs <- err
+		}()
+	}
+	for inner.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("VerifyIDToken: %v", err)
+		}
+	}
+	if calls := inner.calls.Load(); calls != 1 {
+		t.Fatalf("inner calls = %d, want 1", calls)
+	}
+}
+
+func TestTokenCacheWaiterHonoursContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	inner := &countingProvider{Servicer: NewMockProvider(), block: release}
+	cache := NewTokenCache(inner, TokenCacheConfig{})
+
+	go func() { _, _ = cache.VerifyIDToken(context.Background(), "test-token-1") }()
+	for inner.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cache.VerifyIDToken(ctx, "test-token-1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("VerifyIDToken = %v, want context.Canceled", err)
+	}
+}
+
+func TestTokenCacheWaitersOutliveCanceledCaller(t *testing.T) {
+	release := make(chan struct{})
+	inner := &countingProvider{Servicer: NewMockProvider(), block: release}
+	cache := NewTokenCache(inner, TokenCacheConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cache.VerifyIDToken(ctx, "test-token-1")
+		first <- err
+	}()
+	for inner.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := cache.VerifyIDToken(context.Background(), "test-token-1")
+		second <- err
+	}()
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled caller = %v, want context.Canceled", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Fatalf("waiting caller = %v, want the token verified", err)
+	}
+}
+
+func TestTokenCacheRecoversFromPanickingProvider(t *testing.T) {
+	inner := &countingProvider{Servicer: NewMockProvider()}
+	cache := NewTokenCache(inner, TokenCacheConfig{})
+
+	inner.panics.Store(true)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected the panic to propagate")
+			}
+		}()
+		_, _ = cache.VerifyIDToken(context.Background(), "test-token-1")
+	}()
+
+	inner.panics.Store(false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := cache.VerifyIDToken(ctx, "test-token-1"); err != nil {
+		t.Fatalf("VerifyIDToken after a panic = %v", err)
+	}
+}
+
+func TestTokenCacheCopiesNestedClaims(t *testing.T) {
+	ctx := context.Background()
+	mock := NewMockProvider()
+	mock.AddUser("nested-token", &Token{
+		UID:    "nested",
+		Expiry: time.Now().Add(time.Hour),
+		Claims: map[string]interface{}{
+			"org":   map[string]interface{}{"role": "member"},
+			"roles": []interface{}{"user"},
+		},
+	}, nil)
+	cache := NewTokenCache(mock, TokenCacheConfig{})
+
+	token, err := cache.VerifyIDToken(ctx, "nested-token")
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	token.Claims["org"].(map[string]interface{})["role"] = "admin"
+	token.Claims["roles"].([]interface{})[0] = "admin"
+
+	token, _ = cache.VerifyIDToken(ctx, "nested-token")
+	if role := token.Claims["org"].(map[string]interface{})["role"]; role != "member" {
+		t.Fatalf("org role = %v, want the cached token unchanged", role)
+	}
+	if role := token.Claims["roles"].([]interface{})[0]; role != "user" {
+		t.Fatalf("roles = %v, want the cached token unchanged", token.Claims["roles"])
+	}
+}
+
+func TestTokenCacheInvalidatesOnRevocation(t *testing.T) {
+	ctx := context.Background()
+	inner := &revokingProvider{countingProvider: countingProvider{Servicer: NewMockProvider()}}
+	cache := NewTokenCache(inner, TokenCacheConfig{})
+
+	revoker, ok := Lookup[SessionRevoker](cache)
+	if !ok || revoker != SessionRevoker(cache) {
+		t.Fatal("Lookup did not find the cache as the SessionRevoker")
+	}
+
+	_, _ = cache.VerifySessionCookie(ctx, "test-token-1")
+	_, _ = cache.VerifySessionCookie(ctx, "test-token-admin")
+	if err := revoker.RevokeSessionCookie(ctx, "test-token-1"); err != nil {
+		t.Fatalf("RevokeSessionCookie: %v", err)
+	}
+	if _, err := cache.VerifySessionCookie(ctx, "test-token-1"); !IsRevokedError(err) {
+		t.Fatalf("VerifySessionCookie after revocation = %v, want revoked", err)
+	}
+
+	if err := revoker.RevokeUserSessions(ctx, "user-admin"); err != nil {
+		t.Fatalf("RevokeUserSessions: %v", err)
+	}
+	if _, err := cache.VerifySessionCookie(ctx, "test-token-admin"); !IsRevokedError(err) {
+		t.Fatalf("VerifySessionCookie after RevokeUserSessions = %v, want revoked", err)
+	}
+}
+
+func TestTokenCacheForwardsOnlyInnerCapabilities(t *testing.T) {
+	cache := NewTokenCache(NewMockProvider(), TokenCacheConfig{})
+	if _, ok := Lookup[SessionRevoker](cache); ok {
+		t.Fatal("Lookup found a SessionRevoker over a provider without one")
+	}
+	if _, ok := Lookup[StepUpRecorder](cache); ok {
+		t.Fatal("Lookup found a StepUpRecorder over a provider without one")
+	}
+}
+
+// countingProvider counts verifications, optionally failing them with err,
+// panicking, or holding them until block is closed or their context ends
+type countingProvider struct {
+	Servicer
+	calls  atomic.Int32
+	block  chan struct{}
+	err    error
+	panics atomic.Bool
+}
+
+func (p *countingProvider) VerifyIDToken(ctx context.Context, idToken string) (*Token, error) {
+	p.calls.Add(1)
+	if p.panics.Load() {
+		panic("provider failed")
+	}
+	if p.block != nil {
+		select {
+		case <-p.block:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.Servicer.VerifyIDToken(ctx, idToken)
+}
+
+func (p *countingProvider) VerifySessionCookie(ctx context.Context, sessionCookie string) (*Token, error) {
+	return p.VerifyIDToken(ctx, sessionCookie)
+}
+
+// revokingProvider rejects revoked cookies and the cookies of revoked users
+type revokingProvider struct {
+	countingProvider
+	mu      sync.Mutex
+	revoked map[string]bool
+}
+
+func (p *revokingProvider) VerifySessionCookie(ctx context.Context, sessionCookie string) (*Token, error) {
+	token, err := p.countingProvider.VerifySessionCookie(ctx, sessionCookie)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.revoked[sessionCookie] || p.revoked[token.UID] {
+		return nil, ErrRevokedSessionCookie
+	}
+	return token, nil
+}
+
+func (p *revokingProvider) RevokeSessionCookie(ctx context.Context, sessionCookie string) error {
+	return p.revoke(sessionCookie)
+}
+
+func (p *revokingProvider) RevokeUserSessions(ctx context.Context, uid string) error {
+	return p.revoke(uid)
+}
+
+func (p *revokingProvider) revoke(key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.revoked == nil {
+		p.revoked = make(map[string]bool)
+	}
+	p.revoked[key] = true
+	return nil
+}
@@ -0,0 +1,167 @@
+// Package totp implements time-based one-time passwords (RFC 6238) and the
+// single-use recovery codes that back them up.
+//
+// Codes are the 6 digit, 30 second, HMAC-SHA1 variant that every
+// authenticator app supports. Validate accepts codes from a few steps either
+// side of the current one to allow for clock drift, and returns the step it
+// matched so callers can refuse to accept the same step twice.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is the length of a code
+	Digits = 6
+	// Period is how long each code is valid
+	Period = 30 * time.Second
+	// DefaultSkew is how many steps before and after the current one
+	// Validate accepts
+	DefaultSkew = 1
+	// RecoveryCodeCount is how many recovery codes GenerateRecoveryCodes makes
+	RecoveryCodeCount = 10
+
+	secretLength = 20
+)
+
+// ErrMalformedSecret is returned for secrets that are not valid base32
+var ErrMalformedSecret = errors.New("totp: malformed secret")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random 160 bit secret, base32 encoded without
+// padding as authenticator apps expect
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// URI returns the otpauth:// URI that authenticator apps import, usually by
+// scanning it as a QR code. issuer names the application and account the
+// user within it.
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(account)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+	params := url.Values{}
+	params.Set("secret", secret)
+	if issuer != "" {
+		params.Set("issuer", issuer)
+	}
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(int(Period.Seconds())))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step returns the time step containing t
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code for secret at time t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, Step(t)), nil
+}
+
+// Validate checks code against the steps within skew of t and returns the
+// matching step. Steps at or before lastStep are refused, so a code cannot
+// be replayed once its step has been recorded as used.
+func Validate(secret, code string, t time.Time, skew int, lastStep int64) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	// Compare against every candidate so the time taken does not reveal
+	// which step matched
+	current := Step(t)
+	var matched int64
+	for step := current - int64(skew); step <= current+int64(skew); step++ {
+		if subtle.ConstantTimeCompare([]byte(codeFor(key, step)), []byte(code)) == 1 && step > lastStep {
+			matched = step
+		}
+	}
+	return matched, matched != 0
+}
+
+// codeFor is code with a guard against negative steps
+func codeFor(key []byte, step int64) string {
+	if step < 0 {
+		return ""
+	}
+	return code(key, step)
+}
+
+// code computes the HOTP value (RFC 4226) of key for counter step
+func code(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1_000_000)
+}
+
+// decodeSecret accepts secrets with or without padding, in any case and
+// with spaces, as users may type them
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := encoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrMalformedSecret
+	}
+	return key, nil
+}
+
+// GenerateRecoveryCodes returns RecoveryCodeCount random codes of the form
+// xxxxx-xxxxx. Each carries 50 bits of entropy, enough for a fast hash since
+// each can be used only once.
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		encoded := strings.ToLower(encoding.EncodeToString(raw))[:10]
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the stored form of a recovery code. Case, spaces
+// and dashes are ignored.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,110 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 test key of RFC 6238 appendix B, base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeMatchesRFC6238Vectors(t *testing.T) {
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		got, err := Code(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", unix, err)
+		}
+		if got != want {
+			t.Fatalf("Code(%d) = %s, want %s", unix, got, want)
+		}
+	}
+
+	if _, err := Code("not base32!", time.Now()); err != ErrMalformedSecret {
+		t.Fatalf("err = %v, want ErrMalformedSecret", err)
+	}
+}
+
+func TestValidateAllowsDriftAndRefusesReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	previous, _ := Code(rfcSecret, now.Add(-Period))
+	step, ok := Validate(rfcSecret, previous, now, DefaultSkew, 0)
+	if !ok || step != current-1 {
+		t.Fatalf("Validate(previous) = %d, %v, want %d", step, ok, current-1)
+	}
+
+	// Once a step is used, it and earlier steps are refused
+	if _, ok := Validate(rfcSecret, previous, now, DefaultSkew, step); ok {
+		t.Fatal("Validate accepted a replayed code")
+	}
+	code, _ := Code(rfcSecret, now)
+	if step, ok := Validate(rfcSecret, code, now, DefaultSkew, current-1); !ok || step != current {
+		t.Fatalf("Validate(current) = %d, %v, want %d", step, ok, current)
+	}
+
+	tooOld, _ := Code(rfcSecret, now.Add(-2*Period))
+	for _, code := range []string{tooOld, "000000", "12345", ""} {
+		if _, ok := Validate(rfcSecret, code, now, DefaultSkew, 0); ok {
+			t.Fatalf("Validate(%q) = ok, want refused", code)
+		}
+	}
+}
+
+func TestGenerateSecretAndURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	if len(secret) != 32 {
+		t.Fatalf("len(secret) = %d, want 32", len(secret))
+	}
+	if _, err := Code(strings.ToLower(secret), time.Now()); err != nil {
+		t.Fatalf("Code with lower case secret: %v", err)
+	}
+
+	u, err := url.Parse(URI("Starter App", "marc@example.com", secret))
+	if err != nil {
+		t.Fatalf("parse URI: %v", err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Starter App:marc@example.com" {
+		t.Fatalf("URI = %s", u)
+	}
+	if q := u.Query(); q.Get("secret") != secret || q.Get("issuer") != "Starter App" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Fatalf("URI query = %v", q)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes: %v", err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("len(codes) = %d, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Fatalf("code %q, want xxxxx-xxxxx", code)
+		}
+		hash := HashRecoveryCode(code)
+		if seen[hash] {
+			t.Fatalf("duplicate code %q", code)
+		}
+		seen[hash] = true
+	}
+
+	code := codes[0]
+	if HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", " "))) != HashRecoveryCode(code) {
+		t.Fatal("HashRecoveryCode depends on case or separators")
+	}
+}
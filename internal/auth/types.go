@@ -7,6 +7,9 @@ type contextKey string
 const (
 	// authTokenKey is the context key for storing the authenticated token.
 	authTokenKey contextKey = "auth-token"
+
+	// sessionMetadataKey is the context key for SessionMetadata.
+	sessionMetadataKey contextKey = "session-metadata"
 )
 
 // Token represents a validated token.
@@ -27,3 +30,18 @@ type UserInfo struct {
 	DisplayName   string
 	PhotoURL      string
 }
+
+// UserInfoFromToken describes the token's identity by its email,
+// email_verified, name and picture claims, for providers without a user
+// directory.
+func UserInfoFromToken(token *Token) *UserInfo {
+	name, _ := token.Claims["name"].(string)
+	picture, _ := token.Claims["picture"].(string)
+	return &UserInfo{
+		UID:           token.UID,
+		Email:         token.Email,
+		EmailVerified: token.EmailVerified,
+		DisplayName:   name,
+		PhotoURL:      picture,
+	}
+}
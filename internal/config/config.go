@@ -7,11 +7,43 @@ const (
 	ProductionEnvironment  = "prod"
 )
 
+const (
+	// DatabaseModeRemote connects to a Turso/libsql server
+	DatabaseModeRemote = "remote"
+	// DatabaseModeFile opens a local SQLite database file
+	DatabaseModeFile = "file"
+	// DatabaseModeMemory opens a private in-memory SQLite database
+	DatabaseModeMemory = "memory"
+)
+
+const (
+	// AuthProviderNone disables authentication; every route is public
+	AuthProviderNone = "none"
+	// AuthProviderMock accepts the fixed test tokens of auth.MockProvider.
+	// It is refused when App.Environment is prod.
+	AuthProviderMock = "mock"
+	// AuthProviderJWT verifies JWTs against the issuer's JWKS
+	AuthProviderJWT = "jwt"
+	// AuthProviderLocal checks passwords stored in the database
+	AuthProviderLocal = "local"
+)
+
+const (
+	// MailDriverLog logs outgoing email instead of sending it
+	MailDriverLog = "log"
+	// MailDriverFile writes outgoing email to files in Mail.Dir
+	MailDriverFile = "file"
+	// MailDriverSMTP sends email through an SMTP server
+	MailDriverSMTP = "smtp"
+)
+
 // Config holds all application configuration
 type Config struct {
 	Database Database `toml:"Database"`
 	Server   Server   `toml:"Server"`
 	App      App      `toml:"App"`
+	Auth     Auth     `toml:"Auth"`
+	Mail     Mail     `toml:"Mail"`
 }
 
 // App contains application-wide settings
@@ -27,11 +59,140 @@ type Server struct {
 	AllowedCorsURLs      []string `toml:"AllowedCorsURLs" env:"ALLOWED_CORS_URLS"`
 	TaskTimeOutInSeconds int      `toml:"TaskTimeOutInSeconds" env:"TASK_TIMEOUT_IN_SECONDS" env-default:"3600"`
 	ServerDomain         string   `toml:"ServerDomain" env:"SERVER_DOMAIN"`
+	// TrustedProxies are the IPs or CIDR prefixes of reverse proxies whose
+	// X-Forwarded-For and X-Real-IP headers give the client IP. Headers
+	// from anyone else are ignored.
+	TrustedProxies []string `toml:"TrustedProxies" env:"TRUSTED_PROXIES"`
 }
 
 // Database contains database connection settings
 type Database struct {
+	Mode                  string `toml:"Mode" env:"DATABASE_MODE" env-default:"remote"`
 	TursoConnectionString string `toml:"TursoConnectionString" env:"TURSO_CONNECTION_STRING"`
+	Path                  string `toml:"Path" env:"DATABASE_PATH" env-default:"data/app.db"`
+	BusyTimeoutMillis     int    `toml:"BusyTimeoutMillis" env:"DATABASE_BUSY_TIMEOUT_MILLIS" env-default:"5000"`
+	AutoMigrate           bool   `toml:"AutoMigrate" env:"DATABASE_AUTO_MIGRATE" env-default:"true"`
+}
+
+// Auth selects the provider that verifies bearer tokens and session cookies
+type Auth struct {
+	Provider string `toml:"Provider" env:"AUTH_PROVIDER" env-default:"none"`
+
+	// Session cookie issued by POST /auth/session. CookieSameSite is lax,
+	// strict or none; CookieSecure should only be disabled for plain-HTTP
+	// local development.
+	SessionLifetimeSeconds int    `toml:"SessionLifetimeSeconds" env:"AUTH_SESSION_LIFETIME_SECONDS" env-default:"604800"`
+	CookieSecure           bool   `toml:"CookieSecure" env:"AUTH_COOKIE_SECURE" env-default:"true"`
+	CookieSameSite         string `toml:"CookieSameSite" env:"AUTH_COOKIE_SAMESITE" env-default:"lax"`
+
+	// ServerSessions wraps the mock and jwt providers with database-backed
+	// sessions so cookies can be revoked; SessionLifetimeSeconds then becomes
+	// an idle timeout that slides up to SessionMaxLifetimeSeconds. The local
+	// provider always keeps its sessions in the database.
+	ServerSessions              bool `toml:"ServerSessions" env:"AUTH_SERVER_SESSIONS" env-default:"true"`
+	SessionMaxLifetimeSeconds   int  `toml:"SessionMaxLifetimeSeconds" env:"AUTH_SESSION_MAX_LIFETIME_SECONDS" env-default:"2592000"`
+	SessionPurgeIntervalSeconds int  `toml:"SessionPurgeIntervalSeconds" env:"AUTH_SESSION_PURGE_INTERVAL_SECONDS" env-default:"3600"`
+
+	// PurgeIntervalSeconds is how often expired login throttle counters,
+	// magic links and OAuth states are deleted; 0 never purges them
+	PurgeIntervalSeconds int `toml:"PurgeIntervalSeconds" env:"AUTH_PURGE_INTERVAL_SECONDS" env-default:"3600"`
+
+	// APIKeys accepts keys managed under /api/keys in the X-API-Key header
+	// or an "Authorization: ApiKey" header
+	APIKeys bool `toml:"APIKeys" env:"AUTH_API_KEYS" env-default:"true"`
+
+	// TokenCache keeps verified tokens and session cookies in memory for up
+	// to TokenCacheTTLSeconds, never past their expiry, and remembers
+	// rejected ones for TokenCacheNegativeTTLSeconds, holding at most
+	// TokenCacheSize entries. ServerSessions cookies are reused for at most
+	// a minute so their idle timeout still slides. The cache is per
+	// process: revocations made through another instance can take up to the
+	// TTL to apply here, except on routes that check revocation.
+	TokenCache                   bool `toml:"TokenCache" env:"AUTH_TOKEN_CACHE" env-default:"false"`
+	TokenCacheTTLSeconds         int  `toml:"TokenCacheTTLSeconds" env:"AUTH_TOKEN_CACHE_TTL_SECONDS" env-default:"60"`
+	TokenCacheNegativeTTLSeconds int  `toml:"TokenCacheNegativeTTLSeconds" env:"AUTH_TOKEN_CACHE_NEGATIVE_TTL_SECONDS" env-default:"10"`
+	TokenCacheSize               int  `toml:"TokenCacheSize" env:"AUTH_TOKEN_CACHE_SIZE" env-default:"10000"`
+
+	// Throttle slows down repeated failed logins per username and client IP.
+	// After ThrottleFreeAttempts failures each further one locks the key for
+	// an exponentially growing delay; at ThrottleLockoutThreshold failures
+	// it is locked out for ThrottleLockoutSeconds or until an admin unlocks
+	// it. Counters live in the database and are purged every
+	// PurgeIntervalSeconds.
+	Throttle                 bool `toml:"Throttle" env:"AUTH_THROTTLE" env-default:"true"`
+	ThrottleFreeAttempts     int  `toml:"ThrottleFreeAttempts" env:"AUTH_THROTTLE_FREE_ATTEMPTS" env-default:"5"`
+	ThrottleLockoutThreshold int  `toml:"ThrottleLockoutThreshold" env:"AUTH_THROTTLE_LOCKOUT_THRESHOLD" env-default:"20"`
+	ThrottleLockoutSeconds   int  `toml:"ThrottleLockoutSeconds" env:"AUTH_THROTTLE_LOCKOUT_SECONDS" env-default:"3600"`
+
+	// TOTP second factor. MFAIssuer names the app in authenticator apps. A
+	// step-up at POST /auth/mfa lasts MFAMaxAgeSeconds; RequireMFA makes
+	// deleting users need one.
+	MFAIssuer        string `toml:"MFAIssuer" env:"AUTH_MFA_ISSUER" env-default:"starterA"`
+	MFAMaxAgeSeconds int    `toml:"MFAMaxAgeSeconds" env:"AUTH_MFA_MAX_AGE_SECONDS" env-default:"900"`
+	RequireMFA       bool   `toml:"RequireMFA" env:"AUTH_REQUIRE_MFA" env-default:"false"`
+
+	// MagicLink enables passwordless login: POST /auth/magic-link emails a
+	// single-use link valid for MagicLinkTTLSeconds. MagicLinkURL is the
+	// absolute address of /auth/magic-link/verify, empty to derive it from
+	// Server.ServerDomain; MagicLinkRedirectURL is where the browser goes
+	// once signed in. Needs the local provider.
+	MagicLink            bool   `toml:"MagicLink" env:"AUTH_MAGIC_LINK" env-default:"false"`
+	MagicLinkTTLSeconds  int    `toml:"MagicLinkTTLSeconds" env:"AUTH_MAGIC_LINK_TTL_SECONDS" env-default:"900"`
+	MagicLinkURL         string `toml:"MagicLinkURL" env:"AUTH_MAGIC_LINK_URL"`
+	MagicLinkRedirectURL string `toml:"MagicLinkRedirectURL" env:"AUTH_MAGIC_LINK_REDIRECT_URL" env-default:"/"`
+
+	// OAuthProviders are external OAuth 2.0 / OpenID Connect providers for
+	// login at /auth/{name}/login, keyed by name as in
+	// [Auth.OAuthProviders.google]. OAuthRedirectURL is where the browser
+	// goes once signed in. Needs the local provider.
+	OAuthProviders   map[string]OAuthProvider `toml:"OAuthProviders"`
+	OAuthRedirectURL string                   `toml:"OAuthRedirectURL" env:"AUTH_OAUTH_REDIRECT_URL" env-default:"/"`
+
+	// JWT provider settings. JWKSURL may be left empty to discover it from
+	// the issuer's OpenID configuration.
+	Issuer              string   `toml:"Issuer" env:"AUTH_ISSUER"`
+	Audience            []string `toml:"Audience" env:"AUTH_AUDIENCE"`
+	JWKSURL             string   `toml:"JWKSURL" env:"AUTH_JWKS_URL"`
+	ClockSkewSeconds    int      `toml:"ClockSkewSeconds" env:"AUTH_CLOCK_SKEW_SECONDS" env-default:"60"`
+	JWKSCacheTTLSeconds int      `toml:"JWKSCacheTTLSeconds" env:"AUTH_JWKS_CACHE_TTL_SECONDS" env-default:"3600"`
+
+	// Local provider settings. SessionSecret signs ID tokens and session
+	// cookies and must be at least 32 bytes. Raising the Argon2 costs
+	// rehashes each password on its next successful login.
+	SessionSecret     string `toml:"SessionSecret" env:"AUTH_SESSION_SECRET"`
+	PasswordMinLength int    `toml:"PasswordMinLength" env:"AUTH_PASSWORD_MIN_LENGTH" env-default:"12"`
+	Argon2MemoryKiB   uint32 `toml:"Argon2MemoryKiB" env:"AUTH_ARGON2_MEMORY_KIB" env-default:"65536"`
+	Argon2Iterations  uint32 `toml:"Argon2Iterations" env:"AUTH_ARGON2_ITERATIONS" env-default:"3"`
+	Argon2Parallelism uint8  `toml:"Argon2Parallelism" env:"AUTH_ARGON2_PARALLELISM" env-default:"2"`
+}
+
+// OAuthProvider configures one external login provider. AuthURL, TokenURL
+// and JWKSURL may be left empty to discover them from the issuer's OpenID
+// configuration; Scopes defaults to openid, email and profile. An empty
+// ClientSecret is read from AUTH_OAUTH_<NAME>_CLIENT_SECRET, and an empty
+// RedirectURL is derived from Server.ServerDomain as
+// https://<domain>/auth/<name>/callback.
+type OAuthProvider struct {
+	Issuer       string   `toml:"Issuer"`
+	ClientID     string   `toml:"ClientID"`
+	ClientSecret string   `toml:"ClientSecret"`
+	AuthURL      string   `toml:"AuthURL"`
+	TokenURL     string   `toml:"TokenURL"`
+	JWKSURL      string   `toml:"JWKSURL"`
+	Scopes       []string `toml:"Scopes"`
+	RedirectURL  string   `toml:"RedirectURL"`
+}
+
+// Mail selects how outgoing email is delivered. The log and file drivers
+// are for development; smtp sends through SMTPHost.
+type Mail struct {
+	Driver       string `toml:"Driver" env:"MAIL_DRIVER" env-default:"log"`
+	From         string `toml:"From" env:"MAIL_FROM" env-default:"noreply@localhost"`
+	Dir          string `toml:"Dir" env:"MAIL_DIR" env-default:"data/mail"`
+	SMTPHost     string `toml:"SMTPHost" env:"MAIL_SMTP_HOST"`
+	SMTPPort     int    `toml:"SMTPPort" env:"MAIL_SMTP_PORT" env-default:"587"`
+	SMTPUsername string `toml:"SMTPUsername" env:"MAIL_SMTP_USERNAME"`
+	SMTPPassword string `toml:"SMTPPassword" env:"MAIL_SMTP_PASSWORD"`
 }
 
 // Load reads configuration from the specified file path
@@ -52,6 +52,55 @@ TursoConnectionString = "libsql://example.turso.io?authToken=test-token"
 	if cfg.Database.TursoConnectionString == "" {
 		t.Fatal("TursoConnectionString is empty")
 	}
+	if !cfg.Database.AutoMigrate {
+		t.Fatal("AutoMigrate = false, want default true")
+	}
+	if cfg.Database.Mode != DatabaseModeRemote {
+		t.Fatalf("Mode = %q, want %q", cfg.Database.Mode, DatabaseModeRemote)
+	}
+	if cfg.Auth.Provider != AuthProviderNone {
+		t.Fatalf("Auth.Provider = %q, want %q", cfg.Auth.Provider, AuthProviderNone)
+	}
+	if !cfg.Auth.CookieSecure || !cfg.Auth.ServerSessions || cfg.Auth.CookieSameSite != "lax" || cfg.Auth.SessionLifetimeSeconds != 604800 {
+		t.Fatalf("Auth cookie defaults = %#v", cfg.Auth)
+	}
+	if !cfg.Auth.Throttle || cfg.Auth.ThrottleFreeAttempts != 5 || cfg.Auth.ThrottleLockoutThreshold != 20 || cfg.Auth.ThrottleLockoutSeconds != 3600 {
+		t.Fatalf("Auth throttle defaults = %#v", cfg.Auth)
+	}
+	if cfg.Auth.TokenCache || cfg.Auth.TokenCacheTTLSeconds != 60 || cfg.Auth.TokenCacheNegativeTTLSeconds != 10 || cfg.Auth.TokenCacheSize != 10000 {
+		t.Fatalf("Auth token cache defaults = %#v", cfg.Auth)
+	}
+	if cfg.Auth.MagicLink || cfg.Auth.MagicLinkTTLSeconds != 900 || cfg.Auth.MagicLinkRedirectURL != "/" {
+		t.Fatalf("Auth magic link defaults = %#v", cfg.Auth)
+	}
+	if cfg.Mail.Driver != MailDriverLog || cfg.Mail.SMTPPort != 587 {
+		t.Fatalf("Mail defaults = %#v", cfg.Mail)
+	}
+}
+
+func TestLoadReadsOAuthProviders(t *testing.T) {
+	configPath := writeConfig(t, `
+[Auth.OAuthProviders.google]
+Issuer = "https://accounts.google.com"
+ClientID = "starter-app"
+Scopes = ["openid", "email"]
+`)
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+
+	google, ok := cfg.Auth.OAuthProviders["google"]
+	if !ok || len(cfg.Auth.OAuthProviders) != 1 {
+		t.Fatalf("OAuthProviders = %#v, want google", cfg.Auth.OAuthProviders)
+	}
+	if google.Issuer != "https://accounts.google.com" || google.ClientID != "starter-app" || len(google.Scopes) != 2 {
+		t.Fatalf("google = %#v", google)
+	}
+	if cfg.Auth.OAuthRedirectURL != "/" {
+		t.Fatalf("OAuthRedirectURL = %q, want default /", cfg.Auth.OAuthRedirectURL)
+	}
 }
 
 func TestLoadUsesEnvironmentOverride(t *testing.T) {
@@ -4,25 +4,36 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
 	"github.com/mhpenta/starterA/internal/config"
 	"github.com/mhpenta/starterA/internal/database/repo"
-	"time"
 
 	_ "github.com/tursodatabase/libsql-client-go/libsql"
+	_ "modernc.org/sqlite"
 )
 
-// GetConnection establishes a connection to the Turso database
-// and returns a database handle with proper connection pool settings
+const (
+	libsqlDriver = "libsql"
+	sqliteDriver = "sqlite"
+
+	defaultBusyTimeout = 5 * time.Second
+)
+
+// GetConnection establishes a connection to the configured database
+// and returns a database handle with connection pool settings suited to the mode:
+// a pooled client for remote Turso, a single writer for local SQLite files,
+// and a single pinned connection for in-memory databases
 func GetConnection(dbCfg config.Database) (*sql.DB, error) {
-	db, err := sql.Open("libsql", dbCfg.TursoConnectionString)
+	db, err := open(dbCfg)
 	if err != nil {
 		return nil, err
 	}
 
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(25)
-	db.SetConnMaxLifetime(5 * time.Minute)
-
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -37,6 +48,82 @@ func GetConnection(dbCfg config.Database) (*sql.DB, error) {
 	return db, nil
 }
 
+func open(dbCfg config.Database) (*sql.DB, error) {
+	switch dbCfg.Mode {
+	case config.DatabaseModeRemote, "":
+		db, err := sql.Open(libsqlDriver, dbCfg.TursoConnectionString)
+		if err != nil {
+			return nil, err
+		}
+
+		db.SetMaxOpenConns(25)
+		db.SetMaxIdleConns(25)
+		db.SetConnMaxLifetime(5 * time.Minute)
+
+		return db, nil
+
+	case config.DatabaseModeFile:
+		if dbCfg.Path == "" {
+			return nil, fmt.Errorf("database path is required in %s mode", config.DatabaseModeFile)
+		}
+		if dir := filepath.Dir(dbCfg.Path); dir != "." {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return nil, fmt.Errorf("error creating database directory: %w", err)
+			}
+		}
+
+		db, err := sql.Open(sqliteDriver, sqliteDSN("file:"+dbCfg.Path, dbCfg, true))
+		if err != nil {
+			return nil, err
+		}
+
+		// SQLite allows a single writer; serialise access through one
+		// connection rather than surfacing SQLITE_BUSY to callers
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+		db.SetConnMaxLifetime(0)
+
+		return db, nil
+
+	case config.DatabaseModeMemory:
+		db, err := sql.Open(sqliteDriver, sqliteDSN(":memory:", dbCfg, false))
+		if err != nil {
+			return nil, err
+		}
+
+		// Every connection to :memory: is a separate database, so the pool
+		// must hold exactly one connection and never recycle it
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+		db.SetConnMaxLifetime(0)
+		db.SetConnMaxIdleTime(0)
+
+		return db, nil
+
+	default:
+		return nil, fmt.Errorf("unknown database mode %q", dbCfg.Mode)
+	}
+}
+
+// sqliteDSN appends the pragmas applied to every new SQLite connection
+func sqliteDSN(name string, dbCfg config.Database, wal bool) string {
+	busyTimeout := time.Duration(dbCfg.BusyTimeoutMillis) * time.Millisecond
+	if busyTimeout <= 0 {
+		busyTimeout = defaultBusyTimeout
+	}
+
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout("+strconv.FormatInt(busyTimeout.Milliseconds(), 10)+")")
+	if wal {
+		params.Add("_pragma", "journal_mode(WAL)")
+		params.Add("_pragma", "synchronous(NORMAL)")
+		params.Set("_txlock", "immediate")
+	}
+
+	return name + "?" + params.Encode()
+}
+
 // GetDatabase initializes a database connection and returns
 // a Queries object that provides type-safe access to all database operations
 func GetDatabase(dbCfg config.Database) (*repo.Queries, error) {
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/mhpenta/starterA/internal/config"
+)
+
+func TestGetConnectionMemoryModeKeepsSingleDatabase(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	if _, err := db.ExecContext(ctx, "CREATE TABLE widgets (id INTEGER)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	// A second statement must see the same database, not a fresh :memory: one
+	if _, err := db.ExecContext(ctx, "INSERT INTO widgets (id) VALUES (1)"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	assertPragma(t, db, "foreign_keys", "1")
+	assertPragma(t, db, "busy_timeout", "5000")
+}
+
+func TestGetConnectionFileModeAppliesPragmas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "app.db")
+
+	db, err := GetConnection(config.Database{
+		Mode:              config.DatabaseModeFile,
+		Path:              path,
+		BusyTimeoutMillis: 1234,
+	})
+	if err != nil {
+		t.Fatalf("open file database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	assertPragma(t, db, "journal_mode", "wal")
+	assertPragma(t, db, "foreign_keys", "1")
+	assertPragma(t, db, "busy_timeout", "1234")
+
+	if got := db.Stats().MaxOpenConnections; got != 1 {
+		t.Fatalf("MaxOpenConnections = %d, want 1", got)
+	}
+}
+
+func TestGetConnectionRejectsInvalidConfig(t *testing.T) {
+	tests := map[string]config.Database{
+		"unknown mode":      {Mode: "postgres"},
+		"file without path": {Mode: config.DatabaseModeFile},
+	}
+
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := GetConnection(cfg); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func assertPragma(t *testing.T, db *sql.DB, pragma, want string) {
+	t.Helper()
+
+	var got string
+	if err := db.QueryRowContext(context.Background(), "PRAGMA "+pragma).Scan(&got); err != nil {
+		t.Fatalf("read pragma %s: %v", pragma, err)
+	}
+	if got != want {
+		t.Fatalf("pragma %s = %q, want %q", pragma, got, want)
+	}
+}
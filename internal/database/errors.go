@@ -0,0 +1,152 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// uniqueViolationPrefix starts the message SQLite and libsql servers report
+// for UNIQUE and PRIMARY KEY failures, followed by "table.column[, table.column]"
+const uniqueViolationPrefix = "UNIQUE constraint failed: "
+
+// UniqueViolation describes a failed UNIQUE constraint
+type UniqueViolation struct {
+	Table   string
+	Columns []string
+}
+
+// AsUniqueViolation reports whether err is a UNIQUE constraint failure and,
+// if so, which table and columns collided. It understands errors from the
+// local SQLite driver and the text-only errors returned by libsql servers.
+func AsUniqueViolation(err error) (*UniqueViolation, bool) {
+	if err == nil {
+		return nil, false
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		default:
+			return nil, false
+		}
+	}
+
+	msg := err.Error()
+	idx := strings.Index(msg, uniqueViolationPrefix)
+	if idx < 0 {
+		return nil, false
+	}
+
+	// The column list ends at the first character that cannot appear in
+	// "table.column, table.column", e.g. the " (2067)" code suffix
+	list := msg[idx+len(uniqueViolationPrefix):]
+	if end := strings.IndexFunc(list, func(r rune) bool {
+		return r != '_' && r != '.' && r != ',' && r != ' ' &&
+			!(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9')
+	}); end >= 0 {
+		list = list[:end]
+	}
+
+	violation := &UniqueViolation{}
+	for _, qualified := range strings.Split(list, ",") {
+		table, column, ok := strings.Cut(strings.TrimSpace(qualified), ".")
+		if !ok || column == "" {
+			continue
+		}
+		violation.Table = table
+		violation.Columns = append(violation.Columns, strings.TrimSpace(column))
+	}
+	if len(violation.Columns) == 0 {
+		return nil, false
+	}
+
+	return violation, true
+}
+
+// busyMessages are substrings reported by libsql servers when the database
+// is locked by another writer. Remote errors only carry the message text.
+var busyMessages = []string{
+	"SQLITE_BUSY",
+	"SQLITE_LOCKED",
+	"database is locked",
+	"database table is locked",
+}
+
+// IsBusyError reports whether err indicates the database was locked by
+// another connection and the operation can be retried
+func IsBusyError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+			return true
+		}
+		return false
+	}
+
+	msg := err.Error()
+	for _, busy := range busyMessages {
+		if strings.Contains(msg, busy) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsTransientError reports whether err is a lock conflict or a dropped
+// connection to a remote database, where retrying the whole operation is safe
+// as long as it had not been committed
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if IsBusyError(err) {
+		return true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// ftsUnavailableMessages are reported when the users_fts migration was
+// skipped because the database was built without FTS5
+var ftsUnavailableMessages = []string{
+	"no such table: users_fts",
+	"no such module: fts5",
+}
+
+// IsFTSUnavailable reports whether err means full-text search is not
+// available on this database, so callers should fall back to LIKE
+func IsFTSUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	for _, unavailable := range ftsUnavailableMessages {
+		if strings.Contains(msg, unavailable) {
+			return true
+		}
+	}
+
+	return false
+}
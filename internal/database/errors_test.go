@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestAsUniqueViolationFromSQLiteDriver(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	if err := Migrate(ctx, db, discardLogger()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	insert := "INSERT INTO users (username, email) VALUES (?, ?)"
+	if _, err := db.ExecContext(ctx, insert, "marc", "marc@example.com"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	_, err := db.ExecContext(ctx, insert, "anna", "marc@example.com")
+
+	violation, ok := AsUniqueViolation(fmt.Errorf("wrapped: %w", err))
+	if !ok {
+		t.Fatalf("AsUniqueViolation(%v) = false, want true", err)
+	}
+	if violation.Table != "users" || len(violation.Columns) != 1 || violation.Columns[0] != "email" {
+		t.Fatalf("violation = %#v, want users.email", violation)
+	}
+}
+
+func TestAsUniqueViolationFromLibsqlMessage(t *testing.T) {
+	err := errors.New(`failed to execute SQL: INSERT INTO users (username, email) VALUES (?, ?)
+error code = 2067: UNIQUE constraint failed: users.username`)
+
+	violation, ok := AsUniqueViolation(err)
+	if !ok {
+		t.Fatal("AsUniqueViolation = false, want true")
+	}
+	if violation.Table != "users" || violation.Columns[0] != "username" {
+		t.Fatalf("violation = %#v, want users.username", violation)
+	}
+
+	violation, ok = AsUniqueViolation(errors.New("SQLite error: UNIQUE constraint failed: memberships.org_id, memberships.user_id"))
+	if !ok || len(violation.Columns) != 2 || violation.Columns[1] != "user_id" {
+		t.Fatalf("violation = %#v, want two columns", violation)
+	}
+
+	if _, ok := AsUniqueViolation(errors.New("NOT NULL constraint failed: users.email")); ok {
+		t.Fatal("NOT NULL failure reported as unique violation")
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: errors.New("SQLite error: database is locked"), want: true},
+		{err: fmt.Errorf("wrapped: %w", errors.New("SQLITE_BUSY")), want: true},
+		{err: context.Canceled, want: false},
+		{err: errors.New("no such table: users"), want: false},
+		{err: nil, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := IsTransientError(tt.err); got != tt.want {
+			t.Errorf("IsTransientError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package memdb
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func (q *queries) CreateApiKey(ctx context.Context, arg repo.CreateApiKeyParams) (repo.ApiKey, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, key := range q.tables.apiKeys {
+		if key.Prefix == arg.Prefix {
+			return repo.ApiKey{}, &ConstraintError{Table: "api_keys", Column: "prefix"}
+		}
+	}
+
+	key := repo.ApiKey{
+		ID:         q.tables.nextKeyID,
+		Prefix:     arg.Prefix,
+		SecretHash: arg.SecretHash,
+		Name:       arg.Name,
+		Scopes:     arg.Scopes,
+		CreatedBy:  arg.CreatedBy,
+		CreatedAt:  q.timestamp(),
+		ExpiresAt:  arg.ExpiresAt,
+	}
+	q.tables.apiKeys[key.ID] = key
+	q.tables.nextKeyID++
+	q.version++
+
+	return key, nil
+}
+
+func (q *queries) DeleteApiKey(ctx context.Context, id int64) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tables.apiKeys[id]; !ok {
+		return 0, nil
+	}
+	delete(q.tables.apiKeys, id)
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) GetApiKey(ctx context.Context, id int64) (repo.ApiKey, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key, ok := q.tables.apiKeys[id]
+	if !ok {
+		return repo.ApiKey{}, sql.ErrNoRows
+	}
+	return key, nil
+}
+
+func (q *queries) GetApiKeyByPrefix(ctx context.Context, prefix string) (repo.ApiKey, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, key := range q.tables.apiKeys {
+		if key.Prefix == prefix {
+			return key, nil
+		}
+	}
+	return repo.ApiKey{}, sql.ErrNoRows
+}
+
+func (q *queries) ListApiKeys(ctx context.Context) ([]repo.ApiKey, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	keys := []repo.ApiKey{}
+	for _, key := range q.tables.apiKeys {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b repo.ApiKey) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return keys, nil
+}
+
+func (q *queries) TouchApiKey(ctx context.Context, arg repo.TouchApiKeyParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key, ok := q.tables.apiKeys[arg.ID]
+	if !ok {
+		return 0, nil
+	}
+	key.LastUsedAt = arg.LastUsedAt
+	q.tables.apiKeys[arg.ID] = key
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) UpdateApiKey(ctx context.Context, arg repo.UpdateApiKeyParams) (repo.ApiKey, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key, ok := q.tables.apiKeys[arg.ID]
+	if !ok {
+		return repo.ApiKey{}, sql.ErrNoRows
+	}
+	key.Name = arg.Name
+	key.Scopes = arg.Scopes
+	q.tables.apiKeys[arg.ID] = key
+	q.version++
+
+	return key, nil
+}
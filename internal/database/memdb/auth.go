@@ -0,0 +1,189 @@
+package memdb
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func (q *queries) SetUserPassword(ctx context.Context, arg repo.SetUserPasswordParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tables.users[arg.UserID]; !ok {
+		return 0, ErrForeignKey
+	}
+	q.tables.passwords[arg.UserID] = repo.UserPassword{
+		UserID:       arg.UserID,
+		PasswordHash: arg.PasswordHash,
+		UpdatedAt:    q.timestamp(),
+	}
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) GetUserCredentials(ctx context.Context, username string) (repo.GetUserCredentialsRow, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, user := range q.tables.users {
+		if user.Username != username {
+			continue
+		}
+		password, ok := q.tables.passwords[user.ID]
+		if !ok {
+			break
+		}
+		return repo.GetUserCredentialsRow{
+			ID:           user.ID,
+			Username:     user.Username,
+			Email:        user.Email,
+			PasswordHash: password.PasswordHash,
+		}, nil
+	}
+
+	return repo.GetUserCredentialsRow{}, sql.ErrNoRows
+}
+
+func (q *queries) CreateSession(ctx context.Context, arg repo.CreateSessionParams) (repo.Session, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tables.sessions[arg.ID]; ok {
+		return repo.Session{}, &ConstraintError{Table: "sessions", Column: "id"}
+	}
+	session := repo.Session{
+		ID:                 arg.ID,
+		Subject:            arg.Subject,
+		CreatedAt:          q.timestamp(),
+		ExpiresAt:          arg.ExpiresAt,
+		IdleTimeoutSeconds: arg.IdleTimeoutSeconds,
+		Ip:                 arg.Ip,
+		UserAgent:          arg.UserAgent,
+		Device:             arg.Device,
+	}
+	q.tables.sessions[arg.ID] = session
+	q.version++
+
+	return session, nil
+}
+
+func (q *queries) GetSession(ctx context.Context, id string) (repo.Session, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	session, ok := q.tables.sessions[id]
+	if !ok {
+		return repo.Session{}, sql.ErrNoRows
+	}
+	return session, nil
+}
+
+func (q *queries) ListSubjectSessions(ctx context.Context, subject string) ([]repo.Session, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	sessions := []repo.Session{}
+	for _, session := range q.tables.sessions {
+		if session.Subject == subject {
+			sessions = append(sessions, session)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b repo.Session) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return sessions, nil
+}
+
+func (q *queries) TouchSession(ctx context.Context, arg repo.TouchSessionParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	session, ok := q.tables.sessions[arg.ID]
+	if !ok || session.RevokedAt.Valid {
+		return 0, nil
+	}
+	session.LastSeenAt = arg.LastSeenAt
+	session.ExpiresAt = arg.ExpiresAt
+	q.tables.sessions[arg.ID] = session
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) MarkSessionMFA(ctx context.Context, arg repo.MarkSessionMFAParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	session, ok := q.tables.sessions[arg.ID]
+	if !ok || session.RevokedAt.Valid {
+		return 0, nil
+	}
+	session.MfaVerifiedAt = arg.MfaVerifiedAt
+	q.tables.sessions[arg.ID] = session
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) RevokeSession(ctx context.Context, id string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	session, ok := q.tables.sessions[id]
+	if !ok || session.RevokedAt.Valid {
+		return 0, nil
+	}
+	session.RevokedAt = sql.NullTime{Time: q.timestamp(), Valid: true}
+	q.tables.sessions[id] = session
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) RevokeSubjectSessions(ctx context.Context, subject string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var revoked int64
+	for id, session := range q.tables.sessions {
+		if session.Subject != subject || session.RevokedAt.Valid {
+			continue
+		}
+		session.RevokedAt = sql.NullTime{Time: q.timestamp(), Valid: true}
+		q.tables.sessions[id] = session
+		revoked++
+	}
+	if revoked > 0 {
+		q.version++
+	}
+
+	return revoked, nil
+}
+
+func (q *queries) DeleteExpiredSessions(ctx context.Context, expiresAt time.Time) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var deleted int64
+	for id, session := range q.tables.sessions {
+		if session.ExpiresAt.Before(expiresAt) {
+			delete(q.tables.sessions, id)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		q.version++
+	}
+
+	return deleted, nil
+}
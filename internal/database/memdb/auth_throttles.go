@@ -0,0 +1,130 @@
+package memdb
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func (q *queries) DeleteAuthThrottle(ctx context.Context, key string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tables.throttles[key]; !ok {
+		return 0, nil
+	}
+	delete(q.tables.throttles, key)
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) DeleteStaleAuthThrottles(ctx context.Context, arg repo.DeleteStaleAuthThrottlesParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var deleted int64
+	for key, throttle := range q.tables.throttles {
+		if !throttle.LastFailureAt.Before(arg.LastFailureAt) {
+			continue
+		}
+		if throttle.LockedUntil.Valid && !throttle.LockedUntil.Time.Before(arg.LockedUntil.Time) {
+			continue
+		}
+		delete(q.tables.throttles, key)
+		deleted++
+	}
+	if deleted > 0 {
+		q.version++
+	}
+
+	return deleted, nil
+}
+
+func (q *queries) GetAuthThrottle(ctx context.Context, key string) (repo.AuthThrottle, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	throttle, ok := q.tables.throttles[key]
+	if !ok {
+		return repo.AuthThrottle{}, sql.ErrNoRows
+	}
+	return throttle, nil
+}
+
+func (q *queries) ListLockedAuthThrottles(ctx context.Context, lockedUntil sql.NullTime) ([]repo.AuthThrottle, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	throttles := []repo.AuthThrottle{}
+	for _, throttle := range q.tables.throttles {
+		if throttle.LockedUntil.Valid && throttle.LockedUntil.Time.After(lockedUntil.Time) {
+			throttles = append(throttles, throttle)
+		}
+	}
+	slices.SortFunc(throttles, func(a, b repo.AuthThrottle) int {
+		return cmp.Or(b.LockedUntil.Time.Compare(a.LockedUntil.Time), cmp.Compare(a.Key, b.Key))
+	})
+
+	return throttles, nil
+}
+
+func (q *queries) LockAuthThrottle(ctx context.Context, arg repo.LockAuthThrottleParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	throttle, ok := q.tables.throttles[arg.Key]
+	if !ok {
+		return 0, nil
+	}
+	throttle.LockedUntil = arg.LockedUntil
+	q.tables.throttles[arg.Key] = throttle
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) ReleaseAuthAttempt(ctx context.Context, key string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	throttle, ok := q.tables.throttles[key]
+	if !ok {
+		return 0, nil
+	}
+	throttle.Failures = max(throttle.Failures-1, 0)
+	throttle.LockedUntil = sql.NullTime{}
+	q.tables.throttles[key] = throttle
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) ReserveAuthAttempt(ctx context.Context, arg repo.ReserveAuthAttemptParams) (repo.AuthThrottle, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	throttle, ok := q.tables.throttles[arg.Key]
+	switch {
+	case !ok:
+		throttle = repo.AuthThrottle{Key: arg.Key, Failures: 1}
+	case throttle.LockedUntil.Valid && throttle.LockedUntil.Time.After(arg.LastFailureAt):
+		// The upsert's WHERE clause skips locked keys, returning no row
+		return repo.AuthThrottle{}, sql.ErrNoRows
+	case throttle.LastFailureAt.Before(arg.WindowStart):
+		throttle.Failures = 1
+	default:
+		if throttle.Failures >= arg.FreeAttempts {
+			throttle.LockedUntil = arg.HoldUntil
+		}
+		throttle.Failures++
+	}
+	throttle.LastFailureAt = arg.LastFailureAt
+	q.tables.throttles[arg.Key] = throttle
+	q.version++
+
+	return throttle, nil
+}
@@ -0,0 +1,66 @@
+package memdb
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func (q *queries) CreateUserIdentity(ctx context.Context, arg repo.CreateUserIdentityParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tables.users[arg.UserID]; !ok {
+		return 0, ErrForeignKey
+	}
+	key := identityKey{provider: arg.Provider, externalUID: arg.ExternalUid}
+	// ON CONFLICT DO NOTHING
+	if _, ok := q.tables.identities[key]; ok {
+		return 0, nil
+	}
+	q.tables.identities[key] = repo.UserIdentity{
+		Provider:    arg.Provider,
+		ExternalUid: arg.ExternalUid,
+		UserID:      arg.UserID,
+		CreatedAt:   q.timestamp(),
+	}
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) GetUserByIdentity(ctx context.Context, arg repo.GetUserByIdentityParams) (repo.User, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	identity, ok := q.tables.identities[identityKey{provider: arg.Provider, externalUID: arg.ExternalUid}]
+	if !ok {
+		return repo.User{}, sql.ErrNoRows
+	}
+	user, ok := q.tables.users[identity.UserID]
+	if !ok {
+		return repo.User{}, sql.ErrNoRows
+	}
+
+	return user, nil
+}
+
+func (q *queries) ListUserIdentities(ctx context.Context, userID int64) ([]repo.UserIdentity, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	identities := []repo.UserIdentity{}
+	for _, identity := range q.tables.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	slices.SortFunc(identities, func(a, b repo.UserIdentity) int {
+		return cmp.Or(cmp.Compare(a.Provider, b.Provider), cmp.Compare(a.ExternalUid, b.ExternalUid))
+	})
+
+	return identities, nil
+}
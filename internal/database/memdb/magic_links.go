@@ -0,0 +1,63 @@
+package memdb
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func (q *queries) CreateMagicLink(ctx context.Context, arg repo.CreateMagicLinkParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tables.users[arg.UserID]; !ok {
+		return 0, ErrForeignKey
+	}
+	if _, ok := q.tables.magicLinks[arg.TokenHash]; ok {
+		return 0, &ConstraintError{Table: "magic_links", Column: "token_hash"}
+	}
+	q.tables.magicLinks[arg.TokenHash] = repo.MagicLink{
+		TokenHash: arg.TokenHash,
+		UserID:    arg.UserID,
+		ExpiresAt: arg.ExpiresAt,
+		CreatedAt: q.timestamp(),
+	}
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) ConsumeMagicLink(ctx context.Context, arg repo.ConsumeMagicLinkParams) (repo.MagicLink, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	link, ok := q.tables.magicLinks[arg.TokenHash]
+	if !ok || link.UsedAt.Valid || !link.ExpiresAt.After(arg.UsedAt.Time) {
+		return repo.MagicLink{}, sql.ErrNoRows
+	}
+	link.UsedAt = arg.UsedAt
+	q.tables.magicLinks[arg.TokenHash] = link
+	q.version++
+
+	return link, nil
+}
+
+func (q *queries) DeleteExpiredMagicLinks(ctx context.Context, expiresAt time.Time) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var deleted int64
+	for hash, link := range q.tables.magicLinks {
+		if link.ExpiresAt.Before(expiresAt) {
+			delete(q.tables.magicLinks, hash)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		q.version++
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,152 @@
+package memdb
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func (q *queries) UpsertUserTOTP(ctx context.Context, arg repo.UpsertUserTOTPParams) (repo.UserTotp, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tables.users[arg.UserID]; !ok {
+		return repo.UserTotp{}, ErrForeignKey
+	}
+	if existing, ok := q.tables.totp[arg.UserID]; ok && existing.ConfirmedAt.Valid {
+		return repo.UserTotp{}, sql.ErrNoRows
+	}
+	totp := repo.UserTotp{
+		UserID:    arg.UserID,
+		Secret:    arg.Secret,
+		CreatedAt: q.timestamp(),
+	}
+	q.tables.totp[arg.UserID] = totp
+	q.version++
+
+	return totp, nil
+}
+
+func (q *queries) GetUserTOTP(ctx context.Context, userID int64) (repo.UserTotp, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	totp, ok := q.tables.totp[userID]
+	if !ok {
+		return repo.UserTotp{}, sql.ErrNoRows
+	}
+	return totp, nil
+}
+
+func (q *queries) ConfirmUserTOTP(ctx context.Context, arg repo.ConfirmUserTOTPParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	totp, ok := q.tables.totp[arg.UserID]
+	if !ok || totp.ConfirmedAt.Valid {
+		return 0, nil
+	}
+	totp.ConfirmedAt = arg.ConfirmedAt
+	totp.LastUsedStep = arg.LastUsedStep
+	q.tables.totp[arg.UserID] = totp
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) UseTOTPStep(ctx context.Context, arg repo.UseTOTPStepParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	totp, ok := q.tables.totp[arg.UserID]
+	if !ok || !totp.ConfirmedAt.Valid || totp.LastUsedStep >= arg.Step {
+		return 0, nil
+	}
+	totp.LastUsedStep = arg.Step
+	q.tables.totp[arg.UserID] = totp
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) DeleteUserTOTP(ctx context.Context, userID int64) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tables.totp[userID]; !ok {
+		return 0, nil
+	}
+	delete(q.tables.totp, userID)
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) CreateRecoveryCode(ctx context.Context, arg repo.CreateRecoveryCodeParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tables.users[arg.UserID]; !ok {
+		return 0, ErrForeignKey
+	}
+	key := recoveryCodeKey{userID: arg.UserID, codeHash: arg.CodeHash}
+	if _, ok := q.tables.recovery[key]; ok {
+		return 0, &ConstraintError{Table: "user_recovery_codes", Column: "user_id, user_recovery_codes.code_hash"}
+	}
+	q.tables.recovery[key] = repo.UserRecoveryCode{
+		UserID:    arg.UserID,
+		CodeHash:  arg.CodeHash,
+		CreatedAt: q.timestamp(),
+	}
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) UseRecoveryCode(ctx context.Context, arg repo.UseRecoveryCodeParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := recoveryCodeKey{userID: arg.UserID, codeHash: arg.CodeHash}
+	code, ok := q.tables.recovery[key]
+	if !ok || code.UsedAt.Valid {
+		return 0, nil
+	}
+	code.UsedAt = arg.UsedAt
+	q.tables.recovery[key] = code
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) CountUnusedRecoveryCodes(ctx context.Context, userID int64) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var count int64
+	for key, code := range q.tables.recovery {
+		if key.userID == userID && !code.UsedAt.Valid {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (q *queries) DeleteRecoveryCodes(ctx context.Context, userID int64) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var deleted int64
+	for key := range q.tables.recovery {
+		if key.userID == userID {
+			delete(q.tables.recovery, key)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		q.version++
+	}
+
+	return deleted, nil
+}
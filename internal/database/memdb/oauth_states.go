@@ -0,0 +1,61 @@
+package memdb
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func (q *queries) CreateOAuthState(ctx context.Context, arg repo.CreateOAuthStateParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tables.oauth[arg.StateHash]; ok {
+		return 0, &ConstraintError{Table: "oauth_states", Column: "state_hash"}
+	}
+	q.tables.oauth[arg.StateHash] = repo.OauthState{
+		StateHash:    arg.StateHash,
+		Provider:     arg.Provider,
+		Nonce:        arg.Nonce,
+		CodeVerifier: arg.CodeVerifier,
+		ExpiresAt:    arg.ExpiresAt,
+		CreatedAt:    q.timestamp(),
+	}
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) ConsumeOAuthState(ctx context.Context, arg repo.ConsumeOAuthStateParams) (repo.OauthState, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	state, ok := q.tables.oauth[arg.StateHash]
+	if !ok || state.Provider != arg.Provider || !state.ExpiresAt.After(arg.ExpiresAt) {
+		return repo.OauthState{}, sql.ErrNoRows
+	}
+	delete(q.tables.oauth, arg.StateHash)
+	q.version++
+
+	return state, nil
+}
+
+func (q *queries) DeleteExpiredOAuthStates(ctx context.Context, expiresAt time.Time) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var deleted int64
+	for hash, state := range q.tables.oauth {
+		if state.ExpiresAt.Before(expiresAt) {
+			delete(q.tables.oauth, hash)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		q.version++
+	}
+
+	return deleted, nil
+}
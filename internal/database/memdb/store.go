@@ -0,0 +1,212 @@
+// Package memdb provides an in-memory implementation of repo.Store for
+// unit tests. It mimics the behaviour of the SQLite schema that matters to
+// callers: UNIQUE constraint failures, sql.ErrNoRows from :one queries,
+// RETURNING rows with generated ids and timestamps, and transactions that
+// roll back cleanly or conflict with a SQLITE_BUSY style error. It has no
+// full-text index, so searches take the LIKE fallback.
+package memdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/database"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+var (
+	// ErrTxDone is returned when committing or rolling back a finished transaction
+	ErrTxDone = errors.New("memdb: transaction has already been committed or rolled back")
+
+	// ErrBusy is returned when committing a transaction that raced with
+	// another write, matching the text of SQLite's own busy error
+	ErrBusy = errors.New("memdb: database is locked (SQLITE_BUSY)")
+
+	// ErrNoFullTextSearch is returned by SearchUsers, matching the error of a
+	// SQLite database where the optional users_fts migration was skipped
+	ErrNoFullTextSearch = errors.New("memdb: no such table: users_fts")
+
+	// ErrForeignKey mimics SQLite's error for a row referencing a missing parent
+	ErrForeignKey = errors.New("memdb: FOREIGN KEY constraint failed")
+)
+
+// ConstraintError mimics the error SQLite returns when a UNIQUE constraint fails
+type ConstraintError struct {
+	Table  string
+	Column string
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("UNIQUE constraint failed: %s.%s", e.Table, e.Column)
+}
+
+// Store is an in-memory database. The zero value is not usable; call New.
+type Store struct {
+	*queries
+}
+
+// New creates an empty Store whose generated timestamps come from the wall clock
+func New() *Store {
+	return NewWithClock(time.Now)
+}
+
+// NewWithClock creates an empty Store whose generated timestamps come from now
+func NewWithClock(now func() time.Time) *Store {
+	return &Store{
+		queries: &queries{
+			tables: newTables(),
+			now:    now,
+		},
+	}
+}
+
+// BeginTx starts a transaction that works on a private copy of the data.
+// Commit fails with ErrBusy if the store was written to in the meantime.
+func (s *Store) BeginTx(ctx context.Context) (database.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &tx{
+		store:   s,
+		base:    s.version,
+		queries: &queries{tables: s.tables.clone(), now: s.now},
+	}, nil
+}
+
+type tx struct {
+	store   *Store
+	base    uint64
+	queries *queries
+	done    bool
+}
+
+func (t *tx) Queries() repo.Store {
+	return t.queries
+}
+
+func (t *tx) Commit() error {
+	if t.done {
+		return ErrTxDone
+	}
+	t.done = true
+
+	t.queries.mu.Lock()
+	defer t.queries.mu.Unlock()
+	if t.queries.version == 0 {
+		return nil
+	}
+
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	if t.store.version != t.base {
+		return ErrBusy
+	}
+	t.store.tables = t.queries.tables
+	t.store.version++
+
+	return nil
+}
+
+func (t *tx) Rollback() error {
+	if t.done {
+		return ErrTxDone
+	}
+	t.done = true
+	return nil
+}
+
+// queries holds one copy of the data. Every mutation bumps version so
+// transactions can detect concurrent writes when they commit.
+type queries struct {
+	mu      sync.Mutex
+	tables  *tables
+	version uint64
+	now     func() time.Time
+}
+
+// timestamp mimics CURRENT_TIMESTAMP, which has second precision in UTC
+func (q *queries) timestamp() time.Time {
+	return q.now().UTC().Truncate(time.Second)
+}
+
+// tables holds a copy of every table in the schema
+type tables struct {
+	users      map[int64]repo.User
+	nextUserID int64
+	passwords  map[int64]repo.UserPassword
+	sessions   map[string]repo.Session
+	apiKeys    map[int64]repo.ApiKey
+	nextKeyID  int64
+	identities map[identityKey]repo.UserIdentity
+	roles      map[roleKey]repo.UserRole
+	throttles  map[string]repo.AuthThrottle
+	totp       map[int64]repo.UserTotp
+	recovery   map[recoveryCodeKey]repo.UserRecoveryCode
+	magicLinks map[string]repo.MagicLink
+	oauth      map[string]repo.OauthState
+}
+
+// identityKey is the primary key of user_identities
+type identityKey struct {
+	provider    string
+	externalUID string
+}
+
+// roleKey is the primary key of user_roles
+type roleKey struct {
+	userID int64
+	role   string
+}
+
+// recoveryCodeKey is the primary key of user_recovery_codes
+type recoveryCodeKey struct {
+	userID   int64
+	codeHash string
+}
+
+func newTables() *tables {
+	return &tables{
+		users:      make(map[int64]repo.User),
+		nextUserID: 1,
+		passwords:  make(map[int64]repo.UserPassword),
+		sessions:   make(map[string]repo.Session),
+		apiKeys:    make(map[int64]repo.ApiKey),
+		nextKeyID:  1,
+		identities: make(map[identityKey]repo.UserIdentity),
+		roles:      make(map[roleKey]repo.UserRole),
+		throttles:  make(map[string]repo.AuthThrottle),
+		totp:       make(map[int64]repo.UserTotp),
+		recovery:   make(map[recoveryCodeKey]repo.UserRecoveryCode),
+		magicLinks: make(map[string]repo.MagicLink),
+		oauth:      make(map[string]repo.OauthState),
+	}
+}
+
+func (t *tables) clone() *tables {
+	c := *t
+	c.users = maps.Clone(t.users)
+	c.passwords = maps.Clone(t.passwords)
+	c.sessions = maps.Clone(t.sessions)
+	c.apiKeys = maps.Clone(t.apiKeys)
+	c.identities = maps.Clone(t.identities)
+	c.roles = maps.Clone(t.roles)
+	c.throttles = maps.Clone(t.throttles)
+	c.totp = maps.Clone(t.totp)
+	c.recovery = maps.Clone(t.recovery)
+	c.magicLinks = maps.Clone(t.magicLinks)
+	c.oauth = maps.Clone(t.oauth)
+	return &c
+}
+
+var (
+	_ repo.Store         = (*Store)(nil)
+	_ database.TxStarter = (*Store)(nil)
+)
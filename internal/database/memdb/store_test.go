@@ -0,0 +1,131 @@
+package memdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/database"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func TestStoreMimicsUniqueConstraints(t *testing.T) {
+	ctx := context.Background()
+	store := New()
+
+	if _, err := store.CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	_, err := store.CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "other@example.com"})
+	var constraintErr *ConstraintError
+	if !errors.As(err, &constraintErr) || constraintErr.Column != "username" {
+		t.Fatalf("err = %v, want username constraint error", err)
+	}
+	if err.Error() != "UNIQUE constraint failed: users.username" {
+		t.Fatalf("err = %q, want SQLite constraint message", err.Error())
+	}
+
+	_, err = store.CreateUser(ctx, repo.CreateUserParams{Username: "anna", Email: "marc@example.com"})
+	if !errors.As(err, &constraintErr) || constraintErr.Column != "email" {
+		t.Fatalf("err = %v, want email constraint error", err)
+	}
+}
+
+func TestStoreReturnsGeneratedRowsAndErrNoRows(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 2, 3, 4, 5, 600, time.UTC)
+	store := NewWithClock(func() time.Time { return now })
+
+	first, err := store.CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	second, err := store.CreateUser(ctx, repo.CreateUserParams{Username: "anna", Email: "anna@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if first.ID != 1 || second.ID != 2 {
+		t.Fatalf("ids = %d, %d, want 1, 2", first.ID, second.ID)
+	}
+	if !first.CreatedAt.Equal(now.Truncate(time.Second)) {
+		t.Fatalf("CreatedAt = %v, want %v", first.CreatedAt, now.Truncate(time.Second))
+	}
+
+	if _, err := store.GetUser(ctx, 99); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("get err = %v, want %v", err, sql.ErrNoRows)
+	}
+	if _, err := store.UpdateUser(ctx, repo.UpdateUserParams{ID: 99, Username: "x", Email: "x@example.com"}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("update err = %v, want %v", err, sql.ErrNoRows)
+	}
+
+	users, err := store.ListUsersFiltered(ctx, repo.ListUsersFilteredParams{After: &repo.UserKey{ID: 1}, Limit: 5})
+	if err != nil {
+		t.Fatalf("list users: %v", err)
+	}
+	if len(users) != 1 || users[0].ID != 2 {
+		t.Fatalf("users = %#v, want only id 2", users)
+	}
+}
+
+func TestStoreTransactionsCommitAndRollBack(t *testing.T) {
+	ctx := context.Background()
+	store := New()
+
+	tx, err := store.BeginTx(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if _, err := tx.Queries().CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"}); err != nil {
+		t.Fatalf("create in tx: %v", err)
+	}
+	if _, err := store.GetUser(ctx, 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal("uncommitted row is visible outside the transaction")
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if _, err := store.GetUser(ctx, 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal("rolled back row is visible")
+	}
+
+	tx, err = store.BeginTx(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if _, err := tx.Queries().CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"}); err != nil {
+		t.Fatalf("create in tx: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if _, err := store.GetUser(ctx, 1); err != nil {
+		t.Fatalf("committed row is not visible: %v", err)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Fatalf("second commit err = %v, want %v", err, ErrTxDone)
+	}
+}
+
+func TestStoreConflictingCommitIsBusy(t *testing.T) {
+	ctx := context.Background()
+	store := New()
+
+	tx, err := store.BeginTx(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if _, err := tx.Queries().CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"}); err != nil {
+		t.Fatalf("create in tx: %v", err)
+	}
+	if _, err := store.CreateUser(ctx, repo.CreateUserParams{Username: "anna", Email: "anna@example.com"}); err != nil {
+		t.Fatalf("create outside tx: %v", err)
+	}
+
+	err = tx.Commit()
+	if !errors.Is(err, ErrBusy) || !database.IsBusyError(err) {
+		t.Fatalf("err = %v, want busy error", err)
+	}
+}
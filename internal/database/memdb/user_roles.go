@@ -0,0 +1,55 @@
+package memdb
+
+import (
+	"context"
+	"slices"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func (q *queries) ListUserRoles(ctx context.Context, userID int64) ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	roles := []string{}
+	for key := range q.tables.roles {
+		if key.userID == userID {
+			roles = append(roles, key.role)
+		}
+	}
+	slices.Sort(roles)
+
+	return roles, nil
+}
+
+func (q *queries) GrantUserRole(ctx context.Context, arg repo.GrantUserRoleParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tables.users[arg.UserID]; !ok {
+		return 0, ErrForeignKey
+	}
+	key := roleKey{userID: arg.UserID, role: arg.Role}
+	// ON CONFLICT DO NOTHING
+	if _, ok := q.tables.roles[key]; ok {
+		return 0, nil
+	}
+	q.tables.roles[key] = repo.UserRole{UserID: arg.UserID, Role: arg.Role, CreatedAt: q.timestamp()}
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) RevokeUserRole(ctx context.Context, arg repo.RevokeUserRoleParams) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := roleKey{userID: arg.UserID, role: arg.Role}
+	if _, ok := q.tables.roles[key]; !ok {
+		return 0, nil
+	}
+	delete(q.tables.roles, key)
+	q.version++
+
+	return 1, nil
+}
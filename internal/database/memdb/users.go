@@ -0,0 +1,137 @@
+package memdb
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"maps"
+	"slices"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func (q *queries) CreateUser(ctx context.Context, arg repo.CreateUserParams) (repo.User, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.checkUserUnique(0, arg.Username, arg.Email); err != nil {
+		return repo.User{}, err
+	}
+
+	now := q.timestamp()
+	user := repo.User{
+		ID:        q.tables.nextUserID,
+		Username:  arg.Username,
+		Email:     arg.Email,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	q.tables.users[user.ID] = user
+	q.tables.nextUserID++
+	q.version++
+
+	return user, nil
+}
+
+func (q *queries) DeleteUser(ctx context.Context, id int64) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tables.users[id]; !ok {
+		return 0, nil
+	}
+	delete(q.tables.users, id)
+	// user_passwords, user_identities, user_roles, user_totp,
+	// user_recovery_codes and magic_links reference users ON DELETE CASCADE
+	delete(q.tables.passwords, id)
+	maps.DeleteFunc(q.tables.identities, func(_ identityKey, identity repo.UserIdentity) bool {
+		return identity.UserID == id
+	})
+	maps.DeleteFunc(q.tables.roles, func(key roleKey, _ repo.UserRole) bool {
+		return key.userID == id
+	})
+	delete(q.tables.totp, id)
+	maps.DeleteFunc(q.tables.recovery, func(key recoveryCodeKey, _ repo.UserRecoveryCode) bool {
+		return key.userID == id
+	})
+	maps.DeleteFunc(q.tables.magicLinks, func(_ string, link repo.MagicLink) bool {
+		return link.UserID == id
+	})
+	q.version++
+
+	return 1, nil
+}
+
+func (q *queries) GetUser(ctx context.Context, id int64) (repo.User, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	user, ok := q.tables.users[id]
+	if !ok {
+		return repo.User{}, sql.ErrNoRows
+	}
+
+	return user, nil
+}
+
+func (q *queries) GetUserByEmail(ctx context.Context, email string) (repo.User, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, user := range q.tables.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+
+	return repo.User{}, sql.ErrNoRows
+}
+
+func (q *queries) UpdateUser(ctx context.Context, arg repo.UpdateUserParams) (repo.User, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	user, ok := q.tables.users[arg.ID]
+	if !ok {
+		return repo.User{}, sql.ErrNoRows
+	}
+	if err := q.checkUserUnique(arg.ID, arg.Username, arg.Email); err != nil {
+		return repo.User{}, err
+	}
+
+	user.Username = arg.Username
+	user.Email = arg.Email
+	user.UpdatedAt = q.timestamp()
+	q.tables.users[arg.ID] = user
+	q.version++
+
+	return user, nil
+}
+
+// checkUserUnique enforces the UNIQUE constraints on users, ignoring the row being updated
+func (q *queries) checkUserUnique(id int64, username, email string) error {
+	for _, user := range q.tables.users {
+		if user.ID == id {
+			continue
+		}
+		if user.Username == username {
+			return &ConstraintError{Table: "users", Column: "username"}
+		}
+		if user.Email == email {
+			return &ConstraintError{Table: "users", Column: "email"}
+		}
+	}
+	return nil
+}
+
+// sortedUsers returns every user ordered by id
+func (q *queries) sortedUsers() []repo.User {
+	users := make([]repo.User, 0, len(q.tables.users))
+	for _, user := range q.tables.users {
+		users = append(users, user)
+	}
+	slices.SortFunc(users, func(a, b repo.User) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return users
+}
@@ -0,0 +1,132 @@
+package memdb
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func (q *queries) ListUsersFiltered(ctx context.Context, arg repo.ListUsersFilteredParams) ([]repo.User, error) {
+	column := arg.SortColumn
+	if column == "" {
+		column = repo.UserSortID
+	}
+	if _, err := userSortValue(repo.User{}, column); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	compare := func(a, b repo.User) int {
+		av, _ := userSortValue(a, column)
+		bv, _ := userSortValue(b, column)
+		c := compareValues(av, bv)
+		if c == 0 {
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		if arg.Descending {
+			c = -c
+		}
+		return c
+	}
+
+	users := q.filterUsers(arg.Filter)
+	slices.SortFunc(users, compare)
+
+	if arg.After != nil {
+		users = slices.DeleteFunc(users, func(u repo.User) bool {
+			v, _ := userSortValue(u, column)
+			c := compareValues(v, arg.After.Value)
+			if c == 0 || column == repo.UserSortID {
+				c = cmp.Compare(u.ID, arg.After.ID)
+			}
+			if arg.Descending {
+				c = -c
+			}
+			return c <= 0
+		})
+	}
+
+	users = users[min(max(arg.Offset, 0), int64(len(users))):]
+	if arg.Limit >= 0 && arg.Limit < int64(len(users)) {
+		users = users[:arg.Limit]
+	}
+	return users, nil
+}
+
+func (q *queries) CountUsersFiltered(ctx context.Context, arg repo.UserFilter) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return int64(len(q.filterUsers(arg))), nil
+}
+
+// filterUsers returns the users matching f, mimicking LIKE's ASCII case
+// folding for prefixes and the second precision of stored timestamps
+func (q *queries) filterUsers(f repo.UserFilter) []repo.User {
+	var users []repo.User
+	for _, u := range q.sortedUsers() {
+		if f.UsernamePrefix != "" && !hasPrefixFold(u.Username, f.UsernamePrefix) {
+			continue
+		}
+		if f.EmailPrefix != "" && !hasPrefixFold(u.Email, f.EmailPrefix) {
+			continue
+		}
+		if !inRange(u.CreatedAt, f.CreatedAfter, f.CreatedBefore) ||
+			!inRange(u.UpdatedAt, f.UpdatedAfter, f.UpdatedBefore) {
+			continue
+		}
+		users = append(users, u)
+	}
+	return users
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func inRange(t, after, before time.Time) bool {
+	t = t.UTC().Truncate(time.Second)
+	if !after.IsZero() && t.Before(after.UTC().Truncate(time.Second)) {
+		return false
+	}
+	if !before.IsZero() && !t.Before(before.UTC().Truncate(time.Second)) {
+		return false
+	}
+	return true
+}
+
+// userSortValue returns the value of column in the form it is compared in SQL
+func userSortValue(u repo.User, column string) (interface{}, error) {
+	switch column {
+	case repo.UserSortID:
+		return u.ID, nil
+	case repo.UserSortUsername:
+		return u.Username, nil
+	case repo.UserSortEmail:
+		return u.Email, nil
+	case repo.UserSortCreatedAt:
+		return u.CreatedAt.UTC().Format(repo.TimestampLayout), nil
+	case repo.UserSortUpdatedAt:
+		return u.UpdatedAt.UTC().Format(repo.TimestampLayout), nil
+	}
+	return nil, fmt.Errorf("repo: cannot sort users by %q", column)
+}
+
+func compareValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case int64:
+		bv, _ := b.(int64)
+		return cmp.Compare(av, bv)
+	case string:
+		bv, _ := b.(string)
+		return strings.Compare(av, bv)
+	}
+	return 0
+}
@@ -0,0 +1,117 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+// TestListUsersFilteredMatchesSQLite runs the same listings against the
+// hand-written SQL and the in-memory store to keep the fake honest
+func TestListUsersFilteredMatchesSQLite(t *testing.T) {
+	ctx := context.Background()
+	sqlite := newSQLiteQueries(t)
+	store := New()
+
+	for _, u := range []repo.CreateUserParams{
+		{Username: "marc", Email: "marc@example.com"},
+		{Username: "Maria", Email: "maria@test.org"},
+		{Username: "anna", Email: "anna@example.com"},
+		{Username: "ma_x", Email: "max@test.org"},
+		{Username: "bob", Email: "bob@example.com"},
+	} {
+		if _, err := sqlite.CreateUser(ctx, u); err != nil {
+			t.Fatalf("create sqlite user: %v", err)
+		}
+		if _, err := store.CreateUser(ctx, u); err != nil {
+			t.Fatalf("create memdb user: %v", err)
+		}
+	}
+
+	tests := map[string]repo.ListUsersFilteredParams{
+		"default order":         {Limit: 10},
+		"username prefix fold":  {Filter: repo.UserFilter{UsernamePrefix: "MA"}, Limit: 10},
+		"literal underscore":    {Filter: repo.UserFilter{UsernamePrefix: "ma_"}, Limit: 10},
+		"email prefix":          {Filter: repo.UserFilter{EmailPrefix: "ma"}, Limit: 10},
+		"sort username":         {SortColumn: repo.UserSortUsername, Limit: 10},
+		"sort email desc":       {SortColumn: repo.UserSortEmail, Descending: true, Limit: 10},
+		"after username":        {SortColumn: repo.UserSortUsername, After: &repo.UserKey{Value: "bob", ID: 5}, Limit: 10},
+		"after id desc":         {Descending: true, After: &repo.UserKey{ID: 4}, Limit: 2},
+		"created before future": {Filter: repo.UserFilter{CreatedBefore: time.Now().Add(time.Hour)}, Limit: 10},
+		"created after future":  {Filter: repo.UserFilter{CreatedAfter: time.Now().Add(time.Hour)}, Limit: 10},
+		"sort created at":       {SortColumn: repo.UserSortCreatedAt, Descending: true, Limit: 3},
+		"offset":                {SortColumn: repo.UserSortUsername, Limit: 2, Offset: 2},
+		"offset past end":       {Limit: 10, Offset: 10},
+	}
+
+	for name, params := range tests {
+		t.Run(name, func(t *testing.T) {
+			want, err := sqlite.ListUsersFiltered(ctx, params)
+			if err != nil {
+				t.Fatalf("sqlite: %v", err)
+			}
+			got, err := store.ListUsersFiltered(ctx, params)
+			if err != nil {
+				t.Fatalf("memdb: %v", err)
+			}
+			if ids(got) != ids(want) {
+				t.Fatalf("memdb ids = %v, sqlite ids = %v", ids(got), ids(want))
+			}
+
+			wantCount, err := sqlite.CountUsersFiltered(ctx, params.Filter)
+			if err != nil {
+				t.Fatalf("sqlite count: %v", err)
+			}
+			gotCount, err := store.CountUsersFiltered(ctx, params.Filter)
+			if err != nil {
+				t.Fatalf("memdb count: %v", err)
+			}
+			if gotCount != wantCount {
+				t.Fatalf("memdb count = %d, sqlite count = %d", gotCount, wantCount)
+			}
+		})
+	}
+}
+
+func TestListUsersFilteredRejectsUnknownSortColumn(t *testing.T) {
+	ctx := context.Background()
+	params := repo.ListUsersFilteredParams{SortColumn: "password; DROP TABLE users", Limit: 1}
+
+	if _, err := newSQLiteQueries(t).ListUsersFiltered(ctx, params); err == nil {
+		t.Fatal("sqlite: expected error, got nil")
+	}
+	if _, err := New().ListUsersFiltered(ctx, params); err == nil {
+		t.Fatal("memdb: expected error, got nil")
+	}
+}
+
+func newSQLiteQueries(t *testing.T) *repo.Queries {
+	t.Helper()
+
+	db, err := database.GetConnection(config.Database{Mode: config.DatabaseModeMemory})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := database.Migrate(context.Background(), db, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return repo.New(db)
+}
+
+func ids(users []repo.User) string {
+	out := make([]int64, len(users))
+	for i, u := range users {
+		out[i] = u.ID
+	}
+	return fmt.Sprint(out)
+}
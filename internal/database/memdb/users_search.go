@@ -0,0 +1,70 @@
+package memdb
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func (q *queries) SearchUsers(ctx context.Context, arg repo.SearchUsersParams) ([]repo.UserSearchRow, error) {
+	return nil, ErrNoFullTextSearch
+}
+
+func (q *queries) SearchUsersLike(ctx context.Context, arg repo.SearchUsersLikeParams) ([]repo.User, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var users []repo.User
+	for _, u := range q.sortedUsers() {
+		matches := true
+		for _, term := range arg.Terms {
+			if !containsFold(u.Username, term) && !containsFold(u.Email, term) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			users = append(users, u)
+		}
+	}
+
+	prefixed := func(u repo.User) bool {
+		return len(arg.Terms) > 0 && hasPrefixFold(u.Username, arg.Terms[0])
+	}
+	slices.SortStableFunc(users, func(a, b repo.User) int {
+		if pa, pb := prefixed(a), prefixed(b); pa != pb {
+			if pa {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(a.Username, b.Username); c != 0 {
+			return c
+		}
+		return int(a.ID - b.ID)
+	})
+
+	if arg.Limit >= 0 && arg.Limit < int64(len(users)) {
+		users = users[:arg.Limit]
+	}
+	if users == nil {
+		users = []repo.User{}
+	}
+	return users, nil
+}
+
+// containsFold mimics LIKE '%sub%', which folds ASCII letters only
+func containsFold(s, sub string) bool {
+	return strings.Contains(asciiLower(s), asciiLower(sub))
+}
+
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, s)
+}
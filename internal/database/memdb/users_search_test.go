@@ -0,0 +1,64 @@
+package memdb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mhpenta/starterA/internal/database"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+// TestSearchUsersLikeMatchesSQLite keeps the LIKE fallback in the fake in
+// step with the hand-written SQL
+func TestSearchUsersLikeMatchesSQLite(t *testing.T) {
+	ctx := context.Background()
+	sqlite := newSQLiteQueries(t)
+	store := New()
+
+	for _, u := range []repo.CreateUserParams{
+		{Username: "marc", Email: "marc@example.com"},
+		{Username: "Maria", Email: "maria@test.org"},
+		{Username: "anna", Email: "anna.marc@example.com"},
+		{Username: "ma_x", Email: "max@test.org"},
+		{Username: "bob", Email: "bob@example.com"},
+	} {
+		if _, err := sqlite.CreateUser(ctx, u); err != nil {
+			t.Fatalf("create sqlite user: %v", err)
+		}
+		if _, err := store.CreateUser(ctx, u); err != nil {
+			t.Fatalf("create memdb user: %v", err)
+		}
+	}
+
+	tests := map[string]repo.SearchUsersLikeParams{
+		"username and email": {Terms: []string{"marc"}, Limit: 10},
+		"case folding":       {Terms: []string{"MA"}, Limit: 10},
+		"literal underscore": {Terms: []string{"a_"}, Limit: 10},
+		"every term":         {Terms: []string{"ma", "test"}, Limit: 10},
+		"limit":              {Terms: []string{"example"}, Limit: 2},
+		"no match":           {Terms: []string{"zed"}, Limit: 10},
+	}
+
+	for name, params := range tests {
+		t.Run(name, func(t *testing.T) {
+			want, err := sqlite.SearchUsersLike(ctx, params)
+			if err != nil {
+				t.Fatalf("sqlite: %v", err)
+			}
+			got, err := store.SearchUsersLike(ctx, params)
+			if err != nil {
+				t.Fatalf("memdb: %v", err)
+			}
+			if ids(got) != ids(want) {
+				t.Fatalf("memdb ids = %v, sqlite ids = %v", ids(got), ids(want))
+			}
+		})
+	}
+}
+
+func TestSearchUsersReportsMissingIndex(t *testing.T) {
+	_, err := New().SearchUsers(context.Background(), repo.SearchUsersParams{Match: "marc", Limit: 10})
+	if !database.IsFTSUnavailable(err) {
+		t.Fatalf("err = %v, want full-text search to be unavailable", err)
+	}
+}
@@ -0,0 +1,352 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed schema/*.sql
+var schemaFS embed.FS
+
+const (
+	// migrationUpMarker starts the section applied when migrating up.
+	migrationUpMarker = "-- +goose Up"
+
+	// migrationDownMarker starts the section applied when rolling back.
+	// sqlc ignores everything after this marker when reading the schema.
+	migrationDownMarker = "-- +goose Down"
+
+	// migrationOptionalMarker marks a migration whose failure is logged and
+	// skipped rather than aborting startup. It is retried on every run until
+	// it succeeds, so it must not be depended on by later migrations.
+	migrationOptionalMarker = "-- +optional"
+)
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  checksum TEXT NOT NULL,
+  applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+var (
+	ErrMigrationChecksumMismatch = errors.New("database: applied migration has been modified")
+	ErrUnknownMigration          = errors.New("database: applied migration is not known to this binary")
+	ErrMissingDownMigration      = errors.New("database: migration has no down section")
+)
+
+// Migration is a single versioned schema change loaded from a SQL file
+// named <version>_<name>.sql
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+	Optional bool
+}
+
+// AppliedMigration is a row recorded in the schema_migrations table
+type AppliedMigration struct {
+	Version  int64
+	Name     string
+	Checksum string
+}
+
+// Migrations returns the schema migrations embedded in the binary,
+// ordered by version
+func Migrations() ([]Migration, error) {
+	return LoadMigrations(schemaFS, "schema")
+}
+
+// LoadMigrations reads every .sql file in dir and parses it into a Migration,
+// ordered by version
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations directory: %w", err)
+	}
+
+	var migrations []Migration
+	seen := make(map[int64]string)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		contents, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", entry.Name(), err)
+		}
+
+		migration, err := parseMigration(entry.Name(), contents)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := seen[migration.Version]; ok {
+			return nil, fmt.Errorf("duplicate migration version %d in %s and %s", migration.Version, other, entry.Name())
+		}
+		seen[migration.Version] = entry.Name()
+
+		migrations = append(migrations, migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// parseMigration splits a migration file into its up and down sections.
+// A file without an up marker is treated as entirely up.
+func parseMigration(filename string, contents []byte) (Migration, error) {
+	base := strings.TrimSuffix(filename, ".sql")
+	versionStr, name, ok := strings.Cut(base, "_")
+	if !ok || name == "" {
+		return Migration{}, fmt.Errorf("invalid migration filename %q: want <version>_<name>.sql", filename)
+	}
+	version, err := strconv.ParseInt(versionStr, 10, 64)
+	if err != nil || version <= 0 {
+		return Migration{}, fmt.Errorf("invalid migration filename %q: version must be a positive integer", filename)
+	}
+
+	var up, down strings.Builder
+	var optional bool
+	current := &up
+	scanner := bufio.NewScanner(strings.NewReader(string(contents)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch strings.TrimSpace(line) {
+		case migrationUpMarker:
+			current = &up
+			continue
+		case migrationDownMarker:
+			current = &down
+			continue
+		case migrationOptionalMarker:
+			optional = true
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return Migration{}, fmt.Errorf("error parsing migration %s: %w", filename, err)
+	}
+
+	sum := sha256.Sum256(contents)
+
+	return Migration{
+		Version:  version,
+		Name:     name,
+		Up:       strings.TrimSpace(up.String()),
+		Down:     strings.TrimSpace(down.String()),
+		Checksum: hex.EncodeToString(sum[:]),
+		Optional: optional,
+	}, nil
+}
+
+// Migrate applies all pending embedded migrations to the database.
+// It refuses to run if a previously applied migration has been edited
+// or is no longer present in the binary.
+func Migrate(ctx context.Context, db *sql.DB, logger *slog.Logger) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	return NewMigrator(db, migrations, logger).Up(ctx)
+}
+
+// VerifyMigrations checks that every applied migration matches the
+// embedded migration of the same version without applying anything
+func VerifyMigrations(ctx context.Context, db *sql.DB, logger *slog.Logger) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	return NewMigrator(db, migrations, logger).Verify(ctx)
+}
+
+// Migrator applies and rolls back a fixed set of migrations,
+// tracking progress in the schema_migrations table
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	logger     *slog.Logger
+}
+
+// NewMigrator creates a Migrator for the given migrations
+func NewMigrator(db *sql.DB, migrations []Migration, logger *slog.Logger) *Migrator {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+		logger:     logger,
+	}
+}
+
+// Applied returns the migrations recorded in the schema_migrations table,
+// ordered by version
+func (m *Migrator) Applied(ctx context.Context) ([]AppliedMigration, error) {
+	if _, err := m.db.ExecContext(ctx, createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+
+	rows, err := m.db.QueryContext(ctx, "SELECT version, name, checksum FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	var applied []AppliedMigration
+	for rows.Next() {
+		var a AppliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum); err != nil {
+			return nil, fmt.Errorf("error scanning schema_migrations: %w", err)
+		}
+		applied = append(applied, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading schema_migrations: %w", err)
+	}
+
+	return applied, nil
+}
+
+// Verify checks that every applied migration is known and unmodified
+func (m *Migrator) Verify(ctx context.Context) error {
+	_, err := m.verify(ctx)
+	return err
+}
+
+func (m *Migrator) verify(ctx context.Context) (map[int64]bool, error) {
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int64]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	done := make(map[int64]bool, len(applied))
+	for _, a := range applied {
+		migration, ok := known[a.Version]
+		if !ok {
+			return nil, fmt.Errorf("%w: version %d (%s)", ErrUnknownMigration, a.Version, a.Name)
+		}
+		if migration.Checksum != a.Checksum {
+			return nil, fmt.Errorf("%w: version %d (%s)", ErrMigrationChecksumMismatch, a.Version, a.Name)
+		}
+		done[a.Version] = true
+	}
+
+	return done, nil
+}
+
+// Up applies every migration that has not been applied yet, in version order.
+// Each migration runs in its own transaction.
+func (m *Migrator) Up(ctx context.Context) error {
+	done, err := m.verify(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if done[migration.Version] {
+			continue
+		}
+
+		m.logger.Info("Applying migration", "version", migration.Version, "name", migration.Name)
+
+		err := m.inTx(ctx, func(tx *sql.Tx) error {
+			if migration.Up != "" {
+				if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)",
+				migration.Version, migration.Name, migration.Checksum)
+			return err
+		})
+		if err != nil {
+			if migration.Optional {
+				m.logger.Warn("Skipping optional migration", "version", migration.Version, "name", migration.Name, "error", err)
+				continue
+			}
+			return fmt.Errorf("error applying migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Down rolls back the given number of most recently applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	done, err := m.verify(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+		migration := m.migrations[i]
+		if !done[migration.Version] {
+			continue
+		}
+		if migration.Down == "" {
+			return fmt.Errorf("%w: version %d (%s)", ErrMissingDownMigration, migration.Version, migration.Name)
+		}
+
+		m.logger.Info("Rolling back migration", "version", migration.Version, "name", migration.Name)
+
+		err := m.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error rolling back migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		steps--
+	}
+
+	return nil
+}
+
+func (m *Migrator) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Join(err, fmt.Errorf("error rolling back: %w", rbErr))
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
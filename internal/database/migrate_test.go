@@ -0,0 +1,275 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mhpenta/starterA/internal/config"
+)
+
+func TestLoadMigrationsParsesUpAndDownSections(t *testing.T) {
+	fsys := fstest.MapFS{
+		"schema/002_posts.sql": {Data: []byte("-- +goose Up\nCREATE TABLE posts (id INTEGER);\n\n-- +goose Down\nDROP TABLE posts;\n")},
+		"schema/001_users.sql": {Data: []byte("CREATE TABLE users (id INTEGER);\n")},
+		"schema/README.md":     {Data: []byte("not a migration")},
+	}
+
+	migrations, err := LoadMigrations(fsys, "schema")
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("len(migrations) = %d, want 2", len(migrations))
+	}
+
+	users, posts := migrations[0], migrations[1]
+	if users.Version != 1 || users.Name != "users" {
+		t.Fatalf("first migration = %d %q, want 1 users", users.Version, users.Name)
+	}
+	if users.Up != "CREATE TABLE users (id INTEGER);" || users.Down != "" {
+		t.Fatalf("users migration up = %q down = %q", users.Up, users.Down)
+	}
+	if posts.Up != "CREATE TABLE posts (id INTEGER);" {
+		t.Fatalf("posts up = %q", posts.Up)
+	}
+	if posts.Down != "DROP TABLE posts;" {
+		t.Fatalf("posts down = %q", posts.Down)
+	}
+	if users.Checksum == "" || users.Checksum == posts.Checksum {
+		t.Fatalf("unexpected checksums %q and %q", users.Checksum, posts.Checksum)
+	}
+}
+
+func TestLoadMigrationsRejectsBadFilenames(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"no name":   {"schema/001.sql": {Data: []byte("SELECT 1;")}},
+		"no number": {"schema/abc_users.sql": {Data: []byte("SELECT 1;")}},
+		"duplicate": {
+			"schema/001_users.sql": {Data: []byte("SELECT 1;")},
+			"schema/1_posts.sql":   {Data: []byte("SELECT 1;")},
+		},
+	}
+
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadMigrations(fsys, "schema"); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrationsApplyAndRollBack(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	if err := Migrate(ctx, db, discardLogger()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	// Applying twice is a no-op
+	if err := Migrate(ctx, db, discardLogger()); err != nil {
+		t.Fatalf("migrate again: %v", err)
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("load embedded migrations: %v", err)
+	}
+	migrator := NewMigrator(db, migrations, discardLogger())
+
+	applied, err := migrator.Applied(ctx)
+	if err != nil {
+		t.Fatalf("applied: %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+
+	if _, err := db.ExecContext(ctx, "INSERT INTO users (username, email) VALUES ('marc', 'marc@example.com')"); err != nil {
+		t.Fatalf("insert into migrated schema: %v", err)
+	}
+
+	if err := migrator.Down(ctx, len(migrations)); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	applied, err = migrator.Applied(ctx)
+	if err != nil {
+		t.Fatalf("applied after down: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Fatalf("applied %d migrations after rollback, want 0", len(applied))
+	}
+	if _, err := db.ExecContext(ctx, "SELECT 1 FROM users"); err == nil {
+		t.Fatal("expected users table to be dropped")
+	}
+}
+
+func TestMigratorRefusesEditedMigration(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	original := fstest.MapFS{
+		"schema/001_widgets.sql": {Data: []byte("CREATE TABLE widgets (id INTEGER);")},
+	}
+	edited := fstest.MapFS{
+		"schema/001_widgets.sql": {Data: []byte("CREATE TABLE widgets (id INTEGER, name TEXT);")},
+	}
+
+	if err := newTestMigrator(t, db, original).Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	err := newTestMigrator(t, db, edited).Up(ctx)
+	if !errors.Is(err, ErrMigrationChecksumMismatch) {
+		t.Fatalf("err = %v, want %v", err, ErrMigrationChecksumMismatch)
+	}
+}
+
+func TestMigratorRefusesUnknownAppliedMigration(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	newer := fstest.MapFS{
+		"schema/001_widgets.sql": {Data: []byte("CREATE TABLE widgets (id INTEGER);")},
+		"schema/002_gadgets.sql": {Data: []byte("CREATE TABLE gadgets (id INTEGER);")},
+	}
+	older := fstest.MapFS{
+		"schema/001_widgets.sql": {Data: []byte("CREATE TABLE widgets (id INTEGER);")},
+	}
+
+	if err := newTestMigrator(t, db, newer).Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	err := newTestMigrator(t, db, older).Verify(ctx)
+	if !errors.Is(err, ErrUnknownMigration) {
+		t.Fatalf("err = %v, want %v", err, ErrUnknownMigration)
+	}
+}
+
+func TestMigratorRollsBackFailedMigration(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	fsys := fstest.MapFS{
+		"schema/001_widgets.sql": {Data: []byte("CREATE TABLE widgets (id INTEGER);\nINSERT INTO missing VALUES (1);")},
+	}
+
+	migrator := newTestMigrator(t, db, fsys)
+	if err := migrator.Up(ctx); err == nil {
+		t.Fatal("expected migration error, got nil")
+	}
+
+	applied, err := migrator.Applied(ctx)
+	if err != nil {
+		t.Fatalf("applied: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Fatalf("applied %d migrations, want 0", len(applied))
+	}
+	if _, err := db.ExecContext(ctx, "SELECT 1 FROM widgets"); err == nil {
+		t.Fatal("expected widgets table creation to be rolled back")
+	}
+}
+
+func TestMigratorSkipsFailedOptionalMigration(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	fsys := fstest.MapFS{
+		"schema/001_widgets.sql": {Data: []byte("CREATE TABLE widgets (id INTEGER);")},
+		"schema/002_search.sql":  {Data: []byte("-- +goose Up\n-- +optional\nCREATE VIRTUAL TABLE widgets_idx USING no_such_module(id);")},
+		"schema/003_gadgets.sql": {Data: []byte("CREATE TABLE gadgets (id INTEGER);")},
+	}
+
+	migrator := newTestMigrator(t, db, fsys)
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	applied, err := migrator.Applied(ctx)
+	if err != nil {
+		t.Fatalf("applied: %v", err)
+	}
+	var versions []int64
+	for _, a := range applied {
+		versions = append(versions, a.Version)
+	}
+	if len(versions) != 2 || versions[0] != 1 || versions[1] != 3 {
+		t.Fatalf("applied versions = %v, want [1 3]", versions)
+	}
+}
+
+func TestEmbeddedSearchIndexFollowsUsers(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	if err := Migrate(ctx, db, discardLogger()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := db.ExecContext(ctx, query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	matches := func(term string) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT count(*) FROM users_fts WHERE users_fts MATCH ?", term).Scan(&n); err != nil {
+			t.Fatalf("match %q: %v", term, err)
+		}
+		return n
+	}
+
+	exec("INSERT INTO users (username, email) VALUES ('marc', 'marc@example.com')")
+	if got := matches("marc"); got != 1 {
+		t.Fatalf("matches after insert = %d, want 1", got)
+	}
+
+	exec("UPDATE users SET username = 'marcus' WHERE username = 'marc'")
+	if got := matches("marcus"); got != 1 {
+		t.Fatalf("matches for new name after update = %d, want 1", got)
+	}
+	if got := matches("username:marc"); got != 0 {
+		t.Fatalf("matches for old name after update = %d, want 0", got)
+	}
+
+	exec("DELETE FROM users")
+	if got := matches("marcus"); got != 0 {
+		t.Fatalf("matches after delete = %d, want 0", got)
+	}
+}
+
+func newTestMigrator(t *testing.T, db *sql.DB, fsys fstest.MapFS) *Migrator {
+	t.Helper()
+
+	migrations, err := LoadMigrations(fsys, "schema")
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+
+	return NewMigrator(db, migrations, discardLogger())
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := GetConnection(config.Database{Mode: config.DatabaseModeMemory})
+	if err != nil {
+		t.Fatalf("open in-memory database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
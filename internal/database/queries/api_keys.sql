@@ -0,0 +1,41 @@
+-- name: CreateApiKey :one
+INSERT INTO api_keys (
+  prefix,
+  secret_hash,
+  name,
+  scopes,
+  created_by,
+  expires_at
+) VALUES (
+  ?, ?, ?, ?, ?, ?
+)
+RETURNING *;
+
+-- name: GetApiKey :one
+SELECT * FROM api_keys
+WHERE id = ?;
+
+-- name: GetApiKeyByPrefix :one
+SELECT * FROM api_keys
+WHERE prefix = ?;
+
+-- name: ListApiKeys :many
+SELECT * FROM api_keys
+ORDER BY id;
+
+-- name: UpdateApiKey :one
+UPDATE api_keys
+SET
+  name = ?,
+  scopes = ?
+WHERE id = ?
+RETURNING *;
+
+-- name: TouchApiKey :execrows
+UPDATE api_keys
+SET last_used_at = ?
+WHERE id = ?;
+
+-- name: DeleteApiKey :execrows
+DELETE FROM api_keys
+WHERE id = ?;
@@ -0,0 +1,52 @@
+-- name: GetAuthThrottle :one
+SELECT * FROM auth_throttles
+WHERE key = ?;
+
+-- name: ReserveAuthAttempt :one
+INSERT INTO auth_throttles (
+  key,
+  failures,
+  last_failure_at
+) VALUES (
+  sqlc.arg(key), 1, sqlc.arg(last_failure_at)
+)
+ON CONFLICT (key) DO UPDATE
+SET
+  failures = CASE
+    WHEN auth_throttles.last_failure_at < sqlc.arg(window_start) THEN 1
+    ELSE auth_throttles.failures + 1
+  END,
+  last_failure_at = excluded.last_failure_at,
+  locked_until = CASE
+    WHEN auth_throttles.last_failure_at >= sqlc.arg(window_start)
+      AND auth_throttles.failures >= sqlc.arg(free_attempts) THEN sqlc.arg(hold_until)
+    ELSE auth_throttles.locked_until
+  END
+WHERE auth_throttles.locked_until IS NULL
+  OR auth_throttles.locked_until <= excluded.last_failure_at
+RETURNING *;
+
+-- name: ReleaseAuthAttempt :execrows
+UPDATE auth_throttles
+SET
+  failures = MAX(failures - 1, 0),
+  locked_until = NULL
+WHERE key = ?;
+
+-- name: LockAuthThrottle :execrows
+UPDATE auth_throttles
+SET locked_until = ?
+WHERE key = ?;
+
+-- name: DeleteAuthThrottle :execrows
+DELETE FROM auth_throttles
+WHERE key = ?;
+
+-- name: ListLockedAuthThrottles :many
+SELECT * FROM auth_throttles
+WHERE locked_until > ?
+ORDER BY locked_until DESC, key;
+
+-- name: DeleteStaleAuthThrottles :execrows
+DELETE FROM auth_throttles
+WHERE last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?);
@@ -0,0 +1,19 @@
+-- name: GetUserByIdentity :one
+SELECT users.* FROM users
+JOIN user_identities ON user_identities.user_id = users.id
+WHERE user_identities.provider = ? AND user_identities.external_uid = ?;
+
+-- name: CreateUserIdentity :execrows
+INSERT INTO user_identities (
+  provider,
+  external_uid,
+  user_id
+) VALUES (
+  ?, ?, ?
+)
+ON CONFLICT (provider, external_uid) DO NOTHING;
+
+-- name: ListUserIdentities :many
+SELECT * FROM user_identities
+WHERE user_id = ?
+ORDER BY provider, external_uid;
@@ -0,0 +1,22 @@
+-- name: CreateMagicLink :execrows
+INSERT INTO magic_links (
+  token_hash,
+  user_id,
+  expires_at
+) VALUES (
+  ?, ?, ?
+);
+
+-- name: ConsumeMagicLink :one
+-- Marks an unused, unexpired link as used and returns it; any other link
+-- returns no row.
+UPDATE magic_links
+SET used_at = sqlc.arg(used_at)
+WHERE token_hash = sqlc.arg(token_hash)
+  AND used_at IS NULL
+  AND expires_at > sqlc.arg(used_at)
+RETURNING *;
+
+-- name: DeleteExpiredMagicLinks :execrows
+DELETE FROM magic_links
+WHERE expires_at < ?;
@@ -0,0 +1,57 @@
+-- name: UpsertUserTOTP :one
+-- Starts or restarts enrollment; a confirmed secret is left alone and no
+-- row is returned.
+INSERT INTO user_totp (
+  user_id,
+  secret
+) VALUES (
+  ?, ?
+)
+ON CONFLICT (user_id) DO UPDATE
+SET
+  secret = excluded.secret,
+  last_used_step = 0,
+  created_at = CURRENT_TIMESTAMP
+WHERE user_totp.confirmed_at IS NULL
+RETURNING *;
+
+-- name: GetUserTOTP :one
+SELECT * FROM user_totp
+WHERE user_id = ?;
+
+-- name: ConfirmUserTOTP :execrows
+UPDATE user_totp
+SET confirmed_at = ?, last_used_step = ?
+WHERE user_id = ? AND confirmed_at IS NULL;
+
+-- name: UseTOTPStep :execrows
+UPDATE user_totp
+SET last_used_step = sqlc.arg(step)
+WHERE user_id = sqlc.arg(user_id)
+  AND confirmed_at IS NOT NULL
+  AND last_used_step < sqlc.arg(step);
+
+-- name: DeleteUserTOTP :execrows
+DELETE FROM user_totp
+WHERE user_id = ?;
+
+-- name: CreateRecoveryCode :execrows
+INSERT INTO user_recovery_codes (
+  user_id,
+  code_hash
+) VALUES (
+  ?, ?
+);
+
+-- name: UseRecoveryCode :execrows
+UPDATE user_recovery_codes
+SET used_at = ?
+WHERE user_id = ? AND code_hash = ? AND used_at IS NULL;
+
+-- name: CountUnusedRecoveryCodes :one
+SELECT COUNT(*) FROM user_recovery_codes
+WHERE user_id = ? AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :execrows
+DELETE FROM user_recovery_codes
+WHERE user_id = ?;
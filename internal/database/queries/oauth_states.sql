@@ -0,0 +1,21 @@
+-- name: CreateOAuthState :execrows
+INSERT INTO oauth_states (
+  state_hash,
+  provider,
+  nonce,
+  code_verifier,
+  expires_at
+) VALUES (
+  ?, ?, ?, ?, ?
+);
+
+-- name: ConsumeOAuthState :one
+-- Deletes and returns an unexpired state for the provider; any other state
+-- returns no row.
+DELETE FROM oauth_states
+WHERE state_hash = ? AND provider = ? AND expires_at > ?
+RETURNING *;
+
+-- name: DeleteExpiredOAuthStates :execrows
+DELETE FROM oauth_states
+WHERE expires_at < ?;
@@ -0,0 +1,17 @@
+-- name: SetUserPassword :execrows
+INSERT INTO user_passwords (
+  user_id,
+  password_hash
+) VALUES (
+  ?, ?
+)
+ON CONFLICT (user_id) DO UPDATE
+SET
+  password_hash = excluded.password_hash,
+  updated_at = CURRENT_TIMESTAMP;
+
+-- name: GetUserCredentials :one
+SELECT users.id, users.username, users.email, user_passwords.password_hash
+FROM users
+JOIN user_passwords ON user_passwords.user_id = users.id
+WHERE users.username = ?;
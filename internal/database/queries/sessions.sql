@@ -0,0 +1,46 @@
+-- name: CreateSession :one
+INSERT INTO sessions (
+  id,
+  subject,
+  expires_at,
+  idle_timeout_seconds,
+  ip,
+  user_agent,
+  device
+) VALUES (
+  ?, ?, ?, ?, ?, ?, ?
+)
+RETURNING *;
+
+-- name: GetSession :one
+SELECT * FROM sessions
+WHERE id = ?;
+
+-- name: ListSubjectSessions :many
+SELECT * FROM sessions
+WHERE subject = ?
+ORDER BY created_at DESC, id;
+
+-- name: TouchSession :execrows
+UPDATE sessions
+SET last_seen_at = ?, expires_at = ?
+WHERE id = ? AND revoked_at IS NULL;
+
+-- name: RevokeSession :execrows
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = ? AND revoked_at IS NULL;
+
+-- name: RevokeSubjectSessions :execrows
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE subject = ? AND revoked_at IS NULL;
+
+-- name: DeleteExpiredSessions :execrows
+DELETE FROM sessions
+WHERE expires_at < ?;
+
+-- name: MarkSessionMFA :execrows
+UPDATE sessions
+SET mfa_verified_at = ?
+WHERE id = ? AND revoked_at IS NULL;
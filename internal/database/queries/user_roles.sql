@@ -0,0 +1,17 @@
+-- name: ListUserRoles :many
+SELECT role FROM user_roles
+WHERE user_id = ?
+ORDER BY role;
+
+-- name: GrantUserRole :execrows
+INSERT INTO user_roles (
+  user_id,
+  role
+) VALUES (
+  ?, ?
+)
+ON CONFLICT (user_id, role) DO NOTHING;
+
+-- name: RevokeUserRole :execrows
+DELETE FROM user_roles
+WHERE user_id = ? AND role = ?;
@@ -11,11 +11,6 @@ RETURNING *;
 SELECT * FROM users
 WHERE id = ?;
 
--- name: ListUsers :many
-SELECT * FROM users
-ORDER BY id
-LIMIT ? OFFSET ?;
-
 -- name: UpdateUser :one
 UPDATE users
 SET
@@ -25,6 +20,10 @@ SET
 WHERE id = ?
 RETURNING *;
 
--- name: DeleteUser :exec
+-- name: DeleteUser :execrows
 DELETE FROM users
-WHERE id = ?;
\ No newline at end of file
+WHERE id = ?;
+
+-- name: GetUserByEmail :one
+SELECT * FROM users
+WHERE email = ?;
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+var queryHeader = regexp.MustCompile(`(?m)^-- name: (\w+) (:\w+)\s*$`)
+
+// TestMutatingQueriesReportAffectedRows enforces the convention that every
+// INSERT, UPDATE or DELETE query lets callers tell whether a row was touched,
+// either through RETURNING (:one/:many) or an affected-row count (:execrows).
+// A plain :exec cannot distinguish "done" from "no such row".
+func TestMutatingQueriesReportAffectedRows(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("queries", "*.sql"))
+	if err != nil {
+		t.Fatalf("glob queries: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no query files found")
+	}
+
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+
+		headers := queryHeader.FindAllStringSubmatchIndex(string(contents), -1)
+		for i, header := range headers {
+			name := string(contents[header[2]:header[3]])
+			kind := string(contents[header[4]:header[5]])
+
+			end := len(contents)
+			if i+1 < len(headers) {
+				end = headers[i+1][0]
+			}
+			body := strings.ToUpper(string(contents[header[1]:end]))
+
+			if !isMutation(body) {
+				continue
+			}
+
+			switch kind {
+			case ":execrows", ":execresult":
+			case ":one", ":many":
+				if !strings.Contains(body, "RETURNING") {
+					t.Errorf("%s: %s is %s but has no RETURNING clause", file, name, kind)
+				}
+			default:
+				t.Errorf("%s: %s mutates rows but is %s; use :execrows or RETURNING", file, name, kind)
+			}
+		}
+	}
+}
+
+func isMutation(body string) bool {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "--") {
+			continue
+		}
+		for _, verb := range []string{"INSERT", "UPDATE", "DELETE", "REPLACE"} {
+			if strings.HasPrefix(line, verb) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func TestDeleteUserReportsAffectedRows(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	if err := Migrate(ctx, db, discardLogger()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	q := repo.New(db)
+
+	user, err := q.CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	deleted, err := q.DeleteUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("deleted = %d, want 1", deleted)
+	}
+
+	deleted, err = q.DeleteUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("delete missing user: %v", err)
+	}
+	if deleted != 0 {
+		t.Fatalf("deleted = %d, want 0", deleted)
+	}
+}
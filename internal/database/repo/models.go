@@ -5,9 +5,60 @@
 package repo
 
 import (
+	"database/sql"
 	"time"
 )
 
+type ApiKey struct {
+	ID         int64        `json:"id"`
+	Prefix     string       `json:"prefix"`
+	SecretHash string       `json:"secret_hash"`
+	Name       string       `json:"name"`
+	Scopes     string       `json:"scopes"`
+	CreatedBy  string       `json:"created_by"`
+	CreatedAt  time.Time    `json:"created_at"`
+	ExpiresAt  sql.NullTime `json:"expires_at"`
+	LastUsedAt sql.NullTime `json:"last_used_at"`
+}
+
+type AuthThrottle struct {
+	Key           string       `json:"key"`
+	Failures      int64        `json:"failures"`
+	LastFailureAt time.Time    `json:"last_failure_at"`
+	LockedUntil   sql.NullTime `json:"locked_until"`
+}
+
+type MagicLink struct {
+	TokenHash string       `json:"token_hash"`
+	UserID    int64        `json:"user_id"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	UsedAt    sql.NullTime `json:"used_at"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+type OauthState struct {
+	StateHash    string    `json:"state_hash"`
+	Provider     string    `json:"provider"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type Session struct {
+	ID                 string       `json:"id"`
+	Subject            string       `json:"subject"`
+	CreatedAt          time.Time    `json:"created_at"`
+	ExpiresAt          time.Time    `json:"expires_at"`
+	RevokedAt          sql.NullTime `json:"revoked_at"`
+	LastSeenAt         sql.NullTime `json:"last_seen_at"`
+	IdleTimeoutSeconds int64        `json:"idle_timeout_seconds"`
+	Ip                 string       `json:"ip"`
+	UserAgent          string       `json:"user_agent"`
+	Device             string       `json:"device"`
+	MfaVerifiedAt      sql.NullTime `json:"mfa_verified_at"`
+}
+
 type User struct {
 	ID        int64     `json:"id"`
 	Username  string    `json:"username"`
@@ -15,3 +66,37 @@ type User struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+type UserIdentity struct {
+	Provider    string    `json:"provider"`
+	ExternalUid string    `json:"external_uid"`
+	UserID      int64     `json:"user_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type UserPassword struct {
+	UserID       int64     `json:"user_id"`
+	PasswordHash string    `json:"password_hash"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type UserRecoveryCode struct {
+	UserID    int64        `json:"user_id"`
+	CodeHash  string       `json:"code_hash"`
+	UsedAt    sql.NullTime `json:"used_at"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+type UserRole struct {
+	UserID    int64     `json:"user_id"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type UserTotp struct {
+	UserID       int64        `json:"user_id"`
+	Secret       string       `json:"secret"`
+	ConfirmedAt  sql.NullTime `json:"confirmed_at"`
+	LastUsedStep int64        `json:"last_used_step"`
+	CreatedAt    time.Time    `json:"created_at"`
+}
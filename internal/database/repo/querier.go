@@ -6,14 +6,67 @@ package repo
 
 import (
 	"context"
+	"database/sql"
+	"time"
 )
 
 type Querier interface {
+	ConfirmUserTOTP(ctx context.Context, arg ConfirmUserTOTPParams) (int64, error)
+	// Marks an unused, unexpired link as used and returns it; any other link
+	// returns no row.
+	ConsumeMagicLink(ctx context.Context, arg ConsumeMagicLinkParams) (MagicLink, error)
+	// Deletes and returns an unexpired state for the provider; any other state
+	// returns no row.
+	ConsumeOAuthState(ctx context.Context, arg ConsumeOAuthStateParams) (OauthState, error)
+	CountUnusedRecoveryCodes(ctx context.Context, userID int64) (int64, error)
+	CreateApiKey(ctx context.Context, arg CreateApiKeyParams) (ApiKey, error)
+	CreateMagicLink(ctx context.Context, arg CreateMagicLinkParams) (int64, error)
+	CreateOAuthState(ctx context.Context, arg CreateOAuthStateParams) (int64, error)
+	CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) (int64, error)
+	CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
-	DeleteUser(ctx context.Context, id int64) error
+	CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) (int64, error)
+	DeleteApiKey(ctx context.Context, id int64) (int64, error)
+	DeleteAuthThrottle(ctx context.Context, key string) (int64, error)
+	DeleteExpiredMagicLinks(ctx context.Context, expiresAt time.Time) (int64, error)
+	DeleteExpiredOAuthStates(ctx context.Context, expiresAt time.Time) (int64, error)
+	DeleteExpiredSessions(ctx context.Context, expiresAt time.Time) (int64, error)
+	DeleteRecoveryCodes(ctx context.Context, userID int64) (int64, error)
+	DeleteStaleAuthThrottles(ctx context.Context, arg DeleteStaleAuthThrottlesParams) (int64, error)
+	DeleteUser(ctx context.Context, id int64) (int64, error)
+	DeleteUserTOTP(ctx context.Context, userID int64) (int64, error)
+	GetApiKey(ctx context.Context, id int64) (ApiKey, error)
+	GetApiKeyByPrefix(ctx context.Context, prefix string) (ApiKey, error)
+	GetAuthThrottle(ctx context.Context, key string) (AuthThrottle, error)
+	GetSession(ctx context.Context, id string) (Session, error)
 	GetUser(ctx context.Context, id int64) (User, error)
-	ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error)
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	GetUserByIdentity(ctx context.Context, arg GetUserByIdentityParams) (User, error)
+	GetUserCredentials(ctx context.Context, username string) (GetUserCredentialsRow, error)
+	GetUserTOTP(ctx context.Context, userID int64) (UserTotp, error)
+	GrantUserRole(ctx context.Context, arg GrantUserRoleParams) (int64, error)
+	ListApiKeys(ctx context.Context) ([]ApiKey, error)
+	ListLockedAuthThrottles(ctx context.Context, lockedUntil sql.NullTime) ([]AuthThrottle, error)
+	ListSubjectSessions(ctx context.Context, subject string) ([]Session, error)
+	ListUserIdentities(ctx context.Context, userID int64) ([]UserIdentity, error)
+	ListUserRoles(ctx context.Context, userID int64) ([]string, error)
+	LockAuthThrottle(ctx context.Context, arg LockAuthThrottleParams) (int64, error)
+	MarkSessionMFA(ctx context.Context, arg MarkSessionMFAParams) (int64, error)
+	ReleaseAuthAttempt(ctx context.Context, key string) (int64, error)
+	ReserveAuthAttempt(ctx context.Context, arg ReserveAuthAttemptParams) (AuthThrottle, error)
+	RevokeSession(ctx context.Context, id string) (int64, error)
+	RevokeSubjectSessions(ctx context.Context, subject string) (int64, error)
+	RevokeUserRole(ctx context.Context, arg RevokeUserRoleParams) (int64, error)
+	SetUserPassword(ctx context.Context, arg SetUserPasswordParams) (int64, error)
+	TouchApiKey(ctx context.Context, arg TouchApiKeyParams) (int64, error)
+	TouchSession(ctx context.Context, arg TouchSessionParams) (int64, error)
+	UpdateApiKey(ctx context.Context, arg UpdateApiKeyParams) (ApiKey, error)
 	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
+	// Starts or restarts enrollment; a confirmed secret is left alone and no
+	// row is returned.
+	UpsertUserTOTP(ctx context.Context, arg UpsertUserTOTPParams) (UserTotp, error)
+	UseRecoveryCode(ctx context.Context, arg UseRecoveryCodeParams) (int64, error)
+	UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error)
 }
 
 var _ Querier = (*Queries)(nil)
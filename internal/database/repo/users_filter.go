@@ -0,0 +1,205 @@
+package repo
+
+// This file is written by hand, not generated by sqlc. It holds queries whose
+// WHERE and ORDER BY clauses are composed at runtime. Only whitelisted column
+// names are ever interpolated into the SQL; every value is a bound parameter.
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimestampLayout is the text format SQLite's CURRENT_TIMESTAMP stores.
+// Timestamps compared against stored columns must be bound in this format.
+const TimestampLayout = "2006-01-02 15:04:05"
+
+// UserSortColumns lists the users columns that listings may be ordered by
+const (
+	UserSortID        = "id"
+	UserSortUsername  = "username"
+	UserSortEmail     = "email"
+	UserSortCreatedAt = "created_at"
+	UserSortUpdatedAt = "updated_at"
+)
+
+var userSortColumns = map[string]bool{
+	UserSortID:        true,
+	UserSortUsername:  true,
+	UserSortEmail:     true,
+	UserSortCreatedAt: true,
+	UserSortUpdatedAt: true,
+}
+
+// DynamicQuerier contains the hand-written queries that sqlc cannot express
+type DynamicQuerier interface {
+	ListUsersFiltered(ctx context.Context, arg ListUsersFilteredParams) ([]User, error)
+	CountUsersFiltered(ctx context.Context, arg UserFilter) (int64, error)
+	SearchUsers(ctx context.Context, arg SearchUsersParams) ([]UserSearchRow, error)
+	SearchUsersLike(ctx context.Context, arg SearchUsersLikeParams) ([]User, error)
+}
+
+// Store is every query available on Queries, generated and hand-written
+type Store interface {
+	Querier
+	DynamicQuerier
+}
+
+var _ Store = (*Queries)(nil)
+
+// UserFilter restricts a users listing. Zero values are ignored.
+// Prefix matches use LIKE, so they are case-insensitive for ASCII letters.
+type UserFilter struct {
+	UsernamePrefix string
+	EmailPrefix    string
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	UpdatedAfter   time.Time
+	UpdatedBefore  time.Time
+}
+
+// UserKey is a position in a sorted users listing: the value of the sort
+// column (formatted with TimestampLayout for timestamps) and the id that
+// breaks ties between equal values
+type UserKey struct {
+	Value interface{}
+	ID    int64
+}
+
+type ListUsersFilteredParams struct {
+	Filter UserFilter
+	// SortColumn is one of the UserSort constants; empty means id
+	SortColumn string
+	Descending bool
+	// After, when set, skips every row up to and including this key in the
+	// requested order
+	After  *UserKey
+	Limit  int64
+	Offset int64
+}
+
+func (q *Queries) ListUsersFiltered(ctx context.Context, arg ListUsersFilteredParams) ([]User, error) {
+	column := arg.SortColumn
+	if column == "" {
+		column = UserSortID
+	}
+	if !userSortColumns[column] {
+		return nil, fmt.Errorf("repo: cannot sort users by %q", column)
+	}
+
+	where, args := userFilterClauses(arg.Filter)
+
+	direction, op := "ASC", ">"
+	if arg.Descending {
+		direction, op = "DESC", "<"
+	}
+
+	if arg.After != nil {
+		if column == UserSortID {
+			where = append(where, "id "+op+" ?")
+			args = append(args, arg.After.ID)
+		} else {
+			where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, op))
+			args = append(args, arg.After.Value, arg.After.Value, arg.After.ID)
+		}
+	}
+
+	var query strings.Builder
+	query.WriteString("SELECT id, username, email, created_at, updated_at FROM users")
+	if len(where) > 0 {
+		query.WriteString(" WHERE " + strings.Join(where, " AND "))
+	}
+	if column == UserSortID {
+		query.WriteString(" ORDER BY id " + direction)
+	} else {
+		query.WriteString(fmt.Sprintf(" ORDER BY %s %s, id %s", column, direction, direction))
+	}
+	query.WriteString(" LIMIT ?")
+	args = append(args, arg.Limit)
+	if arg.Offset > 0 {
+		query.WriteString(" OFFSET ?")
+		args = append(args, arg.Offset)
+	}
+
+	rows, err := q.db.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []User{}
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Email,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (q *Queries) CountUsersFiltered(ctx context.Context, arg UserFilter) (int64, error) {
+	where, args := userFilterClauses(arg)
+
+	query := "SELECT count(*) FROM users"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var count int64
+	err := q.db.QueryRowContext(ctx, query, args...).Scan(&count)
+	return count, err
+}
+
+// userFilterClauses builds the WHERE conditions and arguments for f
+func userFilterClauses(f UserFilter) ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+
+	if f.UsernamePrefix != "" {
+		where = append(where, `username LIKE ? ESCAPE '\'`)
+		args = append(args, EscapeLike(f.UsernamePrefix)+"%")
+	}
+	if f.EmailPrefix != "" {
+		where = append(where, `email LIKE ? ESCAPE '\'`)
+		args = append(args, EscapeLike(f.EmailPrefix)+"%")
+	}
+
+	ranges := []struct {
+		column string
+		op     string
+		value  time.Time
+	}{
+		{"created_at", ">=", f.CreatedAfter},
+		{"created_at", "<", f.CreatedBefore},
+		{"updated_at", ">=", f.UpdatedAfter},
+		{"updated_at", "<", f.UpdatedBefore},
+	}
+	for _, r := range ranges {
+		if r.value.IsZero() {
+			continue
+		}
+		where = append(where, r.column+" "+r.op+" ?")
+		args = append(args, r.value.UTC().Format(TimestampLayout))
+	}
+
+	return where, args
+}
+
+// EscapeLike escapes the LIKE wildcards in s so it matches literally
+// when used with ESCAPE '\'
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
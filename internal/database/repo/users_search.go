@@ -0,0 +1,137 @@
+package repo
+
+// This file is written by hand, not generated by sqlc. The full-text query
+// depends on the optional users_fts table, so its absence must surface as an
+// ordinary query error that callers can fall back from.
+
+import (
+	"context"
+	"strings"
+)
+
+// UserSearchRow is a user matched by a full-text search, with its bm25 rank
+// (lower is better) and the username and email with matched terms wrapped in
+// the requested highlight markers
+type UserSearchRow struct {
+	User
+	Rank              float64
+	UsernameHighlight string
+	EmailHighlight    string
+}
+
+type SearchUsersParams struct {
+	// Match is an FTS5 query expression
+	Match          string
+	HighlightStart string
+	HighlightEnd   string
+	Limit          int64
+}
+
+const searchUsers = `SELECT u.id, u.username, u.email, u.created_at, u.updated_at,
+  bm25(users_fts) AS rank,
+  highlight(users_fts, 0, ?, ?),
+  highlight(users_fts, 1, ?, ?)
+FROM users_fts
+JOIN users u ON u.id = users_fts.rowid
+WHERE users_fts MATCH ?
+ORDER BY rank, u.id
+LIMIT ?`
+
+// SearchUsers runs a ranked full-text search over usernames and emails.
+// It fails if the users_fts table does not exist.
+func (q *Queries) SearchUsers(ctx context.Context, arg SearchUsersParams) ([]UserSearchRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchUsers,
+		arg.HighlightStart, arg.HighlightEnd,
+		arg.HighlightStart, arg.HighlightEnd,
+		arg.Match,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []UserSearchRow{}
+	for rows.Next() {
+		var i UserSearchRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Email,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Rank,
+			&i.UsernameHighlight,
+			&i.EmailHighlight,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+type SearchUsersLikeParams struct {
+	// Terms must each appear in the username or the email
+	Terms []string
+	Limit int64
+}
+
+// SearchUsersLike is the fallback for SearchUsers when FTS5 is unavailable.
+// Every term must occur as a substring of the username or email; users whose
+// username starts with the first term sort first.
+func (q *Queries) SearchUsersLike(ctx context.Context, arg SearchUsersLikeParams) ([]User, error) {
+	var where []string
+	var args []interface{}
+	for _, term := range arg.Terms {
+		pattern := "%" + EscapeLike(term) + "%"
+		where = append(where, `(username LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+
+	var query strings.Builder
+	query.WriteString("SELECT id, username, email, created_at, updated_at FROM users")
+	if len(where) > 0 {
+		query.WriteString(" WHERE " + strings.Join(where, " AND "))
+	}
+	if len(arg.Terms) > 0 {
+		query.WriteString(` ORDER BY username LIKE ? ESCAPE '\' DESC, username, id`)
+		args = append(args, EscapeLike(arg.Terms[0])+"%")
+	} else {
+		query.WriteString(" ORDER BY username, id")
+	}
+	query.WriteString(" LIMIT ?")
+	args = append(args, arg.Limit)
+
+	rows, err := q.db.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []User{}
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Email,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +optional
+-- Full-text index over users. Optional because some libsql builds lack FTS5;
+-- search falls back to LIKE until this migration can be applied.
+-- Add future searchable profile columns here through a new migration that
+-- recreates the table and triggers, then rebuilds the index.
+CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(
+    username,
+    email,
+    content = 'users',
+    content_rowid = 'id',
+    tokenize = 'unicode61'
+);
+
+CREATE TRIGGER IF NOT EXISTS users_fts_after_insert AFTER INSERT ON users BEGIN
+    INSERT INTO users_fts (rowid, username, email) VALUES (new.id, new.username, new.email);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_after_delete AFTER DELETE ON users BEGIN
+    INSERT INTO users_fts (users_fts, rowid, username, email) VALUES ('delete', old.id, old.username, old.email);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_after_update AFTER UPDATE ON users BEGIN
+    INSERT INTO users_fts (users_fts, rowid, username, email) VALUES ('delete', old.id, old.username, old.email);
+    INSERT INTO users_fts (rowid, username, email) VALUES (new.id, new.username, new.email);
+END;
+
+INSERT INTO users_fts (users_fts) VALUES ('rebuild');
+
+-- +goose Down
+DROP TRIGGER IF EXISTS users_fts_after_update;
+DROP TRIGGER IF EXISTS users_fts_after_delete;
+DROP TRIGGER IF EXISTS users_fts_after_insert;
+DROP TABLE IF EXISTS users_fts;
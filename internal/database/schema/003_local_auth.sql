@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS user_passwords (
+    user_id INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    password_hash TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Server-side sessions. id is the SHA-256 of the secret in the session
+-- cookie, so a leaked table cannot be replayed as cookies. subject is the
+-- auth provider's UID rather than a users foreign key.
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    subject TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS sessions_subject ON sessions (subject);
+
+-- +goose Down
+DROP INDEX IF EXISTS sessions_subject;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS user_passwords;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- Client metadata and sliding expiration for server-side sessions. An
+-- idle_timeout_seconds of 0 means expires_at is fixed; otherwise each use
+-- pushes expires_at out to last_seen_at + idle_timeout_seconds.
+ALTER TABLE sessions ADD COLUMN last_seen_at TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN idle_timeout_seconds INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN device TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS sessions_expires_at ON sessions (expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS sessions_expires_at;
+ALTER TABLE sessions DROP COLUMN device;
+ALTER TABLE sessions DROP COLUMN user_agent;
+ALTER TABLE sessions DROP COLUMN ip;
+ALTER TABLE sessions DROP COLUMN idle_timeout_seconds;
+ALTER TABLE sessions DROP COLUMN last_seen_at;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- API keys for machine clients. A key is shown once as
+-- sk_<prefix>_<secret>; only the prefix (for lookup and display) and the
+-- SHA-256 of the secret are stored. scopes is space-separated.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    prefix TEXT NOT NULL UNIQUE,
+    secret_hash TEXT NOT NULL,
+    name TEXT NOT NULL,
+    scopes TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- Links an auth provider's UID to a local user. A user can have one
+-- identity per provider; the first authenticated request from an unknown
+-- identity creates or links the user.
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider TEXT NOT NULL,
+    external_uid TEXT NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, external_uid)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_id ON user_identities (user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS user_identities_user_id;
+DROP TABLE IF EXISTS user_identities;
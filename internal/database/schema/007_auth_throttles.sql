@@ -0,0 +1,13 @@
+-- +goose Up
+-- Failed credential checks per limiter key (user:<name>, ip:<addr> or
+-- uid:<uid>), kept in the database so limits hold across restarts and
+-- instances. failures restarts at 1 when the previous failure has aged out.
+CREATE TABLE IF NOT EXISTS auth_throttles (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS auth_throttles;
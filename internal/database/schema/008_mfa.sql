@@ -0,0 +1,29 @@
+-- +goose Up
+-- TOTP second factor per user. secret is the base32 shared secret, which
+-- must stay readable to check codes. confirmed_at is NULL until the user
+-- proves their authenticator works; last_used_step is the newest time step
+-- accepted, so a code cannot be replayed.
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    confirmed_at TIMESTAMP,
+    last_used_step INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Single-use recovery codes, stored as SHA-256 hashes
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, code_hash)
+);
+
+-- When the session last passed a second factor
+ALTER TABLE sessions ADD COLUMN mfa_verified_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE sessions DROP COLUMN mfa_verified_at;
+DROP TABLE IF EXISTS user_recovery_codes;
+DROP TABLE IF EXISTS user_totp;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- Pending OAuth2 authorization-code logins. Each row is created when the
+-- browser is sent to the provider and deleted when it comes back, so a
+-- state can be used once. Only the SHA-256 of the state is stored; nonce
+-- and code_verifier are needed to finish the login.
+CREATE TABLE IF NOT EXISTS oauth_states (
+    state_hash TEXT PRIMARY KEY,
+    provider TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS oauth_states;
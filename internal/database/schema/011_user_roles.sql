@@ -0,0 +1,13 @@
+-- +goose Up
+-- Roles granted to local users, such as "admin". They are added to the
+-- caller's token once the local user is loaded, so role checks work with
+-- every auth provider.
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_roles;
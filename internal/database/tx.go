@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+// TxStarter begins transactions whose queries are exposed as a repo.Store,
+// so callers can be backed by a real database or an in-memory fake
+type TxStarter interface {
+	BeginTx(ctx context.Context) (Tx, error)
+}
+
+// Tx is a transaction started by a TxStarter
+type Tx interface {
+	Queries() repo.Store
+	Commit() error
+	Rollback() error
+}
+
+// SQLTxStarter starts transactions on a database connection pool
+type SQLTxStarter struct {
+	db      *sql.DB
+	queries *repo.Queries
+}
+
+// NewTxStarter creates a TxStarter for the given connection pool
+func NewTxStarter(db *sql.DB) *SQLTxStarter {
+	return &SQLTxStarter{
+		db:      db,
+		queries: repo.New(db),
+	}
+}
+
+// BeginTx starts a transaction and binds a copy of the queries to it
+func (s *SQLTxStarter) BeginTx(ctx context.Context) (Tx, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlTx{
+		tx:      tx,
+		queries: s.queries.WithTx(tx),
+	}, nil
+}
+
+type sqlTx struct {
+	tx      *sql.Tx
+	queries *repo.Queries
+}
+
+func (t *sqlTx) Queries() repo.Store {
+	return t.queries
+}
+
+func (t *sqlTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *sqlTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+var _ TxStarter = (*SQLTxStarter)(nil)
@@ -0,0 +1,114 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/mhpenta/starterA/internal/service"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// CreateAPIKeyHandler returns an HTTP handler for creating an API key. The
+// response is the only time the plaintext key is shown.
+func (h *HTTPHandlers) CreateAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input service.CreateAPIKeyInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			h.badRequest(w, r, "Request body must be a valid JSON api key object", err)
+			return
+		}
+
+		key, err := h.Service.CreateAPIKey(r.Context(), &input)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		h.respond(w, http.StatusCreated, key)
+	}
+}
+
+// ListAPIKeysHandler returns an HTTP handler for listing API keys
+func (h *HTTPHandlers) ListAPIKeysHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := h.Service.ListAPIKeys(r.Context())
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		h.respond(w, http.StatusOK, keys)
+	}
+}
+
+// GetAPIKeyHandler returns an HTTP handler for getting a single API key
+func (h *HTTPHandlers) GetAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := h.apiKeyID(w, r)
+		if !ok {
+			return
+		}
+
+		key, err := h.Service.GetAPIKey(r.Context(), id)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		h.respond(w, http.StatusOK, key)
+	}
+}
+
+// UpdateAPIKeyHandler returns an HTTP handler for renaming an API key and
+// replacing its scopes
+func (h *HTTPHandlers) UpdateAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := h.apiKeyID(w, r)
+		if !ok {
+			return
+		}
+
+		var input service.UpdateAPIKeyInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			h.badRequest(w, r, "Request body must be a valid JSON api key object", err)
+			return
+		}
+
+		key, err := h.Service.UpdateAPIKey(r.Context(), id, &input)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		h.respond(w, http.StatusOK, key)
+	}
+}
+
+// DeleteAPIKeyHandler returns an HTTP handler for deleting an API key
+func (h *HTTPHandlers) DeleteAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := h.apiKeyID(w, r)
+		if !ok {
+			return
+		}
+
+		if err := h.Service.DeleteAPIKey(r.Context(), id); err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		h.respond(w, http.StatusNoContent, nil)
+	}
+}
+
+// apiKeyID parses the {id} path parameter, writing a 400 problem if it is invalid
+func (h *HTTPHandlers) apiKeyID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		h.badRequest(w, r, "API key id must be an integer", err)
+		return 0, false
+	}
+	return id, true
+}
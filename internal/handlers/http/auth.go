@@ -0,0 +1,358 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/service"
+)
+
+// SessionCookieConfig controls the session cookie set by the auth handlers
+type SessionCookieConfig struct {
+	// Lifetime is passed to CreateSessionCookie; with server-side sessions
+	// it is the idle timeout
+	Lifetime time.Duration
+	// MaxAge is how long the browser keeps the cookie; zero means Lifetime.
+	// Sliding sessions need it to cover the maximum session lifetime.
+	MaxAge   time.Duration
+	Secure   bool
+	SameSite http.SameSite
+}
+
+func (c SessionCookieConfig) maxAge() time.Duration {
+	if c.MaxAge > 0 {
+		return c.MaxAge
+	}
+	return c.Lifetime
+}
+
+// SessionCookieConfigFromAuth converts the [Auth] config section
+func SessionCookieConfigFromAuth(cfg config.Auth) (SessionCookieConfig, error) {
+	c := SessionCookieConfig{
+		Lifetime: time.Duration(cfg.SessionLifetimeSeconds) * time.Second,
+		Secure:   cfg.CookieSecure,
+	}
+	if cfg.ServerSessions {
+		c.MaxAge = time.Duration(cfg.SessionMaxLifetimeSeconds) * time.Second
+	}
+
+	switch strings.ToLower(cfg.CookieSameSite) {
+	case "lax", "":
+		c.SameSite = http.SameSiteLaxMode
+	case "strict":
+		c.SameSite = http.SameSiteStrictMode
+	case "none":
+		if !cfg.CookieSecure {
+			return c, fmt.Errorf("CookieSameSite none requires CookieSecure")
+		}
+		c.SameSite = http.SameSiteNoneMode
+	default:
+		return c, fmt.Errorf("unknown CookieSameSite %q: want lax, strict or none", cfg.CookieSameSite)
+	}
+
+	return c, nil
+}
+
+// CSRFConfigFromServer trusts the [Server] AllowedCorsURLs and ServerDomain
+// origins for cookie-authenticated requests
+func CSRFConfigFromServer(cfg config.Server) auth.CSRFConfig {
+	origins := append([]string(nil), cfg.AllowedCorsURLs...)
+	if cfg.ServerDomain != "" {
+		origins = append(origins, "https://"+cfg.ServerDomain)
+		if !cfg.EnableHTTPS {
+			origins = append(origins, "http://"+cfg.ServerDomain)
+		}
+	}
+	return auth.CSRFConfig{TrustedOrigins: origins}
+}
+
+// AuthHandlers exchange tokens for session cookies on top of any auth.Servicer
+type AuthHandlers struct {
+	Provider auth.Servicer
+	Cookie   SessionCookieConfig
+	// CSRF configures the CSRF protection of cookie-authenticated routes
+	CSRF auth.CSRFConfig
+	// Limiter throttles failed logins, token exchanges and MFA codes; nil
+	// disables it
+	Limiter *auth.Limiter
+	// MFA configures step-up with a second factor
+	MFA MFAConfig
+	// MagicLink configures passwordless login by email
+	MagicLink MagicLinkConfig
+	// OAuth configures login with external OAuth providers
+	OAuth  OAuthConfig
+	Logger *slog.Logger
+}
+
+// NewAuthHandlers creates a new AuthHandlers instance
+func NewAuthHandlers(provider auth.Servicer, cookie SessionCookieConfig, logger *slog.Logger) *AuthHandlers {
+	return &AuthHandlers{
+		Provider: provider,
+		Cookie:   cookie,
+		Logger:   logger,
+	}
+}
+
+// handlers gives the auth handlers the shared response helpers
+func (h *AuthHandlers) handlers() *HTTPHandlers {
+	return &HTTPHandlers{Logger: h.Logger}
+}
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type loginResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+// LoginHandler returns an HTTP handler that checks a username and password
+// and returns an ID token for CreateSessionHandler. It answers 404 when the
+// provider does not check passwords itself, and 429 once the username or
+// client IP has failed too often.
+func (h *AuthHandlers) LoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authenticator, ok := auth.Lookup[auth.PasswordAuthenticator](h.Provider)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		var input loginRequest
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			h.handlers().badRequest(w, r, "Request body must be a JSON object with username and password", err)
+			return
+		}
+
+		userKey := auth.ThrottleKeyUsername(input.Username)
+		ipKey := auth.ThrottleKeyIP(auth.SessionMetadataFromRequest(r).IP)
+		if err := h.Limiter.Check(r.Context(), userKey, ipKey); err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+
+		idToken, err := authenticator.Login(r.Context(), input.Username, input.Password)
+		if err != nil {
+			if !errors.Is(err, auth.ErrInvalidCredentials) {
+				h.Limiter.Release(r.Context(), userKey, ipKey)
+			}
+			h.handlers().respondError(w, r, err)
+			return
+		}
+		h.Limiter.Succeed(r.Context(), userKey)
+		h.Limiter.Release(r.Context(), ipKey)
+
+		h.handlers().respond(w, http.StatusOK, loginResponse{IDToken: idToken})
+	}
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePasswordHandler returns an HTTP handler that replaces the caller's
+// password after checking their current one. It answers 404 when the
+// provider does not store passwords itself, and 429 once the caller or
+// client IP has given a wrong current password too often.
+func (h *AuthHandlers) ChangePasswordHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		changer, ok := auth.Lookup[auth.PasswordChanger](h.Provider)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		token, ok := auth.TokenFromContext(r.Context())
+		if !ok || token == nil {
+			h.handlers().respondError(w, r, auth.ErrTokenNotInContext)
+			return
+		}
+
+		var input changePasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			h.handlers().badRequest(w, r, "Request body must be a JSON object with current_password and new_password", err)
+			return
+		}
+
+		uidKey := auth.ThrottleKeyUID(token.UID)
+		ipKey := auth.ThrottleKeyIP(auth.SessionMetadataFromRequest(r).IP)
+		if err := h.Limiter.Check(r.Context(), uidKey, ipKey); err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+
+		err := changer.ChangePassword(r.Context(), token.UID, input.CurrentPassword, input.NewPassword)
+		switch {
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			err = &service.ValidationError{
+				Err:    err,
+				Detail: "Current password is incorrect",
+				Fields: []service.FieldError{{Field: "current_password", Message: "is incorrect"}},
+			}
+		case err != nil:
+			h.Limiter.Release(r.Context(), uidKey, ipKey)
+		}
+		if err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+		h.Limiter.Succeed(r.Context(), uidKey)
+		h.Limiter.Release(r.Context(), ipKey)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type createSessionRequest struct {
+	IDToken string `json:"id_token"`
+}
+
+// CreateSessionHandler returns an HTTP handler that exchanges an ID token
+// for an HttpOnly session cookie. Invalid tokens count against the client IP.
+func (h *AuthHandlers) CreateSessionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input createSessionRequest
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			h.handlers().badRequest(w, r, "Request body must be a JSON object with an id_token", err)
+			return
+		}
+		if input.IDToken == "" {
+			h.handlers().respondError(w, r, auth.ErrMissingToken)
+			return
+		}
+
+		metadata := auth.SessionMetadataFromRequest(r)
+		ipKey := auth.ThrottleKeyIP(metadata.IP)
+		if err := h.Limiter.Check(r.Context(), ipKey); err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+
+		ctx := auth.ContextWithSessionMetadata(r.Context(), metadata)
+		cookie, err := h.Provider.CreateSessionCookie(ctx, input.IDToken, h.Cookie.Lifetime)
+		if err != nil {
+			if !auth.IsInvalidError(err) {
+				h.Limiter.Release(r.Context(), ipKey)
+			}
+			h.handlers().respondError(w, r, err)
+			return
+		}
+		h.Limiter.Release(r.Context(), ipKey)
+
+		http.SetCookie(w, h.sessionCookie(cookie, int(h.Cookie.maxAge().Seconds())))
+		w.Header().Set(auth.CSRFHeader, auth.CSRFTokenForSession(cookie))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type csrfResponse struct {
+	CSRFToken string `json:"csrf_token"`
+}
+
+// CSRFHandler returns an HTTP handler that answers with the CSRF token for
+// the caller's session cookie. Callers authenticated by a bearer token or
+// API key do not need one and get 401.
+func (h *AuthHandlers) CSRFHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := auth.CSRFToken(r)
+		if token == "" {
+			h.handlers().respondError(w, r, auth.ErrMissingToken)
+			return
+		}
+
+		h.handlers().respond(w, http.StatusOK, csrfResponse{CSRFToken: token})
+	}
+}
+
+// LogoutHandler returns an HTTP handler that clears the session cookie and
+// revokes the session when the provider tracks sessions server-side
+func (h *AuthHandlers) LogoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(auth.SessionCookieName); err == nil && cookie.Value != "" {
+			if revoker, ok := auth.Lookup[auth.SessionRevoker](h.Provider); ok {
+				err := revoker.RevokeSessionCookie(r.Context(), cookie.Value)
+				if err != nil && !auth.IsInvalidError(err) {
+					h.handlers().respondError(w, r, err)
+					return
+				}
+			}
+		}
+
+		http.SetCookie(w, h.sessionCookie("", -1))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type userInfoResponse struct {
+	UID           string `json:"uid"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	DisplayName   string `json:"display_name,omitempty"`
+	PhotoURL      string `json:"photo_url,omitempty"`
+}
+
+type meResponse struct {
+	UID           string            `json:"uid"`
+	Email         string            `json:"email"`
+	EmailVerified bool              `json:"email_verified"`
+	Expiry        time.Time         `json:"expires_at"`
+	User          *userInfoResponse `json:"user"`
+}
+
+// MeHandler returns an HTTP handler describing the authenticated caller.
+// It must be mounted behind RequireAuth. User is null when the provider has
+// no record of the UID.
+func (h *AuthHandlers) MeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := auth.TokenFromContext(r.Context())
+		if !ok || token == nil {
+			h.handlers().respondError(w, r, auth.ErrTokenNotInContext)
+			return
+		}
+
+		info, err := h.Provider.GetUserInfo(r.Context(), token.UID)
+		if err != nil && !errors.Is(err, auth.ErrUserNotFound) {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+
+		resp := meResponse{
+			UID:           token.UID,
+			Email:         token.Email,
+			EmailVerified: token.EmailVerified,
+			Expiry:        token.Expiry,
+		}
+		if info != nil {
+			resp.User = &userInfoResponse{
+				UID:           info.UID,
+				Email:         info.Email,
+				EmailVerified: info.EmailVerified,
+				DisplayName:   info.DisplayName,
+				PhotoURL:      info.PhotoURL,
+			}
+		}
+
+		h.handlers().respond(w, http.StatusOK, resp)
+	}
+}
+
+// sessionCookie builds the session cookie; a negative maxAge deletes it
+func (h *AuthHandlers) sessionCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     auth.SessionCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   h.Cookie.Secure,
+		SameSite: h.Cookie.SameSite,
+	}
+}
@@ -0,0 +1,363 @@
+package httphandlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/auth/dbsession"
+	"github.com/mhpenta/starterA/internal/auth/dbthrottle"
+	"github.com/mhpenta/starterA/internal/auth/localauth"
+	"github.com/mhpenta/starterA/internal/auth/magiclink"
+	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database/memdb"
+	"github.com/mhpenta/starterA/internal/database/repo"
+	"github.com/mhpenta/starterA/internal/mail"
+	"github.com/mhpenta/starterA/internal/problem"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestCreateSessionHandlerSetsCookie(t *testing.T) {
+	router := newAuthTestRouter(auth.NewMockProvider())
+
+	rec := doRequest(router, http.MethodPost, "/auth/session", `{"id_token":"test-token-1"}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+
+	cookie := sessionCookieFrom(t, rec)
+	if cookie.Value != "test-token-1" || !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("cookie = %#v, want HttpOnly, Secure, SameSite=Strict", cookie)
+	}
+	if cookie.MaxAge != 3600 || cookie.Path != "/" {
+		t.Fatalf("cookie MaxAge = %d, Path = %q, want 3600 and /", cookie.MaxAge, cookie.Path)
+	}
+}
+
+func TestCreateSessionHandlerRejectsInvalidToken(t *testing.T) {
+	router := newAuthTestRouter(auth.NewMockProvider())
+
+	for _, body := range []string{`{"id_token":"nope"}`, `{}`} {
+		rec := doRequest(router, http.MethodPost, "/auth/session", body)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("body %s: status = %d, want %d", body, rec.Code, http.StatusUnauthorized)
+		}
+		if p := decodeProblem(t, rec); p.Type != problem.TypeUnauthorized {
+			t.Fatalf("problem = %#v, want unauthorized", p)
+		}
+		if got := rec.Header().Get("WWW-Authenticate"); got != "Bearer" {
+			t.Fatalf("body %s: WWW-Authenticate = %q, want Bearer", body, got)
+		}
+		if len(rec.Result().Cookies()) != 0 {
+			t.Fatalf("cookies = %v, want none", rec.Result().Cookies())
+		}
+	}
+
+	if rec := doRequest(router, http.MethodPost, "/auth/session", `not json`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestCreateSessionHandlerThrottlesInvalidTokens(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	h := NewAuthHandlers(auth.NewMockProvider(), SessionCookieConfig{Lifetime: time.Hour}, logger)
+	h.Limiter = auth.NewLimiter(dbthrottle.New(memdb.New()), auth.LimiterConfig{
+		FreeAttempts: 1,
+		BaseDelay:    90 * time.Second,
+		Logger:       logger,
+	})
+	router := chi.NewRouter()
+	router.Post("/auth/session", h.CreateSessionHandler())
+
+	for range 2 {
+		if rec := doRequest(router, http.MethodPost, "/auth/session", `{"id_token":"nope"}`); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// Even a valid token is refused while the client IP is locked
+	rec := doRequest(router, http.MethodPost, "/auth/session", `{"id_token":"test-token-1"}`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusTooManyRequests, rec.Body.String())
+	}
+	if got := rec.Header().Get("Retry-After"); got != "90" {
+		t.Fatalf("Retry-After = %q, want 90", got)
+	}
+	if p := decodeProblem(t, rec); p.Type != problem.TypeTooManyRequests {
+		t.Fatalf("problem = %#v, want too-many-requests", p)
+	}
+}
+
+func TestRequestMagicLinkHandlerLimitsEachAddress(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager, err := magiclink.New(memdb.New(), magiclink.Config{
+		URL:    "https://example.com/auth/magic-link/verify",
+		Mailer: mail.NewLogMailer(logger),
+		Logger: logger,
+	})
+	if err != nil {
+		t.Fatalf("new magic links: %v", err)
+	}
+	h := NewAuthHandlers(auth.NewMockProvider(), SessionCookieConfig{Lifetime: time.Hour}, logger)
+	h.Limiter = auth.NewLimiter(dbthrottle.New(memdb.New()), auth.LimiterConfig{FreeAttempts: 1, Logger: logger})
+	h.MagicLink = MagicLinkConfig{Manager: manager}
+	router := chi.NewRouter()
+	router.Post("/auth/magic-link", h.RequestMagicLinkHandler())
+
+	if rec := doRequest(router, http.MethodPost, "/auth/magic-link", `{"email":""}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("empty email status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	for range 2 {
+		if rec := doRequest(router, http.MethodPost, "/auth/magic-link", `{"email":"marc@example.com"}`); rec.Code != http.StatusAccepted {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body.String())
+		}
+	}
+	if rec := doRequest(router, http.MethodPost, "/auth/magic-link", `{"email":"MARC@example.com"}`); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec := doRequest(router, http.MethodPost, "/auth/magic-link", `{"email":"other@example.com"}`); rec.Code != http.StatusAccepted {
+		t.Fatalf("other address status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+}
+
+func TestLoginHandlerNotFoundWithoutPasswordProvider(t *testing.T) {
+	router := newAuthTestRouter(auth.NewMockProvider())
+
+	rec := doRequest(router, http.MethodPost, "/auth/login", `{"username":"marc","password":"secret"}`)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestLoginHandlerThrottlesConcurrentFailures(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := memdb.New()
+	user, err := store.CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	provider, err := localauth.New(store, localauth.Config{
+		Secret: []byte(strings.Repeat("s", 32)),
+		Argon2: localauth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		Logger: logger,
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	if err := provider.SetPassword(ctx, user.ID, "correct horse battery"); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	h := NewAuthHandlers(provider, SessionCookieConfig{Lifetime: time.Hour}, logger)
+	h.Limiter = auth.NewLimiter(dbthrottle.New(store), auth.LimiterConfig{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		Logger:       logger,
+	})
+	router := chi.NewRouter()
+	router.Post("/auth/login", h.LoginHandler())
+
+	// A burst gets the free attempts and the one that starts the backoff,
+	// not one guess per request
+	codes := make([]int, 20)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Go(func() {
+			codes[i] = doRequest(router, http.MethodPost, "/auth/login", `{"username":"marc","password":"wrong password!"}`).Code
+		})
+	}
+	wg.Wait()
+
+	if got := countCodes(codes, http.StatusUnauthorized); got != 4 {
+		t.Fatalf("%d of %d guesses were checked, want 4: %v", got, len(codes), codes)
+	}
+	if got := countCodes(codes, http.StatusTooManyRequests); got != len(codes)-4 {
+		t.Fatalf("%d of %d guesses were throttled, want %d: %v", got, len(codes), len(codes)-4, codes)
+	}
+	rec := doRequest(router, http.MethodPost, "/auth/login", `{"username":"marc","password":"correct horse battery"}`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("login status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestStepUpHandlerChallengesWithoutToken(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	provider := auth.NewSessionManager(auth.NewMockProvider(), dbsession.New(memdb.New()), auth.SessionManagerConfig{Logger: logger})
+	h := NewAuthHandlers(provider, SessionCookieConfig{Lifetime: time.Hour}, logger)
+	router := chi.NewRouter()
+	router.Post("/auth/mfa", h.StepUpHandler())
+
+	// Mounted without RequireAuth, the handler itself answers 401
+	rec := doRequest(router, http.MethodPost, "/auth/mfa", `{"code":"123456"}`)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusUnauthorized, rec.Body.String())
+	}
+	if got := rec.Header().Get("WWW-Authenticate"); got != "Bearer" {
+		t.Fatalf("WWW-Authenticate = %q, want Bearer", got)
+	}
+}
+
+func TestMeHandlerReturnsTokenAndUserInfo(t *testing.T) {
+	router := newAuthTestRouter(auth.NewMockProvider())
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+	req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: "test-token-1"})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	var me meResponse
+	if err := json.NewDecoder(rec.Body).Decode(&me); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if me.UID != "user-1" || me.Email != "user1@example.com" || me.User == nil || me.User.UID != "user-1" {
+		t.Fatalf("me = %#v, want user-1 with user info", me)
+	}
+}
+
+func TestLocalLoginSessionLogoutFlow(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.New()
+	user, err := store.CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	provider, err := localauth.New(store, localauth.Config{
+		Secret: []byte(strings.Repeat("s", 32)),
+		Argon2: localauth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	if err := provider.SetPassword(ctx, user.ID, "correct horse battery"); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	router := newAuthTestRouter(provider)
+
+	rec := doRequest(router, http.MethodPost, "/auth/login", `{"username":"marc","password":"wrong password!"}`)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("bad login status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec = doRequest(router, http.MethodPost, "/auth/login", `{"username":"marc","password":"correct horse battery"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var login loginResponse
+	if err := json.NewDecoder(rec.Body).Decode(&login); err != nil || login.IDToken == "" {
+		t.Fatalf("login response = %#v, %v", login, err)
+	}
+
+	rec = doRequest(router, http.MethodPost, "/auth/session", `{"id_token":"`+login.IDToken+`"}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("session status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+	session := sessionCookieFrom(t, rec)
+
+	rec = doCookieRequest(router, http.MethodGet, "/auth/me", session)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("me status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	rec = doCookieRequest(router, http.MethodPost, "/auth/logout", session)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("logout status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if cleared := sessionCookieFrom(t, rec); cleared.Value != "" || cleared.MaxAge >= 0 {
+		t.Fatalf("logout cookie = %#v, want cleared", cleared)
+	}
+
+	// The cookie is revoked server-side, not just cleared in the browser
+	if _, err := provider.VerifySessionCookieAndCheckRevoked(ctx, session.Value); !auth.IsRevokedError(err) {
+		t.Fatalf("err = %v, want revoked", err)
+	}
+}
+
+func TestSessionCookieConfigFromAuth(t *testing.T) {
+	if _, err := SessionCookieConfigFromAuth(configAuth("none", false)); err == nil {
+		t.Fatal("SameSite=None without Secure: want error")
+	}
+	if _, err := SessionCookieConfigFromAuth(configAuth("sideways", true)); err == nil {
+		t.Fatal("unknown SameSite: want error")
+	}
+	c, err := SessionCookieConfigFromAuth(configAuth("Strict", true))
+	if err != nil || c.SameSite != http.SameSiteStrictMode || c.Lifetime != time.Hour {
+		t.Fatalf("config = %#v, %v", c, err)
+	}
+}
+
+func TestCSRFConfigFromServer(t *testing.T) {
+	got := CSRFConfigFromServer(config.Server{
+		AllowedCorsURLs: []string{"http://localhost:3000"},
+		ServerDomain:    "example.com",
+		EnableHTTPS:     true,
+	}).TrustedOrigins
+
+	want := []string{"http://localhost:3000", "https://example.com"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("TrustedOrigins = %v, want %v", got, want)
+	}
+}
+
+func newAuthTestRouter(provider auth.Servicer) http.Handler {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	h := NewAuthHandlers(provider, SessionCookieConfig{
+		Lifetime: time.Hour,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	}, logger)
+
+	r := chi.NewRouter()
+	r.Post("/auth/login", h.LoginHandler())
+	r.Post("/auth/session", h.CreateSessionHandler())
+	r.Post("/auth/logout", h.LogoutHandler())
+	r.With(auth.RequireAuth(provider)).Get("/auth/me", h.MeHandler())
+	return r
+}
+
+func sessionCookieFrom(t *testing.T, rec *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == auth.SessionCookieName {
+			return c
+		}
+	}
+	t.Fatalf("no %s cookie in response", auth.SessionCookieName)
+	return nil
+}
+
+func doCookieRequest(handler http.Handler, method, target string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func countCodes(codes []int, code int) int {
+	count := 0
+	for _, c := range codes {
+		if c == code {
+			count++
+		}
+	}
+	return count
+}
+
+func configAuth(sameSite string, secure bool) config.Auth {
+	return config.Auth{SessionLifetimeSeconds: 3600, CookieSecure: secure, CookieSameSite: sameSite}
+}
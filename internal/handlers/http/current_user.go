@@ -0,0 +1,48 @@
+package httphandlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/service"
+)
+
+// LoadCurrentUser is middleware that resolves the caller's local user,
+// provisioning it on first use, and stores it in the request context for
+// service.UserFromContext. Roles granted to the user are added to the
+// request's token with Service.ContextWithUserRoles, so role middleware sees
+// them. It must run after auth middleware. Anonymous callers, API keys and
+// identities that cannot be provisioned continue without a user.
+func (h *HTTPHandlers) LoadCurrentUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := h.Service.ResolveUser(r.Context())
+		switch {
+		case err == nil:
+			ctx, err := h.Service.ContextWithUserRoles(r.Context(), user)
+			if err != nil {
+				h.respondError(w, r, err)
+				return
+			}
+			r = r.WithContext(service.ContextWithUser(ctx, user))
+		case errors.Is(err, service.ErrUserNotFound), errors.Is(err, auth.ErrTokenNotInContext):
+		default:
+			h.respondError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CurrentUserHandler returns an HTTP handler for the caller's local user
+func (h *HTTPHandlers) CurrentUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := h.Service.CurrentUser(r.Context())
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		h.respond(w, http.StatusOK, user)
+	}
+}
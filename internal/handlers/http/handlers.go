@@ -2,10 +2,20 @@ package httphandlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/auth/localauth"
+	"github.com/mhpenta/starterA/internal/auth/magiclink"
+	"github.com/mhpenta/starterA/internal/auth/oauth"
+	"github.com/mhpenta/starterA/internal/problem"
 	"github.com/mhpenta/starterA/internal/service"
+
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 // HTTPHandlers handles HTTP-specific request/response logic
@@ -33,24 +43,139 @@ func (h *HTTPHandlers) respond(w http.ResponseWriter, status int, data interface
 	}
 }
 
-// respondError sends a JSON error response
-func (h *HTTPHandlers) respondError(w http.ResponseWriter, status int, message string) {
-	h.respond(w, status, map[string]string{"error": message})
+// requestError is a malformed request detected by the handler itself,
+// such as an unparsable path parameter or JSON body
+type requestError struct {
+	detail string
+	err    error
 }
 
-// badRequest logs and returns a 400 Bad Request response
-func (h *HTTPHandlers) badRequest(w http.ResponseWriter, err error) {
-	h.Logger.Warn("Bad request", "error", err)
-	h.respondError(w, http.StatusBadRequest, "Invalid request")
+func (e *requestError) Error() string {
+	if e.err == nil {
+		return e.detail
+	}
+	return e.detail + ": " + e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// respondError maps err to an RFC 7807 problem response. It is the single
+// place where service errors are translated into HTTP statuses. Every 401
+// carries a WWW-Authenticate challenge, as from the auth middleware.
+func (h *HTTPHandlers) respondError(w http.ResponseWriter, r *http.Request, err error) {
+	p := problemFor(err)
+	p.Instance = middleware.GetReqID(r.Context())
+	var throttledErr *auth.ThrottledError
+	if errors.As(err, &throttledErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttledErr.RetryAfter.Seconds()))))
+	}
+	if p.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", auth.Challenge(r, err))
+	}
+	if p.Status >= http.StatusInternalServerError {
+		h.Logger.Error("Server error", "error", err, "request_id", p.Instance)
+	} else {
+		h.Logger.Warn("Request failed", "status", p.Status, "error", err, "request_id", p.Instance)
+	}
+
+	if err := problem.Write(w, r, p); err != nil {
+		h.Logger.Error("Failed to encode response", "error", err)
+	}
 }
 
-// notFound returns a 404 Not Found response
-func (h *HTTPHandlers) notFound(w http.ResponseWriter) {
-	h.respondError(w, http.StatusNotFound, "Resource not found")
+// problemFor converts an error into problem details
+func problemFor(err error) *problem.Details {
+	var (
+		reqErr        *requestError
+		validationErr *service.ValidationError
+		conflictErr   *service.UserConflictError
+		forbiddenErr  *auth.ForbiddenError
+		providerErr   *oauth.ProviderError
+		policyErr     *localauth.PasswordPolicyError
+	)
+
+	switch {
+	case errors.As(err, &reqErr):
+		return problem.New(http.StatusBadRequest, problem.TypeBadRequest, reqErr.detail)
+
+	case errors.As(err, &validationErr):
+		p := problem.New(http.StatusBadRequest, problem.TypeValidation, validationErr.Detail)
+		p.Title = "Validation failed"
+		if p.Detail == "" {
+			p.Detail = "One or more fields are invalid"
+		}
+		for _, f := range validationErr.Fields {
+			p.Errors = append(p.Errors, problem.FieldError{Field: f.Field, Message: f.Message})
+		}
+		return p
+
+	case errors.As(err, &policyErr):
+		p := problem.New(http.StatusBadRequest, problem.TypeValidation, "New password does not meet the password policy")
+		p.Title = "Validation failed"
+		for _, violation := range policyErr.Violations {
+			p.Errors = append(p.Errors, problem.FieldError{Field: "new_password", Message: violation})
+		}
+		return p
+
+	case errors.As(err, &conflictErr):
+		p := problem.New(http.StatusConflict, problem.TypeConflict, conflictErr.Field+" is already taken")
+		p.Errors = []problem.FieldError{{Field: conflictErr.Field, Message: "is already taken"}}
+		return p
+
+	case errors.Is(err, service.ErrInvalidUserInput):
+		return problem.New(http.StatusBadRequest, problem.TypeValidation, "Invalid user input")
+
+	case errors.Is(err, service.ErrUserNotFound):
+		return problem.New(http.StatusNotFound, problem.TypeNotFound, "User not found")
+
+	case errors.Is(err, service.ErrAPIKeyNotFound):
+		return problem.New(http.StatusNotFound, problem.TypeNotFound, "API key not found")
+
+	case errors.Is(err, service.ErrMFANotEnabled):
+		return problem.New(http.StatusConflict, problem.TypeConflict, "Multi-factor authentication is not enabled")
+
+	case errors.Is(err, service.ErrMFAAlreadyEnabled):
+		return problem.New(http.StatusConflict, problem.TypeConflict, "Multi-factor authentication is already enabled")
+
+	case errors.As(err, &forbiddenErr):
+		return problem.New(http.StatusForbidden, problem.TypeForbidden, "Forbidden: "+forbiddenErr.Reason)
+
+	case errors.Is(err, errLockoutNotFound):
+		return problem.New(http.StatusNotFound, problem.TypeNotFound, "No failed attempts recorded for key")
+
+	case errors.Is(err, auth.ErrThrottled):
+		return problem.New(http.StatusTooManyRequests, problem.TypeTooManyRequests, "Too many failed attempts, try again later")
+
+	case errors.Is(err, magiclink.ErrInvalidLink):
+		return problem.New(http.StatusUnauthorized, problem.TypeUnauthorized, "Invalid or expired sign-in link")
+
+	case errors.Is(err, oauth.ErrUnknownProvider):
+		return problem.New(http.StatusNotFound, problem.TypeNotFound, "Unknown login provider")
+
+	case errors.Is(err, oauth.ErrInvalidState):
+		return problem.New(http.StatusUnauthorized, problem.TypeUnauthorized, "Invalid or expired sign-in attempt")
+
+	case errors.As(err, &providerErr):
+		return problem.New(http.StatusUnauthorized, problem.TypeUnauthorized, "Sign-in with the provider failed: "+providerErr.Code)
+
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		return problem.New(http.StatusUnauthorized, problem.TypeUnauthorized, "Invalid username or password")
+
+	case errors.Is(err, auth.ErrMissingToken), errors.Is(err, auth.ErrTokenNotInContext):
+		return problem.New(http.StatusUnauthorized, problem.TypeUnauthorized, "Authentication required")
+
+	case auth.IsExpiredError(err), auth.IsRevokedError(err), auth.IsInvalidError(err):
+		// Do not reveal which check failed
+		return problem.New(http.StatusUnauthorized, problem.TypeUnauthorized, "Invalid or expired credentials")
+
+	default:
+		return problem.New(http.StatusInternalServerError, problem.TypeInternal, "")
+	}
 }
 
-// serverError logs and returns a 500 Internal Server Error response
-func (h *HTTPHandlers) serverError(w http.ResponseWriter, err error) {
-	h.Logger.Error("Server error", "error", err)
-	h.respondError(w, http.StatusInternalServerError, "Internal server error")
+// badRequest returns a 400 Bad Request problem for a malformed request
+func (h *HTTPHandlers) badRequest(w http.ResponseWriter, r *http.Request, detail string, err error) {
+	h.respondError(w, r, &requestError{detail: detail, err: err})
 }
@@ -0,0 +1,52 @@
+package httphandlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// errLockoutNotFound is returned when unlocking a key without failures
+var errLockoutNotFound = errors.New("lockout not found")
+
+// ListLockoutsHandler returns an HTTP handler listing the limiter keys that
+// are currently locked. It must be mounted only when a Limiter is set.
+func (h *AuthHandlers) ListLockoutsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		records, err := h.Limiter.Locked(r.Context())
+		if err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+
+		h.handlers().respond(w, http.StatusOK, records)
+	}
+}
+
+// UnlockHandler returns an HTTP handler that clears the failures of the
+// limiter key in the path, such as user:alice or ip:192.0.2.1, lifting any
+// lockout on it
+func (h *AuthHandlers) UnlockHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := url.PathUnescape(chi.URLParam(r, "key"))
+		if err != nil || key == "" {
+			h.handlers().badRequest(w, r, "Invalid lockout key", err)
+			return
+		}
+
+		found, err := h.Limiter.Unlock(r.Context(), key)
+		if err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+		if !found {
+			h.handlers().respondError(w, r, errLockoutNotFound)
+			return
+		}
+
+		h.Logger.Info("Unlocked auth throttle", "key", key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
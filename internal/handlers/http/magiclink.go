@@ -0,0 +1,115 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/auth/magiclink"
+)
+
+// MagicLinkConfig controls passwordless login by email
+type MagicLinkConfig struct {
+	// Manager sends and consumes links; nil disables magic links
+	Manager *magiclink.Manager
+	// RedirectURL is where a verified link sends the browser; empty means "/"
+	RedirectURL string
+}
+
+type magicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// RequestMagicLinkHandler returns an HTTP handler that emails a sign-in link
+// to a registered address. It answers 202 whether or not the address is
+// registered. Every request counts against the address in the Limiter, so
+// one inbox cannot be flooded.
+func (h *AuthHandlers) RequestMagicLinkHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.MagicLink.Manager == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		var input magicLinkRequest
+		err := json.NewDecoder(r.Body).Decode(&input)
+		if err != nil || strings.TrimSpace(input.Email) == "" {
+			h.handlers().badRequest(w, r, "Request body must be a JSON object with an email", err)
+			return
+		}
+
+		emailKey := auth.ThrottleKeyEmail(input.Email)
+		ipKey := auth.ThrottleKeyIP(auth.SessionMetadataFromRequest(r).IP)
+		if err := h.Limiter.Check(r.Context(), emailKey, ipKey); err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+		// Every link sent counts against the address, not the client IP
+		h.Limiter.Release(r.Context(), ipKey)
+
+		if err := h.MagicLink.Manager.Send(r.Context(), input.Email); err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// VerifyMagicLinkHandler returns an HTTP handler that consumes the token of
+// a sign-in link, sets a session cookie for its user and redirects to
+// RedirectURL. It needs a provider implementing auth.IDTokenIssuer. Invalid
+// tokens count against the client IP.
+func (h *AuthHandlers) VerifyMagicLinkHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		issuer, ok := auth.Lookup[auth.IDTokenIssuer](h.Provider)
+		if !ok || h.MagicLink.Manager == nil {
+			http.NotFound(w, r)
+			return
+		}
+		// Keep the token out of the Referer of the next page
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		metadata := auth.SessionMetadataFromRequest(r)
+		ipKey := auth.ThrottleKeyIP(metadata.IP)
+		if err := h.Limiter.Check(r.Context(), ipKey); err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+
+		uid, err := h.MagicLink.Manager.Consume(r.Context(), r.URL.Query().Get(magiclink.TokenParam))
+		if err != nil {
+			if !errors.Is(err, magiclink.ErrInvalidLink) {
+				h.Limiter.Release(r.Context(), ipKey)
+			}
+			h.handlers().respondError(w, r, err)
+			return
+		}
+		h.Limiter.Release(r.Context(), ipKey)
+
+		idToken, err := issuer.IssueIDToken(r.Context(), uid)
+		if err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+		ctx := auth.ContextWithSessionMetadata(r.Context(), metadata)
+		cookie, err := h.Provider.CreateSessionCookie(ctx, idToken, h.Cookie.Lifetime)
+		if err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+		// A used link ends the sending limit for its address
+		if info, err := h.Provider.GetUserInfo(r.Context(), uid); err == nil {
+			h.Limiter.Succeed(r.Context(), auth.ThrottleKeyEmail(info.Email))
+		}
+
+		redirect := h.MagicLink.RedirectURL
+		if redirect == "" {
+			redirect = "/"
+		}
+		http.SetCookie(w, h.sessionCookie(cookie, int(h.Cookie.maxAge().Seconds())))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,161 @@
+package httphandlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/service"
+)
+
+// MFAVerifier checks a second factor code for the caller in ctx.
+// *service.Service implements it.
+type MFAVerifier interface {
+	VerifyMFA(ctx context.Context, code string) error
+}
+
+// MFAConfig controls multi-factor step-up
+type MFAConfig struct {
+	// Verifier checks codes for POST /auth/mfa; nil disables step-up
+	Verifier MFAVerifier
+	// MaxAge is how long a step-up lasts for routes that need one
+	MaxAge time.Duration
+	// Required makes sensitive routes, such as deleting users, need a
+	// step-up; managing MFA itself always does
+	Required bool
+}
+
+// MFAStatusHandler returns an HTTP handler reporting the caller's second
+// factor
+func (h *HTTPHandlers) MFAStatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := h.Service.GetMFAStatus(r.Context())
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		h.respond(w, http.StatusOK, status)
+	}
+}
+
+// EnrollTOTPHandler returns an HTTP handler that starts TOTP enrollment
+func (h *HTTPHandlers) EnrollTOTPHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enrollment, err := h.Service.EnrollTOTP(r.Context())
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		h.respond(w, http.StatusCreated, enrollment)
+	}
+}
+
+// ConfirmTOTPHandler returns an HTTP handler that enables TOTP once given a
+// valid code and answers with the recovery codes
+func (h *HTTPHandlers) ConfirmTOTPHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input service.MFACodeInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			h.badRequest(w, r, "Request body must be a JSON object with a code", err)
+			return
+		}
+
+		codes, err := h.Service.ConfirmTOTP(r.Context(), &input)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		h.respond(w, http.StatusOK, codes)
+	}
+}
+
+// RegenerateRecoveryCodesHandler returns an HTTP handler that replaces the
+// caller's recovery codes
+func (h *HTTPHandlers) RegenerateRecoveryCodesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		codes, err := h.Service.RegenerateRecoveryCodes(r.Context())
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		h.respond(w, http.StatusOK, codes)
+	}
+}
+
+// DisableTOTPHandler returns an HTTP handler that removes the caller's TOTP
+// secret and recovery codes
+func (h *HTTPHandlers) DisableTOTPHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.Service.DisableTOTP(r.Context()); err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// StepUpHandler returns an HTTP handler that checks a TOTP or recovery code
+// and records the second factor on the caller's session, so auth.RequireMFA
+// lets it through. It must be mounted behind RequireAuth and only works for
+// session cookies from a provider that implements auth.StepUpRecorder.
+// Failed codes count against the caller's UID in the Limiter.
+func (h *AuthHandlers) StepUpHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder, ok := auth.Lookup[auth.StepUpRecorder](h.Provider)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		token, ok := auth.TokenFromContext(r.Context())
+		if !ok || token == nil {
+			h.handlers().respondError(w, r, auth.ErrTokenNotInContext)
+			return
+		}
+		// The step-up is recorded on the cookie's session, so it must be the
+		// session that authenticated the request
+		cookie, err := r.Cookie(auth.SessionCookieName)
+		if err != nil || cookie.Value == "" {
+			h.handlers().badRequest(w, r, "MFA step-up needs a session cookie", err)
+			return
+		}
+		if session, err := h.Provider.VerifySessionCookie(r.Context(), cookie.Value); err != nil || session.UID != token.UID {
+			h.handlers().badRequest(w, r, "MFA step-up needs a session cookie", err)
+			return
+		}
+
+		var input service.MFACodeInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			h.handlers().badRequest(w, r, "Request body must be a JSON object with a code", err)
+			return
+		}
+
+		uidKey := auth.ThrottleKeyUID(token.UID)
+		if err := h.Limiter.Check(r.Context(), uidKey); err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+		if err := h.MFA.Verifier.VerifyMFA(r.Context(), input.Code); err != nil {
+			if !errors.Is(err, service.ErrInvalidMFACode) {
+				h.Limiter.Release(r.Context(), uidKey)
+			}
+			h.handlers().respondError(w, r, err)
+			return
+		}
+		h.Limiter.Succeed(r.Context(), uidKey)
+
+		if err := recorder.RecordStepUp(r.Context(), cookie.Value, time.Now()); err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
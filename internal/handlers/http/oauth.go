@@ -0,0 +1,142 @@
+package httphandlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/auth/oauth"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+// oauthStateCookieName is the cookie binding a login's state to the browser
+// that started it
+const oauthStateCookieName = "oauth_state"
+
+// IdentityProvisioner links an identity from an external login provider to
+// a local user. *service.Service implements it.
+type IdentityProvisioner interface {
+	ProvisionIdentity(ctx context.Context, provider string, token *auth.Token) (*repo.User, error)
+}
+
+// OAuthConfig controls login with external OAuth providers
+type OAuthConfig struct {
+	// Manager runs the authorization code flow; nil disables OAuth login
+	Manager *oauth.Manager
+	// Provisioner finds or creates the local user for each login
+	Provisioner IdentityProvisioner
+	// RedirectURL is where a completed login sends the browser; empty means "/"
+	RedirectURL string
+}
+
+// OAuthLoginHandler returns an HTTP handler that starts a login with the
+// provider named in the path. It binds the state to the browser with a
+// short-lived cookie and redirects to the provider.
+func (h *AuthHandlers) OAuthLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.OAuth.Manager == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		authURL, state, err := h.OAuth.Manager.Start(r.Context(), chi.URLParam(r, "provider"))
+		if err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+
+		http.SetCookie(w, h.oauthStateCookie(state, 0))
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// OAuthCallbackHandler returns an HTTP handler for the provider's redirect
+// back to the app. It checks the state against the cookie set by
+// OAuthLoginHandler, exchanges the code, links the identity to a local user,
+// sets a session cookie for that user and redirects to RedirectURL. It needs
+// a provider implementing auth.IDTokenIssuer. Invalid states and tokens
+// count against the client IP.
+func (h *AuthHandlers) OAuthCallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		issuer, ok := auth.Lookup[auth.IDTokenIssuer](h.Provider)
+		if !ok || h.OAuth.Manager == nil || h.OAuth.Provisioner == nil {
+			http.NotFound(w, r)
+			return
+		}
+		// Keep the code out of the Referer of the next page
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		// Each state works once, whatever the outcome
+		http.SetCookie(w, h.oauthStateCookie("", -1))
+
+		metadata := auth.SessionMetadataFromRequest(r)
+		ipKey := auth.ThrottleKeyIP(metadata.IP)
+		if err := h.Limiter.Check(r.Context(), ipKey); err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+
+		query := r.URL.Query()
+		if code := query.Get("error"); code != "" {
+			h.Limiter.Release(r.Context(), ipKey)
+			h.handlers().respondError(w, r, &oauth.ProviderError{Code: code, Description: query.Get("error_description")})
+			return
+		}
+		state := query.Get("state")
+		if cookie, err := r.Cookie(oauthStateCookieName); err != nil || cookie.Value != state {
+			h.handlers().respondError(w, r, oauth.ErrInvalidState)
+			return
+		}
+
+		name := chi.URLParam(r, "provider")
+		token, err := h.OAuth.Manager.Finish(r.Context(), name, state, query.Get("code"))
+		if err != nil {
+			if !errors.Is(err, oauth.ErrInvalidState) && !auth.IsInvalidError(err) && !auth.IsExpiredError(err) {
+				h.Limiter.Release(r.Context(), ipKey)
+			}
+			h.handlers().respondError(w, r, err)
+			return
+		}
+		h.Limiter.Release(r.Context(), ipKey)
+
+		user, err := h.OAuth.Provisioner.ProvisionIdentity(r.Context(), "oauth:"+name, token)
+		if err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+		idToken, err := issuer.IssueIDToken(r.Context(), strconv.FormatInt(user.ID, 10))
+		if err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+		ctx := auth.ContextWithSessionMetadata(r.Context(), metadata)
+		cookie, err := h.Provider.CreateSessionCookie(ctx, idToken, h.Cookie.Lifetime)
+		if err != nil {
+			h.handlers().respondError(w, r, err)
+			return
+		}
+
+		redirect := h.OAuth.RedirectURL
+		if redirect == "" {
+			redirect = "/"
+		}
+		http.SetCookie(w, h.sessionCookie(cookie, int(h.Cookie.maxAge().Seconds())))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+	}
+}
+
+// oauthStateCookie is limited to the auth routes. It must be sent on the
+// cross-site redirect back from the provider, so SameSite is always Lax.
+func (h *AuthHandlers) oauthStateCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    value,
+		Path:     "/auth",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   h.Cookie.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -0,0 +1,175 @@
+package httphandlers
+
+import (
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/service"
+)
+
+// userListParams is the whitelist of query parameters accepted by GET /api/users.
+// Anything else is rejected so typos do not silently return unfiltered data.
+//
+//	limit=<1..500>                       page size
+//	offset=<n>                           number of users to skip; not allowed with cursor
+//	cursor=<opaque>                      next_cursor or prev_cursor from a previous page; empty for
+//	                                     the first page. Switches the response to a page object.
+//	sort=[-]<field>                      id, username, email, created_at or updated_at; "-" for descending
+//	username_prefix=<text>               case-insensitive prefix match
+//	email_prefix=<text>                  case-insensitive prefix match
+//	created_after=<time>                 inclusive lower bound, RFC 3339 or YYYY-MM-DD
+//	created_before=<time>                exclusive upper bound
+//	updated_after=<time>                 inclusive lower bound
+//	updated_before=<time>                exclusive upper bound
+var userListParams = map[string]bool{
+	"limit":           true,
+	"offset":          true,
+	"cursor":          true,
+	"sort":            true,
+	"username_prefix": true,
+	"email_prefix":    true,
+	"created_after":   true,
+	"created_before":  true,
+	"updated_after":   true,
+	"updated_before":  true,
+}
+
+var userSortParams = map[string]service.UserSortField{
+	"id":         service.SortByID,
+	"username":   service.SortByUsername,
+	"email":      service.SortByEmail,
+	"created_at": service.SortByCreatedAt,
+	"updated_at": service.SortByUpdatedAt,
+}
+
+// parseUserListQuery parses the GET /api/users query string into a filter and
+// page request, returning a validation error naming every bad parameter
+func parseUserListQuery(query url.Values) (*service.UserFilter, *service.PageInput, error) {
+	filter := &service.UserFilter{}
+	page := &service.PageInput{}
+	verr := &service.ValidationError{Err: service.ErrInvalidUserFilter, Detail: "Invalid query parameters"}
+	invalid := func(field, message string) {
+		verr.Fields = append(verr.Fields, service.FieldError{Field: field, Message: message})
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(query)) {
+		values := query[key]
+		if !userListParams[key] {
+			invalid(key, "is not a supported parameter")
+			continue
+		}
+		if len(values) != 1 {
+			invalid(key, "must be given once")
+		}
+	}
+
+	if l := query.Get("limit"); l != "" {
+		limit, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || limit < 1 {
+			invalid("limit", "must be a positive integer")
+		}
+		page.Limit = limit
+	}
+	if o := query.Get("offset"); o != "" {
+		offset, err := strconv.ParseInt(o, 10, 64)
+		if err != nil || offset < 0 {
+			invalid("offset", "must be a non-negative integer")
+		}
+		page.Offset = offset
+	}
+	page.Cursor = query.Get("cursor")
+
+	if s := query.Get("sort"); s != "" {
+		name := strings.TrimPrefix(s, "-")
+		field, ok := userSortParams[name]
+		if !ok {
+			invalid("sort", "must be one of id, username, email, created_at, updated_at, optionally prefixed with -")
+		}
+		filter.SortBy = field
+		filter.Descending = strings.HasPrefix(s, "-")
+	}
+
+	filter.UsernamePrefix = query.Get("username_prefix")
+	filter.EmailPrefix = query.Get("email_prefix")
+
+	times := []struct {
+		name string
+		dest *time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+		{"updated_after", &filter.UpdatedAfter},
+		{"updated_before", &filter.UpdatedBefore},
+	}
+	for _, t := range times {
+		v := query.Get(t.name)
+		if v == "" {
+			continue
+		}
+		parsed, err := parseTimeParam(v)
+		if err != nil {
+			invalid(t.name, "must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+			continue
+		}
+		*t.dest = parsed
+	}
+
+	if len(verr.Fields) > 0 {
+		return nil, nil, verr
+	}
+	return filter, page, nil
+}
+
+func parseTimeParam(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
+
+// userSearchParams is the whitelist of query parameters accepted by
+// GET /api/users/search.
+//
+//	q=<text>                             whitespace-separated terms, all of which must match
+//	limit=<1..100>                       number of results
+var userSearchParams = map[string]bool{
+	"q":     true,
+	"limit": true,
+}
+
+// parseUserSearchQuery parses the GET /api/users/search query string
+func parseUserSearchQuery(query url.Values) (*service.UserSearchInput, error) {
+	input := &service.UserSearchInput{Query: query.Get("q")}
+	verr := &service.ValidationError{Err: service.ErrInvalidSearch, Detail: "Invalid query parameters"}
+	invalid := func(field, message string) {
+		verr.Fields = append(verr.Fields, service.FieldError{Field: field, Message: message})
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(query)) {
+		values := query[key]
+		if !userSearchParams[key] {
+			invalid(key, "is not a supported parameter")
+			continue
+		}
+		if len(values) != 1 {
+			invalid(key, "must be given once")
+		}
+	}
+
+	if l := query.Get("limit"); l != "" {
+		limit, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || limit < 1 {
+			invalid("limit", "must be a positive integer")
+		}
+		input.Limit = limit
+	}
+
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+	return input, nil
+}
@@ -2,8 +2,9 @@ package httphandlers
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/mhpenta/starterA/internal/service"
@@ -11,32 +12,79 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-// GetUsersHandler returns an HTTP handler for listing users
+// GetUsersHandler returns an HTTP handler for listing users.
+// See userListParams for the accepted query parameters. By default it
+// responds with an array of users selected with limit and offset, and the
+// number of users matching the filter in an X-Total-Count header. With a
+// cursor parameter, empty for the first page, it responds with a
+// service.UserPage instead, and neighbouring pages are advertised in RFC
+// 8288 Link headers.
 func (h *HTTPHandlers) GetUsersHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Default pagination
-		limit := int64(100)
-		offset := int64(0)
-
-		// Parse query parameters
-		if l := r.URL.Query().Get("limit"); l != "" {
-			if parsedLimit, err := strconv.ParseInt(l, 10, 64); err == nil && parsedLimit > 0 {
-				limit = parsedLimit
-			}
+		query := r.URL.Query()
+		filter, input, err := parseUserListQuery(query)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
 		}
-		if o := r.URL.Query().Get("offset"); o != "" {
-			if parsedOffset, err := strconv.ParseInt(o, 10, 64); err == nil && parsedOffset >= 0 {
-				offset = parsedOffset
+
+		if !query.Has("cursor") {
+			users, total, err := h.Service.GetUsers(r.Context(), filter, input)
+			if err != nil {
+				h.respondError(w, r, err)
+				return
 			}
+			w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+			h.respond(w, http.StatusOK, users)
+			return
 		}
 
-		users, err := h.Service.GetUsers(r.Context(), limit, offset)
+		page, err := h.Service.GetUsersPage(r.Context(), filter, input)
 		if err != nil {
-			h.serverError(w, err)
+			h.respondError(w, r, err)
 			return
 		}
 
-		h.respond(w, http.StatusOK, users)
+		setPageLinks(w, r, input.Limit, page.NextCursor, page.PrevCursor)
+		h.respond(w, http.StatusOK, page)
+	}
+}
+
+// SearchUsersHandler returns an HTTP handler for full-text user search.
+// See userSearchParams for the accepted query parameters.
+func (h *HTTPHandlers) SearchUsersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		input, err := parseUserSearchQuery(r.URL.Query())
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		results, err := h.Service.SearchUsers(r.Context(), input)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		h.respond(w, http.StatusOK, results)
+	}
+}
+
+// setPageLinks adds RFC 8288 Link headers pointing at the next and previous pages
+func setPageLinks(w http.ResponseWriter, r *http.Request, limit int64, next, prev string) {
+	link := func(cursor, rel string) string {
+		query := r.URL.Query()
+		query.Set("limit", strconv.FormatInt(limit, 10))
+		query.Set("cursor", cursor)
+		u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		return fmt.Sprintf("<%s>; rel=%q", u.String(), rel)
+	}
+
+	if next != "" {
+		w.Header().Add("Link", link(next, "next"))
+	}
+	if prev != "" {
+		w.Header().Add("Link", link(prev, "prev"))
 	}
 }
 
@@ -45,17 +93,13 @@ func (h *HTTPHandlers) CreateUserHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var input service.CreateUserInput
 		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-			h.badRequest(w, err)
+			h.badRequest(w, r, "Request body must be a valid JSON user object", err)
 			return
 		}
 
 		user, err := h.Service.CreateUser(r.Context(), &input)
 		if err != nil {
-			if errors.Is(err, service.ErrInvalidUserInput) {
-				h.badRequest(w, err)
-				return
-			}
-			h.serverError(w, err)
+			h.respondError(w, r, err)
 			return
 		}
 
@@ -66,20 +110,14 @@ func (h *HTTPHandlers) CreateUserHandler() http.HandlerFunc {
 // GetUserHandler returns an HTTP handler for getting a single user
 func (h *HTTPHandlers) GetUserHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idStr := chi.URLParam(r, "id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
-		if err != nil {
-			h.badRequest(w, err)
+		id, ok := h.userID(w, r)
+		if !ok {
 			return
 		}
 
 		user, err := h.Service.GetUser(r.Context(), id)
 		if err != nil {
-			if errors.Is(err, service.ErrUserNotFound) {
-				h.notFound(w)
-				return
-			}
-			h.serverError(w, err)
+			h.respondError(w, r, err)
 			return
 		}
 
@@ -90,30 +128,20 @@ func (h *HTTPHandlers) GetUserHandler() http.HandlerFunc {
 // UpdateUserHandler returns an HTTP handler for updating a user
 func (h *HTTPHandlers) UpdateUserHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idStr := chi.URLParam(r, "id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
-		if err != nil {
-			h.badRequest(w, err)
+		id, ok := h.userID(w, r)
+		if !ok {
 			return
 		}
 
 		var input service.UpdateUserInput
 		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-			h.badRequest(w, err)
+			h.badRequest(w, r, "Request body must be a valid JSON user object", err)
 			return
 		}
 
 		user, err := h.Service.UpdateUser(r.Context(), id, &input)
 		if err != nil {
-			if errors.Is(err, service.ErrInvalidUserInput) {
-				h.badRequest(w, err)
-				return
-			}
-			if errors.Is(err, service.ErrUserNotFound) {
-				h.notFound(w)
-				return
-			}
-			h.serverError(w, err)
+			h.respondError(w, r, err)
 			return
 		}
 
@@ -124,23 +152,27 @@ func (h *HTTPHandlers) UpdateUserHandler() http.HandlerFunc {
 // DeleteUserHandler returns an HTTP handler for deleting a user
 func (h *HTTPHandlers) DeleteUserHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idStr := chi.URLParam(r, "id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
-		if err != nil {
-			h.badRequest(w, err)
+		id, ok := h.userID(w, r)
+		if !ok {
 			return
 		}
 
-		err = h.Service.DeleteUser(r.Context(), id)
-		if err != nil {
-			if errors.Is(err, service.ErrUserNotFound) {
-				h.notFound(w)
-				return
-			}
-			h.serverError(w, err)
+		if err := h.Service.DeleteUser(r.Context(), id); err != nil {
+			h.respondError(w, r, err)
 			return
 		}
 
 		h.respond(w, http.StatusNoContent, nil)
 	}
 }
+
+// userID parses the {id} path parameter, writing a 400 problem if it is invalid
+func (h *HTTPHandlers) userID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.badRequest(w, r, "User id must be an integer", err)
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,305 @@
+package httphandlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mhpenta/starterA/internal/database/memdb"
+	"github.com/mhpenta/starterA/internal/database/repo"
+	"github.com/mhpenta/starterA/internal/problem"
+	"github.com/mhpenta/starterA/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestGetUsersHandlerSetsLinkHeaders(t *testing.T) {
+	router := newTestRouter()
+	for _, name := range []string{"marc", "anna", "lena"} {
+		doRequest(router, http.MethodPost, "/users", `{"username":"`+name+`","email":"`+name+`@example.com"}`)
+	}
+
+	rec := doRequest(router, http.MethodGet, "/users?limit=2&cursor=", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var page service.UserPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("decode page: %v", err)
+	}
+	if len(page.Users) != 2 || page.NextCursor == "" {
+		t.Fatalf("page = %#v, want 2 users and a next cursor", page)
+	}
+
+	links := rec.Header().Values("Link")
+	if len(links) != 1 || !strings.Contains(links[0], `rel="next"`) || !strings.Contains(links[0], "cursor="+page.NextCursor) {
+		t.Fatalf("Link = %q, want next link with cursor", links)
+	}
+}
+
+func TestGetUsersHandlerFiltersAndSorts(t *testing.T) {
+	router := newTestRouter()
+	for _, name := range []string{"marc", "anna", "mara", "bob"} {
+		doRequest(router, http.MethodPost, "/users", `{"username":"`+name+`","email":"`+name+`@example.com"}`)
+	}
+
+	rec := doRequest(router, http.MethodGet, "/users?sort=-username&username_prefix=MA&created_after=2000-01-01&cursor=", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	var page service.UserPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("decode page: %v", err)
+	}
+	if page.Total != 2 || len(page.Users) != 2 {
+		t.Fatalf("page = %#v, want 2 users", page)
+	}
+	if page.Users[0].Username != "marc" || page.Users[1].Username != "mara" {
+		t.Fatalf("users = %s, %s, want marc, mara", page.Users[0].Username, page.Users[1].Username)
+	}
+}
+
+func TestGetUsersHandlerDefaultsToOffsetArray(t *testing.T) {
+	router := newTestRouter()
+	for _, name := range []string{"marc", "anna", "lena"} {
+		doRequest(router, http.MethodPost, "/users", `{"username":"`+name+`","email":"`+name+`@example.com"}`)
+	}
+
+	rec := doRequest(router, http.MethodGet, "/users?limit=1&offset=1", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	var users []repo.User
+	if err := json.NewDecoder(rec.Body).Decode(&users); err != nil {
+		t.Fatalf("decode users: %v", err)
+	}
+	if len(users) != 1 || users[0].Username != "anna" {
+		t.Fatalf("users = %#v, want anna", users)
+	}
+	if total := rec.Header().Get("X-Total-Count"); total != "3" {
+		t.Fatalf("X-Total-Count = %q, want 3", total)
+	}
+	if links := rec.Header().Values("Link"); len(links) != 0 {
+		t.Fatalf("Link = %q, want none", links)
+	}
+}
+
+func TestGetUsersHandlerRejectsMalformedParameters(t *testing.T) {
+	router := newTestRouter()
+
+	for _, target := range []string{
+		"/users?limit=abc",
+		"/users?limit=0",
+		"/users?limit=100000",
+		"/users?cursor=%21%21",
+		"/users?offset=-1",
+		"/users?offset=10&cursor=",
+		"/users?sort=password",
+		"/users?created_after=yesterday",
+		"/users?limit=1&limit=2",
+	} {
+		rec := doRequest(router, http.MethodGet, target, "")
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s status = %d, want %d", target, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetUsersHandlerSortsParameterErrors(t *testing.T) {
+	router := newTestRouter()
+
+	rec := doRequest(router, http.MethodGet, "/users?zeta=1&alpha=1&limit=1&limit=2&beta=1", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	var fields []string
+	for _, e := range decodeProblem(t, rec).Errors {
+		fields = append(fields, e.Field)
+	}
+	if got := strings.Join(fields, ","); got != "alpha,beta,limit,zeta" {
+		t.Fatalf("error fields = %s, want alpha,beta,limit,zeta", got)
+	}
+}
+
+func TestCreateUserHandlerReturnsConflictForDuplicateUsername(t *testing.T) {
+	router := newTestRouter()
+
+	rec := doRequest(router, http.MethodPost, "/users", `{"username":"marc","email":"marc@example.com"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+
+	rec = doRequest(router, http.MethodPost, "/users", `{"username":"marc","email":"other@example.com"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	p := decodeProblem(t, rec)
+	if p.Type != problem.TypeConflict || p.Status != http.StatusConflict {
+		t.Fatalf("problem = %#v, want conflict", p)
+	}
+	if len(p.Errors) != 1 || p.Errors[0].Field != "username" {
+		t.Fatalf("errors = %#v, want username", p.Errors)
+	}
+}
+
+func TestUpdateUserHandlerReturnsConflictForDuplicateEmail(t *testing.T) {
+	router := newTestRouter()
+
+	doRequest(router, http.MethodPost, "/users", `{"username":"marc","email":"marc@example.com"}`)
+	doRequest(router, http.MethodPost, "/users", `{"username":"anna","email":"anna@example.com"}`)
+
+	rec := doRequest(router, http.MethodPut, "/users/2", `{"username":"anna","email":"marc@example.com"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if p := decodeProblem(t, rec); len(p.Errors) != 1 || p.Errors[0].Field != "email" {
+		t.Fatalf("errors = %#v, want email", p.Errors)
+	}
+}
+
+func TestCreateUserHandlerReturnsFieldErrors(t *testing.T) {
+	router := middleware.RequestID(newTestRouter())
+
+	rec := doRequest(router, http.MethodPost, "/users", `{"username":"ma","email":"not-an-email"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != problem.ContentType {
+		t.Fatalf("Content-Type = %q, want %q", ct, problem.ContentType)
+	}
+
+	p := decodeProblem(t, rec)
+	if p.Type != problem.TypeValidation {
+		t.Fatalf("type = %q, want %q", p.Type, problem.TypeValidation)
+	}
+	if p.Instance == "" {
+		t.Fatal("instance is empty, want request ID")
+	}
+
+	fields := map[string]string{}
+	for _, e := range p.Errors {
+		fields[e.Field] = e.Message
+	}
+	if fields["username"] == "" || fields["email"] != "is invalid" {
+		t.Fatalf("errors = %#v, want username and email", p.Errors)
+	}
+}
+
+func TestGetUserHandlerRejectsNonNumericID(t *testing.T) {
+	router := newTestRouter()
+
+	rec := doRequest(router, http.MethodGet, "/users/abc", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if p := decodeProblem(t, rec); p.Type != problem.TypeBadRequest || p.Detail == "" {
+		t.Fatalf("problem = %#v, want bad request with detail", p)
+	}
+}
+
+func TestDeleteUserHandlerReturnsNotFoundForUnknownUser(t *testing.T) {
+	router := newTestRouter()
+
+	doRequest(router, http.MethodPost, "/users", `{"username":"marc","email":"marc@example.com"}`)
+
+	rec := doRequest(router, http.MethodDelete, "/users/1", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	rec = doRequest(router, http.MethodDelete, "/users/1", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestUpdateUserHandlerReturnsNotFoundForUnknownUser(t *testing.T) {
+	router := newTestRouter()
+
+	rec := doRequest(router, http.MethodPut, "/users/7", `{"username":"marc","email":"marc@example.com"}`)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestSearchUsersHandler(t *testing.T) {
+	router := newTestRouter()
+	for _, name := range []string{"marc", "anna", "marcella"} {
+		doRequest(router, http.MethodPost, "/users", `{"username":"`+name+`","email":"`+name+`@example.com"}`)
+	}
+
+	rec := doRequest(router, http.MethodGet, "/users/search?q=marc&limit=5", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	var results service.UserSearchResults
+	if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+		t.Fatalf("decode results: %v", err)
+	}
+	if len(results.Results) != 2 || results.Results[0].Username != "marc" {
+		t.Fatalf("results = %#v, want marc and marcella", results.Results)
+	}
+	if results.Results[0].UsernameHighlight != "<mark>marc</mark>" {
+		t.Fatalf("username highlight = %q", results.Results[0].UsernameHighlight)
+	}
+}
+
+func TestSearchUsersHandlerRejectsBadQuery(t *testing.T) {
+	router := newTestRouter()
+
+	rec := doRequest(router, http.MethodGet, "/users/search?limit=0&page=2", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	p := decodeProblem(t, rec)
+	if p.Type != problem.TypeValidation || len(p.Errors) != 2 {
+		t.Fatalf("problem = %#v, want validation errors for limit and page", p)
+	}
+}
+
+func newTestRouter() http.Handler {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := memdb.New()
+	h := New(service.New(context.Background(), store, store, logger), logger)
+
+	r := chi.NewRouter()
+	r.Get("/users", h.GetUsersHandler())
+	r.Post("/users", h.CreateUserHandler())
+	r.Get("/users/search", h.SearchUsersHandler())
+	r.Get("/users/{id}", h.GetUserHandler())
+	r.Put("/users/{id}", h.UpdateUserHandler())
+	r.Delete("/users/{id}", h.DeleteUserHandler())
+	return r
+}
+
+func decodeProblem(t *testing.T, rec *httptest.ResponseRecorder) problem.Details {
+	t.Helper()
+
+	var p problem.Details
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+		t.Fatalf("decode problem: %v", err)
+	}
+	return p
+}
+
+func doRequest(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
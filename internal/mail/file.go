@@ -0,0 +1,73 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileMailer writes each message to its own .eml file in a directory
+// instead of sending it. Files are named so they sort in send order.
+type FileMailer struct {
+	dir  string
+	from string
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewFileMailer creates a FileMailer writing to dir, creating it if needed
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("mail: creating %s: %w", dir, err)
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+// Send writes msg to a new file
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	data, err := format(m.from, msg, now)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.seq++
+	name := fmt.Sprintf("%s-%06d.eml", now.UTC().Format("20060102T150405.000000000"), m.seq)
+	m.mu.Unlock()
+
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0o600)
+}
+
+// LogMailer logs each message instead of sending it. The body is logged in
+// full, so use it only where the messages are not secret.
+type LogMailer struct {
+	logger *slog.Logger
+}
+
+// NewLogMailer creates a LogMailer; nil logger means slog.Default
+func NewLogMailer(logger *slog.Logger) *LogMailer {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &LogMailer{logger: logger}
+}
+
+// Send logs msg
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	m.logger.InfoContext(ctx, "Email not sent; logged instead", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+var (
+	_ Mailer = (*FileMailer)(nil)
+	_ Mailer = (*LogMailer)(nil)
+)
@@ -0,0 +1,154 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailerWritesMessages(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	mailer, err := NewFileMailer(dir, "Starter <noreply@example.com>")
+	if err != nil {
+		t.Fatalf("NewFileMailer: %v", err)
+	}
+
+	for _, subject := range []string{"first", "second"} {
+		err := mailer.Send(context.Background(), Message{To: "marc@example.com", Subject: subject, Body: "line one\nline two"})
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("files = %v, %v; want 2", files, err)
+	}
+	data, err := os.ReadFile(files[1])
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	for _, want := range []string{
+		"From: Starter <noreply@example.com>\r\n",
+		"To: marc@example.com\r\n",
+		"Subject: second\r\n",
+		"\r\n\r\nline one\r\nline two\r\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("message %q does not contain %q", data, want)
+		}
+	}
+}
+
+func TestMailersRejectHeaderInjection(t *testing.T) {
+	fileMailer, err := NewFileMailer(t.TempDir(), "noreply@example.com")
+	if err != nil {
+		t.Fatalf("NewFileMailer: %v", err)
+	}
+	msg := Message{To: "marc@example.com\r\nBcc: eve@example.com", Subject: "hi"}
+
+	for name, mailer := range map[string]Mailer{
+		"file": fileMailer,
+		"log":  NewLogMailer(slog.New(slog.DiscardHandler)),
+	} {
+		if err := mailer.Send(context.Background(), msg); !errors.Is(err, ErrInvalidHeader) {
+			t.Fatalf("%s: err = %v, want ErrInvalidHeader", name, err)
+		}
+	}
+}
+
+func TestLogMailerLogsMessage(t *testing.T) {
+	var buf bytes.Buffer
+	mailer := NewLogMailer(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	if err := mailer.Send(context.Background(), Message{To: "marc@example.com", Subject: "Sign in", Body: "https://example.com/link"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if !strings.Contains(buf.String(), "https://example.com/link") {
+		t.Fatalf("log %q does not contain the body", buf.String())
+	}
+}
+
+func TestSMTPMailerDelivers(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go serveSMTP(t, ln, received)
+
+	addr := ln.Addr().(*net.TCPAddr)
+	mailer, err := NewSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: addr.Port, From: "Starter <noreply@example.com>"})
+	if err != nil {
+		t.Fatalf("NewSMTPMailer: %v", err)
+	}
+	if err := mailer.Send(context.Background(), Message{To: "marc@example.com", Subject: "Sign in", Body: "hello\n.dotted line"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	transcript := <-received
+	for _, want := range []string{
+		"MAIL FROM:<noreply@example.com>",
+		"RCPT TO:<marc@example.com>",
+		"Subject: Sign in",
+		"..dotted line",
+	} {
+		if !strings.Contains(transcript, want) {
+			t.Fatalf("transcript %q does not contain %q", transcript, want)
+		}
+	}
+}
+
+// serveSMTP accepts one connection, answers it like a minimal SMTP server
+// without extensions and sends everything the client wrote to received
+func serveSMTP(t *testing.T, ln net.Listener, received chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Errorf("accept: %v", err)
+		close(received)
+		return
+	}
+	defer conn.Close()
+
+	var transcript strings.Builder
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	inData := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		transcript.WriteString(line)
+		switch {
+		case inData:
+			if line == ".\r\n" {
+				inData = false
+				reply("250 queued")
+			}
+		case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(line, "DATA"):
+			inData = true
+			reply("354 go ahead")
+		case strings.HasPrefix(line, "QUIT"):
+			reply("221 bye")
+			received <- transcript.String()
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+	received <- transcript.String()
+}
@@ -0,0 +1,115 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// DefaultSMTPTimeout bounds a delivery when ctx has no deadline
+const DefaultSMTPTimeout = 30 * time.Second
+
+// SMTPConfig configures an SMTPMailer
+type SMTPConfig struct {
+	Host string
+	Port int
+	// Username and Password authenticate with PLAIN auth when Username is
+	// set; net/smtp refuses to send them unencrypted except to localhost
+	Username string
+	Password string
+	// From is the sender address, such as "Starter <noreply@example.com>"
+	From string
+}
+
+// SMTPMailer delivers messages through an SMTP server, upgrading the
+// connection with STARTTLS when the server offers it
+type SMTPMailer struct {
+	cfg    SMTPConfig
+	sender string
+}
+
+// NewSMTPMailer creates an SMTPMailer
+func NewSMTPMailer(cfg SMTPConfig) (*SMTPMailer, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("mail: SMTP host is required")
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("mail: invalid sender %q: %w", cfg.From, err)
+	}
+	return &SMTPMailer{cfg: cfg, sender: from.Address}, nil
+}
+
+// Send delivers msg
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	data, err := format(m.cfg.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("mail: invalid recipient %q: %w", msg.To, err)
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultSMTPTimeout)
+		defer cancel()
+	}
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("mail: connecting to %s: %w", addr, err)
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("mail: greeting from %s: %w", addr, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.cfg.Host}); err != nil {
+			return fmt.Errorf("mail: starttls: %w", err)
+		}
+	}
+	if m.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)); err != nil {
+			return fmt.Errorf("mail: auth: %w", err)
+		}
+	}
+	if err := client.Mail(m.sender); err != nil {
+		return fmt.Errorf("mail: MAIL FROM: %w", err)
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("mail: RCPT TO: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("mail: DATA: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("mail: writing message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("mail: sending message: %w", err)
+	}
+	return client.Quit()
+}
+
+var _ Mailer = (*SMTPMailer)(nil)
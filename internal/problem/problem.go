@@ -0,0 +1,69 @@
+// Package problem writes RFC 7807 "problem details" error responses.
+//
+// It is shared by the HTTP handlers and the auth middleware so every error
+// returned by the API has the same application/problem+json shape.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ContentType is the media type of problem details bodies
+const ContentType = "application/problem+json"
+
+// Problem types identify the class of error independently of the status code.
+// They are relative URI references, as allowed by RFC 7807.
+const (
+	TypeValidation      = "/problems/validation-error"
+	TypeBadRequest      = "/problems/bad-request"
+	TypeNotFound        = "/problems/not-found"
+	TypeConflict        = "/problems/conflict"
+	TypeUnauthorized    = "/problems/unauthorized"
+	TypeForbidden       = "/problems/forbidden"
+	TypeTooManyRequests = "/problems/too-many-requests"
+	TypeInternal        = "/problems/internal-error"
+)
+
+// Details is an RFC 7807 problem details object with a field-level errors
+// extension and a code extension. Code is a stable, machine-readable reason
+// finer than Type, such as "expired_token"; clients may switch on it.
+type Details struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Code     string       `json:"code,omitempty"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// New creates problem details for status, using the standard status text as the title
+func New(status int, problemType, detail string) *Details {
+	return &Details{
+		Type:   problemType,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Write sends p as an application/problem+json response. The instance is set
+// to the chi request ID when the request carries one and p has none.
+func Write(w http.ResponseWriter, r *http.Request, p *Details) error {
+	if p.Instance == "" && r != nil {
+		p.Instance = middleware.GetReqID(r.Context())
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
+}
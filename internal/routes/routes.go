@@ -1,33 +1,221 @@
 package routes
 
 import (
+	"net/http"
+
 	"github.com/go-chi/chi/v5"
+	"github.com/mhpenta/starterA/internal/auth"
 	httphandlers "github.com/mhpenta/starterA/internal/handlers/http"
+	"github.com/mhpenta/starterA/internal/service"
 )
 
-// RegisterRoutes sets up all the routes for the application
-func RegisterRoutes(r *chi.Mux, handlers *httphandlers.HTTPHandlers) {
+// RegisterRoutes sets up all the routes for the application.
+// When authHandlers is nil, authentication is disabled and every route is public.
+func RegisterRoutes(r *chi.Mux, handlers *httphandlers.HTTPHandlers, authHandlers *httphandlers.AuthHandlers) {
 	// No static files needed with Tailwind CSS via CDN
 
-	// Register home route
-	r.Get("/", handlers.HomeHandler())
+	var authProvider auth.Servicer
+	g := guards{csrfProtect: passThrough, stepUp: passThrough, sensitive: passThrough}
+	if authHandlers != nil {
+		authProvider = authHandlers.Provider
+		if authHandlers.Logger != nil {
+			g.authOptions = append(g.authOptions, auth.WithLogger(authHandlers.Logger))
+		}
+		g.csrfProtect = auth.CSRFProtect(authHandlers.CSRF)
+		g.stepUp = auth.RequireMFA(authHandlers.MFA.MaxAge)
+		if authHandlers.MFA.Required {
+			g.sensitive = g.stepUp
+		}
+		registerAuthRoutes(r, authHandlers, handlers, g.authOptions)
+	}
+
+	// Register home route; it renders for everyone but may personalise
+	// the page when a session is present
+	r.With(optionalAuth(authProvider, g.authOptions...)).Get("/", handlers.HomeHandler())
 
 	// Register API routes
-	registerAPIRoutes(r, handlers)
+	registerAPIRoutes(r, handlers, authProvider, g)
+}
+
+// guards are the middleware applied to API routes on top of authentication.
+// Each is passThrough when auth is disabled.
+type guards struct {
+	// csrfProtect guards the routes that change state
+	csrfProtect func(http.Handler) http.Handler
+	// stepUp requires a recent second factor
+	stepUp func(http.Handler) http.Handler
+	// sensitive guards destructive routes; it is stepUp when MFA is required
+	sensitive func(http.Handler) http.Handler
+	// authOptions configure the authentication middleware, such as logging
+	// rejected credentials
+	authOptions []auth.MiddlewareOption
 }
 
 // registerAPIRoutes sets up all API routes
-func registerAPIRoutes(r *chi.Mux, handlers *httphandlers.HTTPHandlers) {
+func registerAPIRoutes(r *chi.Mux, handlers *httphandlers.HTTPHandlers, authProvider auth.Servicer, g guards) {
 	r.Route("/api", func(r chi.Router) {
-		// Users endpoints
+		// Users endpoints: reads need a valid token, writes also reject
+		// revoked sessions and need a CSRF token with cookie auth, and
+		// deleting is for admins, after a step-up when MFA is required. API
+		// keys also need the matching scope.
+		// Callers get a local user on first use.
 		r.Route("/users", func(r chi.Router) {
-			r.Get("/", handlers.GetUsersHandler())
-			r.Post("/", handlers.CreateUserHandler())
-			r.Get("/{id}", handlers.GetUserHandler())
-			r.Put("/{id}", handlers.UpdateUserHandler())
-			r.Delete("/{id}", handlers.DeleteUserHandler())
+			r.Group(func(r chi.Router) {
+				r.Use(requireAuth(authProvider, g.authOptions...))
+				r.Use(loadCurrentUser(authProvider, handlers))
+				r.Use(requirePolicy(authProvider, auth.APIKeyScopes(service.ScopeUsersRead, service.ScopeUsersWrite)))
+				r.Get("/", handlers.GetUsersHandler())
+				r.Get("/search", handlers.SearchUsersHandler())
+				r.Get("/{id}", handlers.GetUserHandler())
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(requireAuthWithRevocationCheck(authProvider, g.authOptions...))
+				r.Use(g.csrfProtect)
+				r.Use(loadCurrentUser(authProvider, handlers))
+				r.Use(requirePolicy(authProvider, auth.APIKeyScopes(service.ScopeUsersWrite)))
+				r.Post("/", handlers.CreateUserHandler())
+				r.Put("/{id}", handlers.UpdateUserHandler())
+				r.With(requireRole(authProvider, auth.RoleAdmin), g.sensitive).Delete("/{id}", handlers.DeleteUserHandler())
+			})
 		})
 
+		// API key management is for admins, so it only exists with auth
+		if authProvider != nil {
+			r.Route("/keys", func(r chi.Router) {
+				r.Use(auth.RequireAuthWithRevocationCheck(authProvider, g.authOptions...))
+				r.Use(g.csrfProtect)
+				r.Use(handlers.LoadCurrentUser)
+				r.Use(auth.RequireRole(auth.RoleAdmin))
+				r.Get("/", handlers.ListAPIKeysHandler())
+				r.Post("/", handlers.CreateAPIKeyHandler())
+				r.Get("/{id}", handlers.GetAPIKeyHandler())
+				r.Put("/{id}", handlers.UpdateAPIKeyHandler())
+				r.Delete("/{id}", handlers.DeleteAPIKeyHandler())
+			})
+		}
+
+		// The caller's own local user and second factor, which only exist
+		// with auth. Changing an enabled second factor needs a step-up.
+		if authProvider != nil {
+			r.Route("/me", func(r chi.Router) {
+				r.With(auth.RequireAuth(authProvider, g.authOptions...), handlers.LoadCurrentUser).Get("/", handlers.CurrentUserHandler())
+
+				r.Route("/mfa", func(r chi.Router) {
+					r.Use(auth.RequireAuthWithRevocationCheck(authProvider, g.authOptions...))
+					r.Use(g.csrfProtect)
+					r.Use(handlers.LoadCurrentUser)
+					r.Get("/", handlers.MFAStatusHandler())
+					r.Post("/totp", handlers.EnrollTOTPHandler())
+					r.Post("/totp/confirm", handlers.ConfirmTOTPHandler())
+					r.With(g.stepUp).Delete("/totp", handlers.DisableTOTPHandler())
+					r.With(g.stepUp).Post("/recovery-codes", handlers.RegenerateRecoveryCodesHandler())
+				})
+			})
+		}
+
 		// Add more API routes as needed
 	})
 }
+
+// registerAuthRoutes sets up the login, session, logout and password change
+// endpoints, MFA
+// step-up when a verifier is set, magic links and OAuth providers when
+// configured, and the admin lockout endpoints when login throttling is
+// enabled. Logout is not CSRF protected so that it works even with an
+// expired session.
+func registerAuthRoutes(r *chi.Mux, authHandlers *httphandlers.AuthHandlers, handlers *httphandlers.HTTPHandlers, opts []auth.MiddlewareOption) {
+	r.Route("/auth", func(r chi.Router) {
+		r.Post("/login", authHandlers.LoginHandler())
+		r.Post("/session", authHandlers.CreateSessionHandler())
+		r.Post("/logout", authHandlers.LogoutHandler())
+		r.With(
+			auth.RequireAuthWithRevocationCheck(authHandlers.Provider, opts...),
+			auth.CSRFProtect(authHandlers.CSRF),
+		).Put("/password", authHandlers.ChangePasswordHandler())
+		r.With(auth.RequireAuth(authHandlers.Provider, opts...)).Get("/me", authHandlers.MeHandler())
+		r.With(auth.RequireAuth(authHandlers.Provider, opts...)).Get("/csrf", authHandlers.CSRFHandler())
+
+		if authHandlers.MFA.Verifier != nil {
+			r.With(
+				auth.RequireAuthWithRevocationCheck(authHandlers.Provider, opts...),
+				auth.CSRFProtect(authHandlers.CSRF),
+			).Post("/mfa", authHandlers.StepUpHandler())
+		}
+
+		if authHandlers.MagicLink.Manager != nil {
+			r.Post("/magic-link", authHandlers.RequestMagicLinkHandler())
+			r.Get("/magic-link/verify", authHandlers.VerifyMagicLinkHandler())
+		}
+
+		if authHandlers.OAuth.Manager != nil {
+			r.Get("/{provider}/login", authHandlers.OAuthLoginHandler())
+			r.Get("/{provider}/callback", authHandlers.OAuthCallbackHandler())
+		}
+
+		if authHandlers.Limiter != nil {
+			r.Route("/lockouts", func(r chi.Router) {
+				r.Use(auth.RequireAuthWithRevocationCheck(authHandlers.Provider, opts...))
+				r.Use(auth.CSRFProtect(authHandlers.CSRF))
+				r.Use(handlers.LoadCurrentUser)
+				r.Use(auth.RequireRole(auth.RoleAdmin))
+				r.Get("/", authHandlers.ListLockoutsHandler())
+				r.Delete("/{key}", authHandlers.UnlockHandler())
+			})
+		}
+	})
+}
+
+// requireAuth applies auth.RequireAuth, or nothing when auth is disabled
+func requireAuth(provider auth.Servicer, opts ...auth.MiddlewareOption) func(http.Handler) http.Handler {
+	if provider == nil {
+		return passThrough
+	}
+	return auth.RequireAuth(provider, opts...)
+}
+
+// requireAuthWithRevocationCheck applies auth.RequireAuthWithRevocationCheck,
+// or nothing when auth is disabled
+func requireAuthWithRevocationCheck(provider auth.Servicer, opts ...auth.MiddlewareOption) func(http.Handler) http.Handler {
+	if provider == nil {
+		return passThrough
+	}
+	return auth.RequireAuthWithRevocationCheck(provider, opts...)
+}
+
+// requirePolicy applies auth.RequirePolicy, or nothing when auth is disabled
+func requirePolicy(provider auth.Servicer, policy auth.Policy) func(http.Handler) http.Handler {
+	if provider == nil {
+		return passThrough
+	}
+	return auth.RequirePolicy(policy)
+}
+
+// requireRole applies auth.RequireRole, or nothing when auth is disabled
+func requireRole(provider auth.Servicer, roles ...string) func(http.Handler) http.Handler {
+	if provider == nil {
+		return passThrough
+	}
+	return auth.RequireRole(roles...)
+}
+
+// loadCurrentUser applies handlers.LoadCurrentUser, or nothing when auth is
+// disabled
+func loadCurrentUser(provider auth.Servicer, handlers *httphandlers.HTTPHandlers) func(http.Handler) http.Handler {
+	if provider == nil {
+		return passThrough
+	}
+	return handlers.LoadCurrentUser
+}
+
+// optionalAuth applies auth.OptionalAuth, or nothing when auth is disabled
+func optionalAuth(provider auth.Servicer, opts ...auth.MiddlewareOption) func(http.Handler) http.Handler {
+	if provider == nil {
+		return passThrough
+	}
+	return auth.OptionalAuth(provider, opts...)
+}
+
+func passThrough(next http.Handler) http.Handler {
+	return next
+}
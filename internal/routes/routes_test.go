@@ -0,0 +1,666 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/auth/apikey"
+	"github.com/mhpenta/starterA/internal/auth/dbsession"
+	"github.com/mhpenta/starterA/internal/auth/dbthrottle"
+	"github.com/mhpenta/starterA/internal/auth/localauth"
+	"github.com/mhpenta/starterA/internal/auth/magiclink"
+	"github.com/mhpenta/starterA/internal/auth/oauth"
+	"github.com/mhpenta/starterA/internal/auth/oauth/oauthtest"
+	"github.com/mhpenta/starterA/internal/auth/totp"
+	"github.com/mhpenta/starterA/internal/database/memdb"
+	httphandlers "github.com/mhpenta/starterA/internal/handlers/http"
+	"github.com/mhpenta/starterA/internal/mail"
+	"github.com/mhpenta/starterA/internal/service"
+)
+
+func TestAPIRoutesRequireAuthWhenProviderConfigured(t *testing.T) {
+	router := newTestRouter(auth.NewMockProvider())
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		token  string
+		want   int
+	}{
+		{"list without token", http.MethodGet, "/api/users", "", "", http.StatusUnauthorized},
+		{"list with bad token", http.MethodGet, "/api/users", "", "nope", http.StatusUnauthorized},
+		{"list with token", http.MethodGet, "/api/users", "", "test-token-1", http.StatusOK},
+		{"search without token", http.MethodGet, "/api/users/search?q=marc", "", "", http.StatusUnauthorized},
+		{"create without token", http.MethodPost, "/api/users", `{"username":"marc","email":"marc@example.com"}`, "", http.StatusUnauthorized},
+		{"create with token", http.MethodPost, "/api/users", `{"username":"marc","email":"marc@example.com"}`, "test-token-1", http.StatusCreated},
+		{"delete as user", http.MethodDelete, "/api/users/1", "", "test-token-1", http.StatusForbidden},
+		{"delete as admin", http.MethodDelete, "/api/users/1", "", "test-token-admin", http.StatusNoContent},
+		{"home without token", http.MethodGet, "/", "", "", http.StatusOK},
+		{"home with bad token", http.MethodGet, "/", "", "nope", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doRequest(router, tt.method, tt.target, tt.body, tt.token)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPIRoutesArePublicWhenAuthDisabled(t *testing.T) {
+	router := newTestRouter(nil)
+
+	rec := doRequest(router, http.MethodGet, "/api/users", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestAuthRoutesNotRegisteredWhenAuthDisabled(t *testing.T) {
+	router := newTestRouter(nil)
+
+	rec := doRequest(router, http.MethodPost, "/auth/session", `{"id_token":"test-token-1"}`, "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAPIKeyRoutesNotRegisteredWhenAuthDisabled(t *testing.T) {
+	router := newTestRouter(nil)
+
+	rec := doRequest(router, http.MethodPost, "/api/keys", `{"name":"batch","scopes":["users:read"]}`, "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAuthMeRequiresAuth(t *testing.T) {
+	router := newTestRouter(auth.NewMockProvider())
+
+	if rec := doRequest(router, http.MethodGet, "/auth/me", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := doRequest(router, http.MethodGet, "/auth/me", "", "test-token-1"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+}
+
+func TestCookieWritesRequireCSRFToken(t *testing.T) {
+	router := newTestRouter(auth.NewMockProvider())
+
+	rec := doRequest(router, http.MethodPost, "/auth/session", `{"id_token":"test-token-1"}`, "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("create session status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	csrfToken := rec.Header().Get(auth.CSRFHeader)
+	if csrfToken == "" {
+		t.Fatal("session response has no CSRF token")
+	}
+
+	withCookie := func(method, target, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.AddCookie(cookies[0])
+		if token != "" {
+			req.Header.Set(auth.CSRFHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec = withCookie(http.MethodGet, "/auth/csrf", "", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), csrfToken) {
+		t.Fatalf("csrf status = %d, body %s; want token %q", rec.Code, rec.Body.String(), csrfToken)
+	}
+
+	user := `{"username":"marc","email":"marc@example.com"}`
+	if rec := withCookie(http.MethodGet, "/api/users", "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("list status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := withCookie(http.MethodPost, "/api/users", user, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("create without token status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := withCookie(http.MethodPost, "/api/users", user, csrfToken); rec.Code != http.StatusCreated {
+		t.Fatalf("create with token status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+}
+
+func TestAPIMeProvisionsLocalUser(t *testing.T) {
+	router := newTestRouter(auth.NewMockProvider())
+
+	if rec := doRequest(router, http.MethodGet, "/api/me", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	var ids []int64
+	for range 2 {
+		rec := doRequest(router, http.MethodGet, "/api/me", "", "test-token-1")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var user struct {
+			ID       int64  `json:"id"`
+			Username string `json:"username"`
+			Email    string `json:"email"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &user); err != nil {
+			t.Fatalf("decode user: %v", err)
+		}
+		if user.Username != "user1" || user.Email != "user1@example.com" {
+			t.Fatalf("user = %+v", user)
+		}
+		ids = append(ids, user.ID)
+	}
+	if ids[0] != ids[1] {
+		t.Fatalf("user ids = %v, want the same user twice", ids)
+	}
+}
+
+func TestAPIKeysAuthenticateWithinTheirScopes(t *testing.T) {
+	router := newTestRouter(auth.NewMockProvider())
+
+	if rec := doRequest(router, http.MethodPost, "/api/keys", `{"name":"batch","scopes":["users:read"]}`, "test-token-1"); rec.Code != http.StatusForbidden {
+		t.Fatalf("create as user status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec := doRequest(router, http.MethodPost, "/api/keys", `{"name":"batch","scopes":["users:read"]}`, "test-token-admin")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	var created struct {
+		ID  int64  `json:"id"`
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || created.Key == "" {
+		t.Fatalf("decode created key %q: %v", rec.Body.String(), err)
+	}
+
+	withKey := func(method, target, body string, header func(*http.Request)) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		header(req)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	xAPIKey := func(r *http.Request) { r.Header.Set(auth.APIKeyHeader, created.Key) }
+	scheme := func(r *http.Request) { r.Header.Set(auth.AuthHeader, auth.APIKeyPrefix+created.Key) }
+
+	if code := withKey(http.MethodGet, "/api/users", "", xAPIKey); code != http.StatusOK {
+		t.Fatalf("list with X-API-Key status = %d, want %d", code, http.StatusOK)
+	}
+	if code := withKey(http.MethodGet, "/api/users", "", scheme); code != http.StatusOK {
+		t.Fatalf("list with ApiKey scheme status = %d, want %d", code, http.StatusOK)
+	}
+	if code := withKey(http.MethodPost, "/api/users", `{"username":"marc","email":"marc@example.com"}`, xAPIKey); code != http.StatusForbidden {
+		t.Fatalf("create without write scope status = %d, want %d", code, http.StatusForbidden)
+	}
+	if code := withKey(http.MethodGet, "/api/keys", "", xAPIKey); code != http.StatusForbidden {
+		t.Fatalf("key management with api key status = %d, want %d", code, http.StatusForbidden)
+	}
+
+	if rec := doRequest(router, http.MethodDelete, "/api/keys/"+strconv.FormatInt(created.ID, 10), "", "test-token-admin"); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if code := withKey(http.MethodGet, "/api/users", "", xAPIKey); code != http.StatusUnauthorized {
+		t.Fatalf("list with deleted key status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestLocalAdminReachesAdminRoutes(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := memdb.New()
+	svc := service.New(ctx, store, store, logger)
+	svc.Policies = service.DefaultPolicies()
+	provider, err := localauth.New(store, localauth.Config{
+		Secret: []byte(strings.Repeat("s", 32)),
+		Argon2: localauth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		Logger: logger,
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	svc.Provisioning = service.Provisioning{Provider: "local", UserInfo: provider, UIDIsUserID: true}
+	authHandlers := httphandlers.NewAuthHandlers(provider, httphandlers.SessionCookieConfig{Lifetime: time.Hour}, logger)
+	authHandlers.Limiter = auth.NewLimiter(dbthrottle.New(store), auth.LimiterConfig{Logger: logger})
+	router := chi.NewRouter()
+	RegisterRoutes(router, httphandlers.New(svc, logger), authHandlers)
+
+	login := func(username string) string {
+		user, err := svc.CreateUser(ctx, &service.CreateUserInput{Username: username, Email: username + "@example.com"})
+		if err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		if err := provider.SetPassword(ctx, user.ID, "correct horse"); err != nil {
+			t.Fatalf("set password: %v", err)
+		}
+		rec := doRequest(router, http.MethodPost, "/auth/login", `{"username":"`+username+`","password":"correct horse"}`, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var body struct {
+			IDToken string `json:"id_token"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.IDToken == "" {
+			t.Fatalf("decode login %q: %v", rec.Body.String(), err)
+		}
+		return body.IDToken
+	}
+	adminToken := login("marc")
+	userToken := login("jane")
+
+	if rec := doRequest(router, http.MethodGet, "/api/keys", "", adminToken); rec.Code != http.StatusForbidden {
+		t.Fatalf("keys before grant status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if _, err := svc.GrantRole(ctx, "marc@example.com", auth.RoleAdmin); err != nil {
+		t.Fatalf("grant role: %v", err)
+	}
+	if rec := doRequest(router, http.MethodGet, "/api/keys", "", adminToken); rec.Code != http.StatusOK {
+		t.Fatalf("keys as admin status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if rec := doRequest(router, http.MethodGet, "/auth/lockouts", "", adminToken); rec.Code != http.StatusOK {
+		t.Fatalf("lockouts as admin status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if rec := doRequest(router, http.MethodGet, "/api/keys", "", userToken); rec.Code != http.StatusForbidden {
+		t.Fatalf("keys as user status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if _, err := svc.GrantRole(ctx, "nobody@example.com", auth.RoleAdmin); !errors.Is(err, service.ErrUserNotFound) {
+		t.Fatalf("grant to unknown user error = %v, want %v", err, service.ErrUserNotFound)
+	}
+}
+
+func TestLocalUserChangesPassword(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := memdb.New()
+	svc := service.New(ctx, store, store, logger)
+	provider, err := localauth.New(store, localauth.Config{
+		Secret: []byte(strings.Repeat("s", 32)),
+		Argon2: localauth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		Logger: logger,
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	authHandlers := httphandlers.NewAuthHandlers(provider, httphandlers.SessionCookieConfig{Lifetime: time.Hour}, logger)
+	authHandlers.Limiter = auth.NewLimiter(dbthrottle.New(store), auth.LimiterConfig{Logger: logger})
+	router := chi.NewRouter()
+	RegisterRoutes(router, httphandlers.New(svc, logger), authHandlers)
+
+	user, err := svc.CreateUser(ctx, &service.CreateUserInput{Username: "marc", Email: "marc@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := provider.SetPassword(ctx, user.ID, "correct horse"); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	login := func(password string) *httptest.ResponseRecorder {
+		return doRequest(router, http.MethodPost, "/auth/login", `{"username":"marc","password":"`+password+`"}`, "")
+	}
+	rec := login("correct horse")
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.IDToken == "" {
+		t.Fatalf("decode login %q: %v", rec.Body.String(), err)
+	}
+
+	change := func(current, next, token string) *httptest.ResponseRecorder {
+		return doRequest(router, http.MethodPut, "/auth/password", `{"current_password":"`+current+`","new_password":"`+next+`"}`, token)
+	}
+	if rec := change("correct horse", "battery staple", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("change without token status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := change("wrong horse", "battery staple", body.IDToken); rec.Code != http.StatusBadRequest {
+		t.Fatalf("change with wrong password status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+	if rec := change("correct horse", "short", body.IDToken); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "new_password") {
+		t.Fatalf("change to weak password status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+	if rec := change("correct horse", "battery staple", body.IDToken); rec.Code != http.StatusNoContent {
+		t.Fatalf("change status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+
+	if rec := login("correct horse"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("login with old password status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := login("battery staple"); rec.Code != http.StatusOK {
+		t.Fatalf("login with new password status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+}
+
+func TestAdminUnlocksThrottledClient(t *testing.T) {
+	router := newTestRouter(auth.NewMockProvider())
+
+	for range auth.DefaultThrottleFreeAttempts + 1 {
+		doRequest(router, http.MethodPost, "/auth/session", `{"id_token":"nope"}`, "")
+	}
+	rec := doRequest(router, http.MethodPost, "/auth/session", `{"id_token":"test-token-1"}`, "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d, Retry-After = %q, want 429 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	if rec := doRequest(router, http.MethodGet, "/auth/lockouts", "", "test-token-1"); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-admin list status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec = doRequest(router, http.MethodGet, "/auth/lockouts", "", "test-token-admin")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var locked []auth.ThrottleRecord
+	if err := json.NewDecoder(rec.Body).Decode(&locked); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(locked) != 1 || locked[0].Key != "ip:192.0.2.1" {
+		t.Fatalf("locked = %+v, want ip:192.0.2.1", locked)
+	}
+
+	target := "/auth/lockouts/" + url.PathEscape(locked[0].Key)
+	if rec := doRequest(router, http.MethodDelete, target, "", "test-token-admin"); rec.Code != http.StatusNoContent {
+		t.Fatalf("unlock status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+	if rec := doRequest(router, http.MethodDelete, target, "", "test-token-admin"); rec.Code != http.StatusNotFound {
+		t.Fatalf("second unlock status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	if rec := doRequest(router, http.MethodPost, "/auth/session", `{"id_token":"test-token-1"}`, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("session status after unlock = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+}
+
+func TestSensitiveRoutesRequireMFAStepUp(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := memdb.New()
+	svc := service.New(context.Background(), store, store, logger)
+	svc.Policies = service.DefaultPolicies()
+	provider := auth.NewSessionManager(auth.NewMockProvider(), dbsession.New(store), auth.SessionManagerConfig{Logger: logger})
+	svc.Provisioning = service.Provisioning{Provider: "mock", UserInfo: provider}
+	authHandlers := httphandlers.NewAuthHandlers(provider, httphandlers.SessionCookieConfig{Lifetime: time.Hour}, logger)
+	authHandlers.MFA = httphandlers.MFAConfig{Verifier: svc, MaxAge: time.Minute, Required: true}
+	router := chi.NewRouter()
+	RegisterRoutes(router, httphandlers.New(svc, logger), authHandlers)
+
+	rec := doRequest(router, http.MethodPost, "/auth/session", `{"id_token":"test-token-admin"}`, "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("create session status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	cookie := rec.Result().Cookies()[0]
+	csrfToken := rec.Header().Get(auth.CSRFHeader)
+	withCookie := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.AddCookie(cookie)
+		req.Header.Set(auth.CSRFHeader, csrfToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec = withCookie(http.MethodPost, "/api/users", `{"username":"marc","email":"marc@example.com"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create user status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode user: %v", err)
+	}
+	target := "/api/users/" + strconv.FormatInt(created.ID, 10)
+	if rec := withCookie(http.MethodDelete, target, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("delete without step-up status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec = withCookie(http.MethodPost, "/api/me/mfa/totp", "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("enroll status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	var enrollment service.TOTPEnrollment
+	if err := json.Unmarshal(rec.Body.Bytes(), &enrollment); err != nil {
+		t.Fatalf("decode enrollment: %v", err)
+	}
+	code, err := totp.Code(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("totp code: %v", err)
+	}
+	rec = withCookie(http.MethodPost, "/api/me/mfa/totp/confirm", `{"code":"`+code+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("confirm status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var recovery service.RecoveryCodes
+	if err := json.Unmarshal(rec.Body.Bytes(), &recovery); err != nil || len(recovery.Codes) == 0 {
+		t.Fatalf("decode recovery codes %q: %v", rec.Body.String(), err)
+	}
+
+	if rec := withCookie(http.MethodPost, "/auth/mfa", `{"code":"000000"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("step-up with wrong code status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+	if rec := withCookie(http.MethodPost, "/auth/mfa", `{"code":"`+recovery.Codes[0]+`"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("step-up status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+	if rec := withCookie(http.MethodDelete, target, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete after step-up status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+}
+
+func TestMagicLinkSignsInOnce(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := memdb.New()
+	svc := service.New(context.Background(), store, store, logger)
+	provider, err := localauth.New(store, localauth.Config{
+		Secret: []byte(strings.Repeat("s", 32)),
+		Argon2: localauth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		Logger: logger,
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	dir := t.TempDir()
+	mailer, err := mail.NewFileMailer(dir, "noreply@example.com")
+	if err != nil {
+		t.Fatalf("new mailer: %v", err)
+	}
+	manager, err := magiclink.New(store, magiclink.Config{URL: "https://example.com/auth/magic-link/verify", Mailer: mailer, Logger: logger})
+	if err != nil {
+		t.Fatalf("new magic links: %v", err)
+	}
+	authHandlers := httphandlers.NewAuthHandlers(provider, httphandlers.SessionCookieConfig{Lifetime: time.Hour}, logger)
+	authHandlers.Limiter = auth.NewLimiter(dbthrottle.New(store), auth.LimiterConfig{Logger: logger})
+	authHandlers.MagicLink = httphandlers.MagicLinkConfig{Manager: manager, RedirectURL: "/app"}
+	router := chi.NewRouter()
+	RegisterRoutes(router, httphandlers.New(svc, logger), authHandlers)
+
+	if _, err := svc.CreateUser(context.Background(), &service.CreateUserInput{Username: "marc", Email: "marc@example.com"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	for _, email := range []string{"marc@example.com", "nobody@example.com"} {
+		if rec := doRequest(router, http.MethodPost, "/auth/magic-link", `{"email":"`+email+`"}`, ""); rec.Code != http.StatusAccepted {
+			t.Fatalf("request link for %s status = %d, want %d: %s", email, rec.Code, http.StatusAccepted, rec.Body.String())
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("emails = %v, %v; want exactly one", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("read email: %v", err)
+	}
+	_, link, ok := strings.Cut(string(data), "https://example.com")
+	if !ok {
+		t.Fatalf("email %q has no link", data)
+	}
+	link, _, _ = strings.Cut(link, "\r\n")
+
+	rec := doRequest(router, http.MethodGet, link, "", "")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/app" {
+		t.Fatalf("verify status = %d, Location = %q; want 303 to /app: %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != auth.SessionCookieName {
+		t.Fatalf("cookies = %v, want a session cookie", cookies)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+	req.AddCookie(cookies[0])
+	me := httptest.NewRecorder()
+	router.ServeHTTP(me, req)
+	if me.Code != http.StatusOK || !strings.Contains(me.Body.String(), "marc@example.com") {
+		t.Fatalf("me status = %d, body %s", me.Code, me.Body.String())
+	}
+
+	if rec := doRequest(router, http.MethodGet, link, "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("reused link status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestOAuthLoginProvisionsUserAndSignsIn(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := memdb.New()
+	svc := service.New(context.Background(), store, store, logger)
+	provider, err := localauth.New(store, localauth.Config{
+		Secret: []byte(strings.Repeat("s", 32)),
+		Argon2: localauth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		Logger: logger,
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	server, err := oauthtest.NewServer("starter-app", "secret")
+	if err != nil {
+		t.Fatalf("new authorization server: %v", err)
+	}
+	defer server.Close()
+	manager, err := oauth.New(store, oauth.Config{
+		Providers: []oauth.ProviderConfig{{
+			Name:         "test",
+			Issuer:       server.Issuer(),
+			ClientID:     server.ClientID,
+			ClientSecret: server.ClientSecret,
+			RedirectURL:  "https://example.com/auth/test/callback",
+		}},
+		Logger: logger,
+	})
+	if err != nil {
+		t.Fatalf("new oauth manager: %v", err)
+	}
+	authHandlers := httphandlers.NewAuthHandlers(provider, httphandlers.SessionCookieConfig{Lifetime: time.Hour}, logger)
+	authHandlers.Limiter = auth.NewLimiter(dbthrottle.New(store), auth.LimiterConfig{Logger: logger})
+	authHandlers.OAuth = httphandlers.OAuthConfig{Manager: manager, Provisioner: svc, RedirectURL: "/app"}
+	router := chi.NewRouter()
+	RegisterRoutes(router, httphandlers.New(svc, logger), authHandlers)
+
+	if rec := doRequest(router, http.MethodGet, "/auth/missing/login", "", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown provider status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// login redirects to the provider and binds the state to the browser
+	login := doRequest(router, http.MethodGet, "/auth/test/login", "", "")
+	if login.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want %d: %s", login.Code, http.StatusFound, login.Body.String())
+	}
+	stateCookies := login.Result().Cookies()
+	if len(stateCookies) != 1 || !stateCookies[0].HttpOnly {
+		t.Fatalf("login cookies = %v, want an HttpOnly state cookie", stateCookies)
+	}
+	callback, err := server.Authorize(login.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	if callback.Host != "example.com" || callback.Path != "/auth/test/callback" {
+		t.Fatalf("callback = %s", callback)
+	}
+
+	// without the state cookie another browser cannot finish the login
+	if rec := doRequest(router, http.MethodGet, callback.RequestURI(), "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("callback without cookie status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	req.AddCookie(stateCookies[0])
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/app" {
+		t.Fatalf("callback status = %d, Location = %q; want 303 to /app: %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == auth.SessionCookieName {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatalf("cookies = %v, want a session cookie", rec.Result().Cookies())
+	}
+	req = httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+	req.AddCookie(session)
+	me := httptest.NewRecorder()
+	router.ServeHTTP(me, req)
+	if me.Code != http.StatusOK || !strings.Contains(me.Body.String(), "marc@example.com") {
+		t.Fatalf("me status = %d, body %s", me.Code, me.Body.String())
+	}
+
+	// the state works once
+	req = httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	req.AddCookie(stateCookies[0])
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed callback status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func newTestRouter(provider auth.Servicer) http.Handler {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := memdb.New()
+	svc := service.New(context.Background(), store, store, logger)
+	handlers := httphandlers.New(svc, logger)
+
+	var authHandlers *httphandlers.AuthHandlers
+	if provider != nil {
+		svc.Policies = service.DefaultPolicies()
+		svc.Provisioning = service.Provisioning{Provider: "mock", UserInfo: provider}
+		provider = auth.WithAPIKeys(provider, apikey.NewVerifier(store, apikey.Config{Logger: logger}))
+		authHandlers = httphandlers.NewAuthHandlers(provider, httphandlers.SessionCookieConfig{
+			Lifetime: time.Hour,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		}, logger)
+		authHandlers.Limiter = auth.NewLimiter(dbthrottle.New(store), auth.LimiterConfig{Logger: logger})
+	}
+
+	r := chi.NewRouter()
+	RegisterRoutes(r, handlers, authHandlers)
+	return r
+}
+
+func doRequest(handler http.Handler, method, target, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set(auth.AuthHeader, auth.BearerPrefix+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
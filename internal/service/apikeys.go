@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/auth/apikey"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+// Scopes an API key can be granted. Routes check them with auth.APIKeyScopes.
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+)
+
+const maxAPIKeyNameLength = 100
+
+// APIKeyScopes lists every scope an API key may be granted
+var APIKeyScopes = []string{ScopeUsersRead, ScopeUsersWrite}
+
+var (
+	ErrInvalidAPIKeyInput = errors.New("invalid api key input")
+	ErrAPIKeyNotFound     = errors.New("api key not found")
+)
+
+// APIKey describes an API key without its secret
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Prefix     string     `json:"prefix"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// CreatedAPIKey is a new API key with its plaintext value, which is only
+// ever returned here
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type CreateAPIKeyInput struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type UpdateAPIKeyInput struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// CreateAPIKey generates a key owned by the caller. The plaintext key is in
+// the result and cannot be recovered later.
+func (s *Service) CreateAPIKey(ctx context.Context, input *CreateAPIKeyInput) (*CreatedAPIKey, error) {
+	if err := s.authorize(ctx, s.Policies.ManageAPIKeys); err != nil {
+		return nil, err
+	}
+	if err := validateCreateAPIKeyInput(input, time.Now()); err != nil {
+		return nil, err
+	}
+
+	key, err := apikey.Generate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate api key: %w", err)
+	}
+
+	params := repo.CreateApiKeyParams{
+		Prefix:     key.Prefix,
+		SecretHash: key.SecretHash,
+		Name:       input.Name,
+		Scopes:     strings.Join(input.Scopes, " "),
+		CreatedBy:  auth.UIDFromContext(ctx),
+	}
+	if input.ExpiresAt != nil {
+		params.ExpiresAt = sql.NullTime{Time: input.ExpiresAt.UTC(), Valid: true}
+	}
+
+	s.Logger.Info("Creating api key", "name", input.Name, "prefix", key.Prefix, "created_by", params.CreatedBy)
+
+	stored, err := s.queries(ctx).CreateApiKey(ctx, params)
+	if err != nil {
+		s.Logger.Error("Failed to create api key", "error", err)
+		return nil, fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	return &CreatedAPIKey{APIKey: toAPIKey(stored), Key: key.Plaintext}, nil
+}
+
+// ListAPIKeys returns every API key ordered by id
+func (s *Service) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	if err := s.authorize(ctx, s.Policies.ManageAPIKeys); err != nil {
+		return nil, err
+	}
+
+	stored, err := s.queries(ctx).ListApiKeys(ctx)
+	if err != nil {
+		s.Logger.Error("Failed to list api keys", "error", err)
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+
+	keys := make([]APIKey, 0, len(stored))
+	for _, k := range stored {
+		keys = append(keys, toAPIKey(k))
+	}
+	return keys, nil
+}
+
+func (s *Service) GetAPIKey(ctx context.Context, id int64) (*APIKey, error) {
+	if err := s.authorize(ctx, s.Policies.ManageAPIKeys); err != nil {
+		return nil, err
+	}
+
+	stored, err := s.queries(ctx).GetApiKey(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAPIKeyNotFound
+		}
+		s.Logger.Error("Failed to fetch api key", "error", err)
+		return nil, fmt.Errorf("failed to fetch api key: %w", err)
+	}
+
+	key := toAPIKey(stored)
+	return &key, nil
+}
+
+// UpdateAPIKey renames a key and replaces its scopes. The secret and
+// expiry cannot change; create a new key instead.
+func (s *Service) UpdateAPIKey(ctx context.Context, id int64, input *UpdateAPIKeyInput) (*APIKey, error) {
+	if err := s.authorize(ctx, s.Policies.ManageAPIKeys); err != nil {
+		return nil, err
+	}
+	if err := validateUpdateAPIKeyInput(input); err != nil {
+		return nil, err
+	}
+
+	s.Logger.Info("Updating api key", "id", id)
+
+	stored, err := s.queries(ctx).UpdateApiKey(ctx, repo.UpdateApiKeyParams{
+		ID:     id,
+		Name:   input.Name,
+		Scopes: strings.Join(input.Scopes, " "),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAPIKeyNotFound
+		}
+		s.Logger.Error("Failed to update api key", "error", err)
+		return nil, fmt.Errorf("failed to update api key: %w", err)
+	}
+
+	key := toAPIKey(stored)
+	return &key, nil
+}
+
+// DeleteAPIKey deletes a key, which stops working immediately
+func (s *Service) DeleteAPIKey(ctx context.Context, id int64) error {
+	if err := s.authorize(ctx, s.Policies.ManageAPIKeys); err != nil {
+		return err
+	}
+
+	s.Logger.Info("Deleting api key", "id", id)
+
+	deleted, err := s.queries(ctx).DeleteApiKey(ctx, id)
+	if err != nil {
+		s.Logger.Error("Failed to delete api key", "error", err)
+		return fmt.Errorf("failed to delete api key: %w", err)
+	}
+	if deleted == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func validateCreateAPIKeyInput(input *CreateAPIKeyInput, now time.Time) error {
+	if input == nil {
+		return &ValidationError{Err: ErrInvalidAPIKeyInput, Detail: "missing api key payload"}
+	}
+
+	verr := &ValidationError{Err: ErrInvalidAPIKeyInput}
+	input.Name, input.Scopes = validateAPIKeyFields(verr, input.Name, input.Scopes)
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(now) {
+		verr.add("expires_at", "must be in the future")
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func validateUpdateAPIKeyInput(input *UpdateAPIKeyInput) error {
+	if input == nil {
+		return &ValidationError{Err: ErrInvalidAPIKeyInput, Detail: "missing api key payload"}
+	}
+
+	verr := &ValidationError{Err: ErrInvalidAPIKeyInput}
+	input.Name, input.Scopes = validateAPIKeyFields(verr, input.Name, input.Scopes)
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// validateAPIKeyFields records problems with the name and scopes in verr and
+// returns them trimmed, with duplicate scopes removed
+func validateAPIKeyFields(verr *ValidationError, name string, scopes []string) (string, []string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		verr.add("name", "is required")
+	} else if len(name) > maxAPIKeyNameLength {
+		verr.add("name", fmt.Sprintf("must be at most %d characters", maxAPIKeyNameLength))
+	}
+
+	if len(scopes) == 0 {
+		verr.add("scopes", "must include at least one of "+strings.Join(APIKeyScopes, ", "))
+	}
+	var unique []string
+	for _, scope := range scopes {
+		if !slices.Contains(APIKeyScopes, scope) {
+			verr.add("scopes", fmt.Sprintf("%q is not a known scope", scope))
+			continue
+		}
+		if !slices.Contains(unique, scope) {
+			unique = append(unique, scope)
+		}
+	}
+
+	return name, unique
+}
+
+func toAPIKey(k repo.ApiKey) APIKey {
+	key := APIKey{
+		ID:        k.ID,
+		Prefix:    k.Prefix,
+		Name:      k.Name,
+		Scopes:    strings.Fields(k.Scopes),
+		CreatedBy: k.CreatedBy,
+		CreatedAt: k.CreatedAt,
+	}
+	if k.ExpiresAt.Valid {
+		key.ExpiresAt = &k.ExpiresAt.Time
+	}
+	if k.LastUsedAt.Valid {
+		key.LastUsedAt = &k.LastUsedAt.Time
+	}
+	return key
+}
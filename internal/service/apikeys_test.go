@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth"
+)
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	svc := newMemService()
+	ctx := auth.ContextWithToken(context.Background(), &auth.Token{UID: "user-admin"})
+
+	expiresAt := time.Now().Add(time.Hour)
+	created, err := svc.CreateAPIKey(ctx, &CreateAPIKeyInput{
+		Name:      " batch ",
+		Scopes:    []string{ScopeUsersRead, ScopeUsersRead},
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created.Name != "batch" || len(created.Scopes) != 1 || created.CreatedBy != "user-admin" || created.ExpiresAt == nil {
+		t.Fatalf("created = %+v", created.APIKey)
+	}
+	if created.Key == "" {
+		t.Fatal("created key has no plaintext")
+	}
+
+	updated, err := svc.UpdateAPIKey(ctx, created.ID, &UpdateAPIKeyInput{Name: "nightly", Scopes: []string{ScopeUsersRead, ScopeUsersWrite}})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if updated.Name != "nightly" || len(updated.Scopes) != 2 || updated.Prefix != created.Prefix {
+		t.Fatalf("updated = %+v", updated)
+	}
+
+	keys, err := svc.ListAPIKeys(ctx)
+	if err != nil || len(keys) != 1 || keys[0].Name != "nightly" {
+		t.Fatalf("list = %+v, %v", keys, err)
+	}
+
+	if err := svc.DeleteAPIKey(ctx, created.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := svc.GetAPIKey(ctx, created.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("get err = %v, want %v", err, ErrAPIKeyNotFound)
+	}
+	if err := svc.DeleteAPIKey(ctx, created.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("delete err = %v, want %v", err, ErrAPIKeyNotFound)
+	}
+}
+
+func TestCreateAPIKeyRejectsInvalidInput(t *testing.T) {
+	svc := newMemService()
+	past := time.Now().Add(-time.Minute)
+
+	_, err := svc.CreateAPIKey(context.Background(), &CreateAPIKeyInput{Scopes: []string{"users:admin"}, ExpiresAt: &past})
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || !errors.Is(err, ErrInvalidAPIKeyInput) {
+		t.Fatalf("err = %v, want validation error", err)
+	}
+	var fields []string
+	for _, f := range validationErr.Fields {
+		fields = append(fields, f.Field)
+	}
+	for _, field := range []string{"name", "scopes", "expires_at"} {
+		if !slices.Contains(fields, field) {
+			t.Errorf("missing %s in %v", field, validationErr.Fields)
+		}
+	}
+}
+
+func TestAPIKeyManagementEnforcesPolicy(t *testing.T) {
+	svc := newMemService()
+	svc.Policies = DefaultPolicies()
+	input := &CreateAPIKeyInput{Name: "batch", Scopes: []string{ScopeUsersRead}}
+
+	userCtx := auth.ContextWithToken(context.Background(), &auth.Token{UID: "u", Claims: map[string]interface{}{"role": auth.RoleUser}})
+	if _, err := svc.CreateAPIKey(userCtx, input); !errors.Is(err, auth.ErrForbidden) {
+		t.Fatalf("user create err = %v, want %v", err, auth.ErrForbidden)
+	}
+	if _, err := svc.ListAPIKeys(userCtx); !errors.Is(err, auth.ErrForbidden) {
+		t.Fatalf("user list err = %v, want %v", err, auth.ErrForbidden)
+	}
+
+	adminCtx := auth.ContextWithToken(context.Background(), &auth.Token{UID: "a", Claims: map[string]interface{}{"role": auth.RoleAdmin}})
+	if _, err := svc.CreateAPIKey(adminCtx, input); err != nil {
+		t.Fatalf("admin create: %v", err)
+	}
+}
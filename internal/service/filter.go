@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+var ErrInvalidUserFilter = errors.New("invalid user filter")
+
+// UserSortField is a column users can be ordered by
+type UserSortField string
+
+const (
+	SortByID        UserSortField = repo.UserSortID
+	SortByUsername  UserSortField = repo.UserSortUsername
+	SortByEmail     UserSortField = repo.UserSortEmail
+	SortByCreatedAt UserSortField = repo.UserSortCreatedAt
+	SortByUpdatedAt UserSortField = repo.UserSortUpdatedAt
+)
+
+var userSortFields = map[UserSortField]bool{
+	SortByID:        true,
+	SortByUsername:  true,
+	SortByEmail:     true,
+	SortByCreatedAt: true,
+	SortByUpdatedAt: true,
+}
+
+// UserFilter selects and orders the users in a listing. Zero values are
+// ignored. Prefixes match case-insensitively; each After bound is inclusive
+// and each Before bound exclusive.
+type UserFilter struct {
+	UsernamePrefix string
+	EmailPrefix    string
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	UpdatedAfter   time.Time
+	UpdatedBefore  time.Time
+	SortBy         UserSortField
+	Descending     bool
+}
+
+// sortSpec identifies the filter's order, e.g. "username" or "-created_at"
+func (f *UserFilter) sortSpec() string {
+	if f.Descending {
+		return "-" + string(f.SortBy)
+	}
+	return string(f.SortBy)
+}
+
+func (f *UserFilter) repoFilter() repo.UserFilter {
+	return repo.UserFilter{
+		UsernamePrefix: f.UsernamePrefix,
+		EmailPrefix:    f.EmailPrefix,
+		CreatedAfter:   f.CreatedAfter,
+		CreatedBefore:  f.CreatedBefore,
+		UpdatedAfter:   f.UpdatedAfter,
+		UpdatedBefore:  f.UpdatedBefore,
+	}
+}
+
+// sortKey returns the cursor key for u under the filter's order
+func (f *UserFilter) sortKey(u repo.User) string {
+	switch f.SortBy {
+	case SortByUsername:
+		return u.Username
+	case SortByEmail:
+		return u.Email
+	case SortByCreatedAt:
+		return u.CreatedAt.UTC().Format(repo.TimestampLayout)
+	case SortByUpdatedAt:
+		return u.UpdatedAt.UTC().Format(repo.TimestampLayout)
+	}
+	return ""
+}
+
+func validateUserFilter(f *UserFilter) error {
+	verr := &ValidationError{Err: ErrInvalidUserFilter}
+
+	if f.SortBy == "" {
+		f.SortBy = SortByID
+	}
+	if !userSortFields[f.SortBy] {
+		verr.add("sort", "is not a sortable field")
+	}
+	if len(f.UsernamePrefix) > maxUsernameLength {
+		verr.add("username_prefix", "is too long")
+	}
+	if !f.CreatedAfter.IsZero() && !f.CreatedBefore.IsZero() && !f.CreatedAfter.Before(f.CreatedBefore) {
+		verr.add("created_before", "must be later than created_after")
+	}
+	if !f.UpdatedAfter.IsZero() && !f.UpdatedBefore.IsZero() && !f.UpdatedAfter.Before(f.UpdatedBefore) {
+		verr.add("updated_before", "must be later than updated_after")
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
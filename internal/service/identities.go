@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+// maxUsernameAttempts is how many numbered usernames are tried for a new
+// user before falling back to a random suffix
+const maxUsernameAttempts = 10
+
+// UserInfoSource looks up the details of an authenticated identity.
+// auth.Servicer implements it.
+type UserInfoSource interface {
+	GetUserInfo(ctx context.Context, uid string) (*auth.UserInfo, error)
+}
+
+// Provisioning configures how ResolveUser maps auth identities to local
+// users. The zero value only finds identities that are already linked.
+type Provisioning struct {
+	// Provider names the auth provider. Identities are unique per provider,
+	// so switching providers does not mix up their UIDs.
+	Provider string
+
+	// UserInfo is asked about identities seen for the first time so a local
+	// user can be created or linked. Without it unknown identities are
+	// reported as ErrUserNotFound.
+	UserInfo UserInfoSource
+
+	// UIDIsUserID is set for providers such as localauth whose UIDs already
+	// are users.id values; no identity rows are kept for them.
+	UIDIsUserID bool
+}
+
+type userContextKey struct{}
+
+// ContextWithUser returns a copy of ctx carrying the current local user
+func ContextWithUser(ctx context.Context, user *repo.User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// UserFromContext returns the local user stored by ContextWithUser
+func UserFromContext(ctx context.Context) (*repo.User, bool) {
+	user, ok := ctx.Value(userContextKey{}).(*repo.User)
+	return user, ok && user != nil
+}
+
+// CurrentUser returns the local user for the caller, using the one stored in
+// ctx by ContextWithUser when present and ResolveUser otherwise.
+func (s *Service) CurrentUser(ctx context.Context) (*repo.User, error) {
+	if user, ok := UserFromContext(ctx); ok {
+		return user, nil
+	}
+	return s.ResolveUser(ctx)
+}
+
+// ResolveUser returns the local user linked to the auth token in ctx. The
+// first time an identity is seen, its details are fetched from
+// Provisioning.UserInfo, or taken from the token's email, email_verified and
+// name claims when the provider has no record of it, as with JWTProvider,
+// and it is linked to the user with the same email if
+// the provider has verified that email, or to a new user with a username
+// derived from the email otherwise.
+//
+// It returns auth.ErrTokenNotInContext for anonymous callers and
+// ErrUserNotFound for API keys and identities that cannot be provisioned.
+// A new identity whose unverified email belongs to an existing user gets a
+// *UserConflictError rather than access to that user.
+func (s *Service) ResolveUser(ctx context.Context) (*repo.User, error) {
+	token, ok := auth.TokenFromContext(ctx)
+	if !ok || token == nil {
+		return nil, auth.ErrTokenNotInContext
+	}
+	// API keys belong to machine clients, not users
+	if _, ok := token.Claims[auth.ClaimAPIKey]; ok {
+		return nil, ErrUserNotFound
+	}
+
+	if s.Provisioning.UIDIsUserID {
+		id, err := strconv.ParseInt(token.UID, 10, 64)
+		if err != nil {
+			return nil, ErrUserNotFound
+		}
+		return s.GetUser(ctx, id)
+	}
+
+	identity := repo.GetUserByIdentityParams{Provider: s.Provisioning.Provider, ExternalUid: token.UID}
+	user, err := s.queries(ctx).GetUserByIdentity(ctx, identity)
+	if err == nil {
+		return &user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		s.Logger.Error("Failed to fetch user by identity", "error", err)
+		return nil, fmt.Errorf("failed to fetch user by identity: %w", err)
+	}
+
+	if s.Provisioning.UserInfo == nil {
+		return nil, ErrUserNotFound
+	}
+	info, err := s.Provisioning.UserInfo.GetUserInfo(ctx, token.UID)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		// The verified token describes the identity itself
+		info, err = auth.UserInfoFromToken(token), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user info: %w", err)
+	}
+	if strings.TrimSpace(info.Email) == "" && strings.TrimSpace(token.Email) == "" {
+		// Users are matched and named by email
+		return nil, ErrUserNotFound
+	}
+
+	var provisioned repo.User
+	err = s.WithTx(ctx, func(ctx context.Context, q repo.Store) error {
+		var err error
+		provisioned, err = s.provisionUser(ctx, q, identity, token, info)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &provisioned, nil
+}
+
+// ProvisionIdentity returns the local user linked to a verified token from
+// an external login provider such as an OAuth provider, linking or creating
+// one as ResolveUser does. The token's email, email_verified and name
+// claims stand in for Provisioning.UserInfo, and provider replaces
+// Provisioning.Provider, so identities from different login providers never
+// mix.
+func (s *Service) ProvisionIdentity(ctx context.Context, provider string, token *auth.Token) (*repo.User, error) {
+	if token == nil || token.UID == "" {
+		return nil, ErrUserNotFound
+	}
+
+	identity := repo.GetUserByIdentityParams{Provider: provider, ExternalUid: token.UID}
+	var user repo.User
+	err := s.WithTx(ctx, func(ctx context.Context, q repo.Store) error {
+		var err error
+		user, err = s.provisionUser(ctx, q, identity, token, auth.UserInfoFromToken(token))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// provisionUser links identity to an existing or new user inside a
+// transaction. A concurrent request may have linked it first, in which case
+// that user wins.
+func (s *Service) provisionUser(ctx context.Context, q repo.Store, identity repo.GetUserByIdentityParams, token *auth.Token, info *auth.UserInfo) (repo.User, error) {
+	if user, err := q.GetUserByIdentity(ctx, identity); err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return user, err
+	}
+
+	email := strings.TrimSpace(info.Email)
+	verified := info.EmailVerified
+	if email == "" {
+		email, verified = strings.TrimSpace(token.Email), token.EmailVerified
+	}
+
+	user, err := q.GetUserByEmail(ctx, email)
+	switch {
+	case err == nil && verified:
+		s.Logger.Info("Linking identity to existing user", "provider", identity.Provider, "uid", identity.ExternalUid, "user_id", user.ID)
+	case err == nil || errors.Is(err, sql.ErrNoRows):
+		user, err = s.createProvisionedUser(ctx, q, email, info.DisplayName)
+		if err != nil {
+			return repo.User{}, err
+		}
+		s.Logger.Info("Provisioned user for identity", "provider", identity.Provider, "uid", identity.ExternalUid, "user_id", user.ID)
+	default:
+		return repo.User{}, fmt.Errorf("failed to fetch user by email: %w", err)
+	}
+
+	linked, err := q.CreateUserIdentity(ctx, repo.CreateUserIdentityParams{
+		Provider:    identity.Provider,
+		ExternalUid: identity.ExternalUid,
+		UserID:      user.ID,
+	})
+	if err != nil {
+		return repo.User{}, fmt.Errorf("failed to link identity: %w", err)
+	}
+	if linked == 0 {
+		return q.GetUserByIdentity(ctx, identity)
+	}
+
+	return user, nil
+}
+
+// createProvisionedUser creates a user for email, trying usernames derived
+// from it until one is free. An email that is already taken is reported as
+// a *UserConflictError.
+func (s *Service) createProvisionedUser(ctx context.Context, q repo.Store, email, displayName string) (repo.User, error) {
+	base, email, err := validateUserFields(baseUsername(email, displayName), email)
+	if err != nil {
+		return repo.User{}, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		username := base
+		switch {
+		case attempt > maxUsernameAttempts:
+			suffix := make([]byte, 4)
+			if _, err := rand.Read(suffix); err != nil {
+				return repo.User{}, err
+			}
+			username = base + "-" + hex.EncodeToString(suffix)
+		case attempt > 1:
+			username = base + "-" + strconv.Itoa(attempt)
+		}
+
+		user, err := q.CreateUser(ctx, repo.CreateUserParams{Username: username, Email: email})
+		if err == nil {
+			return user, nil
+		}
+		conflict, ok := userConflict(err).(*UserConflictError)
+		if !ok {
+			s.Logger.Error("Failed to create user", "error", err)
+			return repo.User{}, fmt.Errorf("failed to create user: %w", err)
+		}
+		if conflict.Field != "username" || attempt > maxUsernameAttempts {
+			return repo.User{}, conflict
+		}
+	}
+}
+
+// baseUsername derives a username from the local part of email, or from
+// displayName when there is no email, keeping letters, digits, '.', '_'
+// and '-'. It leaves room for the suffix added when the name is taken.
+func baseUsername(email, displayName string) string {
+	source, _, _ := strings.Cut(email, "@")
+	if source == "" {
+		source = displayName
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(source) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			b.WriteRune(r)
+		case r == ' ':
+			b.WriteRune('-')
+		}
+	}
+
+	base := strings.Trim(b.String(), "._-")
+	if len(base) > maxUsernameLength-9 {
+		base = strings.TrimRight(base[:maxUsernameLength-9], "._-")
+	}
+	switch {
+	case base == "":
+		return "user"
+	case len(base) < minUsernameLength:
+		return base + "-user"
+	}
+	return base
+}
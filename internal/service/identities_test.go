@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/auth/oauth/oauthtest"
+)
+
+func TestResolveUserProvisionsOnFirstUse(t *testing.T) {
+	for name, svc := range map[string]*Service{
+		"memdb":  newMemService(),
+		"sqlite": newTestService(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			source := &fakeUserInfo{}
+			svc.Provisioning = Provisioning{Provider: "jwt", UserInfo: source}
+			ctx := tokenContext("uid-1", "Marc.Penta@example.com", true)
+
+			user, err := svc.ResolveUser(ctx)
+			if err != nil {
+				t.Fatalf("resolve: %v", err)
+			}
+			if user.Username != "marc.penta" || user.Email != "Marc.Penta@example.com" {
+				t.Fatalf("user = %+v", user)
+			}
+
+			again, err := svc.ResolveUser(ctx)
+			if err != nil || again.ID != user.ID {
+				t.Fatalf("second resolve = %+v, %v; want user %d", again, err, user.ID)
+			}
+			if source.calls != 1 {
+				t.Fatalf("GetUserInfo called %d times, want 1", source.calls)
+			}
+
+			// Deleting the user cascades to its identity
+			if err := svc.DeleteUser(ctx, user.ID); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+			recreated, err := svc.ResolveUser(ctx)
+			if err != nil || recreated.ID == user.ID {
+				t.Fatalf("resolve after delete = %+v, %v; want a new user", recreated, err)
+			}
+		})
+	}
+}
+
+func TestResolveUserLinksVerifiedEmail(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemService()
+	svc.Provisioning = Provisioning{Provider: "jwt", UserInfo: &fakeUserInfo{}}
+
+	existing, err := svc.CreateUser(ctx, &CreateUserInput{Username: "marc", Email: "marc@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	user, err := svc.ResolveUser(tokenContext("uid-1", "marc@example.com", true))
+	if err != nil || user.ID != existing.ID {
+		t.Fatalf("resolve = %+v, %v; want user %d", user, err, existing.ID)
+	}
+
+	_, err = svc.ResolveUser(tokenContext("uid-2", "marc@example.com", false))
+	var conflictErr *UserConflictError
+	if !errors.As(err, &conflictErr) || conflictErr.Field != "email" {
+		t.Fatalf("unverified err = %v, want email conflict", err)
+	}
+}
+
+func TestResolveUserDerivesUniqueUsernames(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemService()
+	svc.Provisioning = Provisioning{Provider: "jwt", UserInfo: &fakeUserInfo{}}
+
+	if _, err := svc.CreateUser(ctx, &CreateUserInput{Username: "marc", Email: "marc@example.org"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	user, err := svc.ResolveUser(tokenContext("uid-1", "marc@example.com", true))
+	if err != nil || user.Username != "marc-2" {
+		t.Fatalf("resolve = %+v, %v; want username marc-2", user, err)
+	}
+}
+
+func TestResolveUserWithoutProvisioning(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemService()
+
+	if _, err := svc.ResolveUser(ctx); !errors.Is(err, auth.ErrTokenNotInContext) {
+		t.Fatalf("anonymous err = %v, want %v", err, auth.ErrTokenNotInContext)
+	}
+	if _, err := svc.ResolveUser(tokenContext("uid-1", "marc@example.com", true)); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("unlinked err = %v, want %v", err, ErrUserNotFound)
+	}
+
+	svc.Provisioning = Provisioning{Provider: "jwt", UserInfo: &fakeUserInfo{}}
+	apiKeyCtx := auth.ContextWithToken(ctx, &auth.Token{UID: "apikey:1", Claims: map[string]interface{}{auth.ClaimAPIKey: "0123456789ab"}})
+	if _, err := svc.ResolveUser(apiKeyCtx); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("api key err = %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestResolveUserProvisionsFromJWTClaims(t *testing.T) {
+	ctx := context.Background()
+	issuer, err := oauthtest.NewServer("starter-app", "")
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(issuer.Close)
+	provider, err := auth.NewJWTProvider(auth.JWTConfig{Issuer: issuer.Issuer(), Audience: []string{issuer.ClientID}})
+	if err != nil {
+		t.Fatalf("NewJWTProvider: %v", err)
+	}
+
+	svc := newMemService()
+	svc.Provisioning = Provisioning{Provider: "jwt", UserInfo: provider}
+	verify := func(user oauthtest.User) context.Context {
+		t.Helper()
+		raw, err := issuer.IDToken(user)
+		if err != nil {
+			t.Fatalf("IDToken: %v", err)
+		}
+		token, err := provider.VerifyIDToken(ctx, raw)
+		if err != nil {
+			t.Fatalf("VerifyIDToken: %v", err)
+		}
+		return auth.ContextWithToken(ctx, token)
+	}
+
+	user, err := svc.ResolveUser(verify(oauthtest.User{Subject: "sub-1", Email: "jwt.user@example.com", EmailVerified: true, Name: "JWT User"}))
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if user.Username != "jwt.user" || user.Email != "jwt.user@example.com" {
+		t.Fatalf("user = %+v", user)
+	}
+
+	if _, err := svc.ResolveUser(verify(oauthtest.User{Subject: "sub-2"})); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("token without email err = %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestResolveUserWithUserIDs(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemService()
+	svc.Provisioning = Provisioning{Provider: "local", UIDIsUserID: true}
+
+	existing, err := svc.CreateUser(ctx, &CreateUserInput{Username: "marc", Email: "marc@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	user, err := svc.CurrentUser(auth.ContextWithToken(ctx, &auth.Token{UID: "1"}))
+	if err != nil || user.ID != existing.ID {
+		t.Fatalf("current user = %+v, %v; want user %d", user, err, existing.ID)
+	}
+	if _, err := svc.CurrentUser(auth.ContextWithToken(ctx, &auth.Token{UID: "2"})); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("unknown err = %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestProvisionIdentityKeepsProvidersApart(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemService()
+
+	google := &auth.Token{UID: "uid-1", Email: "marc@example.com", EmailVerified: true}
+	user, err := svc.ProvisionIdentity(ctx, "oauth:google", google)
+	if err != nil || user.Username != "marc" {
+		t.Fatalf("provision = %+v, %v; want user marc", user, err)
+	}
+	again, err := svc.ProvisionIdentity(ctx, "oauth:google", google)
+	if err != nil || again.ID != user.ID {
+		t.Fatalf("second provision = %+v, %v; want user %d", again, err, user.ID)
+	}
+
+	// The same UID from another provider is another identity
+	github := &auth.Token{UID: "uid-1", Email: "jo.smith@example.org"}
+	other, err := svc.ProvisionIdentity(ctx, "oauth:github", github)
+	if err != nil || other.ID == user.ID || other.Username != "jo.smith" {
+		t.Fatalf("other provider = %+v, %v; want a new user jo.smith", other, err)
+	}
+}
+
+func TestBaseUsername(t *testing.T) {
+	tests := []struct {
+		email, displayName, want string
+	}{
+		{"Marc.Penta@example.com", "", "marc.penta"},
+		{"", "Marc Penta", "marc-penta"},
+		{"jo@example.com", "", "jo-user"},
+		{"+++@example.com", "", "user"},
+		{"_marc+tag@example.com", "", "marctag"},
+	}
+
+	for _, tt := range tests {
+		if got := baseUsername(tt.email, tt.displayName); got != tt.want {
+			t.Errorf("baseUsername(%q, %q) = %q, want %q", tt.email, tt.displayName, got, tt.want)
+		}
+	}
+}
+
+// fakeUserInfo answers GetUserInfo from the token stored by tokenContext
+type fakeUserInfo struct {
+	calls int
+}
+
+func (f *fakeUserInfo) GetUserInfo(ctx context.Context, uid string) (*auth.UserInfo, error) {
+	f.calls++
+	token, ok := auth.TokenFromContext(ctx)
+	if !ok || token.UID != uid {
+		return nil, auth.ErrUserNotFound
+	}
+	return &auth.UserInfo{UID: uid, Email: token.Email, EmailVerified: token.EmailVerified}, nil
+}
+
+func tokenContext(uid, email string, verified bool) context.Context {
+	return auth.ContextWithToken(context.Background(), &auth.Token{UID: uid, Email: email, EmailVerified: verified})
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth/totp"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+var (
+	ErrInvalidMFACode    = errors.New("invalid mfa code")
+	ErrMFANotEnabled     = errors.New("mfa not enabled")
+	ErrMFAAlreadyEnabled = errors.New("mfa already enabled")
+)
+
+// MFAConfig configures TOTP enrollment and verification
+type MFAConfig struct {
+	// Issuer names the application in authenticator apps
+	Issuer string
+	// Now returns the current time; nil means time.Now
+	Now func() time.Time
+}
+
+func (c MFAConfig) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+// MFAStatus describes the caller's second factor
+type MFAStatus struct {
+	Enabled bool `json:"enabled"`
+	// Pending is set between EnrollTOTP and ConfirmTOTP
+	Pending                bool  `json:"pending"`
+	RecoveryCodesRemaining int64 `json:"recovery_codes_remaining"`
+}
+
+// TOTPEnrollment is a new TOTP secret for the caller to add to an
+// authenticator app, by typing Secret or scanning URI as a QR code
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// RecoveryCodes are shown once; only their hashes are stored
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+type MFACodeInput struct {
+	Code string `json:"code"`
+}
+
+// GetMFAStatus reports whether the caller has a second factor
+func (s *Service) GetMFAStatus(ctx context.Context) (*MFAStatus, error) {
+	user, err := s.CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	q := s.queries(ctx)
+	secret, err := q.GetUserTOTP(ctx, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &MFAStatus{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch totp: %w", err)
+	}
+	remaining, err := q.CountUnusedRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+
+	return &MFAStatus{
+		Enabled:                secret.ConfirmedAt.Valid,
+		Pending:                !secret.ConfirmedAt.Valid,
+		RecoveryCodesRemaining: remaining,
+	}, nil
+}
+
+// EnrollTOTP starts TOTP enrollment for the caller, replacing any pending
+// secret. The secret takes effect once ConfirmTOTP sees a code from it.
+func (s *Service) EnrollTOTP(ctx context.Context) (*TOTPEnrollment, error) {
+	user, err := s.CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate totp secret: %w", err)
+	}
+
+	s.Logger.Info("Enrolling TOTP", "user_id", user.ID)
+
+	_, err = s.queries(ctx).UpsertUserTOTP(ctx, repo.UpsertUserTOTPParams{UserID: user.ID, Secret: secret})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if err != nil {
+		s.Logger.Error("Failed to store totp secret", "error", err)
+		return nil, fmt.Errorf("failed to store totp secret: %w", err)
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(s.MFA.Issuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables the pending secret once input holds a valid code from
+// it, and returns a fresh set of recovery codes
+func (s *Service) ConfirmTOTP(ctx context.Context, input *MFACodeInput) (*RecoveryCodes, error) {
+	code, err := validateMFACodeInput(input)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var codes *RecoveryCodes
+	err = s.WithTx(ctx, func(ctx context.Context, q repo.Store) error {
+		secret, err := q.GetUserTOTP(ctx, user.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrMFANotEnabled
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch totp: %w", err)
+		}
+		if secret.ConfirmedAt.Valid {
+			return ErrMFAAlreadyEnabled
+		}
+
+		now := s.MFA.now()
+		step, ok := totp.Validate(secret.Secret, code, now, totp.DefaultSkew, secret.LastUsedStep)
+		if !ok {
+			return invalidMFACode()
+		}
+		confirmed, err := q.ConfirmUserTOTP(ctx, repo.ConfirmUserTOTPParams{
+			ConfirmedAt:  sql.NullTime{Time: now.UTC(), Valid: true},
+			LastUsedStep: step,
+			UserID:       user.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to confirm totp: %w", err)
+		}
+		if confirmed == 0 {
+			return ErrMFAAlreadyEnabled
+		}
+
+		codes, err = replaceRecoveryCodes(ctx, q, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.Logger.Info("Enabled TOTP", "user_id", user.ID)
+	return codes, nil
+}
+
+// VerifyMFA checks a TOTP or recovery code for the caller. Each TOTP time
+// step and each recovery code is accepted only once.
+func (s *Service) VerifyMFA(ctx context.Context, code string) error {
+	code, err := validateMFACodeInput(&MFACodeInput{Code: code})
+	if err != nil {
+		return err
+	}
+	user, err := s.CurrentUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	q := s.queries(ctx)
+	secret, err := q.GetUserTOTP(ctx, user.ID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !secret.ConfirmedAt.Valid) {
+		return ErrMFANotEnabled
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch totp: %w", err)
+	}
+
+	now := s.MFA.now()
+	if isTOTPCode(code) {
+		step, ok := totp.Validate(secret.Secret, code, now, totp.DefaultSkew, secret.LastUsedStep)
+		if !ok {
+			return invalidMFACode()
+		}
+		// A concurrent request may have used the same step first
+		used, err := q.UseTOTPStep(ctx, repo.UseTOTPStepParams{Step: step, UserID: user.ID})
+		if err != nil {
+			return fmt.Errorf("failed to record totp step: %w", err)
+		}
+		if used == 0 {
+			return invalidMFACode()
+		}
+		return nil
+	}
+
+	used, err := q.UseRecoveryCode(ctx, repo.UseRecoveryCodeParams{
+		UsedAt:   sql.NullTime{Time: now.UTC(), Valid: true},
+		UserID:   user.ID,
+		CodeHash: totp.HashRecoveryCode(code),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if used == 0 {
+		return invalidMFACode()
+	}
+
+	s.Logger.Warn("Recovery code used", "user_id", user.ID)
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context) (*RecoveryCodes, error) {
+	user, err := s.CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var codes *RecoveryCodes
+	err = s.WithTx(ctx, func(ctx context.Context, q repo.Store) error {
+		secret, err := q.GetUserTOTP(ctx, user.ID)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !secret.ConfirmedAt.Valid) {
+			return ErrMFANotEnabled
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch totp: %w", err)
+		}
+
+		codes, err = replaceRecoveryCodes(ctx, q, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.Logger.Info("Regenerated recovery codes", "user_id", user.ID)
+	return codes, nil
+}
+
+// DisableTOTP removes the caller's TOTP secret and recovery codes
+func (s *Service) DisableTOTP(ctx context.Context) error {
+	user, err := s.CurrentUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.Logger.Info("Disabling TOTP", "user_id", user.ID)
+
+	return s.WithTx(ctx, func(ctx context.Context, q repo.Store) error {
+		deleted, err := q.DeleteUserTOTP(ctx, user.ID)
+		if err != nil {
+			return fmt.Errorf("failed to delete totp: %w", err)
+		}
+		if deleted == 0 {
+			return ErrMFANotEnabled
+		}
+		if _, err := q.DeleteRecoveryCodes(ctx, user.ID); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+		return nil
+	})
+}
+
+// replaceRecoveryCodes stores the hashes of a new set of recovery codes in
+// place of any old ones
+func replaceRecoveryCodes(ctx context.Context, q repo.Store, userID int64) (*RecoveryCodes, error) {
+	codes, err := totp.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+	if _, err := q.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, code := range codes {
+		_, err := q.CreateRecoveryCode(ctx, repo.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: totp.HashRecoveryCode(code),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+	return &RecoveryCodes{Codes: codes}, nil
+}
+
+func validateMFACodeInput(input *MFACodeInput) (string, error) {
+	if input == nil {
+		return "", &ValidationError{Err: ErrInvalidMFACode, Detail: "missing code payload"}
+	}
+	code := strings.TrimSpace(input.Code)
+	if code == "" {
+		verr := &ValidationError{Err: ErrInvalidMFACode}
+		verr.add("code", "is required")
+		return "", verr
+	}
+	return code, nil
+}
+
+// invalidMFACode reports a code that does not match, without saying why
+func invalidMFACode() error {
+	verr := &ValidationError{Err: ErrInvalidMFACode}
+	verr.add("code", "is invalid")
+	return verr
+}
+
+// isTOTPCode tells TOTP codes, which are all digits, from recovery codes
+func isTOTPCode(code string) bool {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totp.Digits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
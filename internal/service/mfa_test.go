@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/auth/totp"
+)
+
+func TestTOTPEnrollmentAndVerification(t *testing.T) {
+	for name, svc := range map[string]*Service{
+		"memdb":  newMemService(),
+		"sqlite": newTestService(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			svc.MFA = MFAConfig{Issuer: "Starter", Now: func() time.Time { return now }}
+			ctx := userContext(t, svc, "marc")
+
+			if err := svc.VerifyMFA(ctx, "123456"); !errors.Is(err, ErrMFANotEnabled) {
+				t.Fatalf("verify before enrollment = %v, want ErrMFANotEnabled", err)
+			}
+
+			enrollment, err := svc.EnrollTOTP(ctx)
+			if err != nil {
+				t.Fatalf("enroll: %v", err)
+			}
+			if !strings.HasPrefix(enrollment.URI, "otpauth://totp/Starter:marc@example.com?") {
+				t.Fatalf("URI = %s", enrollment.URI)
+			}
+			if status, err := svc.GetMFAStatus(ctx); err != nil || status.Enabled || !status.Pending {
+				t.Fatalf("status = %+v, %v, want pending", status, err)
+			}
+
+			// A pending secret cannot be used for step-up
+			code := codeAt(t, enrollment.Secret, now)
+			if err := svc.VerifyMFA(ctx, code); !errors.Is(err, ErrMFANotEnabled) {
+				t.Fatalf("verify pending = %v, want ErrMFANotEnabled", err)
+			}
+			if _, err := svc.ConfirmTOTP(ctx, &MFACodeInput{Code: "000000"}); !errors.Is(err, ErrInvalidMFACode) {
+				t.Fatalf("confirm with wrong code = %v, want ErrInvalidMFACode", err)
+			}
+			recovery, err := svc.ConfirmTOTP(ctx, &MFACodeInput{Code: code})
+			if err != nil {
+				t.Fatalf("confirm: %v", err)
+			}
+			if len(recovery.Codes) != totp.RecoveryCodeCount {
+				t.Fatalf("recovery codes = %v", recovery.Codes)
+			}
+			if _, err := svc.EnrollTOTP(ctx); !errors.Is(err, ErrMFAAlreadyEnabled) {
+				t.Fatalf("enroll again = %v, want ErrMFAAlreadyEnabled", err)
+			}
+
+			// The confirming code's step is used up, so it cannot be replayed
+			if err := svc.VerifyMFA(ctx, code); !errors.Is(err, ErrInvalidMFACode) {
+				t.Fatalf("replayed code = %v, want ErrInvalidMFACode", err)
+			}
+			now = now.Add(totp.Period)
+			next := codeAt(t, enrollment.Secret, now)
+			if err := svc.VerifyMFA(ctx, next); err != nil {
+				t.Fatalf("verify next code: %v", err)
+			}
+			if err := svc.VerifyMFA(ctx, next); !errors.Is(err, ErrInvalidMFACode) {
+				t.Fatalf("replayed next code = %v, want ErrInvalidMFACode", err)
+			}
+
+			// A code from just before the current step is accepted for drift
+			now = now.Add(2 * totp.Period)
+			if err := svc.VerifyMFA(ctx, codeAt(t, enrollment.Secret, now.Add(-totp.Period))); err != nil {
+				t.Fatalf("verify drifted code: %v", err)
+			}
+
+			// Recovery codes work once, in any case
+			if err := svc.VerifyMFA(ctx, strings.ToUpper(recovery.Codes[0])); err != nil {
+				t.Fatalf("verify recovery code: %v", err)
+			}
+			if err := svc.VerifyMFA(ctx, recovery.Codes[0]); !errors.Is(err, ErrInvalidMFACode) {
+				t.Fatalf("reused recovery code = %v, want ErrInvalidMFACode", err)
+			}
+			if status, err := svc.GetMFAStatus(ctx); err != nil || !status.Enabled || status.RecoveryCodesRemaining != totp.RecoveryCodeCount-1 {
+				t.Fatalf("status = %+v, %v, want enabled with %d codes", status, err, totp.RecoveryCodeCount-1)
+			}
+
+			regenerated, err := svc.RegenerateRecoveryCodes(ctx)
+			if err != nil {
+				t.Fatalf("regenerate: %v", err)
+			}
+			if err := svc.VerifyMFA(ctx, recovery.Codes[1]); !errors.Is(err, ErrInvalidMFACode) {
+				t.Fatalf("old recovery code = %v, want ErrInvalidMFACode", err)
+			}
+			if err := svc.VerifyMFA(ctx, regenerated.Codes[1]); err != nil {
+				t.Fatalf("new recovery code: %v", err)
+			}
+
+			if err := svc.DisableTOTP(ctx); err != nil {
+				t.Fatalf("disable: %v", err)
+			}
+			if status, err := svc.GetMFAStatus(ctx); err != nil || status.Enabled || status.Pending {
+				t.Fatalf("status after disable = %+v, %v", status, err)
+			}
+			if err := svc.DisableTOTP(ctx); !errors.Is(err, ErrMFANotEnabled) {
+				t.Fatalf("disable again = %v, want ErrMFANotEnabled", err)
+			}
+		})
+	}
+}
+
+func TestVerifyMFARequiresCode(t *testing.T) {
+	svc := newMemService()
+	ctx := userContext(t, svc, "marc")
+
+	var verr *ValidationError
+	if err := svc.VerifyMFA(ctx, "  "); !errors.As(err, &verr) || verr.Fields[0].Field != "code" {
+		t.Fatalf("err = %v, want code validation error", err)
+	}
+}
+
+// userContext creates a user and returns a context carrying it, as
+// LoadCurrentUser does for requests
+func userContext(t *testing.T, svc *Service, username string) context.Context {
+	t.Helper()
+
+	user, err := svc.CreateUser(context.Background(), &CreateUserInput{Username: username, Email: username + "@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return ContextWithUser(context.Background(), user)
+}
+
+func codeAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, at)
+	if err != nil {
+		t.Fatalf("totp code: %v", err)
+	}
+	return code
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// DefaultPageSize is used when a list request does not specify a limit
+	DefaultPageSize = 100
+
+	// MaxPageSize is the largest page a list request may ask for
+	MaxPageSize = 500
+)
+
+var ErrInvalidPageRequest = errors.New("invalid page request")
+
+// cursorDirection says which side of the cursor's row a page lies on
+type cursorDirection string
+
+const (
+	cursorAfter  cursorDirection = "a"
+	cursorBefore cursorDirection = "b"
+)
+
+// cursor identifies a position in an ordered listing: the sort order it was
+// issued for, the sort key and id of the boundary row, and which side of that
+// row the page lies on. It is serialised as opaque base64 so clients cannot
+// depend on its contents.
+type cursor struct {
+	Sort      string          `json:"s,omitempty"`
+	Key       string          `json:"k,omitempty"`
+	ID        int64           `json:"id"`
+	Direction cursorDirection `json:"d"`
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, err
+	}
+	if c.ID < 0 || (c.Direction != cursorAfter && c.Direction != cursorBefore) {
+		return c, fmt.Errorf("cursor has invalid position")
+	}
+
+	return c, nil
+}
+
+// PageInput requests one page of a listing, either by offset or, for keyset
+// pagination, by cursor
+type PageInput struct {
+	// Limit is the page size; zero means DefaultPageSize
+	Limit int64
+	// Offset is the number of rows to skip. It cannot be combined with Cursor.
+	Offset int64
+	// Cursor is a NextCursor or PrevCursor from a previous page; empty means the first page
+	Cursor string
+}
+
+// validateOffsetInput applies defaults and bounds to an offset page request
+func validateOffsetInput(input *PageInput) error {
+	verr := &ValidationError{Err: ErrInvalidPageRequest}
+	validateLimit(input, verr)
+	if input.Offset < 0 {
+		verr.add("offset", "must not be negative")
+	}
+	if input.Cursor != "" {
+		verr.add("cursor", "cannot be used with offset pagination")
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// validatePageInput applies defaults and bounds, and decodes the cursor,
+// which must have been issued for the given sort order
+func validatePageInput(input *PageInput, sort string) (cursor, error) {
+	verr := &ValidationError{Err: ErrInvalidPageRequest}
+	validateLimit(input, verr)
+	if input.Offset != 0 {
+		verr.add("offset", "cannot be combined with cursor")
+	}
+
+	c := cursor{Direction: cursorAfter}
+	if input.Cursor != "" {
+		decoded, err := decodeCursor(input.Cursor)
+		switch {
+		case err != nil:
+			verr.add("cursor", "is malformed")
+		case decoded.Sort != sort:
+			verr.add("cursor", "was issued for a different sort order")
+		default:
+			c = decoded
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return cursor{}, verr
+	}
+	return c, nil
+}
+
+// validateLimit defaults the page size and checks its bounds
+func validateLimit(input *PageInput, verr *ValidationError) {
+	if input.Limit == 0 {
+		input.Limit = DefaultPageSize
+	}
+	if input.Limit < 1 || input.Limit > MaxPageSize {
+		verr.add("limit", fmt.Sprintf("must be between 1 and %d", MaxPageSize))
+	}
+}
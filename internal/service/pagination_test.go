@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGetUsersPageWalksForwardAndBack(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemService()
+	for i := 1; i <= 5; i++ {
+		if _, err := svc.CreateUser(ctx, &CreateUserInput{
+			Username: fmt.Sprintf("user%d", i),
+			Email:    fmt.Sprintf("user%d@example.com", i),
+		}); err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+
+	first, err := svc.GetUsersPage(ctx, nil, &PageInput{Limit: 2})
+	if err != nil {
+		t.Fatalf("first page: %v", err)
+	}
+	assertPageIDs(t, first, 1, 2)
+	if first.PrevCursor != "" || first.NextCursor == "" {
+		t.Fatalf("first page cursors = %q / %q", first.PrevCursor, first.NextCursor)
+	}
+
+	second, err := svc.GetUsersPage(ctx, nil, &PageInput{Limit: 2, Cursor: first.NextCursor})
+	if err != nil {
+		t.Fatalf("second page: %v", err)
+	}
+	assertPageIDs(t, second, 3, 4)
+
+	last, err := svc.GetUsersPage(ctx, nil, &PageInput{Limit: 2, Cursor: second.NextCursor})
+	if err != nil {
+		t.Fatalf("last page: %v", err)
+	}
+	assertPageIDs(t, last, 5)
+	if last.NextCursor != "" {
+		t.Fatalf("last page next cursor = %q, want empty", last.NextCursor)
+	}
+
+	back, err := svc.GetUsersPage(ctx, nil, &PageInput{Limit: 2, Cursor: last.PrevCursor})
+	if err != nil {
+		t.Fatalf("previous page: %v", err)
+	}
+	assertPageIDs(t, back, 3, 4)
+
+	start, err := svc.GetUsersPage(ctx, nil, &PageInput{Limit: 2, Cursor: back.PrevCursor})
+	if err != nil {
+		t.Fatalf("first page again: %v", err)
+	}
+	assertPageIDs(t, start, 1, 2)
+	if start.PrevCursor != "" {
+		t.Fatalf("first page prev cursor = %q, want empty", start.PrevCursor)
+	}
+}
+
+func TestGetUsersPagesThroughSortedFilteredUsers(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemService()
+	for _, name := range []string{"mara", "anna", "marc", "mike", "maria"} {
+		if _, err := svc.CreateUser(ctx, &CreateUserInput{Username: name, Email: name + "@example.com"}); err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+
+	filter := &UserFilter{UsernamePrefix: "mar", SortBy: SortByUsername, Descending: true}
+	first, err := svc.GetUsersPage(ctx, filter, &PageInput{Limit: 2})
+	if err != nil {
+		t.Fatalf("first page: %v", err)
+	}
+	if first.Total != 3 {
+		t.Fatalf("Total = %d, want 3", first.Total)
+	}
+	assertPageIDs(t, first, 5, 3) // maria, marc
+
+	second, err := svc.GetUsersPage(ctx, filter, &PageInput{Limit: 2, Cursor: first.NextCursor})
+	if err != nil {
+		t.Fatalf("second page: %v", err)
+	}
+	assertPageIDs(t, second, 1) // mara
+	if second.NextCursor != "" {
+		t.Fatalf("NextCursor = %q, want empty", second.NextCursor)
+	}
+
+	// A cursor only works with the sort order it was issued for
+	_, err = svc.GetUsersPage(ctx, &UserFilter{SortBy: SortByEmail}, &PageInput{Cursor: first.NextCursor})
+	if !errors.Is(err, ErrInvalidPageRequest) {
+		t.Fatalf("err = %v, want %v", err, ErrInvalidPageRequest)
+	}
+}
+
+func TestGetUsersRejectsInvalidFilter(t *testing.T) {
+	svc := newMemService()
+	now := time.Now()
+
+	tests := map[string]*UserFilter{
+		"unknown sort":   {SortBy: "password"},
+		"inverted range": {CreatedAfter: now, CreatedBefore: now.Add(-time.Hour)},
+	}
+
+	for name, filter := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := svc.GetUsersPage(context.Background(), filter, nil)
+			if !errors.Is(err, ErrInvalidUserFilter) {
+				t.Fatalf("err = %v, want %v", err, ErrInvalidUserFilter)
+			}
+		})
+	}
+}
+
+func TestGetUsersRejectsInvalidPageInput(t *testing.T) {
+	svc := newMemService()
+
+	tests := map[string]*PageInput{
+		"negative limit":   {Limit: -1},
+		"limit over max":   {Limit: MaxPageSize + 1},
+		"malformed cursor": {Cursor: "not-a-cursor"},
+		"offset":           {Offset: 10},
+	}
+
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := svc.GetUsersPage(context.Background(), nil, input)
+			if !errors.Is(err, ErrInvalidPageRequest) {
+				t.Fatalf("err = %v, want %v", err, ErrInvalidPageRequest)
+			}
+		})
+	}
+}
+
+func TestGetUsersSkipsOffset(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemService()
+	for _, name := range []string{"marc", "anna", "lena"} {
+		if _, err := svc.CreateUser(ctx, &CreateUserInput{Username: name, Email: name + "@example.com"}); err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+
+	users, total, err := svc.GetUsers(ctx, &UserFilter{SortBy: SortByUsername}, &PageInput{Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatalf("get users: %v", err)
+	}
+	if len(users) != 1 || users[0].Username != "lena" || total != 3 {
+		t.Fatalf("users = %#v, total %d; want lena of 3", users, total)
+	}
+
+	for name, input := range map[string]*PageInput{
+		"negative offset": {Offset: -1},
+		"cursor":          {Cursor: "abc"},
+	} {
+		if _, _, err := svc.GetUsers(ctx, nil, input); !errors.Is(err, ErrInvalidPageRequest) {
+			t.Errorf("%s: err = %v, want %v", name, err, ErrInvalidPageRequest)
+		}
+	}
+}
+
+func assertPageIDs(t *testing.T, page *UserPage, ids ...int64) {
+	t.Helper()
+
+	if len(page.Users) != len(ids) {
+		t.Fatalf("page has %d users, want %d", len(page.Users), len(ids))
+	}
+	for i, id := range ids {
+		if page.Users[i].ID != id {
+			t.Fatalf("page[%d].ID = %d, want %d", i, page.Users[i].ID, id)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+// UserRoles returns the roles granted to a local user, sorted by name
+func (s *Service) UserRoles(ctx context.Context, userID int64) ([]string, error) {
+	roles, err := s.queries(ctx).ListUserRoles(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user roles: %w", err)
+	}
+	return roles, nil
+}
+
+// ContextWithUserRoles returns a copy of ctx whose auth token also carries
+// the roles granted to user, so role policies checked against ctx see them.
+// ctx is returned as is when it has no token or the user has no roles.
+func (s *Service) ContextWithUserRoles(ctx context.Context, user *repo.User) (context.Context, error) {
+	token, ok := auth.TokenFromContext(ctx)
+	if !ok || token == nil {
+		return ctx, nil
+	}
+	roles, err := s.UserRoles(ctx, user.ID)
+	if err != nil || len(roles) == 0 {
+		return ctx, err
+	}
+	return auth.ContextWithToken(ctx, auth.TokenWithRoles(token, roles...)), nil
+}
+
+// GrantRole grants a role, such as auth.RoleAdmin, to the user with the
+// given email. Granting a role the user already holds is a no-op.
+func (s *Service) GrantRole(ctx context.Context, email, role string) (*repo.User, error) {
+	user, err := s.userForRole(ctx, email, role)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Logger.Info("Granting role", "user_id", user.ID, "role", role)
+	if _, err := s.queries(ctx).GrantUserRole(ctx, repo.GrantUserRoleParams{UserID: user.ID, Role: role}); err != nil {
+		return nil, fmt.Errorf("failed to grant role: %w", err)
+	}
+	return user, nil
+}
+
+// RevokeRole removes a role from the user with the given email. Revoking a
+// role the user does not hold is a no-op.
+func (s *Service) RevokeRole(ctx context.Context, email, role string) (*repo.User, error) {
+	user, err := s.userForRole(ctx, email, role)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Logger.Info("Revoking role", "user_id", user.ID, "role", role)
+	if _, err := s.queries(ctx).RevokeUserRole(ctx, repo.RevokeUserRoleParams{UserID: user.ID, Role: role}); err != nil {
+		return nil, fmt.Errorf("failed to revoke role: %w", err)
+	}
+	return user, nil
+}
+
+// userForRole validates a role change and looks up its user
+func (s *Service) userForRole(ctx context.Context, email, role string) (*repo.User, error) {
+	verr := &ValidationError{Err: ErrInvalidUserInput}
+	if strings.TrimSpace(email) == "" {
+		verr.add("email", "is required")
+	}
+	if strings.TrimSpace(role) == "" {
+		verr.add("role", "is required")
+	}
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+
+	return s.GetUserByEmail(ctx, email)
+}
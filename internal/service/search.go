@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mhpenta/starterA/internal/database"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+const (
+	// DefaultSearchLimit is used when a search does not specify a limit
+	DefaultSearchLimit = 20
+
+	// MaxSearchLimit is the most results a search may ask for
+	MaxSearchLimit = 100
+
+	maxSearchQueryLength = 256
+	maxSearchTerms       = 8
+
+	// ftsRetryInterval is how long searches use the LIKE fallback after the
+	// full-text index was found missing, before trying it again; the
+	// optional migration creating it is retried on the next start
+	ftsRetryInterval = 5 * time.Minute
+)
+
+// Search modes reported in UserSearchResults
+const (
+	SearchModeFullText = "fts"
+	SearchModeLike     = "like"
+)
+
+// highlightStart and highlightEnd are private-use runes that cannot occur in
+// valid input, so matches can be marked before the text is HTML-escaped
+const (
+	highlightStart = "\uE000"
+	highlightEnd   = "\uE001"
+)
+
+var ErrInvalidSearch = errors.New("invalid search")
+
+// UserSearchInput is a free-text search over usernames and emails
+type UserSearchInput struct {
+	Query string
+	// Limit is the number of results; zero means DefaultSearchLimit
+	Limit int64
+}
+
+// UserSearchResult is a matched user with its relevance and the username and
+// email as HTML-escaped text with matched terms wrapped in <mark> elements
+type UserSearchResult struct {
+	repo.User
+	Rank              float64 `json:"rank"`
+	UsernameHighlight string  `json:"username_highlight"`
+	EmailHighlight    string  `json:"email_highlight"`
+}
+
+// UserSearchResults are the best matches for a search, most relevant first.
+// Mode says whether they came from the full-text index or the LIKE fallback.
+type UserSearchResults struct {
+	Results []UserSearchResult `json:"results"`
+	Mode    string             `json:"mode"`
+}
+
+// SearchUsers finds users whose username or email contains every term of the
+// query, ranking them with FTS5 when available and LIKE otherwise
+func (s *Service) SearchUsers(ctx context.Context, input *UserSearchInput) (*UserSearchResults, error) {
+	if input == nil {
+		input = &UserSearchInput{}
+	}
+	terms, err := validateUserSearchInput(input)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Logger.Info("Searching users", "query", input.Query, "limit", input.Limit)
+
+	q := s.queries(ctx)
+	if time.Now().UnixNano() >= s.ftsRetryAt.Load() {
+		rows, err := q.SearchUsers(ctx, repo.SearchUsersParams{
+			Match:          ftsMatchExpression(terms),
+			HighlightStart: highlightStart,
+			HighlightEnd:   highlightEnd,
+			Limit:          input.Limit,
+		})
+		switch {
+		case err == nil:
+			results := &UserSearchResults{Results: make([]UserSearchResult, 0, len(rows)), Mode: SearchModeFullText}
+			for _, row := range rows {
+				results.Results = append(results.Results, UserSearchResult{
+					User:              row.User,
+					Rank:              row.Rank,
+					UsernameHighlight: renderHighlight(row.UsernameHighlight),
+					EmailHighlight:    renderHighlight(row.EmailHighlight),
+				})
+			}
+			return results, nil
+		case database.IsFTSUnavailable(err):
+			s.Logger.Warn("Full-text search unavailable, falling back to LIKE", "error", err, "retry_in", ftsRetryInterval)
+			s.ftsRetryAt.Store(time.Now().Add(ftsRetryInterval).UnixNano())
+		default:
+			s.Logger.Error("Failed to search users", "error", err)
+			return nil, fmt.Errorf("failed to search users: %w", err)
+		}
+	}
+
+	users, err := q.SearchUsersLike(ctx, repo.SearchUsersLikeParams{Terms: terms, Limit: input.Limit})
+	if err != nil {
+		s.Logger.Error("Failed to search users", "error", err)
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+
+	results := &UserSearchResults{Results: make([]UserSearchResult, 0, len(users)), Mode: SearchModeLike}
+	for _, u := range users {
+		results.Results = append(results.Results, UserSearchResult{
+			User:              u,
+			UsernameHighlight: renderHighlight(markTerms(u.Username, terms)),
+			EmailHighlight:    renderHighlight(markTerms(u.Email, terms)),
+		})
+	}
+	return results, nil
+}
+
+// validateUserSearchInput applies the default limit and splits the query
+// into its whitespace-separated terms
+func validateUserSearchInput(input *UserSearchInput) ([]string, error) {
+	verr := &ValidationError{Err: ErrInvalidSearch}
+
+	if input.Limit == 0 {
+		input.Limit = DefaultSearchLimit
+	}
+	if input.Limit < 1 || input.Limit > MaxSearchLimit {
+		verr.add("limit", fmt.Sprintf("must be between 1 and %d", MaxSearchLimit))
+	}
+
+	terms := strings.Fields(input.Query)
+	switch {
+	case !utf8.ValidString(input.Query) || strings.ContainsAny(input.Query, highlightStart+highlightEnd):
+		verr.add("q", "contains invalid characters")
+	case len(terms) == 0:
+		verr.add("q", "is required")
+	case len(input.Query) > maxSearchQueryLength:
+		verr.add("q", fmt.Sprintf("must be at most %d bytes", maxSearchQueryLength))
+	case len(terms) > maxSearchTerms:
+		verr.add("q", fmt.Sprintf("must have at most %d terms", maxSearchTerms))
+	}
+
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+	return terms, nil
+}
+
+// ftsMatchExpression quotes each term as an FTS5 string so operators and
+// punctuation in user input are taken literally, and makes each a prefix
+// query so partial words match as they do with LIKE
+func ftsMatchExpression(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// markTerms wraps every case-insensitive occurrence of the terms in s with
+// the highlight markers, the way FTS5's highlight() does for the index.
+// Terms are compared rune by rune with strings.EqualFold against windows of
+// s itself, since lowercasing can change a string's byte length.
+func markTerms(s string, terms []string) string {
+	marked := make([]bool, len(s))
+	for _, term := range terms {
+		runes := utf8.RuneCountInString(term)
+		for i := 0; i < len(s); {
+			end := i
+			for n := 0; n < runes && end < len(s); n++ {
+				_, size := utf8.DecodeRuneInString(s[end:])
+				end += size
+			}
+			if strings.EqualFold(s[i:end], term) {
+				for j := i; j < end; j++ {
+					marked[j] = true
+				}
+			}
+			_, size := utf8.DecodeRuneInString(s[i:])
+			i += size
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString(highlightStart)
+		}
+		b.WriteByte(s[i])
+		if marked[i] && (i == len(s)-1 || !marked[i+1]) {
+			b.WriteString(highlightEnd)
+		}
+	}
+	return b.String()
+}
+
+// renderHighlight escapes s for HTML and turns the highlight markers into
+// <mark> elements
+func renderHighlight(s string) string {
+	return strings.NewReplacer(highlightStart, "<mark>", highlightEnd, "</mark>").Replace(html.EscapeString(s))
+}
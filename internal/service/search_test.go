@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSearchUsersRanksAndHighlightsWithFullText(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+	createUsers(t, svc, "marc", "marcella", "bob")
+	if _, err := svc.CreateUser(ctx, &CreateUserInput{Username: "alice", Email: "marc.fan@example.com"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	results, err := svc.SearchUsers(ctx, &UserSearchInput{Query: "marc"})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if results.Mode != SearchModeFullText {
+		t.Fatalf("mode = %q, want %q", results.Mode, SearchModeFullText)
+	}
+	if len(results.Results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results.Results))
+	}
+	for _, r := range results.Results {
+		if r.Username == "bob" {
+			t.Fatal("bob should not match")
+		}
+	}
+
+	top := results.Results[0]
+	if top.Username != "marc" {
+		t.Fatalf("top result = %q, want marc", top.Username)
+	}
+	if top.UsernameHighlight != "<mark>marc</mark>" {
+		t.Fatalf("username highlight = %q", top.UsernameHighlight)
+	}
+	if top.EmailHighlight != "<mark>marc</mark>@example.com" {
+		t.Fatalf("email highlight = %q", top.EmailHighlight)
+	}
+}
+
+func TestSearchUsersTreatsQuerySyntaxLiterally(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+	createUsers(t, svc, "marc")
+
+	for _, q := range []string{`marc OR`, `"marc`, `marc*`, `NEAR(marc)`, `-marc`} {
+		if _, err := svc.SearchUsers(ctx, &UserSearchInput{Query: q}); err != nil {
+			t.Errorf("search %q: %v", q, err)
+		}
+	}
+}
+
+func TestSearchUsersFallsBackToLike(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemService()
+	createUsers(t, svc, "bob", "marcella", "marc")
+	if _, err := svc.CreateUser(ctx, &CreateUserInput{Username: "x<b>y", Email: "xby@example.com"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	results, err := svc.SearchUsers(ctx, &UserSearchInput{Query: "MARC"})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if results.Mode != SearchModeLike {
+		t.Fatalf("mode = %q, want %q", results.Mode, SearchModeLike)
+	}
+	if len(results.Results) != 2 || results.Results[0].Username != "marc" || results.Results[1].Username != "marcella" {
+		t.Fatalf("results = %+v, want marc then marcella", results.Results)
+	}
+	if got := results.Results[1].UsernameHighlight; got != "<mark>marc</mark>ella" {
+		t.Fatalf("username highlight = %q", got)
+	}
+
+	results, err = svc.SearchUsers(ctx, &UserSearchInput{Query: "<b>"})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(results.Results) != 1 || results.Results[0].UsernameHighlight != "x<mark>&lt;b&gt;</mark>y" {
+		t.Fatalf("results = %+v, want escaped highlight", results.Results)
+	}
+}
+
+func TestMarkTermsKeepsOffsetsInText(t *testing.T) {
+	tests := []struct {
+		text, term, want string
+	}{
+		{"ȺȺȺabc", "abc", "ȺȺȺ\uE000abc\uE001"},
+		{"İİİabc", "abc", "İİİ\uE000abc\uE001"},
+		{"xȺȺy", "ⱥⱥ", "x\uE000ȺȺ\uE001y"},
+		{"MarcMARC", "marc", "\uE000MarcMARC\uE001"},
+	}
+	for _, tt := range tests {
+		if got := markTerms(tt.text, []string{tt.term}); got != tt.want {
+			t.Fatalf("markTerms(%q, %q) = %q, want %q", tt.text, tt.term, got, tt.want)
+		}
+	}
+}
+
+func TestSearchUsersRejectsInvalidInput(t *testing.T) {
+	svc := newMemService()
+
+	tests := map[string]*UserSearchInput{
+		"empty query":    {Query: "   "},
+		"limit too big":  {Query: "marc", Limit: MaxSearchLimit + 1},
+		"too many terms": {Query: "a b c d e f g h i"},
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := svc.SearchUsers(context.Background(), input)
+			var verr *ValidationError
+			if !errors.As(err, &verr) || !errors.Is(err, ErrInvalidSearch) {
+				t.Fatalf("err = %v, want validation error", err)
+			}
+		})
+	}
+}
+
+func createUsers(t *testing.T, svc *Service, usernames ...string) {
+	t.Helper()
+
+	for i, username := range usernames {
+		if _, err := svc.CreateUser(context.Background(), &CreateUserInput{
+			Username: username,
+			Email:    username + "@example.com",
+		}); err != nil {
+			t.Fatalf("create user %d: %v", i, err)
+		}
+	}
+}
@@ -7,9 +7,12 @@ import (
 	"fmt"
 	"log/slog"
 	"net/mail"
+	"slices"
 	"strings"
+	"sync/atomic"
 
-	"github.com/mhpenta/starterA/internal/app"
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/database"
 	"github.com/mhpenta/starterA/internal/database/repo"
 )
 
@@ -18,31 +21,147 @@ const (
 	maxUsernameLength = 64
 )
 
+// Service holds the business logic shared by every transport. It depends only
+// on the repo.Store interface and a TxStarter, so it can run against a real
+// database or the in-memory memdb.Store in tests.
 type Service struct {
-	Ctx    context.Context
-	App    *app.Application
-	Logger *slog.Logger
+	Ctx     context.Context
+	Queries repo.Store
+	Tx      database.TxStarter
+	Logger  *slog.Logger
+
+	// Policies restrict operations by the caller's auth token, so every
+	// transport enforces the same rules. The zero value allows everything.
+	Policies Policies
+
+	// Provisioning controls how ResolveUser links auth identities to users
+	Provisioning Provisioning
+
+	// MFA configures TOTP enrollment and verification
+	MFA MFAConfig
+
+	// ftsRetryAt is when, in Unix nanoseconds, searches try the full-text
+	// index again after finding it missing; until then they go straight to
+	// the LIKE fallback
+	ftsRetryAt atomic.Int64
 }
 
 var (
 	ErrInvalidUserInput = errors.New("invalid user input")
 	ErrUserNotFound     = errors.New("user not found")
+	ErrUserConflict     = errors.New("user conflict")
 )
 
-func New(ctx context.Context, app *app.Application, logger *slog.Logger) *Service {
+// FieldError describes why a single input field was rejected
+type FieldError struct {
+	Field   string
+	Message string
+}
 
-	if logger == nil {
-		if app.Logger == nil {
-			logger = slog.Default()
-		} else {
-			logger = app.Logger
+// ValidationError lists every problem found in an input. It matches its
+// sentinel (such as ErrInvalidUserInput) with errors.Is.
+type ValidationError struct {
+	Err    error
+	Detail string
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var parts []string
+	if e.Detail != "" {
+		parts = append(parts, e.Detail)
+	}
+	for _, f := range e.Fields {
+		parts = append(parts, f.Field+" "+f.Message)
+	}
+	if len(parts) == 0 {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + strings.Join(parts, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// add records a field error
+func (e *ValidationError) add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// UserConflictError reports which unique user field collided with an
+// existing user. It matches ErrUserConflict with errors.Is.
+type UserConflictError struct {
+	Field string
+}
+
+func (e *UserConflictError) Error() string {
+	return fmt.Sprintf("%s: %s is already taken", ErrUserConflict, e.Field)
+}
+
+func (e *UserConflictError) Unwrap() error {
+	return ErrUserConflict
+}
+
+// userConflict converts a UNIQUE constraint failure on the users table
+// into a UserConflictError, returning nil for any other error
+func userConflict(err error) error {
+	violation, ok := database.AsUniqueViolation(err)
+	if !ok || violation.Table != "users" {
+		return nil
+	}
+	return &UserConflictError{Field: violation.Columns[0]}
+}
+
+// Policies holds the auth.Policy checked by each restricted operation; a
+// nil policy leaves the operation open
+type Policies struct {
+	DeleteUser    auth.Policy
+	ManageAPIKeys auth.Policy
+}
+
+// DefaultPolicies are the policies to use when authentication is enabled
+func DefaultPolicies() Policies {
+	return Policies{
+		DeleteUser:    auth.HasRole(auth.RoleAdmin),
+		ManageAPIKeys: auth.HasRole(auth.RoleAdmin),
+	}
+}
+
+// authorize checks policy against the token in ctx, together with the roles
+// granted to the caller's local user
+func (s *Service) authorize(ctx context.Context, policy auth.Policy) error {
+	if policy == nil {
+		return nil
+	}
+
+	user, err := s.CurrentUser(ctx)
+	switch {
+	case err == nil:
+		if ctx, err = s.ContextWithUserRoles(ctx, user); err != nil {
+			return err
 		}
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, auth.ErrTokenNotInContext):
+		// Anonymous callers, API keys and unknown identities only have the
+		// roles in their token
+	default:
+		return err
+	}
+
+	return auth.Authorize(ctx, policy)
+}
+
+func New(ctx context.Context, queries repo.Store, txStarter database.TxStarter, logger *slog.Logger) *Service {
+
+	if logger == nil {
+		logger = slog.Default()
 	}
 
 	return &Service{
-		Ctx:    ctx,
-		App:    app,
-		Logger: logger,
+		Ctx:     ctx,
+		Queries: queries,
+		Tx:      txStarter,
+		Logger:  logger,
 	}
 }
 
@@ -58,11 +177,14 @@ func (s *Service) CreateUser(ctx context.Context, input *CreateUserInput) (*repo
 
 	s.Logger.Info("Creating user", "username", input.Username, "email", input.Email)
 
-	user, err := s.App.DB.CreateUser(ctx, repo.CreateUserParams{
+	user, err := s.queries(ctx).CreateUser(ctx, repo.CreateUserParams{
 		Username: input.Username,
 		Email:    input.Email,
 	})
 	if err != nil {
+		if conflict := userConflict(err); conflict != nil {
+			return nil, conflict
+		}
 		s.Logger.Error("Failed to create user", "error", err)
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
@@ -70,25 +192,147 @@ func (s *Service) CreateUser(ctx context.Context, input *CreateUserInput) (*repo
 	return &user, nil
 }
 
-func (s *Service) GetUsers(ctx context.Context, limit, offset int64) ([]repo.User, error) {
-	s.Logger.Info("Fetching users", "limit", limit, "offset", offset)
+// UserPage is one page of users, with cursors for the neighbouring pages
+// when they exist and the number of users matching the filter
+type UserPage struct {
+	Users      []repo.User `json:"users"`
+	Total      int64       `json:"total"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
+}
 
-	users, err := s.App.DB.ListUsers(ctx, repo.ListUsersParams{
-		Limit:  limit,
-		Offset: offset,
+// GetUsers returns the users matching filter in the filter's order, skipping
+// input.Offset of them, and the number of users matching the filter
+func (s *Service) GetUsers(ctx context.Context, filter *UserFilter, input *PageInput) ([]repo.User, int64, error) {
+	if filter == nil {
+		filter = &UserFilter{}
+	}
+	if input == nil {
+		input = &PageInput{}
+	}
+	if err := validateUserFilter(filter); err != nil {
+		return nil, 0, err
+	}
+	if err := validateOffsetInput(input); err != nil {
+		return nil, 0, err
+	}
+
+	s.Logger.Info("Fetching users", "limit", input.Limit, "offset", input.Offset, "sort", filter.sortSpec())
+
+	users, total, err := s.listUsers(ctx, repo.ListUsersFilteredParams{
+		Filter:     filter.repoFilter(),
+		SortColumn: string(filter.SortBy),
+		Descending: filter.Descending,
+		Limit:      input.Limit,
+		Offset:     input.Offset,
 	})
 	if err != nil {
-		s.Logger.Error("Failed to fetch users", "error", err)
-		return nil, fmt.Errorf("failed to fetch users: %w", err)
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// GetUsersPage returns a page of the users matching filter, using keyset
+// pagination on the sort field with id as the tie-breaker
+func (s *Service) GetUsersPage(ctx context.Context, filter *UserFilter, input *PageInput) (*UserPage, error) {
+	if filter == nil {
+		filter = &UserFilter{}
+	}
+	if input == nil {
+		input = &PageInput{}
+	}
+	if err := validateUserFilter(filter); err != nil {
+		return nil, err
+	}
+	c, err := validatePageInput(input, filter.sortSpec())
+	if err != nil {
+		return nil, err
+	}
+
+	s.Logger.Info("Fetching users", "limit", input.Limit, "cursor", input.Cursor, "sort", filter.sortSpec())
+
+	// A before cursor walks backwards from the boundary row, so query in the
+	// opposite order and flip the rows afterwards. One extra row is fetched
+	// to learn whether another page follows.
+	backwards := c.Direction == cursorBefore
+	params := repo.ListUsersFilteredParams{
+		Filter:     filter.repoFilter(),
+		SortColumn: string(filter.SortBy),
+		Descending: filter.Descending != backwards,
+		Limit:      input.Limit + 1,
+	}
+	if input.Cursor != "" {
+		params.After = &repo.UserKey{Value: c.Key, ID: c.ID}
+	}
+
+	users, total, err := s.listUsers(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	hasMore := int64(len(users)) > input.Limit
+	if hasMore {
+		users = users[:input.Limit]
+	}
+	if backwards {
+		slices.Reverse(users)
+	}
+
+	page := &UserPage{Users: users, Total: total}
+	if len(users) == 0 {
+		return page, nil
+	}
+
+	boundary := func(u repo.User, d cursorDirection) string {
+		return cursor{Sort: filter.sortSpec(), Key: filter.sortKey(u), ID: u.ID, Direction: d}.encode()
+	}
+	first, last := users[0], users[len(users)-1]
+	if backwards {
+		// We came from the page after this one, so it always exists
+		page.NextCursor = boundary(last, cursorAfter)
+		if hasMore {
+			page.PrevCursor = boundary(first, cursorBefore)
+		}
+	} else {
+		if hasMore {
+			page.NextCursor = boundary(last, cursorAfter)
+		}
+		if input.Cursor != "" {
+			page.PrevCursor = boundary(first, cursorBefore)
+		}
 	}
 
-	return users, nil
+	return page, nil
+}
+
+// listUsers runs a listing and counts every user matching its filter. Both
+// are read in one transaction so that writes in between cannot make them
+// disagree.
+func (s *Service) listUsers(ctx context.Context, params repo.ListUsersFilteredParams) ([]repo.User, int64, error) {
+	var users []repo.User
+	var total int64
+	err := s.WithTx(ctx, func(ctx context.Context, q repo.Store) error {
+		var err error
+		users, err = q.ListUsersFiltered(ctx, params)
+		if err != nil {
+			s.Logger.Error("Failed to fetch users", "error", err)
+			return fmt.Errorf("failed to fetch users: %w", err)
+		}
+		total, err = q.CountUsersFiltered(ctx, params.Filter)
+		if err != nil {
+			s.Logger.Error("Failed to count users", "error", err)
+			return fmt.Errorf("failed to count users: %w", err)
+		}
+		return nil
+	})
+	return users, total, err
 }
 
 func (s *Service) GetUser(ctx context.Context, id int64) (*repo.User, error) {
 	s.Logger.Info("Fetching user", "id", id)
 
-	user, err := s.App.DB.GetUser(ctx, id)
+	user, err := s.queries(ctx).GetUser(ctx, id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrUserNotFound
@@ -100,6 +344,20 @@ func (s *Service) GetUser(ctx context.Context, id int64) (*repo.User, error) {
 	return &user, nil
 }
 
+// GetUserByEmail returns the user with the given email
+func (s *Service) GetUserByEmail(ctx context.Context, email string) (*repo.User, error) {
+	user, err := s.queries(ctx).GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		s.Logger.Error("Failed to fetch user by email", "error", err)
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	return &user, nil
+}
+
 type UpdateUserInput struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
@@ -112,7 +370,7 @@ func (s *Service) UpdateUser(ctx context.Context, id int64, input *UpdateUserInp
 
 	s.Logger.Info("Updating user", "id", id)
 
-	user, err := s.App.DB.UpdateUser(ctx, repo.UpdateUserParams{
+	user, err := s.queries(ctx).UpdateUser(ctx, repo.UpdateUserParams{
 		ID:       id,
 		Username: input.Username,
 		Email:    input.Email,
@@ -121,6 +379,9 @@ func (s *Service) UpdateUser(ctx context.Context, id int64, input *UpdateUserInp
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrUserNotFound
 		}
+		if conflict := userConflict(err); conflict != nil {
+			return nil, conflict
+		}
 		s.Logger.Error("Failed to update user", "error", err)
 		return nil, fmt.Errorf("failed to update user: %w", err)
 	}
@@ -129,23 +390,27 @@ func (s *Service) UpdateUser(ctx context.Context, id int64, input *UpdateUserInp
 }
 
 func (s *Service) DeleteUser(ctx context.Context, id int64) error {
+	if err := s.authorize(ctx, s.Policies.DeleteUser); err != nil {
+		return err
+	}
+
 	s.Logger.Info("Deleting user", "id", id)
 
-	err := s.App.DB.DeleteUser(ctx, id)
+	deleted, err := s.queries(ctx).DeleteUser(ctx, id)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return ErrUserNotFound
-		}
 		s.Logger.Error("Failed to delete user", "error", err)
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
+	if deleted == 0 {
+		return ErrUserNotFound
+	}
 
 	return nil
 }
 
 func validateCreateUserInput(input *CreateUserInput) error {
 	if input == nil {
-		return fmt.Errorf("%w: missing user payload", ErrInvalidUserInput)
+		return &ValidationError{Err: ErrInvalidUserInput, Detail: "missing user payload"}
 	}
 
 	username, email, err := validateUserFields(input.Username, input.Email)
@@ -160,7 +425,7 @@ func validateCreateUserInput(input *CreateUserInput) error {
 
 func validateUpdateUserInput(input *UpdateUserInput) error {
 	if input == nil {
-		return fmt.Errorf("%w: missing user payload", ErrInvalidUserInput)
+		return &ValidationError{Err: ErrInvalidUserInput, Detail: "missing user payload"}
 	}
 
 	username, email, err := validateUserFields(input.Username, input.Email)
@@ -173,22 +438,28 @@ func validateUpdateUserInput(input *UpdateUserInput) error {
 	return nil
 }
 
+// validateUserFields trims and checks the user fields, returning a
+// *ValidationError listing every invalid field
 func validateUserFields(username, email string) (string, string, error) {
 	username = strings.TrimSpace(username)
 	email = strings.TrimSpace(email)
 
+	verr := &ValidationError{Err: ErrInvalidUserInput}
+
 	if username == "" {
-		return "", "", fmt.Errorf("%w: username is required", ErrInvalidUserInput)
-	}
-	if len(username) < minUsernameLength || len(username) > maxUsernameLength {
-		return "", "", fmt.Errorf("%w: username must be between %d and %d characters", ErrInvalidUserInput, minUsernameLength, maxUsernameLength)
+		verr.add("username", "is required")
+	} else if len(username) < minUsernameLength || len(username) > maxUsernameLength {
+		verr.add("username", fmt.Sprintf("must be between %d and %d characters", minUsernameLength, maxUsernameLength))
 	}
+
 	if email == "" {
-		return "", "", fmt.Errorf("%w: email is required", ErrInvalidUserInput)
+		verr.add("email", "is required")
+	} else if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+		verr.add("email", "is invalid")
 	}
-	address, err := mail.ParseAddress(email)
-	if err != nil || address.Address != email {
-		return "", "", fmt.Errorf("%w: email is invalid", ErrInvalidUserInput)
+
+	if len(verr.Fields) > 0 {
+		return "", "", verr
 	}
 
 	return username, email, nil
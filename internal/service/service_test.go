@@ -1,8 +1,15 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"io"
+	"log/slog"
+	"strconv"
 	"testing"
+
+	"github.com/mhpenta/starterA/internal/auth"
+	"github.com/mhpenta/starterA/internal/database/memdb"
 )
 
 func TestValidateCreateUserInputTrimsValidFields(t *testing.T) {
@@ -45,6 +52,21 @@ func TestValidateCreateUserInputRejectsInvalidFields(t *testing.T) {
 	}
 }
 
+func TestValidateUserFieldsReportsEveryInvalidField(t *testing.T) {
+	_, _, err := validateUserFields("ma", "not-an-email")
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("err = %v, want *ValidationError", err)
+	}
+	if len(validationErr.Fields) != 2 {
+		t.Fatalf("fields = %#v, want 2", validationErr.Fields)
+	}
+	if validationErr.Fields[0].Field != "username" || validationErr.Fields[1].Field != "email" {
+		t.Fatalf("fields = %#v, want username then email", validationErr.Fields)
+	}
+}
+
 func TestValidateUpdateUserInputRejectsInvalidFields(t *testing.T) {
 	input := &UpdateUserInput{
 		Username: "marc",
@@ -56,3 +78,166 @@ func TestValidateUpdateUserInputRejectsInvalidFields(t *testing.T) {
 		t.Fatalf("err = %v, want %v", err, ErrInvalidUserInput)
 	}
 }
+
+func TestUserLifecycleWithInMemoryQuerier(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemService()
+
+	created, err := svc.CreateUser(ctx, &CreateUserInput{Username: " marc ", Email: "marc@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if created.ID == 0 || created.Username != "marc" {
+		t.Fatalf("created user = %#v", created)
+	}
+	if created.CreatedAt.IsZero() {
+		t.Fatal("CreatedAt was not populated")
+	}
+
+	updated, err := svc.UpdateUser(ctx, created.ID, &UpdateUserInput{Username: "marcus", Email: "marcus@example.com"})
+	if err != nil {
+		t.Fatalf("update user: %v", err)
+	}
+	if updated.Username != "marcus" || updated.Email != "marcus@example.com" {
+		t.Fatalf("updated user = %#v", updated)
+	}
+
+	got, err := svc.GetUser(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if got.Username != "marcus" {
+		t.Fatalf("Username = %q, want marcus", got.Username)
+	}
+
+	if err := svc.DeleteUser(ctx, created.ID); err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+	if _, err := svc.GetUser(ctx, created.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("err = %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestUpdateUserReturnsNotFoundForUnknownID(t *testing.T) {
+	svc := newMemService()
+
+	_, err := svc.UpdateUser(context.Background(), 42, &UpdateUserInput{Username: "marc", Email: "marc@example.com"})
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("err = %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestDeleteUserReturnsNotFoundForUnknownID(t *testing.T) {
+	svc := newMemService()
+
+	err := svc.DeleteUser(context.Background(), 42)
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("err = %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestCreateUserReportsConflictingField(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemService()
+
+	if _, err := svc.CreateUser(ctx, &CreateUserInput{Username: "marc", Email: "marc@example.com"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	_, err := svc.CreateUser(ctx, &CreateUserInput{Username: "marc", Email: "other@example.com"})
+	assertConflict(t, err, "username")
+
+	_, err = svc.CreateUser(ctx, &CreateUserInput{Username: "anna", Email: "marc@example.com"})
+	assertConflict(t, err, "email")
+}
+
+func TestUpdateUserReportsConflictingField(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	if _, err := svc.CreateUser(ctx, &CreateUserInput{Username: "marc", Email: "marc@example.com"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	anna, err := svc.CreateUser(ctx, &CreateUserInput{Username: "anna", Email: "anna@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	_, err = svc.UpdateUser(ctx, anna.ID, &UpdateUserInput{Username: "marc", Email: "anna@example.com"})
+	assertConflict(t, err, "username")
+}
+
+func TestDeleteUserEnforcesPolicy(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemService()
+	svc.Policies = DefaultPolicies()
+
+	user, err := svc.CreateUser(ctx, &CreateUserInput{Username: "marc", Email: "marc@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	if err := svc.DeleteUser(ctx, user.ID); !errors.Is(err, auth.ErrTokenNotInContext) {
+		t.Fatalf("anonymous err = %v, want %v", err, auth.ErrTokenNotInContext)
+	}
+
+	userCtx := auth.ContextWithToken(ctx, &auth.Token{UID: "u", Claims: map[string]interface{}{"role": auth.RoleUser}})
+	if err := svc.DeleteUser(userCtx, user.ID); !errors.Is(err, auth.ErrForbidden) {
+		t.Fatalf("user err = %v, want %v", err, auth.ErrForbidden)
+	}
+
+	adminCtx := auth.ContextWithToken(ctx, &auth.Token{UID: "a", Claims: map[string]interface{}{"role": auth.RoleAdmin}})
+	if err := svc.DeleteUser(adminCtx, user.ID); err != nil {
+		t.Fatalf("admin delete: %v", err)
+	}
+}
+
+func TestDeleteUserHonoursGrantedRoles(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemService()
+	svc.Policies = DefaultPolicies()
+	svc.Provisioning = Provisioning{Provider: "local", UIDIsUserID: true}
+
+	admin, err := svc.CreateUser(ctx, &CreateUserInput{Username: "marc", Email: "marc@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	target, err := svc.CreateUser(ctx, &CreateUserInput{Username: "anna", Email: "anna@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	adminCtx := auth.ContextWithToken(ctx, &auth.Token{UID: strconv.FormatInt(admin.ID, 10)})
+
+	if err := svc.DeleteUser(adminCtx, target.ID); !errors.Is(err, auth.ErrForbidden) {
+		t.Fatalf("delete before grant err = %v, want %v", err, auth.ErrForbidden)
+	}
+	if _, err := svc.GrantRole(ctx, admin.Email, auth.RoleAdmin); err != nil {
+		t.Fatalf("grant role: %v", err)
+	}
+	if err := svc.DeleteUser(adminCtx, target.ID); err != nil {
+		t.Fatalf("delete as granted admin: %v", err)
+	}
+	if _, err := svc.RevokeRole(ctx, admin.Email, auth.RoleAdmin); err != nil {
+		t.Fatalf("revoke role: %v", err)
+	}
+	if err := svc.DeleteUser(adminCtx, admin.ID); !errors.Is(err, auth.ErrForbidden) {
+		t.Fatalf("delete after revoke err = %v, want %v", err, auth.ErrForbidden)
+	}
+}
+
+func assertConflict(t *testing.T, err error, field string) {
+	t.Helper()
+
+	if !errors.Is(err, ErrUserConflict) {
+		t.Fatalf("err = %v, want %v", err, ErrUserConflict)
+	}
+	var conflictErr *UserConflictError
+	if !errors.As(err, &conflictErr) || conflictErr.Field != field {
+		t.Fatalf("err = %v, want conflict on %s", err, field)
+	}
+}
+
+func newMemService() *Service {
+	store := memdb.New()
+	return New(context.Background(), store, store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
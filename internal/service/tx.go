@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mhpenta/starterA/internal/database"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+const (
+	// maxTxAttempts is how many times a transaction is run when it keeps
+	// failing with a transient error such as SQLITE_BUSY
+	maxTxAttempts = 3
+
+	// txRetryBaseDelay is the backoff before the first retry; it doubles
+	// on every subsequent attempt
+	txRetryBaseDelay = 25 * time.Millisecond
+)
+
+// ErrCommitFailed wraps errors from committing a transaction. Such a
+// transaction may have been committed before the error, for example when a
+// remote database drops the connection while answering, so it is never
+// retried.
+var ErrCommitFailed = errors.New("error committing transaction")
+
+type txContextKey struct{}
+
+// txState is stored in the context of a running transaction so nested
+// WithTx calls and service methods join it instead of opening a new one
+type txState struct {
+	queries repo.Store
+}
+
+// WithTx runs fn inside a database transaction. The transaction is committed
+// if fn returns nil and rolled back if it returns an error or panics.
+//
+// fn receives a context that marks the transaction as active; service methods
+// called with that context use the same transaction, and nested WithTx calls
+// join it rather than starting a second one. The outermost call retries the
+// whole of fn when it fails with a transient error such as SQLITE_BUSY, so fn
+// must not have side effects outside the database. Failed commits are
+// returned as ErrCommitFailed without a retry.
+func (s *Service) WithTx(ctx context.Context, fn func(ctx context.Context, q repo.Store) error) error {
+	if state, ok := ctx.Value(txContextKey{}).(*txState); ok {
+		return fn(ctx, state.queries)
+	}
+
+	delay := txRetryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := s.runTx(ctx, fn)
+		if err == nil || attempt >= maxTxAttempts || errors.Is(err, ErrCommitFailed) || !database.IsTransientError(err) {
+			return err
+		}
+
+		s.Logger.Warn("Retrying transaction", "attempt", attempt, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (s *Service) runTx(ctx context.Context, fn func(ctx context.Context, q repo.Store) error) error {
+	tx, err := s.Tx.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				s.Logger.Error("Failed to roll back transaction after panic", "error", rbErr)
+			}
+			panic(p)
+		}
+	}()
+
+	state := &txState{queries: tx.Queries()}
+	if err := fn(context.WithValue(ctx, txContextKey{}, state), state.queries); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Join(err, fmt.Errorf("error rolling back transaction: %w", rbErr))
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: %w", ErrCommitFailed, err)
+	}
+
+	return nil
+}
+
+// queries returns the transaction's queries when ctx belongs to a WithTx call,
+// and the shared connection pool otherwise
+func (s *Service) queries(ctx context.Context) repo.Store {
+	if state, ok := ctx.Value(txContextKey{}).(*txState); ok {
+		return state.queries
+	}
+	return s.Queries
+}
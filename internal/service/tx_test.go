@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"syscall"
+	"testing"
+
+	"github.com/mhpenta/starterA/internal/app"
+	"github.com/mhpenta/starterA/internal/config"
+	"github.com/mhpenta/starterA/internal/database"
+	"github.com/mhpenta/starterA/internal/database/memdb"
+	"github.com/mhpenta/starterA/internal/database/repo"
+)
+
+func TestWithTxCommitsOnSuccess(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	err := svc.WithTx(ctx, func(ctx context.Context, q repo.Store) error {
+		if _, err := q.CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"}); err != nil {
+			return err
+		}
+		_, err := svc.CreateUser(ctx, &CreateUserInput{Username: "anna", Email: "anna@example.com"})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("with tx: %v", err)
+	}
+
+	assertUserCount(t, svc, 2)
+}
+
+func TestWithTxRollsBackOnError(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+	errBoom := errors.New("boom")
+
+	err := svc.WithTx(ctx, func(ctx context.Context, q repo.Store) error {
+		if _, err := q.CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"}); err != nil {
+			return err
+		}
+		return errBoom
+	})
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("err = %v, want %v", err, errBoom)
+	}
+
+	assertUserCount(t, svc, 0)
+}
+
+func TestWithTxRollsBackOnPanic(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected panic to propagate")
+			}
+		}()
+
+		_ = svc.WithTx(ctx, func(ctx context.Context, q repo.Store) error {
+			if _, err := q.CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"}); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+
+	assertUserCount(t, svc, 0)
+}
+
+func TestWithTxNestedCallJoinsOuterTransaction(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+	errBoom := errors.New("boom")
+
+	err := svc.WithTx(ctx, func(ctx context.Context, outer repo.Store) error {
+		err := svc.WithTx(ctx, func(ctx context.Context, inner repo.Store) error {
+			if inner != outer {
+				t.Fatal("nested WithTx opened a new transaction")
+			}
+			_, err := inner.CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return errBoom
+	})
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("err = %v, want %v", err, errBoom)
+	}
+
+	// The nested call must not have committed on its own
+	assertUserCount(t, svc, 0)
+}
+
+func TestWithTxRetriesTransientErrors(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	attempts := 0
+	err := svc.WithTx(ctx, func(ctx context.Context, q repo.Store) error {
+		attempts++
+		if _, err := q.CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"}); err != nil {
+			return err
+		}
+		if attempts == 1 {
+			return errors.New("SQLITE_BUSY: database is locked")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("with tx: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("attempts = %d, want 2", attempts)
+	}
+
+	assertUserCount(t, svc, 1)
+}
+
+func TestWithTxDoesNotRetryPermanentErrors(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+
+	attempts := 0
+	_ = svc.WithTx(ctx, func(ctx context.Context, q repo.Store) error {
+		attempts++
+		return errors.New("boom")
+	})
+	if attempts != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestWithTxDoesNotRetryFailedCommits(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.New()
+	svc := New(ctx, store, resetOnCommit{store}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	attempts := 0
+	err := svc.WithTx(ctx, func(ctx context.Context, q repo.Store) error {
+		attempts++
+		_, err := q.CreateUser(ctx, repo.CreateUserParams{Username: "marc", Email: "marc@example.com"})
+		return err
+	})
+	if !errors.Is(err, ErrCommitFailed) || !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("err = %v, want ErrCommitFailed wrapping ECONNRESET", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts)
+	}
+
+	assertUserCount(t, svc, 1)
+}
+
+// resetOnCommit starts transactions whose commits succeed but report a
+// dropped connection, as a remote database may after committing
+type resetOnCommit struct {
+	database.TxStarter
+}
+
+func (r resetOnCommit) BeginTx(ctx context.Context) (database.Tx, error) {
+	tx, err := r.TxStarter.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resetOnCommitTx{tx}, nil
+}
+
+type resetOnCommitTx struct {
+	database.Tx
+}
+
+func (tx resetOnCommitTx) Commit() error {
+	if err := tx.Tx.Commit(); err != nil {
+		return err
+	}
+	return syscall.ECONNRESET
+}
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	a, err := app.New(context.Background(), logger, &config.Config{
+		Database: config.Database{
+			Mode:        config.DatabaseModeMemory,
+			AutoMigrate: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("new application: %v", err)
+	}
+	t.Cleanup(func() { _ = a.Close() })
+
+	return New(context.Background(), a.DB, a.Tx, logger)
+}
+
+func assertUserCount(t *testing.T, svc *Service, want int) {
+	t.Helper()
+
+	// Read the store directly, outside any transaction the test tampers with
+	count, err := svc.Queries.CountUsersFiltered(context.Background(), repo.UserFilter{})
+	if err != nil {
+		t.Fatalf("count users: %v", err)
+	}
+	if count != int64(want) {
+		t.Fatalf("user count = %d, want %d", count, want)
+	}
+}
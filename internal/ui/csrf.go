@@ -0,0 +1,14 @@
+package ui
+
+import (
+	. "maragu.dev/gomponents"
+	. "maragu.dev/gomponents/html"
+
+	"github.com/mhpenta/starterA/internal/auth"
+)
+
+// CSRFField renders the hidden input that carries the CSRF token in forms
+// posted with the session cookie. Get the token with auth.CSRFToken.
+func CSRFField(token string) Node {
+	return Input(Type("hidden"), Name(auth.CSRFFormField), Value(token))
+}